@@ -0,0 +1,125 @@
+package gaetest
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sync"
+)
+
+// Every form served by the admin server of dev_appserver.py carries a hidden
+// xsrf_token field which has to be sent back with POST requests.
+var xsrfTokenRE = regexp.MustCompile(`name="xsrf_token"\s+value="([^"]+)"`)
+
+// adminMemcachePath is the path of the memcache page of the admin server.
+const adminMemcachePath = "/memcache"
+
+// admin is a client for the pages served by the admin server. It takes care
+// of fetching and caching the XSRF token the pages require.
+type admin struct {
+	url    string
+	client *http.Client // defaults to http.DefaultClient
+
+	mu    sync.Mutex
+	token string
+}
+
+// get fetches the page at path and returns its body.
+func (a *admin) get(path string, query url.Values) (string, error) {
+	u := a.url + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	res, err := a.httpClient().Get(u)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("admin server: GET %s returned %s", path, res.Status)
+	}
+	return string(body), nil
+}
+
+// post submits form to the page at path, adding the XSRF token.
+func (a *admin) post(path string, form url.Values) error {
+	token, err := a.xsrfToken()
+	if err != nil {
+		return err
+	}
+	form.Set("xsrf_token", token)
+	res, err := a.httpClient().PostForm(a.url+path, form)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode == http.StatusForbidden {
+		// The token is generated when the admin server starts. Forget it so
+		// the next call fetches a fresh one.
+		a.mu.Lock()
+		a.token = ""
+		a.mu.Unlock()
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("admin server: POST %s returned %s", path, res.Status)
+	}
+	return nil
+}
+
+func (a *admin) httpClient() *http.Client {
+	if a.client == nil {
+		return http.DefaultClient
+	}
+	return a.client
+}
+
+// xsrfToken returns the XSRF token of the admin server, fetching it from the
+// memcache page the first time it is needed.
+func (a *admin) xsrfToken() (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.token != "" {
+		return a.token, nil
+	}
+	page, err := a.get(adminMemcachePath, nil)
+	if err != nil {
+		return "", err
+	}
+	match := xsrfTokenRE.FindStringSubmatch(page)
+	if match == nil {
+		return "", errors.New("admin server: unable to find XSRF token")
+	}
+	a.token = match[1]
+	return a.token, nil
+}
+
+// flushMemcache removes all items from memcache.
+func (a *admin) flushMemcache() error {
+	return a.post(adminMemcachePath, url.Values{"action:flush": {"Flush Cache"}})
+}
+
+// FlushMemcache removes all items from memcache using the admin server.
+func (sv *Server) FlushMemcache() error {
+	return sv.admin.flushMemcache()
+}
+
+// ClearDatastore deletes all entities, in every namespace.
+//
+// Deprecated: use ResetDatastore, which it calls.
+func (sv *Server) ClearDatastore() error {
+	return sv.ResetDatastore()
+}
+
+// AdminXSRFToken returns the XSRF token expected by the forms of the admin
+// server. It can be used to script admin actions the package does not wrap by
+// posting it in the xsrf_token field. The token is fetched once and cached.
+func (sv *Server) AdminXSRFToken() (string, error) {
+	return sv.admin.xsrfToken()
+}
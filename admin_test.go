@@ -0,0 +1,75 @@
+package gaetest
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newAdminStub returns a server imitating the admin server pages. Every POST
+// is recorded in posts after its XSRF token has been checked.
+func newAdminStub(posts *[]*http.Request) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			fmt.Fprint(w, `<form><input type="hidden" name="xsrf_token" value="s3cr3t"></form>`)
+			return
+		}
+		if r.FormValue("xsrf_token") != "s3cr3t" {
+			http.Error(w, "Invalid XSRF token.", http.StatusForbidden)
+			return
+		}
+		*posts = append(*posts, r)
+	}))
+}
+
+func TestFlushMemcache(t *testing.T) {
+	var posts []*http.Request
+	ts := newAdminStub(&posts)
+	defer ts.Close()
+
+	sv := &Server{admin: &admin{url: ts.URL}}
+	if err := sv.FlushMemcache(); err != nil {
+		t.Fatalf("FlushMemcache returned %v, expected nil", err)
+	}
+	if len(posts) != 1 {
+		t.Fatalf("got %d posts, but expect 1", len(posts))
+	}
+	if posts[0].URL.Path != "/memcache" || posts[0].FormValue("action:flush") == "" {
+		t.Fatalf("got %s %v, but expect a flush of /memcache", posts[0].URL.Path, posts[0].Form)
+	}
+}
+
+func TestAdminXSRFToken(t *testing.T) {
+	ts := newAdminStub(new([]*http.Request))
+	defer ts.Close()
+
+	sv := &Server{admin: &admin{url: ts.URL}}
+	token, err := sv.AdminXSRFToken()
+	if err != nil {
+		t.Fatalf("AdminXSRFToken returned %v, expected nil", err)
+	}
+	if expect := "s3cr3t"; token != expect {
+		t.Fatalf("got %q, but expect %q", token, expect)
+	}
+}
+
+func TestClearDatastore(t *testing.T) {
+	var stored []storedEntity
+	ts := newDatastoreStub(&stored)
+	defer ts.Close()
+	sv := &Server{APIURL: ts.URL, opts: withDefaults(nil)}
+
+	if _, err := sv.Factory("User").CreateN(2); err != nil {
+		t.Fatalf("CreateN returned %v, expected nil", err)
+	}
+	if _, err := sv.Factory("Post").Create(); err != nil {
+		t.Fatalf("Create returned %v, expected nil", err)
+	}
+	if err := sv.ClearDatastore(); err != nil {
+		t.Fatalf("ClearDatastore returned %v, expected nil", err)
+	}
+	if len(stored) != 0 {
+		t.Fatalf("got %d entities left, but expect 0", len(stored))
+	}
+}
@@ -0,0 +1,284 @@
+package gaetest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+)
+
+// The API server of dev_appserver.py serves the service stubs over HTTP:
+// POST requests carry a serialized remote_api.Request and are answered with a
+// remote_api.Response. The messages are encoded by hand to spare the package a
+// dependency on a protocol buffer library.
+
+// pbMessage builds a protocol buffer message.
+type pbMessage struct {
+	buf bytes.Buffer
+}
+
+func (m *pbMessage) varint(v uint64) {
+	for v >= 0x80 {
+		m.buf.WriteByte(byte(v) | 0x80)
+		v >>= 7
+	}
+	m.buf.WriteByte(byte(v))
+}
+
+// int64 appends field num as a varint.
+func (m *pbMessage) int64(num int, v int64) {
+	m.varint(uint64(num)<<3 | 0)
+	m.varint(uint64(v))
+}
+
+// bytes appends field num as a length delimited value.
+func (m *pbMessage) bytes(num int, v []byte) {
+	m.varint(uint64(num)<<3 | 2)
+	m.varint(uint64(len(v)))
+	m.buf.Write(v)
+}
+
+func (m *pbMessage) string(num int, v string) {
+	m.bytes(num, []byte(v))
+}
+
+// double appends field num as a 64-bit value.
+func (m *pbMessage) double(num int, v float64) {
+	m.varint(uint64(num)<<3 | 1)
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], math.Float64bits(v))
+	m.buf.Write(b[:])
+}
+
+// startGroup and endGroup enclose the fields of group num.
+func (m *pbMessage) startGroup(num int) {
+	m.varint(uint64(num)<<3 | 3)
+}
+
+func (m *pbMessage) endGroup(num int) {
+	m.varint(uint64(num)<<3 | 4)
+}
+
+// pbField is a field of a decoded protocol buffer message. Varint and fixed
+// size values are kept in varint, length delimited values and groups in data,
+// the data of groups being their encoded fields.
+type pbField struct {
+	num    int
+	varint uint64
+	data   []byte
+}
+
+func readVarint(data []byte) (uint64, int, error) {
+	var v uint64
+	for i := 0; i < len(data) && i < 10; i++ {
+		v |= uint64(data[i]&0x7f) << (7 * uint(i))
+		if data[i] < 0x80 {
+			return v, i + 1, nil
+		}
+	}
+	return 0, 0, errors.New("invalid varint")
+}
+
+// parsePB decodes the fields of a message.
+func parsePB(data []byte) ([]pbField, error) {
+	var fields []pbField
+	for len(data) > 0 {
+		f, rest, err := readField(data)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, f)
+		data = rest
+	}
+	return fields, nil
+}
+
+// readField decodes the field data starts with and returns it with the rest
+// of data.
+func readField(data []byte) (f pbField, rest []byte, err error) {
+	key, n, err := readVarint(data)
+	if err != nil {
+		return f, nil, err
+	}
+	data = data[n:]
+	f.num = int(key >> 3)
+	switch key & 7 {
+	case 0:
+		if f.varint, n, err = readVarint(data); err != nil {
+			return f, nil, err
+		}
+		data = data[n:]
+	case 1:
+		if len(data) < 8 {
+			return f, nil, errors.New("truncated message")
+		}
+		f.varint, data = binary.LittleEndian.Uint64(data), data[8:]
+	case 2:
+		l, n, err := readVarint(data)
+		if err != nil {
+			return f, nil, err
+		}
+		data = data[n:]
+		if uint64(len(data)) < l {
+			return f, nil, errors.New("truncated message")
+		}
+		f.data, data = data[:l], data[l:]
+	case 3:
+		if f.data, data, err = readGroup(data, f.num); err != nil {
+			return f, nil, err
+		}
+	case 5:
+		if len(data) < 4 {
+			return f, nil, errors.New("truncated message")
+		}
+		f.varint, data = uint64(binary.LittleEndian.Uint32(data)), data[4:]
+	default:
+		return f, nil, fmt.Errorf("unsupported wire type %d", key&7)
+	}
+	return f, data, nil
+}
+
+// replaceField returns the message data with the values of field num replaced
+// by the length delimited value v, keeping the encoding of the other fields.
+func replaceField(data []byte, num int, v []byte) ([]byte, error) {
+	var m pbMessage
+	for len(data) > 0 {
+		f, rest, err := readField(data)
+		if err != nil {
+			return nil, err
+		}
+		if f.num == num {
+			m.bytes(num, v)
+		} else {
+			m.buf.Write(data[:len(data)-len(rest)])
+		}
+		data = rest
+	}
+	return m.buf.Bytes(), nil
+}
+
+// readGroup splits data, which follows the start of group num, into the
+// fields of the group and what follows its end.
+func readGroup(data []byte, num int) (group, rest []byte, err error) {
+	for i := 0; i < len(data); {
+		key, n, err := readVarint(data[i:])
+		if err != nil {
+			return nil, nil, err
+		}
+		start := i
+		i += n
+		switch key & 7 {
+		case 0:
+			if _, n, err = readVarint(data[i:]); err != nil {
+				return nil, nil, err
+			}
+			i += n
+		case 1:
+			if len(data)-i < 8 {
+				return nil, nil, errors.New("truncated message")
+			}
+			i += 8
+		case 2:
+			l, n, err := readVarint(data[i:])
+			if err != nil {
+				return nil, nil, err
+			}
+			i += n
+			if uint64(len(data)-i) < l {
+				return nil, nil, errors.New("truncated message")
+			}
+			i += int(l)
+		case 3:
+			_, after, err := readGroup(data[i:], int(key>>3))
+			if err != nil {
+				return nil, nil, err
+			}
+			i = len(data) - len(after)
+		case 4:
+			if int(key>>3) != num {
+				return nil, nil, errors.New("mismatched end of group")
+			}
+			return data[:start], data[i:], nil
+		case 5:
+			if len(data)-i < 4 {
+				return nil, nil, errors.New("truncated message")
+			}
+			i += 4
+		default:
+			return nil, nil, fmt.Errorf("unsupported wire type %d", key&7)
+		}
+	}
+	return nil, nil, errors.New("truncated group")
+}
+
+// APIError is an application error returned by a service stub.
+type APIError struct {
+	Service string
+	Code    int
+	Detail  string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("API error %d (%s): %s", e.Code, e.Service, e.Detail)
+}
+
+// callAPI calls method of service on the API server with the serialized
+// request and returns the serialized response.
+func (sv *Server) callAPI(service, method string, req []byte) ([]byte, error) {
+	var m pbMessage
+	m.string(2, service)
+	m.string(3, method)
+	m.bytes(4, req)
+	if sv.suiteExpired() {
+		return nil, ErrSuiteDeadline
+	}
+	res, err := sv.httpClient().Post(sv.APIURL, "application/octet-stream", &m.buf)
+	if err != nil {
+		if sv.suiteExpired() {
+			return nil, ErrSuiteDeadline
+		}
+		return nil, err
+	}
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s.%s: API server answered %s", service, method, res.Status)
+	}
+	fields, err := parsePB(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s.%s: %v", service, method, err)
+	}
+	var response []byte
+	for _, f := range fields {
+		switch f.num {
+		case 1:
+			response = f.data
+		case 2:
+			return nil, fmt.Errorf("%s.%s: API server raised an exception: %q", service, method, f.data)
+		case 3:
+			apiErr := &APIError{Service: service}
+			sub, err := parsePB(f.data)
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s: %v", service, method, err)
+			}
+			for _, sf := range sub {
+				switch sf.num {
+				case 1:
+					apiErr.Code = int(sf.varint)
+				case 2:
+					apiErr.Detail = string(sf.data)
+				}
+			}
+			return nil, apiErr
+		case 5:
+			return nil, fmt.Errorf("%s.%s: %v", service, method, rpcError(f.data))
+		}
+	}
+	return response, nil
+}
@@ -0,0 +1,31 @@
+package gaetest
+
+import "testing"
+
+func TestParsePBMalformed(t *testing.T) {
+	var group pbMessage
+	group.startGroup(1)
+	group.string(2, "ok")
+	group.endGroup(1)
+	if fields, err := parsePB(group.buf.Bytes()); err != nil || len(fields) != 1 {
+		t.Fatalf("parsePB returned %v, %v, expected a group", fields, err)
+	}
+
+	for _, data := range [][]byte{
+		// A group holding a field whose length overflows int.
+		{0x0b, 0x12, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x01, 0x0c},
+		{0x0b, 0x12, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01, 0x0c},
+		// A group holding a field longer than the message.
+		{0x0b, 0x12, 0x10, 'o', 'k', 0x0c},
+		// A group holding a truncated fixed64.
+		{0x0b, 0x11, 0x01, 0x02},
+		// A group holding a truncated fixed32.
+		{0x0b, 0x15, 0x01},
+		// A group without its end.
+		{0x0b, 0x10, 0x01},
+	} {
+		if _, err := parsePB(data); err == nil {
+			t.Fatalf("parsePB returned nil for % x, expected an error", data)
+		}
+	}
+}
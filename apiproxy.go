@@ -0,0 +1,413 @@
+package gaetest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// APICall is a call to an App Engine API the app made through the API proxy.
+type APICall struct {
+	Time    time.Time
+	Service string
+	Method  string
+	// Ticket identifies the request of the app that made the call.
+	Ticket string
+	// Request and Response are the serialized messages.
+	Request  []byte
+	Response []byte
+	Duration time.Duration
+	// Err is an *APIError if the stub returned an application error, or
+	// another error if the call failed.
+	Err error
+	// Injected reports whether the call was affected by an APIFault.
+	Injected bool
+}
+
+// Codes of the remote_api.RpcError the API server returns for calls over
+// quota, reported by appengine.IsOverQuota, and for calls to disabled APIs,
+// reported by appengine.IsCapabilityDisabled.
+const (
+	rpcOverQuota          = 4
+	rpcCapabilityDisabled = 6
+)
+
+// rpcError decodes a remote_api.RpcError.
+func rpcError(data []byte) error {
+	var code uint64
+	var detail string
+	fields, _ := parsePB(data)
+	for _, f := range fields {
+		switch f.num {
+		case 1:
+			code = f.varint
+		case 2:
+			detail = string(f.data)
+		}
+	}
+	switch code {
+	case rpcOverQuota:
+		return ErrOverQuota
+	case rpcCapabilityDisabled:
+		return ErrCapabilityDisabled
+	}
+	return fmt.Errorf("RPC error %d: %s", code, detail)
+}
+
+// ErrOverQuota is the error of the recorded API calls failed by the budgets
+// set with Server.SetAPIQuota.
+var ErrOverQuota = errors.New("gaetest: API call over quota")
+
+// ErrCapabilityDisabled is the error of the recorded API calls to the services
+// disabled with Server.SetCapability.
+var ErrCapabilityDisabled = errors.New("gaetest: API capability disabled")
+
+// APIFault delays or fails the API calls of the app matching Service and
+// Method.
+type APIFault struct {
+	// Service and Method select the calls, e.g. "datastore_v3" and "Put".
+	// Empty values match every service or method.
+	Service string
+	Method  string
+	// Rate is the fraction of the matching calls affected, between 0 and 1.
+	// Zero affects all of them.
+	Rate float64
+	// Delay holds the calls before they are answered.
+	Delay time.Duration
+	// Code, if not zero, fails the calls with this application error
+	// instead of passing them to the stub. The codes are specific to each
+	// service, e.g. 11 is TIMEOUT for datastore_v3.
+	Code   int
+	Detail string
+}
+
+func (f *APIFault) matches(c *APICall) bool {
+	return (f.Service == "" || f.Service == c.Service) && (f.Method == "" || f.Method == c.Method)
+}
+
+// apiProxy sits between the app and the API server of dev_appserver.py,
+// which listens on port, and records the calls made by the app.
+type apiProxy struct {
+	srv          *httptest.Server
+	port         int
+	target       string
+	allowedHosts []string // see Options.SocketAllowedHosts
+	redirects    []socketRedirect
+
+	mu       sync.Mutex
+	log      []APICall
+	faults   []APIFault
+	quotas   map[string]int  // remaining calls, by service
+	disabled map[string]bool // services, see SetCapability
+	rand     *rand.Rand
+}
+
+func newAPIProxy(host string) (*apiProxy, error) {
+	port, err := freePort(host)
+	if err != nil {
+		return nil, err
+	}
+	p := &apiProxy{
+		port:   port,
+		target: "http://" + net.JoinHostPort(host, strconv.Itoa(port)),
+		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	p.srv = httptest.NewServer(p)
+	return p, nil
+}
+
+func (p *apiProxy) Close() {
+	p.srv.Close()
+}
+
+// env returns the environment variables making the app call the proxy.
+func (p *apiProxy) env() []string {
+	host, port, _ := net.SplitHostPort(p.srv.Listener.Addr().String())
+	return []string{"API_HOST=" + host, "API_PORT=" + port}
+}
+
+func (p *apiProxy) record(c APICall) {
+	p.mu.Lock()
+	p.log = append(p.log, c)
+	p.mu.Unlock()
+}
+
+func (p *apiProxy) calls() []APICall {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]APICall(nil), p.log...)
+}
+
+// overQuota uses the budget of the service of c, reporting whether it was
+// already exhausted.
+func (p *apiProxy) overQuota(c *APICall) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	left, ok := p.quotas[c.Service]
+	if !ok {
+		return false
+	}
+	if left == 0 {
+		return true
+	}
+	p.quotas[c.Service] = left - 1
+	return false
+}
+
+// fault returns the first fault affecting c, if any.
+func (p *apiProxy) fault(c *APICall) *APIFault {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i := range p.faults {
+		f := &p.faults[i]
+		if f.matches(c) && (f.Rate == 0 || p.rand.Float64() < f.Rate) {
+			return f
+		}
+	}
+	return nil
+}
+
+// parseAPIRequest decodes a remote_api.Request.
+func parseAPIRequest(data []byte) (APICall, error) {
+	var c APICall
+	fields, err := parsePB(data)
+	if err != nil {
+		return c, err
+	}
+	for _, f := range fields {
+		switch f.num {
+		case 2:
+			c.Service = string(f.data)
+		case 3:
+			c.Method = string(f.data)
+		case 4:
+			c.Request = f.data
+		case 5:
+			c.Ticket = string(f.data)
+		}
+	}
+	return c, nil
+}
+
+// parseAPIResponse decodes a remote_api.Response into c.
+func parseAPIResponse(c *APICall, data []byte) {
+	fields, err := parsePB(data)
+	if err != nil {
+		c.Err = err
+		return
+	}
+	for _, f := range fields {
+		switch f.num {
+		case 1:
+			c.Response = f.data
+		case 2:
+			c.Err = &APIError{Service: c.Service, Detail: "exception: " + string(f.data)}
+		case 3:
+			apiErr := &APIError{Service: c.Service}
+			sub, _ := parsePB(f.data)
+			for _, sf := range sub {
+				switch sf.num {
+				case 1:
+					apiErr.Code = int(sf.varint)
+				case 2:
+					apiErr.Detail = string(sf.data)
+				}
+			}
+			c.Err = apiErr
+		case 5:
+			c.Err = rpcError(f.data)
+		}
+	}
+}
+
+func (p *apiProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	call, err := parseAPIRequest(body)
+	if err != nil {
+		http.Error(w, "invalid remote_api.Request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	call.Time = time.Now()
+	defer func() {
+		call.Duration = time.Since(call.Time)
+		p.record(call)
+	}()
+
+	if p.overQuota(&call) {
+		call.Err, call.Injected = ErrOverQuota, true
+		writeRPCError(w, rpcOverQuota, "gaetest: "+call.Service+" quota exhausted")
+		return
+	}
+	if p.isDisabled(&call) {
+		call.Err, call.Injected = ErrCapabilityDisabled, true
+		writeRPCError(w, rpcCapabilityDisabled, "gaetest: the "+call.Service+" API is disabled")
+		return
+	}
+	if res := p.resolveRedirect(&call); res != nil {
+		call.Injected = true
+		call.Response = res
+		var out pbMessage
+		out.bytes(1, res)
+		w.Write(out.buf.Bytes())
+		return
+	}
+	if req, ok := p.redirectSocket(&call); ok {
+		// The app's request stays recorded; dev_appserver.py gets the target.
+		call.Injected = true
+		if body, err = replaceField(body, 4, req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else if addr := p.deniedSocket(&call); addr != "" {
+		detail := "gaetest: " + addr + " is not in Options.SocketAllowedHosts"
+		call.Err = &APIError{Service: call.Service, Code: remoteSocketPermissionDenied, Detail: detail}
+		call.Injected = true
+		writeAPIError(w, remoteSocketPermissionDenied, detail)
+		return
+	}
+	if call.Service == "capability_service" && call.Method == "IsEnabled" && p.reportsDisabled(call.Request) {
+		call.Injected = true
+		call.Response = capabilityDisabled()
+		var res pbMessage
+		res.bytes(1, call.Response)
+		w.Write(res.buf.Bytes())
+		return
+	}
+	if f := p.fault(&call); f != nil {
+		call.Injected = true
+		time.Sleep(f.Delay)
+		if f.Code != 0 {
+			call.Err = &APIError{Service: call.Service, Code: f.Code, Detail: f.Detail}
+			writeAPIError(w, int64(f.Code), f.Detail)
+			return
+		}
+	}
+
+	out, err := http.NewRequest(r.Method, p.target+r.URL.RequestURI(), bytes.NewReader(body))
+	if err != nil {
+		call.Err = err
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	copyHeader(out.Header, r.Header)
+	res, err := http.DefaultTransport.RoundTrip(out)
+	if err != nil {
+		call.Err = err
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		call.Err = err
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	if res.StatusCode == http.StatusOK {
+		parseAPIResponse(&call, data)
+	}
+	copyHeader(w.Header(), res.Header)
+	w.WriteHeader(res.StatusCode)
+	w.Write(data)
+}
+
+// writeAPIError answers an API call with an application error of its service.
+func writeAPIError(w http.ResponseWriter, code int64, detail string) {
+	var appErr, res pbMessage
+	appErr.int64(1, code)
+	appErr.string(2, detail)
+	res.bytes(3, appErr.buf.Bytes())
+	w.Write(res.buf.Bytes())
+}
+
+// writeRPCError answers an API call with a remote_api.RpcError.
+func writeRPCError(w http.ResponseWriter, code int64, detail string) {
+	var rpcErr, res pbMessage
+	rpcErr.int64(1, code)
+	rpcErr.string(2, detail)
+	res.bytes(5, rpcErr.buf.Bytes())
+	w.Write(res.buf.Bytes())
+}
+
+// requireAPIProxy returns an error naming op unless Options.RecordAPICalls is
+// set, which installs the API proxy.
+func (sv *Server) requireAPIProxy(op string) error {
+	if sv.api == nil {
+		return fmt.Errorf("gaetest: %s requires Options.RecordAPICalls", op)
+	}
+	return nil
+}
+
+// InjectAPIFault makes the API proxy delay or fail the calls of the app
+// matching f. When several faults match a call, the first one injected wins.
+// It requires Options.RecordAPICalls.
+func (sv *Server) InjectAPIFault(f APIFault) error {
+	if err := sv.requireAPIProxy("InjectAPIFault"); err != nil {
+		return err
+	}
+	sv.api.mu.Lock()
+	defer sv.api.mu.Unlock()
+	sv.api.faults = append(sv.api.faults, f)
+	return nil
+}
+
+// ClearAPIFaults removes the faults injected with InjectAPIFault.
+func (sv *Server) ClearAPIFaults() error {
+	if err := sv.requireAPIProxy("ClearAPIFaults"); err != nil {
+		return err
+	}
+	sv.api.mu.Lock()
+	defer sv.api.mu.Unlock()
+	sv.api.faults = nil
+	return nil
+}
+
+// SetAPIQuota gives the app a budget of calls to service: once it made that
+// many calls, the following ones fail as over quota, which
+// appengine.IsOverQuota reports. Calls failed with InjectAPIFault count
+// against the budget. It requires Options.RecordAPICalls.
+func (sv *Server) SetAPIQuota(service string, calls int) error {
+	if err := sv.requireAPIProxy("SetAPIQuota"); err != nil {
+		return err
+	}
+	sv.api.mu.Lock()
+	defer sv.api.mu.Unlock()
+	if sv.api.quotas == nil {
+		sv.api.quotas = make(map[string]int)
+	}
+	sv.api.quotas[service] = calls
+	return nil
+}
+
+// ClearAPIQuotas removes the budgets set with SetAPIQuota.
+func (sv *Server) ClearAPIQuotas() error {
+	if err := sv.requireAPIProxy("ClearAPIQuotas"); err != nil {
+		return err
+	}
+	sv.api.mu.Lock()
+	defer sv.api.mu.Unlock()
+	sv.api.quotas = nil
+	return nil
+}
+
+// APICalls returns the App Engine API calls the app made, in order. Calls
+// made by the harness itself, e.g. by Modules, are not included. It returns nil
+// unless Options.RecordAPICalls is set.
+func (sv *Server) APICalls() []APICall {
+	if sv.api == nil {
+		return nil
+	}
+	return sv.api.calls()
+}
@@ -0,0 +1,134 @@
+package gaetest
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAPIProxy(t *testing.T) {
+	var calls []string
+	ts := newModulesStub(&calls)
+	defer ts.Close()
+	p, err := newAPIProxy("127.0.0.1")
+	if err != nil {
+		t.Fatalf("newAPIProxy returned %v, expected nil", err)
+	}
+	defer p.Close()
+	p.target = ts.URL
+	sv := &Server{APIURL: p.srv.URL + "/rpc_http", api: p}
+
+	if _, err := sv.Modules().DefaultVersion("worker"); err != nil {
+		t.Fatalf("DefaultVersion returned %v, expected nil", err)
+	}
+	if _, err := sv.Modules().DefaultVersion("frontend"); err == nil {
+		t.Fatalf("DefaultVersion returned nil for an unknown module, expected an error")
+	}
+
+	recorded := sv.APICalls()
+	if len(recorded) != 2 || len(calls) != 2 {
+		t.Fatalf("got %d recorded calls and %d calls, but expect 2", len(recorded), len(calls))
+	}
+	if c := recorded[0]; c.Service != "modules" || c.Method != "GetDefaultVersion" || c.Err != nil || len(c.Response) == 0 {
+		t.Fatalf("got %+v, but expect a successful modules.GetDefaultVersion call", c)
+	}
+	if apiErr, ok := recorded[1].Err.(*APIError); !ok || apiErr.Code != ModulesInvalidModule {
+		t.Fatalf("got %v, but expect an APIError with code %d", recorded[1].Err, ModulesInvalidModule)
+	}
+	if recorded := (&Server{}).APICalls(); recorded != nil {
+		t.Fatalf("got %v without RecordAPICalls, but expect nil", recorded)
+	}
+	env := p.env()
+	if len(env) != 2 || env[0] != "API_HOST=127.0.0.1" {
+		t.Fatalf("got %q, but expect API_HOST and API_PORT", env)
+	}
+}
+
+func TestAPIFault(t *testing.T) {
+	var calls []string
+	ts := newModulesStub(&calls)
+	defer ts.Close()
+	p, err := newAPIProxy("127.0.0.1")
+	if err != nil {
+		t.Fatalf("newAPIProxy returned %v, expected nil", err)
+	}
+	defer p.Close()
+	p.target = ts.URL
+	sv := &Server{APIURL: p.srv.URL + "/rpc_http", api: p}
+
+	expect := "gaetest: InjectAPIFault requires Options.RecordAPICalls"
+	if err := (&Server{}).InjectAPIFault(APIFault{}); err == nil || err.Error() != expect {
+		t.Fatalf("got %v, but expect %q", err, expect)
+	}
+	for _, f := range []APIFault{
+		{Service: "modules", Method: "GetNumInstances", Delay: 50 * time.Millisecond},
+		{Service: "modules", Method: "GetDefaultVersion", Code: ModulesTransientError, Detail: "injected"},
+	} {
+		if err := sv.InjectAPIFault(f); err != nil {
+			t.Fatalf("InjectAPIFault returned %v, expected nil", err)
+		}
+	}
+	if _, err := sv.Modules().NumInstances("worker", "v1"); err != nil {
+		t.Fatalf("NumInstances returned %v, expected nil", err)
+	}
+	_, err = sv.Modules().DefaultVersion("worker")
+	if apiErr, ok := err.(*APIError); !ok || apiErr.Code != ModulesTransientError || apiErr.Detail != "injected" {
+		t.Fatalf("got %v, but expect the injected error", err)
+	}
+	if len(calls) != 1 {
+		t.Fatalf("got %d calls, but expect the failed call not to reach the stub", len(calls))
+	}
+	recorded := sv.APICalls()
+	if !recorded[0].Injected || recorded[0].Duration < 50*time.Millisecond || recorded[0].Err != nil {
+		t.Fatalf("got %+v, but expect a delayed call", recorded[0])
+	}
+	if !recorded[1].Injected || recorded[1].Err == nil {
+		t.Fatalf("got %+v, but expect a failed call", recorded[1])
+	}
+
+	if err := sv.ClearAPIFaults(); err != nil {
+		t.Fatalf("ClearAPIFaults returned %v, expected nil", err)
+	}
+	if _, err := sv.Modules().DefaultVersion("worker"); err != nil {
+		t.Fatalf("DefaultVersion returned %v, expected nil", err)
+	}
+}
+
+func TestAPIQuota(t *testing.T) {
+	var calls []string
+	ts := newModulesStub(&calls)
+	defer ts.Close()
+	p, err := newAPIProxy("127.0.0.1")
+	if err != nil {
+		t.Fatalf("newAPIProxy returned %v, expected nil", err)
+	}
+	defer p.Close()
+	p.target = ts.URL
+	sv := &Server{APIURL: p.srv.URL + "/rpc_http", api: p}
+
+	expect := "gaetest: SetAPIQuota requires Options.RecordAPICalls"
+	if err := (&Server{}).SetAPIQuota("modules", 2); err == nil || err.Error() != expect {
+		t.Fatalf("got %v, but expect %q", err, expect)
+	}
+	if err := sv.SetAPIQuota("modules", 2); err != nil {
+		t.Fatalf("SetAPIQuota returned %v, expected nil", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := sv.Modules().List(); err != nil {
+			t.Fatalf("List returned %v, expected nil", err)
+		}
+	}
+	expect = "modules.GetModules: " + ErrOverQuota.Error()
+	if _, err := sv.Modules().List(); err == nil || err.Error() != expect {
+		t.Fatalf("got %v, but expect %q", err, expect)
+	}
+	if recorded := sv.APICalls(); recorded[2].Err != ErrOverQuota || !recorded[2].Injected {
+		t.Fatalf("got %+v, but expect the call to be over quota", recorded[2])
+	}
+
+	if err := sv.ClearAPIQuotas(); err != nil {
+		t.Fatalf("ClearAPIQuotas returned %v, expected nil", err)
+	}
+	if _, err := sv.Modules().List(); err != nil {
+		t.Fatalf("List returned %v, expected nil", err)
+	}
+}
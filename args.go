@@ -0,0 +1,40 @@
+package gaetest
+
+import (
+	"fmt"
+	"strings"
+)
+
+// repeatableFlags are the flags of dev_appserver.py that may be given several
+// times.
+var repeatableFlags = map[string]bool{"env_var": true}
+
+// flagName returns the name of the flag set by arg, e.g. "port" for
+// "--port=8080", or "" if arg is not a flag.
+func flagName(arg string) string {
+	if !strings.HasPrefix(arg, "-") {
+		return ""
+	}
+	name := strings.TrimLeft(arg, "-")
+	if i := strings.IndexByte(name, '='); i >= 0 {
+		name = name[:i]
+	}
+	return name
+}
+
+// appendExtraArgs appends extra, from Options.ExtraArgs, to the arguments
+// generated by the package. It fails if extra sets one of the flags in args.
+func appendExtraArgs(args, extra []string) ([]string, error) {
+	set := make(map[string]bool)
+	for _, arg := range args {
+		if name := flagName(arg); name != "" && !repeatableFlags[name] {
+			set[name] = true
+		}
+	}
+	for _, arg := range extra {
+		if name := flagName(arg); set[name] {
+			return nil, fmt.Errorf("extra argument %q conflicts with --%s, which gaetest sets from Options", arg, name)
+		}
+	}
+	return append(args, extra...), nil
+}
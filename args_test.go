@@ -0,0 +1,24 @@
+package gaetest
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAppendExtraArgs(t *testing.T) {
+	args := []string{"--port=0", "--max_module_instances=1", "--env_var=A=1"}
+	got, err := appendExtraArgs(args, []string{"--require_indexes=yes", "--env_var=B=2"})
+	if err != nil {
+		t.Fatalf("appendExtraArgs returned %v, expected nil", err)
+	}
+	expect := []string{"--port=0", "--max_module_instances=1", "--env_var=A=1", "--require_indexes=yes", "--env_var=B=2"}
+	if !reflect.DeepEqual(got, expect) {
+		t.Fatalf("got %q, but expect %q", got, expect)
+	}
+
+	_, err = appendExtraArgs(args, []string{"--max_module_instances", "2"})
+	expectErr := `extra argument "--max_module_instances" conflicts with --max_module_instances, which gaetest sets from Options`
+	if err == nil || err.Error() != expectErr {
+		t.Fatalf("got %v, but expect %q", err, expectErr)
+	}
+}
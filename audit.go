@@ -0,0 +1,242 @@
+package gaetest
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// CacheRoute is a route checked by AuditCaching.
+type CacheRoute struct {
+	Path string
+	// Private routes serve data of the logged in user, which shared caches
+	// must not store.
+	Private bool
+}
+
+// cacheDirectives parses a Cache-Control header into its directives, mapped
+// to their values.
+func cacheDirectives(h http.Header) map[string]string {
+	d := make(map[string]string)
+	for _, v := range h["Cache-Control"] {
+		for _, part := range strings.Split(v, ",") {
+			name, value := strings.TrimSpace(part), ""
+			if i := strings.Index(name, "="); i >= 0 {
+				name, value = name[:i], strings.Trim(name[i+1:], `"`)
+			}
+			if name != "" {
+				d[strings.ToLower(name)] = value
+			}
+		}
+	}
+	return d
+}
+
+// get requests path on the app with c and returns the response, its body
+// drained and closed.
+func (sv *Server) get(c *http.Client, path string, header http.Header) (*http.Response, error) {
+	req := sv.newRequest("GET", path, nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	res, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+	return res, nil
+}
+
+// AuditCaching requests each route with the client returned by Client and
+// checks its caching headers: the response must state a policy with
+// Cache-Control or Expires, private routes must not be cacheable by shared
+// caches, and conditional requests with the ETag or Last-Modified of the
+// response must be answered with 304 Not Modified. Problems are reported with
+// t.Errorf.
+func (sv *Server) AuditCaching(t testing.TB, routes []CacheRoute) {
+	t.Helper()
+	client := sv.Client()
+	for _, r := range routes {
+		res, err := sv.get(client, r.Path, nil)
+		if err != nil {
+			t.Errorf("%s: %v", r.Path, err)
+			continue
+		}
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("%s: got status %d, but expect 200", r.Path, res.StatusCode)
+			continue
+		}
+		d := cacheDirectives(res.Header)
+		if len(d) == 0 && res.Header.Get("Expires") == "" {
+			t.Errorf("%s: no Cache-Control or Expires header", r.Path)
+		}
+		if exp := res.Header.Get("Expires"); exp != "" && exp != "0" && exp != "-1" {
+			if _, err := http.ParseTime(exp); err != nil {
+				t.Errorf("%s: invalid Expires %q", r.Path, exp)
+			}
+		}
+		if r.Private {
+			_, private := d["private"]
+			_, noStore := d["no-store"]
+			if !private && !noStore {
+				t.Errorf("%s: private data is cacheable, Cache-Control %q lacks private or no-store", r.Path, res.Header.Get("Cache-Control"))
+			}
+			if _, ok := d["s-maxage"]; ok {
+				t.Errorf("%s: private data is cacheable by shared caches, Cache-Control %q sets s-maxage", r.Path, res.Header.Get("Cache-Control"))
+			}
+		}
+		if etag := res.Header.Get("ETag"); etag != "" {
+			sv.checkConditional(t, client, r.Path, "If-None-Match", etag)
+		}
+		if mod := res.Header.Get("Last-Modified"); mod != "" {
+			sv.checkConditional(t, client, r.Path, "If-Modified-Since", mod)
+		}
+	}
+}
+
+// checkConditional checks that the app answers a request to path with the
+// precondition header set to value with 304 Not Modified.
+func (sv *Server) checkConditional(t testing.TB, c *http.Client, path, header, value string) {
+	t.Helper()
+	res, err := sv.get(c, path, http.Header{header: {value}})
+	if err != nil {
+		t.Errorf("%s with %s: %v", path, header, err)
+		return
+	}
+	if res.StatusCode != http.StatusNotModified {
+		t.Errorf("%s with %s %q: got status %d, but expect 304", path, header, value, res.StatusCode)
+	}
+}
+
+// SecurityPolicy lists the security headers AuditSecurityHeaders requires.
+// Zero fields are not checked.
+type SecurityPolicy struct {
+	// Minimum max-age of Strict-Transport-Security.
+	HSTSMaxAge time.Duration
+	// Value of X-Frame-Options, e.g. "DENY" or "SAMEORIGIN".
+	FrameOptions string
+	// Require a Content-Security-Policy header.
+	CSP bool
+	// Require X-Content-Type-Options: nosniff.
+	NoSniff bool
+	// Value of Referrer-Policy, e.g. "no-referrer".
+	ReferrerPolicy string
+}
+
+// hstsMaxAge returns the max-age of a Strict-Transport-Security header.
+func hstsMaxAge(v string) (time.Duration, bool) {
+	for _, part := range strings.Split(v, ";") {
+		part = strings.TrimSpace(part)
+		if !strings.HasPrefix(strings.ToLower(part), "max-age=") {
+			continue
+		}
+		secs, err := strconv.ParseInt(strings.Trim(part[len("max-age="):], `"`), 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	return 0, false
+}
+
+// AuditSecurityHeaders requests each route with the client returned by
+// Client and checks its response headers against policy. Problems are
+// reported with t.Errorf.
+func (sv *Server) AuditSecurityHeaders(t testing.TB, routes []string, policy SecurityPolicy) {
+	t.Helper()
+	client := sv.Client()
+	for _, path := range routes {
+		res, err := sv.get(client, path, nil)
+		if err != nil {
+			t.Errorf("%s: %v", path, err)
+			continue
+		}
+		h := res.Header
+		if policy.HSTSMaxAge > 0 {
+			if v := h.Get("Strict-Transport-Security"); v == "" {
+				t.Errorf("%s: no Strict-Transport-Security header", path)
+			} else if age, ok := hstsMaxAge(v); !ok || age < policy.HSTSMaxAge {
+				t.Errorf("%s: got Strict-Transport-Security %q, but expect a max-age of at least %v", path, v, policy.HSTSMaxAge)
+			}
+		}
+		if policy.FrameOptions != "" && !strings.EqualFold(h.Get("X-Frame-Options"), policy.FrameOptions) {
+			t.Errorf("%s: got X-Frame-Options %q, but expect %q", path, h.Get("X-Frame-Options"), policy.FrameOptions)
+		}
+		if policy.CSP && h.Get("Content-Security-Policy") == "" {
+			t.Errorf("%s: no Content-Security-Policy header", path)
+		}
+		if policy.NoSniff && !strings.EqualFold(h.Get("X-Content-Type-Options"), "nosniff") {
+			t.Errorf("%s: got X-Content-Type-Options %q, but expect %q", path, h.Get("X-Content-Type-Options"), "nosniff")
+		}
+		if policy.ReferrerPolicy != "" && !strings.EqualFold(h.Get("Referrer-Policy"), policy.ReferrerPolicy) {
+			t.Errorf("%s: got Referrer-Policy %q, but expect %q", path, h.Get("Referrer-Policy"), policy.ReferrerPolicy)
+		}
+	}
+}
+
+// CookiePolicy lists the attributes AuditCookies requires of cookies.
+type CookiePolicy struct {
+	// Names of the cookies checked. Empty checks every cookie.
+	Names    []string
+	Secure   bool
+	HttpOnly bool
+	// SameSite mode required, unless it is zero.
+	SameSite http.SameSite
+}
+
+func (p *CookiePolicy) covers(name string) bool {
+	if len(p.Names) == 0 {
+		return true
+	}
+	for _, n := range p.Names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+var sameSiteNames = map[http.SameSite]string{
+	0:                        "unset",
+	http.SameSiteDefaultMode: "default",
+	http.SameSiteLaxMode:     "Lax",
+	http.SameSiteStrictMode:  "Strict",
+	http.SameSiteNoneMode:    "None",
+}
+
+// AuditCookies requests each route with the client returned by Client and
+// checks the attributes of the cookies the app sets against policy. The
+// requests carry X-Forwarded-Proto: https, as they do in production behind
+// the TLS front end of App Engine, so that apps only marking cookies Secure
+// over HTTPS are judged by their production behavior. Cookies being deleted
+// are not checked. Problems are reported with t.Errorf.
+func (sv *Server) AuditCookies(t testing.TB, routes []string, policy CookiePolicy) {
+	t.Helper()
+	client := sv.Client()
+	for _, path := range routes {
+		res, err := sv.get(client, path, http.Header{"X-Forwarded-Proto": {"https"}})
+		if err != nil {
+			t.Errorf("%s: %v", path, err)
+			continue
+		}
+		for _, c := range res.Cookies() {
+			if !policy.covers(c.Name) || c.MaxAge < 0 {
+				continue
+			}
+			if policy.Secure && !c.Secure {
+				t.Errorf("%s: cookie %s is not Secure", path, c.Name)
+			}
+			if policy.HttpOnly && !c.HttpOnly {
+				t.Errorf("%s: cookie %s is not HttpOnly", path, c.Name)
+			}
+			if policy.SameSite != 0 && c.SameSite != policy.SameSite {
+				t.Errorf("%s: cookie %s has SameSite %s, but expect %s", path, c.Name, sameSiteNames[c.SameSite], sameSiteNames[policy.SameSite])
+			}
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package gaetest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuditCaching(t *testing.T) {
+	modified := time.Date(2016, 10, 2, 21, 48, 0, 0, time.UTC)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/static":
+			w.Header().Set("Cache-Control", "public, max-age=3600")
+			w.Header().Set("ETag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		case "/profile":
+			w.Header().Set("Cache-Control", "private, max-age=60")
+			http.ServeContent(w, r, "", modified, strings.NewReader("hello"))
+			return
+		case "/account":
+			w.Header().Set("Cache-Control", "max-age=60, s-maxage=60")
+			w.Header().Set("ETag", `"v2"`)
+		}
+		w.Write([]byte("hello"))
+	}))
+	defer ts.Close()
+	sv := &Server{ModuleURL: ts.URL, opts: withDefaults(nil)}
+
+	sv.AuditCaching(t, []CacheRoute{{Path: "/static"}, {Path: "/profile", Private: true}})
+
+	ft := &fakeTB{}
+	sv.AuditCaching(ft, []CacheRoute{{Path: "/none"}, {Path: "/account", Private: true}})
+	expect := []string{
+		"/none: no Cache-Control or Expires header",
+		`/account: private data is cacheable, Cache-Control "max-age=60, s-maxage=60" lacks private or no-store`,
+		`/account: private data is cacheable by shared caches, Cache-Control "max-age=60, s-maxage=60" sets s-maxage`,
+		`/account with If-None-Match "\"v2\"": got status 200, but expect 304`,
+	}
+	if !reflect.DeepEqual(ft.errors, expect) {
+		t.Fatalf("got %q, but expect %q", ft.errors, expect)
+	}
+}
+
+func TestAuditSecurityHeaders(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/secure" {
+			w.Header().Set("Strict-Transport-Security", "max-age=31536000; includeSubDomains")
+			w.Header().Set("X-Frame-Options", "deny")
+			w.Header().Set("Content-Security-Policy", "default-src 'self'")
+			w.Header().Set("X-Content-Type-Options", "nosniff")
+		} else {
+			w.Header().Set("Strict-Transport-Security", "max-age=60")
+		}
+	}))
+	defer ts.Close()
+	sv := &Server{ModuleURL: ts.URL, opts: withDefaults(nil)}
+	policy := SecurityPolicy{HSTSMaxAge: 180 * 24 * time.Hour, FrameOptions: "DENY", CSP: true, NoSniff: true}
+
+	sv.AuditSecurityHeaders(t, []string{"/secure"}, policy)
+
+	ft := &fakeTB{}
+	sv.AuditSecurityHeaders(ft, []string{"/"}, policy)
+	expect := []string{
+		`/: got Strict-Transport-Security "max-age=60", but expect a max-age of at least 4320h0m0s`,
+		`/: got X-Frame-Options "", but expect "DENY"`,
+		"/: no Content-Security-Policy header",
+		`/: got X-Content-Type-Options "", but expect "nosniff"`,
+	}
+	if !reflect.DeepEqual(ft.errors, expect) {
+		t.Fatalf("got %q, but expect %q", ft.errors, expect)
+	}
+}
+
+func TestAuditCookies(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secure := r.Header.Get("X-Forwarded-Proto") == "https"
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "s3cr3t", Secure: secure, HttpOnly: true, SameSite: http.SameSiteLaxMode})
+		http.SetCookie(w, &http.Cookie{Name: "prefs", Value: "dark"})
+		http.SetCookie(w, &http.Cookie{Name: "old", MaxAge: -1})
+	}))
+	defer ts.Close()
+	sv := &Server{ModuleURL: ts.URL, opts: withDefaults(nil)}
+	policy := CookiePolicy{Secure: true, HttpOnly: true, SameSite: http.SameSiteLaxMode}
+
+	sv.AuditCookies(t, []string{"/"}, CookiePolicy{Names: []string{"session"}, Secure: true, HttpOnly: true, SameSite: http.SameSiteLaxMode})
+
+	ft := &fakeTB{}
+	sv.AuditCookies(ft, []string{"/"}, policy)
+	expect := []string{
+		"/: cookie prefs is not Secure",
+		"/: cookie prefs is not HttpOnly",
+		"/: cookie prefs has SameSite unset, but expect Lax",
+	}
+	if !reflect.DeepEqual(ft.errors, expect) {
+		t.Fatalf("got %q, but expect %q", ft.errors, expect)
+	}
+}
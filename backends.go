@@ -0,0 +1,93 @@
+package gaetest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+)
+
+// verifyBackendsTimeout bounds Options.VerifyBackends.
+const verifyBackendsTimeout = 10 * time.Second
+
+// probe requests u with client and succeeds on any HTTP response.
+func probe(ctx context.Context, client *http.Client, u string) error {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	res, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// withContext runs f, returning early with the error of ctx if it is done
+// first.
+func withContext(ctx context.Context, f func() error) error {
+	errc := make(chan error, 1)
+	go func() { errc <- f() }()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// VerifyBackends checks end to end that the services the app depends on
+// answer, so that tests fail fast with a clear message instead of scattered
+// errors: the API server, the datastore stub through the API server, the
+// memcache stub through the admin console, and the stubs started for
+// Options.OAuthStub, InterceptOutbound and Externals. It returns the first
+// failure.
+func (sv *Server) VerifyBackends(ctx context.Context) error {
+	if err := probe(ctx, sv.httpClient(), sv.APIURL); err != nil {
+		return fmt.Errorf("API server unreachable: %v", err)
+	}
+	if err := withContext(ctx, func() error {
+		_, err := sv.admin.get(adminMemcachePath, nil)
+		return err
+	}); err != nil {
+		return fmt.Errorf("memcache stub unreachable: %v", err)
+	}
+	if err := withContext(ctx, func() error {
+		_, err := sv.query("", "__kind__", true)
+		return err
+	}); err != nil {
+		return fmt.Errorf("datastore stub unreachable: %v", err)
+	}
+	if sv.oauth != nil {
+		if err := probe(ctx, sv.httpClient(), sv.oauth.URL+"/userinfo"); err != nil {
+			return fmt.Errorf("OAuth stub unreachable: %v", err)
+		}
+	}
+	if sv.outbound != nil {
+		u, err := url.Parse(sv.outbound.srv.URL)
+		if err != nil {
+			return err
+		}
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", u.Host)
+		if err != nil {
+			return fmt.Errorf("outbound proxy unreachable: %v", err)
+		}
+		conn.Close()
+	}
+	names := make([]string, 0, len(sv.externals))
+	for name := range sv.externals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := probe(ctx, sv.httpClient(), sv.externals[name]); err != nil {
+			return fmt.Errorf("external %q unreachable: %v", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package gaetest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestVerifyBackends(t *testing.T) {
+	var posts []*http.Request
+	ts := newAdminStub(&posts)
+	defer ts.Close()
+	var stored []storedEntity
+	api := newDatastoreStub(&stored)
+	defer api.Close()
+	external := httptest.NewServer(http.NotFoundHandler())
+
+	sv := &Server{
+		opts:      withDefaults(nil),
+		APIURL:    api.URL,
+		admin:     &admin{url: ts.URL},
+		externals: map[string]string{"billing": external.URL},
+	}
+	if err := sv.VerifyBackends(context.Background()); err != nil {
+		t.Fatalf("VerifyBackends returned %v, expected nil", err)
+	}
+	external.Close()
+	err := sv.VerifyBackends(context.Background())
+	if err == nil || !strings.HasPrefix(err.Error(), `external "billing" unreachable`) {
+		t.Fatalf("got %v, but expect the billing external to be reported", err)
+	}
+}
@@ -0,0 +1,68 @@
+package gaetest
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// PhaseStats summarizes the durations of a phase of starting or stopping the
+// server over several runs.
+type PhaseStats struct {
+	Phase string        `json:"phase"`
+	Mean  time.Duration `json:"mean_ns"`
+	P50   time.Duration `json:"p50_ns"`
+	P95   time.Duration `json:"p95_ns"`
+	Max   time.Duration `json:"max_ns"`
+}
+
+func (s PhaseStats) String() string {
+	return fmt.Sprintf("%s: mean %v, p50 %v, p95 %v, max %v", s.Phase, s.Mean, s.P50, s.P95, s.Max)
+}
+
+func phaseStats(phase string, durations []time.Duration) PhaseStats {
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	var total time.Duration
+	for _, d := range sorted {
+		total += d
+	}
+	return PhaseStats{
+		Phase: phase,
+		Mean:  total / time.Duration(len(sorted)),
+		P50:   percentile(sorted, 50),
+		P95:   percentile(sorted, 95),
+		Max:   sorted[len(sorted)-1],
+	}
+}
+
+// BenchmarkStartup starts and closes a server for the app at appDir n times
+// and reports how long the phases took: "spawn" until the dev_appserver.py
+// process was running, "startup" until New returned and "close" for Close.
+// It quantifies the overhead of the package and the effect of options or SDK
+// changes on it.
+func BenchmarkStartup(appDir string, opts *Options, n int) ([]PhaseStats, error) {
+	if n <= 0 {
+		return nil, errors.New("n must be positive")
+	}
+	var spawn, startup, stop []time.Duration
+	for i := 0; i < n; i++ {
+		sv, err := New(appDir, opts)
+		if err != nil {
+			return nil, fmt.Errorf("run %d: %v", i+1, err)
+		}
+		spawn = append(spawn, sv.timings.Spawn)
+		startup = append(startup, sv.timings.Startup)
+		start := time.Now()
+		if err := sv.Close(); err != nil {
+			return nil, fmt.Errorf("run %d: %v", i+1, err)
+		}
+		stop = append(stop, time.Since(start))
+	}
+	return []PhaseStats{
+		phaseStats("spawn", spawn),
+		phaseStats("startup", startup),
+		phaseStats("close", stop),
+	}, nil
+}
@@ -0,0 +1,14 @@
+package gaetest
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPhaseStats(t *testing.T) {
+	s := phaseStats("startup", []time.Duration{3 * time.Second, time.Second, 2 * time.Second})
+	expect := PhaseStats{Phase: "startup", Mean: 2 * time.Second, P50: 2 * time.Second, P95: 3 * time.Second, Max: 3 * time.Second}
+	if s != expect {
+		t.Fatalf("got %v, but expect %v", s, expect)
+	}
+}
@@ -0,0 +1,95 @@
+package gaetest
+
+import "strings"
+
+// capabilityDisabledStatus is the DISABLED value of the summary_status of a
+// capability_service.IsEnabledResponse.
+const capabilityDisabledStatus = 4
+
+// writeMethods are the datastore_v3 calls failed when its write capability is
+// disabled.
+var writeMethods = map[string]bool{"Put": true, "Delete": true, "Commit": true}
+
+// capabilityDisabled returns an IsEnabledResponse reporting a disabled
+// capability.
+func capabilityDisabled() []byte {
+	var res pbMessage
+	res.int64(1, capabilityDisabledStatus)
+	return res.buf.Bytes()
+}
+
+// isDisabled reports whether c is a call to a disabled service or capability.
+func (p *apiProxy) isDisabled(c *APICall) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.disabled[c.Service] || c.Service == "datastore_v3" && writeMethods[c.Method] && p.disabled["datastore_v3.write"]
+}
+
+// reportsDisabled reports whether req, a capability_service.IsEnabledRequest,
+// asks about a disabled service or capability.
+func (p *apiProxy) reportsDisabled(req []byte) bool {
+	var pkg string
+	var capabilities []string
+	fields, _ := parsePB(req)
+	for _, f := range fields {
+		switch f.num {
+		case 1:
+			pkg = string(f.data)
+		case 2:
+			capabilities = append(capabilities, string(f.data))
+		}
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.disabled[pkg] {
+		return true
+	}
+	for name, disabled := range p.disabled {
+		if !disabled || !strings.HasPrefix(name, pkg+".") {
+			continue
+		}
+		for _, c := range capabilities {
+			if c == "*" || pkg+"."+c == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (p *apiProxy) disable(service string, disabled bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.disabled == nil {
+		p.disabled = make(map[string]bool)
+	}
+	p.disabled[service] = disabled
+}
+
+// SetCapability makes service unavailable to the app, as during an App Engine
+// outage or maintenance, or available again. service is an API package, e.g.
+// "memcache" or "mail", or "datastore_v3.write" for the datastore read-only
+// mode, where only writes fail. The calls of a disabled service fail with the
+// error appengine.IsCapabilityDisabled reports, and capability.Enabled reports
+// it as disabled. It requires Options.RecordAPICalls.
+func (sv *Server) SetCapability(service string, enabled bool) error {
+	if err := sv.requireAPIProxy("SetCapability"); err != nil {
+		return err
+	}
+	sv.api.disable(service, !enabled)
+	return nil
+}
+
+// DisableMemcache makes memcache unavailable to the app, as during an App
+// Engine outage: see SetCapability. Apps are expected to keep working, if
+// slower, by falling back to the datastore. It requires
+// Options.RecordAPICalls.
+func (sv *Server) DisableMemcache() error {
+	return sv.SetCapability("memcache", false)
+}
+
+// EnableMemcache makes memcache available again after DisableMemcache. The
+// cached values are kept, as they would be after an outage.
+func (sv *Server) EnableMemcache() error {
+	return sv.SetCapability("memcache", true)
+}
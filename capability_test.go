@@ -0,0 +1,100 @@
+package gaetest
+
+import "testing"
+
+func TestDisableMemcache(t *testing.T) {
+	var calls []string
+	ts := newModulesStub(&calls)
+	defer ts.Close()
+	p, err := newAPIProxy("127.0.0.1")
+	if err != nil {
+		t.Fatalf("newAPIProxy returned %v, expected nil", err)
+	}
+	defer p.Close()
+	p.target = ts.URL
+	sv := &Server{APIURL: p.srv.URL + "/rpc_http", api: p}
+
+	expect := "gaetest: SetCapability requires Options.RecordAPICalls"
+	if err := (&Server{}).DisableMemcache(); err == nil || err.Error() != expect {
+		t.Fatalf("got %v, but expect %q", err, expect)
+	}
+	if err := sv.DisableMemcache(); err != nil {
+		t.Fatalf("DisableMemcache returned %v, expected nil", err)
+	}
+	expect = "memcache.Get: " + ErrCapabilityDisabled.Error()
+	if _, err := sv.callAPI("memcache", "Get", nil); err == nil || err.Error() != expect {
+		t.Fatalf("got %v, but expect %q", err, expect)
+	}
+	var req pbMessage
+	req.string(1, "memcache")
+	req.string(2, "*")
+	data, err := sv.callAPI("capability_service", "IsEnabled", req.buf.Bytes())
+	if err != nil {
+		t.Fatalf("callAPI returned %v, expected nil", err)
+	}
+	if fields, _ := parsePB(data); len(fields) != 1 || fields[0].varint != capabilityDisabledStatus {
+		t.Fatalf("got %v, but expect the DISABLED status", fields)
+	}
+	if len(calls) != 0 {
+		t.Fatalf("got %d calls, but expect none to reach the stub", len(calls))
+	}
+	if recorded := sv.APICalls(); !recorded[0].Injected || recorded[0].Err != ErrCapabilityDisabled {
+		t.Fatalf("got %+v, but expect a disabled call", recorded[0])
+	}
+
+	if err := sv.EnableMemcache(); err != nil {
+		t.Fatalf("EnableMemcache returned %v, expected nil", err)
+	}
+	if _, err := sv.callAPI("memcache", "Get", nil); err != nil {
+		t.Fatalf("callAPI returned %v, expected nil", err)
+	}
+	if len(calls) != 1 {
+		t.Fatalf("got %d calls, but expect the call to reach the stub", len(calls))
+	}
+}
+
+func TestSetCapability(t *testing.T) {
+	var calls []string
+	ts := newModulesStub(&calls)
+	defer ts.Close()
+	p, err := newAPIProxy("127.0.0.1")
+	if err != nil {
+		t.Fatalf("newAPIProxy returned %v, expected nil", err)
+	}
+	defer p.Close()
+	p.target = ts.URL
+	sv := &Server{APIURL: p.srv.URL + "/rpc_http", api: p}
+
+	if err := sv.SetCapability("datastore_v3.write", false); err != nil {
+		t.Fatalf("SetCapability returned %v, expected nil", err)
+	}
+	expect := "datastore_v3.Put: " + ErrCapabilityDisabled.Error()
+	if _, err := sv.callAPI("datastore_v3", "Put", nil); err == nil || err.Error() != expect {
+		t.Fatalf("got %v, but expect %q", err, expect)
+	}
+	if _, err := sv.callAPI("datastore_v3", "Get", nil); err != nil {
+		t.Fatalf("callAPI returned %v for a read, expected nil", err)
+	}
+	for _, test := range []struct {
+		capability string
+		disabled   bool
+	}{
+		{"write", true},
+		{"*", true},
+		{"read", false},
+	} {
+		var req pbMessage
+		req.string(1, "datastore_v3")
+		req.string(2, test.capability)
+		if disabled := p.reportsDisabled(req.buf.Bytes()); disabled != test.disabled {
+			t.Fatalf("%s: got %t, but expect %t", test.capability, disabled, test.disabled)
+		}
+	}
+
+	if err := sv.SetCapability("datastore_v3.write", true); err != nil {
+		t.Fatalf("SetCapability returned %v, expected nil", err)
+	}
+	if _, err := sv.callAPI("datastore_v3", "Put", nil); err != nil {
+		t.Fatalf("callAPI returned %v, expected nil", err)
+	}
+}
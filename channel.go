@@ -0,0 +1,109 @@
+package gaetest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// The Channel API stub of the dev server does not push messages: the
+// JavaScript client of the dev server polls the module server for them, one
+// message per request. ChannelClient polls the same endpoint.
+
+// channelPath is the endpoint the JavaScript client of the dev server polls.
+const channelPath = "/_ah/channel/dev"
+
+// channelPollInterval is the delay between the polls finding no message.
+const channelPollInterval = 100 * time.Millisecond
+
+// ChannelClient receives the messages the app sends on a channel, like a
+// browser would.
+type ChannelClient struct {
+	// Messages receives the messages in the order the app sent them. It is
+	// closed by Close, or when polling fails.
+	Messages <-chan string
+
+	url    string
+	client *http.Client
+	done   chan struct{}
+	wg     sync.WaitGroup
+	err    error
+}
+
+// ConnectChannel connects to the channel with token, as returned by
+// channel.create_channel in the app, and polls it for messages until Close is
+// called.
+func (sv *Server) ConnectChannel(token string) (*ChannelClient, error) {
+	c := &ChannelClient{
+		url:    sv.ModuleURL + channelPath + "?channel=" + url.QueryEscape(token),
+		client: sv.httpClient(),
+		done:   make(chan struct{}),
+	}
+	if _, err := c.command("connect"); err != nil {
+		return nil, fmt.Errorf("gaetest: channel %s: %v", token, err)
+	}
+	messages := make(chan string)
+	c.Messages = messages
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		defer close(messages)
+		for {
+			msg, err := c.command("poll")
+			if err != nil {
+				c.err = fmt.Errorf("gaetest: channel %s: %v", token, err)
+				return
+			}
+			if msg == "" {
+				select {
+				case <-c.done:
+					return
+				case <-time.After(channelPollInterval):
+				}
+				continue
+			}
+			select {
+			case <-c.done:
+				return
+			case messages <- msg:
+			}
+		}
+	}()
+	return c, nil
+}
+
+// command sends command to the channel endpoint and returns the body of the
+// response.
+func (c *ChannelClient) command(command string) (string, error) {
+	res, err := c.client.Get(c.url + "&command=" + command)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: %s", command, res.Status)
+	}
+	return string(body), nil
+}
+
+// Close stops polling, disconnects from the channel, which makes the app
+// receive a disconnection if it handles presence, and returns the error that
+// stopped polling, if any. Messages not received yet are lost.
+func (c *ChannelClient) Close() error {
+	close(c.done)
+	c.wg.Wait()
+	if c.err != nil {
+		return c.err
+	}
+	if _, err := c.command("disconnect"); err != nil {
+		return fmt.Errorf("gaetest: channel: %v", err)
+	}
+	return nil
+}
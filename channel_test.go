@@ -0,0 +1,62 @@
+package gaetest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConnectChannel(t *testing.T) {
+	var mu sync.Mutex
+	queue := []string{"hello", "world"}
+	var commands []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != channelPath || r.FormValue("channel") != "token1" {
+			http.Error(w, "invalid channel", http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		command := r.FormValue("command")
+		if command != "poll" {
+			commands = append(commands, command)
+		}
+		if command == "poll" && len(queue) > 0 {
+			w.Write([]byte(queue[0]))
+			queue = queue[1:]
+		}
+	}))
+	defer ts.Close()
+	sv := &Server{ModuleURL: ts.URL}
+
+	if _, err := sv.ConnectChannel("unknown"); err == nil {
+		t.Fatal("ConnectChannel returned nil for an unknown token, expected an error")
+	}
+	c, err := sv.ConnectChannel("token1")
+	if err != nil {
+		t.Fatalf("ConnectChannel returned %v, expected nil", err)
+	}
+	for _, expect := range []string{"hello", "world"} {
+		select {
+		case msg := <-c.Messages:
+			if msg != expect {
+				t.Fatalf("got %q, but expect %q", msg, expect)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for %q", expect)
+		}
+	}
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close returned %v, expected nil", err)
+	}
+	if _, ok := <-c.Messages; ok {
+		t.Fatal("Messages is open after Close, expected it closed")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(commands) != 2 || commands[0] != "connect" || commands[1] != "disconnect" {
+		t.Fatalf("got commands %q, but expect connect and disconnect", commands)
+	}
+}
@@ -0,0 +1,68 @@
+package gaetest
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Request classes counted by RequestClasses.
+const (
+	ClassUser       = "user"
+	ClassWarmup     = "warmup"     // /_ah/warmup
+	ClassStart      = "start"      // /_ah/start
+	ClassStop       = "stop"       // /_ah/stop
+	ClassBackground = "background" // /_ah/background
+	// ClassOffline counts the requests of the push queues to their default
+	// paths under /_ah/queue, deferred functions included, and of the
+	// inbound mail service. Tasks and cron jobs sent to other paths are
+	// counted as user requests, since the request log does not tell them
+	// apart.
+	ClassOffline = "offline"
+)
+
+// requestClass returns the class of a request to path.
+func requestClass(path string) string {
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path = path[:i]
+	}
+	switch {
+	case path == "/_ah/warmup":
+		return ClassWarmup
+	case path == "/_ah/start":
+		return ClassStart
+	case path == "/_ah/stop":
+		return ClassStop
+	case path == "/_ah/background":
+		return ClassBackground
+	case strings.HasPrefix(path, "/_ah/queue/"), strings.HasPrefix(path, "/_ah/mail/"), strings.HasPrefix(path, "/_ah/bounce"):
+		return ClassOffline
+	}
+	return ClassUser
+}
+
+// RequestClasses returns the number of requests of each class the instances of
+// the app served since the server started, according to the request log.
+func (sv *Server) RequestClasses() map[string]int {
+	sv.logs.mu.Lock()
+	defer sv.logs.mu.Unlock()
+	classes := make(map[string]int)
+	for class, n := range sv.logs.classes {
+		classes[class] = n
+	}
+	return classes
+}
+
+// NewWarmupRequest returns the /_ah/warmup request App Engine sends to new
+// instances when warmup requests are enabled in app.yaml.
+func (sv *Server) NewWarmupRequest() *http.Request {
+	return sv.NewInternalRequest("GET", "/_ah/warmup", nil)
+}
+
+// NewBackgroundRequest returns the /_ah/background request App Engine sends
+// to start the background function the app registered under requestID, like
+// StartBackground but for the default module and without sending it.
+func (sv *Server) NewBackgroundRequest(requestID string) *http.Request {
+	req := sv.NewInternalRequest("GET", "/_ah/background", nil)
+	req.Header.Set("X-Appengine-Backgroundrequest", requestID)
+	return req
+}
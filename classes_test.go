@@ -0,0 +1,30 @@
+package gaetest
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestRequestClasses(t *testing.T) {
+	b, err := newLogBuffer(nil)
+	if err != nil {
+		t.Fatalf("newLogBuffer returned %v, expected nil", err)
+	}
+	for _, path := range []string{"/", "/_ah/warmup", "/_ah/queue/go/delay", "/items?page=2", "/_ah/start"} {
+		fmt.Fprintf(b, "INFO     2016-10-02 21:48:20,110 module.py:788] default: \"GET %s HTTP/1.1\" 200 2\n", path)
+	}
+	sv := &Server{logs: b}
+	expect := map[string]int{ClassUser: 2, ClassWarmup: 1, ClassOffline: 1, ClassStart: 1}
+	if got := sv.RequestClasses(); !reflect.DeepEqual(got, expect) {
+		t.Fatalf("got %v, but expect %v", got, expect)
+	}
+}
+
+func TestNewBackgroundRequest(t *testing.T) {
+	sv := &Server{ModuleURL: "http://localhost:8080"}
+	req := sv.NewBackgroundRequest("42")
+	if req.URL.Path != "/_ah/background" || req.Header.Get("X-Appengine-Backgroundrequest") != "42" {
+		t.Fatalf("got %s %v, but expect a background request for 42", req.URL.Path, req.Header)
+	}
+}
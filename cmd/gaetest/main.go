@@ -0,0 +1,61 @@
+// Command gaetest runs the tools of the gaetest package from the command line.
+//
+// Usage:
+//
+//	gaetest bench [-n runs] [-json] [-dev_appserver path] [-timeout seconds] appdir
+//
+// bench starts and closes the dev server for the app at appdir n times and
+// prints the mean and percentile durations of the phases of starting and
+// stopping it, see gaetest.BenchmarkStartup.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/kkrs/gaetest"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: gaetest bench [-n runs] [-json] [-dev_appserver path] [-timeout seconds] appdir")
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] != "bench" {
+		usage()
+	}
+	if err := bench(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "gaetest bench: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func bench(args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	fs.Usage = usage
+	n := fs.Int("n", 5, "number of runs")
+	asJSON := fs.Bool("json", false, "print the statistics as JSON")
+	opts := &gaetest.Options{}
+	fs.StringVar(&opts.DevAppServer, "dev_appserver", "", "path of dev_appserver.py")
+	fs.IntVar(&opts.Timeout, "timeout", 0, "startup timeout in seconds")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		usage()
+	}
+	stats, err := gaetest.BenchmarkStartup(fs.Arg(0), opts, *n)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(stats)
+	}
+	for _, s := range stats {
+		fmt.Println(s)
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package gaetest
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// NewContext is like New, but the server is closed when ctx is done. If ctx
+// has a deadline, it also bounds the startup: Options.Timeout is lowered to
+// the time left, rounded up to the second. Close may still be called, e.g.
+// from t.Cleanup, to stop the server earlier or to get the errors of stopping
+// it: those of the automatic Close are only logged, with Options.Debug.
+func NewContext(ctx context.Context, appDir string, opts *Options) (*Server, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	o := *withDefaults(opts)
+	opts = &o
+	if deadline, ok := ctx.Deadline(); ok {
+		left := int((time.Until(deadline) + time.Second - 1) / time.Second)
+		if left < opts.Timeout {
+			opts.Timeout = left
+		}
+	}
+	sv, err := New(appDir, opts)
+	if err != nil {
+		return sv, err
+	}
+	watch, cancel := context.WithCancel(ctx)
+	sv.stopWatch = cancel
+	go func() {
+		<-watch.Done()
+		if ctx.Err() == nil {
+			return // stopped by Close
+		}
+		if err := sv.Close(); err != nil && sv.opts.Debug {
+			log.Printf("closing dev_appserver.py on %v: %v", ctx.Err(), err)
+		}
+	}()
+	return sv, nil
+}
@@ -0,0 +1,14 @@
+package gaetest
+
+import (
+	"context"
+	"testing"
+)
+
+func TestNewContextDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := NewContext(ctx, "/tmp/app", nil); err != context.Canceled {
+		t.Fatalf("got %v, but expect %v", err, context.Canceled)
+	}
+}
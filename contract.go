@@ -0,0 +1,196 @@
+package gaetest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// pact is a contract file in the Pact format: the interactions a consumer
+// expects a provider to support.
+type pact struct {
+	Consumer struct {
+		Name string `json:"name"`
+	} `json:"consumer"`
+	Interactions []struct {
+		Description string `json:"description"`
+		Request     struct {
+			Method  string            `json:"method"`
+			Path    string            `json:"path"`
+			Query   json.RawMessage   `json:"query"`
+			Headers map[string]string `json:"headers"`
+			Body    json.RawMessage   `json:"body"`
+		} `json:"request"`
+		Response struct {
+			Status  int               `json:"status"`
+			Headers map[string]string `json:"headers"`
+			Body    json.RawMessage   `json:"body"`
+		} `json:"response"`
+	} `json:"interactions"`
+}
+
+// InteractionResult is the outcome of verifying an interaction of a contract.
+// The interaction is satisfied if Diffs is empty.
+type InteractionResult struct {
+	Description string
+	Diffs       []string
+}
+
+// VerifyContract loads the Pact contract file at path and replays its
+// interactions against the app with the client returned by Client. Responses
+// must have the expected status and headers; JSON bodies must contain the
+// expected values, although objects may have additional keys. Other bodies
+// must match exactly.
+func (sv *Server) VerifyContract(path string) ([]InteractionResult, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var p pact
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	client := sv.Client()
+	var results []InteractionResult
+	for _, in := range p.Interactions {
+		result := InteractionResult{Description: in.Description}
+		query, err := pactQuery(in.Request.Query)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %v", path, in.Description, err)
+		}
+		u := sv.ModuleURL + in.Request.Path
+		if query != "" {
+			u += "?" + query
+		}
+		method := strings.ToUpper(in.Request.Method)
+		if method == "" {
+			method = "GET"
+		}
+		req, err := http.NewRequest(method, u, bytes.NewReader(pactBody(in.Request.Body)))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %v", path, in.Description, err)
+		}
+		for k, v := range in.Request.Headers {
+			req.Header.Set(k, v)
+		}
+		res, err := client.Do(req)
+		if err != nil {
+			result.Diffs = append(result.Diffs, err.Error())
+			results = append(results, result)
+			continue
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			result.Diffs = append(result.Diffs, err.Error())
+			results = append(results, result)
+			continue
+		}
+		if expect := in.Response.Status; expect != 0 && res.StatusCode != expect {
+			result.Diffs = append(result.Diffs, fmt.Sprintf("status: got %d, expected %d", res.StatusCode, expect))
+		}
+		for k, v := range in.Response.Headers {
+			if got := res.Header.Get(k); got != v {
+				result.Diffs = append(result.Diffs, fmt.Sprintf("header %s: got %q, expected %q", k, got, v))
+			}
+		}
+		if len(in.Response.Body) > 0 {
+			result.Diffs = append(result.Diffs, diffBody(in.Response.Body, body)...)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// pactQuery returns the query of a request, given as a string or as a map of
+// names to lists of values.
+func pactQuery(raw json.RawMessage) (string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s, nil
+	}
+	var m map[string][]string
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return "", fmt.Errorf("invalid query %s", raw)
+	}
+	return url.Values(m).Encode(), nil
+}
+
+// pactBody returns the bytes to send for a body: strings as they are, other
+// values as JSON.
+func pactBody(raw json.RawMessage) []byte {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return []byte(s)
+	}
+	return raw
+}
+
+// diffBody compares a response body to the expected one.
+func diffBody(expect json.RawMessage, body []byte) []string {
+	var want, got interface{}
+	json.Unmarshal(expect, &want)
+	if s, ok := want.(string); ok {
+		if string(body) != s {
+			return []string{fmt.Sprintf("body: got %q, expected %q", body, s)}
+		}
+		return nil
+	}
+	if err := json.Unmarshal(body, &got); err != nil {
+		return []string{fmt.Sprintf("body: got %q, expected JSON", body)}
+	}
+	var diffs []string
+	diffJSON("$", want, got, &diffs)
+	return diffs
+}
+
+// diffJSON records where got does not match want. Objects in got may have
+// keys that want does not have.
+func diffJSON(path string, want, got interface{}, diffs *[]string) {
+	switch w := want.(type) {
+	case map[string]interface{}:
+		g, ok := got.(map[string]interface{})
+		if !ok {
+			*diffs = append(*diffs, fmt.Sprintf("%s: got %v, expected an object", path, got))
+			return
+		}
+		keys := make([]string, 0, len(w))
+		for k := range w {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			v, ok := g[k]
+			if !ok {
+				*diffs = append(*diffs, fmt.Sprintf("%s.%s: missing", path, k))
+				continue
+			}
+			diffJSON(path+"."+k, w[k], v, diffs)
+		}
+	case []interface{}:
+		g, ok := got.([]interface{})
+		if !ok || len(g) != len(w) {
+			*diffs = append(*diffs, fmt.Sprintf("%s: got %v, expected an array of %d elements", path, got, len(w)))
+			return
+		}
+		for i := range w {
+			diffJSON(fmt.Sprintf("%s[%d]", path, i), w[i], g[i], diffs)
+		}
+	default:
+		if !reflect.DeepEqual(want, got) {
+			*diffs = append(*diffs, fmt.Sprintf("%s: got %v, expected %v", path, got, want))
+		}
+	}
+}
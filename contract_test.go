@@ -0,0 +1,63 @@
+package gaetest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testPact = `{
+  "consumer": {"name": "web"},
+  "provider": {"name": "api"},
+  "interactions": [
+    {
+      "description": "a user",
+      "request": {"method": "GET", "path": "/users/1", "query": "fields=all"},
+      "response": {
+        "status": 200,
+        "headers": {"Content-Type": "application/json"},
+        "body": {"id": 1, "name": "Alice", "roles": ["admin"]}
+      }
+    },
+    {
+      "description": "a missing user",
+      "request": {"method": "GET", "path": "/users/2"},
+      "response": {"status": 404}
+    }
+  ]
+}`
+
+func TestVerifyContract(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("fields") != "all" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		w.Write([]byte(`{"id": 1, "name": "Bob", "roles": ["admin"], "extra": true}`))
+	}))
+	defer ts.Close()
+	dir, err := ioutil.TempDir("", "gaetest-pact")
+	if err != nil {
+		t.Fatalf("TempDir returned %v, expected nil", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "web-api.json")
+	ioutil.WriteFile(path, []byte(testPact), 0644)
+
+	sv := &Server{ModuleURL: ts.URL, opts: withDefaults(nil)}
+	results, err := sv.VerifyContract(path)
+	if err != nil {
+		t.Fatalf("VerifyContract returned %v, expected nil", err)
+	}
+	expect := []InteractionResult{
+		{Description: "a user", Diffs: []string{"$.name: got Bob, expected Alice"}},
+		{Description: "a missing user", Diffs: []string{"status: got 400, expected 404"}},
+	}
+	if !reflect.DeepEqual(results, expect) {
+		t.Fatalf("got %q, but expect %q", results, expect)
+	}
+}
@@ -0,0 +1,160 @@
+package gaetest
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+)
+
+// startControl serves the control API on Options.ControlAddr. It lets tools
+// that are not written in Go drive the server:
+//
+//	GET  /status     state, process ID and uptime
+//	GET  /endpoints  URLs of the module, admin and API servers and services
+//	POST /reset      clears the datastore and flushes memcache
+//	POST /snapshot   writes a triage bundle (see WriteTriageBundle)
+//	POST /restart    closes the server and starts it again with the same
+//	                 options; the URLs may change
+//
+// The requests are served one at a time. The control API stays up across
+// restarts.
+func (sv *Server) startControl() error {
+	if sv.control == nil {
+		l, err := net.Listen("tcp", sv.opts.ControlAddr)
+		if err != nil {
+			return err
+		}
+		mux := http.NewServeMux()
+		mux.HandleFunc("/status", sv.handleStatus)
+		mux.HandleFunc("/endpoints", sv.handleEndpoints)
+		mux.HandleFunc("/reset", sv.handleReset)
+		mux.HandleFunc("/snapshot", sv.handleSnapshot)
+		mux.HandleFunc("/restart", sv.handleRestart)
+		sv.control = &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sv.controlMu.Lock()
+			defer sv.controlMu.Unlock()
+			mux.ServeHTTP(w, r)
+		})}
+		go sv.control.Serve(l)
+		sv.ControlURL = "http://" + l.Addr().String()
+	}
+	sv.cleanups = append(sv.cleanups, sv.closeControl)
+	return nil
+}
+
+// closeControl stops the control API, unless restart keeps it.
+func (sv *Server) closeControl() error {
+	if sv.control == nil {
+		return nil
+	}
+	err := sv.control.Close()
+	sv.control = nil
+	return err
+}
+
+// restart closes the server and starts it again with the same options,
+// keeping the control API, which calls it, and the link to the context of
+// NewContext. The errors of closing, e.g. for log lines matching
+// Options.FailOnLogPattern, do not prevent the restart but are returned. A
+// Close called meanwhile waits for the restart, then closes the new server.
+func (sv *Server) restart() error {
+	sv.startMu.Lock()
+	defer sv.startMu.Unlock()
+	if err := sv.requireRunning("restart"); err != nil {
+		return err
+	}
+	if sv.detached {
+		return errors.New("gaetest: cannot restart a detached server")
+	}
+	sv.setRestarting(true)
+	defer sv.setRestarting(false)
+	if err := sv.transition(StateStopping); err != nil {
+		return err // closed in the meantime
+	}
+	control, stopWatch := sv.control, sv.stopWatch
+	sv.control, sv.stopWatch = nil, nil
+	errs := sv.shutdown()
+	sv.transition(StateStopped)
+	sv.control, sv.stopWatch = control, stopWatch
+	// Everything else is set up again from the options.
+	sv.env, sv.appEnv, sv.proxy = nil, nil, nil
+	err := sv.transition(StateConfigured)
+	if err == nil {
+		err = sv.launch()
+	}
+	if err != nil {
+		errs = append(errs, err)
+	}
+	// Let Close stop the control API, even if the server did not start.
+	sv.cleanups = append(sv.cleanups, sv.closeControl)
+	return errs.err()
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// requirePost rejects requests that are not POSTs, reporting whether it did.
+func requirePost(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	return true
+}
+
+func (sv *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]interface{}{
+		"state":   sv.Status().String(),
+		"pid":     sv.processID(),
+		"started": sv.timings.Started,
+		"uptime":  time.Since(sv.timings.Started).String(),
+	})
+}
+
+func (sv *Server) handleEndpoints(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]interface{}{
+		"module":   sv.ModuleURL,
+		"admin":    sv.AdminURL,
+		"api":      sv.APIURL,
+		"services": sv.services,
+	})
+}
+
+func (sv *Server) handleReset(w http.ResponseWriter, r *http.Request) {
+	if !requirePost(w, r) {
+		return
+	}
+	if err := sv.reset(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]string{"status": "ok"})
+}
+
+func (sv *Server) handleSnapshot(w http.ResponseWriter, r *http.Request) {
+	if !requirePost(w, r) {
+		return
+	}
+	dir, err := sv.WriteTriageBundle("snapshot")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]string{"path": dir})
+}
+
+func (sv *Server) handleRestart(w http.ResponseWriter, r *http.Request) {
+	if !requirePost(w, r) {
+		return
+	}
+	if err := sv.restart(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]string{"status": "ok"})
+}
@@ -0,0 +1,182 @@
+package gaetest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestControlAPI(t *testing.T) {
+	sv := &Server{
+		opts:      &Options{ControlAddr: "127.0.0.1:0"},
+		ModuleURL: "http://localhost:8080",
+		services:  map[string]string{"default": "http://localhost:8080"},
+	}
+	if err := sv.startControl(); err != nil {
+		t.Fatalf("startControl returned %v, expected nil", err)
+	}
+	defer sv.cleanup()
+
+	res, err := http.Get(sv.ControlURL + "/endpoints")
+	if err != nil {
+		t.Fatalf("Get returned %v, expected nil", err)
+	}
+	var endpoints map[string]interface{}
+	json.NewDecoder(res.Body).Decode(&endpoints)
+	res.Body.Close()
+	if endpoints["module"] != "http://localhost:8080" {
+		t.Fatalf("got %v, but expect the module URL", endpoints)
+	}
+
+	res, err = http.Get(sv.ControlURL + "/reset")
+	if err != nil {
+		t.Fatalf("Get returned %v, expected nil", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("got status %d, but expect %d", res.StatusCode, http.StatusMethodNotAllowed)
+	}
+
+	res, err = http.Post(sv.ControlURL+"/restart", "", nil)
+	if err != nil {
+		t.Fatalf("Post returned %v, expected nil", err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	expect := "gaetest: cannot restart a server that is configured\n"
+	if res.StatusCode != http.StatusInternalServerError || string(body) != expect {
+		t.Fatalf("got status %d and %q, but expect %d and %q", res.StatusCode, body, http.StatusInternalServerError, expect)
+	}
+}
+
+// fakeDevAppServer writes to dir a dev_appserver.py running this test binary
+// as TestFakeDevAppServer, which serves the modules default and worker,
+// answering "hello", until the /quit of its admin server.
+func fakeDevAppServer(t *testing.T, dir string) string {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake dev_appserver.py is a shell script")
+	}
+	path := filepath.Join(dir, "dev_appserver.py")
+	script := fmt.Sprintf("#!/bin/sh\nGAETEST_FAKE_DEVAPPSERVER=1 exec '%s' -test.run='^TestFakeDevAppServer$' -- \"$@\"\n", os.Args[0])
+	if err := ioutil.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatalf("WriteFile returned %v, expected nil", err)
+	}
+	return path
+}
+
+func TestFakeDevAppServer(t *testing.T) {
+	if os.Getenv("GAETEST_FAKE_DEVAPPSERVER") == "" {
+		t.Skip("only run as the dev_appserver.py of fakeDevAppServer")
+	}
+	serve := func(h http.HandlerFunc) string {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Listen returned %v, expected nil", err)
+		}
+		go http.Serve(l, h)
+		return "http://" + l.Addr().String()
+	}
+	api := serve(http.NotFound)
+	hello := func(w http.ResponseWriter, r *http.Request) { fmt.Fprint(w, "hello") }
+	module, worker := serve(hello), serve(hello)
+	admin := serve(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/quit" {
+			go func() {
+				time.Sleep(100 * time.Millisecond)
+				os.Exit(0)
+			}()
+		}
+	})
+	fmt.Fprintf(os.Stderr, "INFO     2016-10-02 21:48:16,776 api_server.py:205] Starting API server at: %s\n", api)
+	fmt.Fprintf(os.Stderr, "INFO     2016-10-02 21:48:16,904 dispatcher.py:197] Starting module \"default\" running at: %s\n", module)
+	fmt.Fprintf(os.Stderr, "INFO     2016-10-02 21:48:16,904 dispatcher.py:197] Starting module \"worker\" running at: %s\n", worker)
+	fmt.Fprintf(os.Stderr, "INFO     2016-10-02 21:48:16,905 admin_server.py:116] Starting admin server at: %s\n", admin)
+	select {}
+}
+
+// runningProcesses returns the live process groups launched for appDir.
+func runningProcesses(appDir string) []int {
+	launched.Lock()
+	defer launched.Unlock()
+	var pids []int
+	for pid, dir := range launched.groups {
+		if dir == appDir && processGroup(pid).alive() {
+			pids = append(pids, pid)
+		}
+	}
+	return pids
+}
+
+func TestRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gaetest")
+	if err != nil {
+		t.Fatalf("TempDir returned %v, expected nil", err)
+	}
+	defer os.RemoveAll(dir)
+	sv, err := New(dir, &Options{
+		DevAppServer: fakeDevAppServer(t, dir),
+		RuntimeDir:   dir,
+		StoragePath:  dir,
+		ControlAddr:  "127.0.0.1:0",
+		Timeout:      10,
+	})
+	if err != nil {
+		t.Fatalf("New returned %v, expected nil", err)
+	}
+	defer sv.Close()
+	pid := sv.processID()
+
+	res, err := http.Post(sv.ControlURL+"/restart", "", nil)
+	if err != nil {
+		t.Fatalf("Post returned %v, expected nil", err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d and %q, but expect %d", res.StatusCode, body, http.StatusOK)
+	}
+	if s := sv.Status(); s != StateReady {
+		t.Fatalf("got %v, but expect %v", s, StateReady)
+	}
+	if p := sv.processID(); p == 0 || p == pid {
+		t.Fatalf("got process %d, but expect a new process to replace %d", p, pid)
+	}
+	res, err = http.Get(sv.ModuleURL)
+	if err != nil {
+		t.Fatalf("Get returned %v, expected nil", err)
+	}
+	body, _ = ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if string(body) != "hello" {
+		t.Fatalf("got %q, but expect the restarted module to answer", body)
+	}
+	if pids := runningProcesses(dir); len(pids) != 1 || pids[0] != sv.processID() {
+		t.Fatalf("got processes %v, but expect only %d", pids, sv.processID())
+	}
+
+	// A Close during a restart closes the restarted server.
+	restarted := make(chan error, 1)
+	go func() { restarted <- sv.restart() }()
+	for !sv.isRestarting() {
+		time.Sleep(time.Millisecond)
+	}
+	if err := sv.Close(); err != nil {
+		t.Fatalf("Close returned %v, expected nil", err)
+	}
+	if err := <-restarted; err != nil {
+		t.Fatalf("restart returned %v, expected nil", err)
+	}
+	if s := sv.Status(); s != StateStopped {
+		t.Fatalf("got %v, but expect %v", s, StateStopped)
+	}
+	if pids := runningProcesses(dir); len(pids) != 0 || sv.processID() != 0 {
+		t.Fatalf("got processes %v, but expect none left after Close", pids)
+	}
+}
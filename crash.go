@@ -0,0 +1,111 @@
+package gaetest
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// maxStackLines is the number of lines of a panic kept for CrashLoopError.
+const maxStackLines = 50
+
+// ErrCrashLoop is matched by the errors reporting that the instances of the
+// app keep dying, see Options.CrashLoopRestarts.
+var ErrCrashLoop = errors.New("gaetest: app instances are crash looping")
+
+// CrashLoopError reports that the instances of the app were restarted
+// Restarts times within Options.CrashLoopWindow. Panic holds the last panic
+// logged by the app and the stack trace following it, if any.
+type CrashLoopError struct {
+	Restarts int
+	Window   time.Duration
+	Panic    []string
+}
+
+func (e *CrashLoopError) Error() string {
+	msg := fmt.Sprintf("%v: %d instance restarts within %v", ErrCrashLoop, e.Restarts, e.Window)
+	if len(e.Panic) > 0 {
+		msg += ", last " + e.Panic[0]
+	}
+	return msg
+}
+
+// Is makes errors.Is(err, ErrCrashLoop) true.
+func (e *CrashLoopError) Is(target error) bool {
+	return target == ErrCrashLoop
+}
+
+// crashDetector counts the instance starts logged after the server started,
+// keeping the last panic. It is used under the lock of its logBuffer.
+type crashDetector struct {
+	limit  int
+	window time.Duration
+	now    func() time.Time
+
+	armed   bool // set once the server started
+	starts  []time.Time
+	panic   []string
+	inPanic bool
+	tripped *CrashLoopError
+}
+
+func newCrashDetector(limit int, window time.Duration) *crashDetector {
+	if window == 0 {
+		window = time.Minute
+	}
+	return &crashDetector{limit: limit, window: window, now: time.Now}
+}
+
+func (d *crashDetector) line(line string) {
+	switch {
+	case strings.HasPrefix(line, "panic: "):
+		d.panic, d.inPanic = []string{line}, true
+	case d.inPanic && len(d.panic) < maxStackLines && !serverLogRE.MatchString(line):
+		d.panic = append(d.panic, line)
+	default:
+		d.inPanic = false
+	}
+	if !d.armed || d.tripped != nil || !instanceStartedRE.MatchString(line) {
+		return
+	}
+	now := d.now()
+	d.starts = append(d.starts, now)
+	for len(d.starts) > 0 && now.Sub(d.starts[0]) > d.window {
+		d.starts = d.starts[1:]
+	}
+	if len(d.starts) >= d.limit {
+		d.tripped = &CrashLoopError{
+			Restarts: len(d.starts),
+			Window:   d.window,
+			Panic:    append([]string(nil), d.panic...),
+		}
+	}
+}
+
+// arm starts counting instance starts, once the server started.
+func (b *logBuffer) arm() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.crash != nil {
+		b.crash.armed = true
+	}
+}
+
+// crashLoop returns the crash loop detected, if any.
+func (b *logBuffer) crashLoop() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.crash == nil || b.crash.tripped == nil {
+		return nil
+	}
+	return b.crash.tripped
+}
+
+// CrashLoop returns a *CrashLoopError if the instances of the app are crash
+// looping, see Options.CrashLoopRestarts, or nil otherwise. Once a crash loop
+// is detected, the proxy answers all requests with 503 Service Unavailable
+// and the error, and Close returns it.
+func (sv *Server) CrashLoop() error {
+	return sv.logs.crashLoop()
+}
@@ -0,0 +1,44 @@
+package gaetest
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCrashLoop(t *testing.T) {
+	b, err := newLogBuffer(nil)
+	if err != nil {
+		t.Fatalf("newLogBuffer returned %v, expected nil", err)
+	}
+	b.crash = newCrashDetector(3, time.Minute)
+	now := time.Date(2016, 10, 2, 21, 50, 0, 0, time.UTC)
+	b.crash.now = func() time.Time { return now }
+
+	started := "INFO     2016-10-02 21:48:18,000 instance.py:280] Instance PID: %d\n"
+	fmt.Fprintf(b, started, 1)
+	b.arm()
+	fmt.Fprintf(b, started, 2)
+	now = now.Add(2 * time.Minute)
+	fmt.Fprint(b, "panic: boom\n\ngoroutine 1 [running]:\nmain.handler()\n\t/app/main.go:12 +0x20\n")
+	fmt.Fprintf(b, started, 3)
+	if err := b.crashLoop(); err != nil {
+		t.Fatalf("got %v, but expect no crash loop", err)
+	}
+	fmt.Fprintf(b, started, 4)
+	fmt.Fprintf(b, started, 5)
+
+	err = b.crashLoop()
+	if !errors.Is(err, ErrCrashLoop) {
+		t.Fatalf("got %v, but expect ErrCrashLoop", err)
+	}
+	cl := err.(*CrashLoopError)
+	if cl.Restarts != 3 || len(cl.Panic) != 5 || cl.Panic[4] != "\t/app/main.go:12 +0x20" {
+		t.Fatalf("got %+v, but expect 3 restarts and the panic with its stack", cl)
+	}
+	expect := "gaetest: app instances are crash looping: 3 instance restarts within 1m0s, last panic: boom"
+	if err.Error() != expect {
+		t.Fatalf("got %q, but expect %q", err.Error(), expect)
+	}
+}
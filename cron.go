@@ -0,0 +1,413 @@
+package gaetest
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CronJob is a job defined in cron.yaml.
+type CronJob struct {
+	URL         string
+	Description string
+	Schedule    string
+	Timezone    string
+	Target      string
+
+	loc   *time.Location
+	sched schedule
+}
+
+// Next returns the next n times the job fires after from. The times are in the
+// time zone of the job.
+func (j *CronJob) Next(from time.Time, n int) []time.Time {
+	var times []time.Time
+	t := from.In(j.loc)
+	for len(times) < n {
+		t = j.sched.next(t)
+		if t.IsZero() {
+			break
+		}
+		times = append(times, t)
+	}
+	return times
+}
+
+// ValidateCron parses the cron.yaml file at path and validates the schedules of
+// its jobs. It returns the jobs so that their fire times can be previewed with
+// CronJob.Next.
+func ValidateCron(path string) ([]*CronJob, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	jobs, err := parseCron(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return jobs, nil
+}
+
+// CronJobs returns the jobs of the cron.yaml of the app, none if the app has
+// no cron.yaml.
+func (sv *Server) CronJobs() ([]*CronJob, error) {
+	jobs, err := ValidateCron(filepath.Join(sv.appDir, "cron.yaml"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return jobs, err
+}
+
+// RunCron runs the job of cron.yaml with path as URL now, sending the request
+// the admin server sends when a job is run from its cron page: a GET with
+// X-Appengine-Cron set, to the service of the target of the job if it has
+// one. It fails if no job has path as URL.
+func (sv *Server) RunCron(path string) (*http.Response, error) {
+	jobs, err := sv.CronJobs()
+	if err != nil {
+		return nil, err
+	}
+	for _, job := range jobs {
+		if job.URL != path {
+			continue
+		}
+		req := sv.NewCronRequest(path)
+		if job.Target != "" {
+			base, err := sv.serviceURL(job.Target)
+			if err != nil {
+				return nil, fmt.Errorf("cron job %s: %v", path, err)
+			}
+			if req.URL, err = url.Parse(base + path); err != nil {
+				return nil, err
+			}
+			req.Host = req.URL.Host
+		}
+		return sv.httpClient().Do(req)
+	}
+	return nil, fmt.Errorf("no cron job with url %s in cron.yaml", path)
+}
+
+func parseCron(data []byte) ([]*CronJob, error) {
+	doc, err := parseYAML(data)
+	if err != nil {
+		return nil, err
+	}
+	root, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("expected a mapping with a cron key")
+	}
+	entries, ok := root["cron"].([]interface{})
+	if !ok {
+		return nil, errors.New("expected a list of jobs under the cron key")
+	}
+	var jobs []*CronJob
+	for i, entry := range entries {
+		m, ok := entry.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("job %d: expected a mapping", i+1)
+		}
+		job := &CronJob{
+			URL:         yamlString(m, "url"),
+			Description: yamlString(m, "description"),
+			Schedule:    yamlString(m, "schedule"),
+			Timezone:    yamlString(m, "timezone"),
+			Target:      yamlString(m, "target"),
+		}
+		if job.URL == "" {
+			return nil, fmt.Errorf("job %d: missing url", i+1)
+		}
+		if !strings.HasPrefix(job.URL, "/") {
+			return nil, fmt.Errorf("job %d: url %q must start with /", i+1, job.URL)
+		}
+		if job.Schedule == "" {
+			return nil, fmt.Errorf("job %d: missing schedule", i+1)
+		}
+		job.loc = time.UTC
+		if job.Timezone != "" {
+			if job.loc, err = time.LoadLocation(job.Timezone); err != nil {
+				return nil, fmt.Errorf("job %d: invalid timezone %q", i+1, job.Timezone)
+			}
+		}
+		if job.sched, err = parseSchedule(job.Schedule); err != nil {
+			return nil, fmt.Errorf("job %d: invalid schedule %q: %v", i+1, job.Schedule, err)
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// schedule computes the fire times of a cron job.
+type schedule interface {
+	// next returns the first fire time after t, in the location of t, or the
+	// zero time if there is none.
+	next(t time.Time) time.Time
+}
+
+// parseSchedule parses the English-like schedule format of cron.yaml, either
+//
+//	every N (minutes|mins|hours) [synchronized | from HH:MM to HH:MM]
+//
+// or
+//
+//	(every|ORDINALS|DAYS-OF-MONTH) [day|WEEKDAYS] [of (month|MONTHS)] HH:MM
+func parseSchedule(s string) (schedule, error) {
+	fields := strings.Fields(strings.ToLower(s))
+	if len(fields) >= 3 && fields[0] == "every" {
+		if n, err := strconv.Atoi(fields[1]); err == nil {
+			return parseInterval(n, fields[2:])
+		}
+	}
+	return parseCalendar(fields)
+}
+
+// intervalSchedule fires every period, either relative to the previous run or
+// aligned to a daily time range.
+type intervalSchedule struct {
+	period     time.Duration
+	aligned    bool
+	start, end int // minutes since midnight of the range, if aligned
+}
+
+func parseInterval(n int, fields []string) (schedule, error) {
+	if n <= 0 {
+		return nil, errors.New("interval must be positive")
+	}
+	sched := &intervalSchedule{}
+	switch fields[0] {
+	case "minutes", "mins", "minute", "min":
+		sched.period = time.Duration(n) * time.Minute
+	case "hours", "hour":
+		sched.period = time.Duration(n) * time.Hour
+	default:
+		return nil, fmt.Errorf("unknown interval unit %q", fields[0])
+	}
+	switch rest := fields[1:]; {
+	case len(rest) == 0:
+	case len(rest) == 1 && rest[0] == "synchronized":
+		sched.aligned, sched.start, sched.end = true, 0, 24*60-1
+	case len(rest) == 4 && rest[0] == "from" && rest[2] == "to":
+		var err error
+		if sched.start, err = parseClock(rest[1]); err != nil {
+			return nil, err
+		}
+		if sched.end, err = parseClock(rest[3]); err != nil {
+			return nil, err
+		}
+		sched.aligned = true
+	default:
+		return nil, fmt.Errorf("unexpected %q", strings.Join(rest, " "))
+	}
+	return sched, nil
+}
+
+func (s *intervalSchedule) next(t time.Time) time.Time {
+	if !s.aligned {
+		return t.Add(s.period)
+	}
+	// Look at the range of the previous day too, it may extend past midnight.
+	day := time.Date(t.Year(), t.Month(), t.Day()-1, 0, 0, 0, 0, t.Location())
+	for i := 0; i < 3; i++ {
+		start := day.Add(time.Duration(s.start) * time.Minute)
+		end := day.Add(time.Duration(s.end) * time.Minute)
+		if s.end < s.start {
+			end = end.Add(24 * time.Hour)
+		}
+		for fire := start; !fire.After(end); fire = fire.Add(s.period) {
+			if fire.After(t) {
+				return fire
+			}
+		}
+		day = day.AddDate(0, 0, 1)
+	}
+	return time.Time{}
+}
+
+// calendarSchedule fires at a time of day on the days it matches.
+type calendarSchedule struct {
+	ordinals  map[int]bool // nth weekday of the month; nil means every
+	monthDays map[int]bool // days of the month; nil means not used
+	weekdays  map[time.Weekday]bool
+	months    map[time.Month]bool
+	clock     int // minutes since midnight
+}
+
+var ordinalNames = map[string]int{
+	"1st": 1, "first": 1, "2nd": 2, "second": 2, "3rd": 3, "third": 3,
+	"4th": 4, "fourth": 4, "5th": 5, "fifth": 5,
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sunday": time.Sunday, "monday": time.Monday, "tuesday": time.Tuesday,
+	"wednesday": time.Wednesday, "thursday": time.Thursday, "friday": time.Friday,
+	"saturday": time.Saturday,
+}
+
+var monthNames = map[string]time.Month{
+	"january": time.January, "february": time.February, "march": time.March,
+	"april": time.April, "may": time.May, "june": time.June, "july": time.July,
+	"august": time.August, "september": time.September, "october": time.October,
+	"november": time.November, "december": time.December,
+}
+
+// lookupName accepts full names and their three letter abbreviations.
+func lookupName(name string, names []string) (string, bool) {
+	for _, full := range names {
+		if name == full || len(name) == 3 && strings.HasPrefix(full, name) {
+			return full, true
+		}
+	}
+	return "", false
+}
+
+func weekdayList() []string {
+	var names []string
+	for name := range weekdayNames {
+		names = append(names, name)
+	}
+	return names
+}
+
+func monthList() []string {
+	var names []string
+	for name := range monthNames {
+		names = append(names, name)
+	}
+	return names
+}
+
+func parseCalendar(fields []string) (schedule, error) {
+	if len(fields) < 2 {
+		return nil, errors.New("expected a day and a time")
+	}
+	sched := &calendarSchedule{}
+	var err error
+	if sched.clock, err = parseClock(fields[len(fields)-1]); err != nil {
+		return nil, err
+	}
+	fields = fields[:len(fields)-1]
+
+	// Leading field: every, ordinals or days of the month.
+	switch first := fields[0]; {
+	case first == "every":
+	case isDigits(strings.Split(first, ",")[0]):
+		sched.monthDays = make(map[int]bool)
+		for _, d := range strings.Split(first, ",") {
+			n, err := strconv.Atoi(d)
+			if err != nil || n < 1 || n > 31 {
+				return nil, fmt.Errorf("invalid day of month %q", d)
+			}
+			sched.monthDays[n] = true
+		}
+	default:
+		sched.ordinals = make(map[int]bool)
+		for _, o := range strings.Split(first, ",") {
+			n, ok := ordinalNames[o]
+			if !ok {
+				return nil, fmt.Errorf("invalid ordinal %q", o)
+			}
+			sched.ordinals[n] = true
+		}
+	}
+	fields = fields[1:]
+
+	// Weekdays, unless days of the month were given.
+	if sched.monthDays == nil {
+		if len(fields) == 0 {
+			return nil, errors.New("expected day or weekdays")
+		}
+		if fields[0] != "day" {
+			sched.weekdays = make(map[time.Weekday]bool)
+			for _, w := range strings.Split(fields[0], ",") {
+				name, ok := lookupName(w, weekdayList())
+				if !ok {
+					return nil, fmt.Errorf("invalid weekday %q", w)
+				}
+				sched.weekdays[weekdayNames[name]] = true
+			}
+		} else if sched.ordinals != nil {
+			return nil, errors.New("ordinals require weekdays")
+		}
+		fields = fields[1:]
+	}
+
+	// Optional months.
+	if len(fields) > 0 {
+		if len(fields) != 2 || fields[0] != "of" {
+			return nil, fmt.Errorf("unexpected %q", strings.Join(fields, " "))
+		}
+		if fields[1] != "month" {
+			sched.months = make(map[time.Month]bool)
+			for _, m := range strings.Split(fields[1], ",") {
+				name, ok := lookupName(m, monthList())
+				if !ok {
+					return nil, fmt.Errorf("invalid month %q", m)
+				}
+				sched.months[monthNames[name]] = true
+			}
+		}
+	} else if sched.monthDays != nil {
+		return nil, errors.New("days of the month require \"of month\"")
+	}
+	return sched, nil
+}
+
+func (s *calendarSchedule) matches(day time.Time) bool {
+	if s.months != nil && !s.months[day.Month()] {
+		return false
+	}
+	if s.monthDays != nil {
+		return s.monthDays[day.Day()]
+	}
+	if s.weekdays != nil && !s.weekdays[day.Weekday()] {
+		return false
+	}
+	return s.ordinals == nil || s.ordinals[(day.Day()-1)/7+1]
+}
+
+func (s *calendarSchedule) next(t time.Time) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	// Every valid schedule fires at least once in four years.
+	for i := 0; i < 4*366+1; i++ {
+		if s.matches(day) {
+			fire := time.Date(day.Year(), day.Month(), day.Day(), s.clock/60, s.clock%60, 0, 0, day.Location())
+			if fire.After(t) {
+				return fire
+			}
+		}
+		day = day.AddDate(0, 0, 1)
+	}
+	return time.Time{}
+}
+
+// parseClock parses HH:MM into minutes since midnight.
+func parseClock(s string) (int, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 || len(parts[0]) != 2 || len(parts[1]) != 2 || !isDigits(parts[0]) || !isDigits(parts[1]) {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	h, _ := strconv.Atoi(parts[0])
+	m, _ := strconv.Atoi(parts[1])
+	if h > 23 || m > 59 {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return h*60 + m, nil
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,141 @@
+package gaetest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	jobs, err := parseCron([]byte(`
+cron:
+- description: daily summary job
+  url: /tasks/summary
+  schedule: every day 09:00
+  timezone: America/New_York
+- url: /tasks/sync
+  schedule: every 30 mins
+`))
+	if err != nil {
+		t.Fatalf("parseCron returned %v, expected nil", err)
+	}
+	if len(jobs) != 2 {
+		t.Fatalf("got %d jobs, but expect 2", len(jobs))
+	}
+	if expect := "/tasks/summary"; jobs[0].URL != expect {
+		t.Fatalf("got %q, but expect %q", jobs[0].URL, expect)
+	}
+
+	from := time.Date(2016, 10, 2, 12, 0, 0, 0, time.UTC) // 08:00 in New York
+	next := jobs[0].Next(from, 2)
+	if expect := "2016-10-02 09:00 EDT"; len(next) != 2 || next[0].Format("2006-01-02 15:04 MST") != expect {
+		t.Fatalf("got %v, but expect %s first", next, expect)
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	from := time.Date(2016, 10, 2, 10, 7, 0, 0, time.UTC) // a Sunday
+	for _, test := range []struct {
+		schedule string
+		expect   []string
+	}{
+		{"every 5 minutes", []string{"10-02 10:12", "10-02 10:17"}},
+		{"every 2 hours synchronized", []string{"10-02 12:00", "10-02 14:00"}},
+		{"every 45 mins from 10:00 to 11:00", []string{"10-02 10:45", "10-03 10:00"}},
+		{"every mon,fri 09:30", []string{"10-03 09:30", "10-07 09:30"}},
+		{"1st,3rd tuesday of month 08:00", []string{"10-04 08:00", "10-18 08:00"}},
+		{"2nd monday of jan,oct 00:00", []string{"10-10 00:00", "01-09 00:00"}},
+		{"1,15 of month 06:00", []string{"10-15 06:00", "11-01 06:00"}},
+		{"every sunday 10:07", []string{"10-09 10:07", "10-16 10:07"}},
+	} {
+		sched, err := parseSchedule(test.schedule)
+		if err != nil {
+			t.Fatalf("parseSchedule(%q) returned %v, expected nil", test.schedule, err)
+		}
+		job := &CronJob{loc: time.UTC, sched: sched}
+		var got []string
+		for _, fire := range job.Next(from, len(test.expect)) {
+			got = append(got, fire.Format("01-02 15:04"))
+		}
+		if len(got) != len(test.expect) || got[0] != test.expect[0] || got[1] != test.expect[1] {
+			t.Fatalf("%q: got %v, but expect %v", test.schedule, got, test.expect)
+		}
+	}
+}
+
+func TestParseScheduleErrors(t *testing.T) {
+	for _, schedule := range []string{
+		"every 0 minutes",
+		"every 5 fortnights",
+		"every day 25:00",
+		"every funday 10:00",
+		"6th monday of month 10:00",
+		"1st day 10:00",
+		"1,15 10:00",
+		"every day of smarch 10:00",
+		"every 10 minutes from 10:00",
+	} {
+		if _, err := parseSchedule(schedule); err == nil {
+			t.Fatalf("parseSchedule(%q) returned nil, expected an error", schedule)
+		}
+	}
+}
+
+func TestRunCron(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gaetest")
+	if err != nil {
+		t.Fatalf("Got %v, expected nil", err)
+	}
+	defer os.RemoveAll(dir)
+	sv := &Server{appDir: dir, opts: withDefaults(nil)}
+	if jobs, err := sv.CronJobs(); err != nil || jobs != nil {
+		t.Fatalf("got %v, %v, but expect no jobs without cron.yaml", jobs, err)
+	}
+	cron := `
+cron:
+- url: /tasks/summary
+  schedule: every day 09:00
+- url: /tasks/sync
+  schedule: every 30 mins
+  target: worker
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "cron.yaml"), []byte(cron), 0644); err != nil {
+		t.Fatalf("Got %v, expected nil", err)
+	}
+
+	var got []string
+	handler := func(service string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = append(got, service+" "+r.Method+" "+r.URL.Path+" "+r.Header.Get("X-Appengine-Cron"))
+		})
+	}
+	app := httptest.NewServer(handler("default"))
+	defer app.Close()
+	worker := httptest.NewServer(handler("worker"))
+	defer worker.Close()
+	sv.ModuleURL = app.URL
+	sv.services = map[string]string{"default": app.URL, "worker": worker.URL}
+
+	jobs, err := sv.CronJobs()
+	if err != nil || len(jobs) != 2 {
+		t.Fatalf("got %d jobs (%v), but expect 2", len(jobs), err)
+	}
+	for _, path := range []string{"/tasks/summary", "/tasks/sync"} {
+		res, err := sv.RunCron(path)
+		if err != nil {
+			t.Fatalf("RunCron returned %v, expected nil", err)
+		}
+		res.Body.Close()
+	}
+	expect := []string{"default GET /tasks/summary true", "worker GET /tasks/sync true"}
+	if len(got) != 2 || got[0] != expect[0] || got[1] != expect[1] {
+		t.Fatalf("got %q, but expect %q", got, expect)
+	}
+	if _, err := sv.RunCron("/tasks/unknown"); err == nil {
+		t.Fatal("RunCron returned nil for an unknown job, expected an error")
+	}
+}
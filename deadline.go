@@ -0,0 +1,63 @@
+package gaetest
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+// ErrSuiteDeadline is returned by the operations on a server failed because
+// its Options.SuiteDeadline expired.
+var ErrSuiteDeadline = errors.New("gaetest: suite deadline exceeded")
+
+// startSuiteDeadline arms Options.SuiteDeadline: when it expires, the
+// processes of dev_appserver.py are killed, which fails the requests waiting
+// on them, and the server is closed. Startup is bounded by the deadline too.
+func (sv *Server) startSuiteDeadline() {
+	d := sv.opts.SuiteDeadline
+	if left := int((d + time.Second - 1) / time.Second); left < sv.opts.Timeout {
+		sv.opts.Timeout = left
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	sv.suite = ctx
+	sv.cleanups = append(sv.cleanups, noError(cancel))
+	go func() {
+		<-ctx.Done()
+		if ctx.Err() != context.DeadlineExceeded {
+			return // stopped by Close
+		}
+		log.Printf("gaetest: suite deadline of %v exceeded, closing dev_appserver.py", d)
+		// A wedged dev_appserver.py would not answer the /quit of Close.
+		if pid := sv.processID(); pid != 0 && !sv.detached {
+			processGroup(pid).kill()
+		}
+		if err := sv.Close(); err != nil && sv.opts.Debug {
+			log.Printf("closing dev_appserver.py on the suite deadline: %v", err)
+		}
+	}()
+}
+
+// suiteExpired reports whether Options.SuiteDeadline expired.
+func (sv *Server) suiteExpired() bool {
+	return sv.suite != nil && sv.suite.Err() == context.DeadlineExceeded
+}
+
+// deadlineTransport fails the requests of Server.Client with ErrSuiteDeadline
+// once Options.SuiteDeadline expired.
+type deadlineTransport struct {
+	base http.RoundTripper
+	sv   *Server
+}
+
+func (t *deadlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.sv.suiteExpired() {
+		return nil, ErrSuiteDeadline
+	}
+	res, err := t.base.RoundTrip(req)
+	if err != nil && t.sv.suiteExpired() {
+		return nil, ErrSuiteDeadline
+	}
+	return res, err
+}
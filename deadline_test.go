@@ -0,0 +1,42 @@
+package gaetest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSuiteDeadline(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	sv := &Server{
+		APIURL:    ts.URL,
+		ModuleURL: ts.URL,
+		state:     StateReady,
+		opts:      withDefaults(&Options{SuiteDeadline: 100 * time.Millisecond}),
+	}
+	sv.startSuiteDeadline()
+	if sv.opts.Timeout != 1 {
+		t.Fatalf("got timeout %d, but expect it lowered to 1", sv.opts.Timeout)
+	}
+	client := sv.Client()
+	if _, err := sv.callAPI("memcache", "FlushAll", nil); err != nil {
+		t.Fatalf("callAPI returned %v, expected nil", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for sv.Status() != StateStopped {
+		if time.Now().After(deadline) {
+			t.Fatalf("got state %v, but expect %v", sv.Status(), StateStopped)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := sv.callAPI("memcache", "FlushAll", nil); err != ErrSuiteDeadline {
+		t.Fatalf("got %v, but expect %v", err, ErrSuiteDeadline)
+	}
+	if _, err := client.Get(sv.ModuleURL); err == nil || !strings.Contains(err.Error(), ErrSuiteDeadline.Error()) {
+		t.Fatalf("got %v, but expect %v", err, ErrSuiteDeadline)
+	}
+}
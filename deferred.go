@@ -0,0 +1,57 @@
+package gaetest
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// deferredPath is the handler google.golang.org/appengine/delay enqueues its
+// tasks to.
+const deferredPath = "/_ah/queue/go/delay"
+
+// DeferredCall describes a function call deferred with
+// google.golang.org/appengine/delay.
+type DeferredCall struct {
+	// Key identifies the function, it is the key passed to delay.Func.
+	Key string
+	// Args holds the arguments of the call. It is nil if the payload could
+	// not be fully decoded, which happens when the argument types have not
+	// been registered with encoding/gob in the test binary.
+	Args []interface{}
+}
+
+// invocation mirrors the type delay gob encodes into task payloads.
+type invocation struct {
+	Key  string
+	Args []interface{}
+}
+
+// DecodeDeferred decodes the payload of a task created by
+// google.golang.org/appengine/delay. Argument types have to be registered with
+// gob.Register to be decoded; if they are not, only Key is returned.
+func DecodeDeferred(payload []byte) (*DeferredCall, error) {
+	var inv invocation
+	if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&inv); err == nil {
+		return &DeferredCall{Key: inv.Key, Args: inv.Args}, nil
+	}
+	// Decoding into a type without Args makes gob skip the arguments,
+	// which does not require their types to be registered.
+	var key struct{ Key string }
+	if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&key); err != nil {
+		return nil, fmt.Errorf("unable to decode deferred payload: %v", err)
+	}
+	return &DeferredCall{Key: key.Key}, nil
+}
+
+// Deferred decodes the payload of a task created by
+// google.golang.org/appengine/delay. It fails if the task was not sent to the
+// delay handler.
+func (t Task) Deferred() (*DeferredCall, error) {
+	if !strings.HasPrefix(t.URL, deferredPath) {
+		return nil, errors.New("task was not created by the delay package")
+	}
+	return DecodeDeferred(t.Payload)
+}
@@ -0,0 +1,32 @@
+package gaetest
+
+import (
+	"bytes"
+	"encoding/gob"
+	"testing"
+)
+
+func TestDecodeDeferred(t *testing.T) {
+	var buf bytes.Buffer
+	inv := invocation{Key: "app.go:sendMail", Args: []interface{}{"alice@example.com", 3}}
+	if err := gob.NewEncoder(&buf).Encode(inv); err != nil {
+		t.Fatalf("Encode returned %v, expected nil", err)
+	}
+
+	task := Task{URL: deferredPath, Payload: buf.Bytes()}
+	call, err := task.Deferred()
+	if err != nil {
+		t.Fatalf("Deferred returned %v, expected nil", err)
+	}
+	if call.Key != inv.Key {
+		t.Fatalf("got key %q, but expect %q", call.Key, inv.Key)
+	}
+	if len(call.Args) != 2 || call.Args[0] != "alice@example.com" || call.Args[1] != 3 {
+		t.Fatalf("got args %#v, but expect %#v", call.Args, inv.Args)
+	}
+
+	task.URL = "/work"
+	if _, err := task.Deferred(); err == nil {
+		t.Fatalf("Deferred returned nil, expected an error")
+	}
+}
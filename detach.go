@@ -0,0 +1,101 @@
+package gaetest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Detach leaves the dev server running after the process that started it
+// exits, so that later test runs can Reconnect to it instead of paying the
+// startup cost again. The endpoints and process ID are recorded under
+// Options.RuntimeDir, keyed by Options.Name, and the server is no longer
+// stopped by Close. Its storage is kept too. The harness fixtures (proxies,
+// stubs) do not outlive the process.
+func (sv *Server) Detach() error {
+	if sv.opts.Name == "" {
+		return errors.New("Options.Name is required to detach a server")
+	}
+	if err := sv.requireRunning("detach"); err != nil {
+		return err
+	}
+	sv.detached = true
+	if err := sv.writePidfile(); err != nil {
+		sv.detached = false
+		return err
+	}
+	// Drop the lock so the record no longer depends on this process.
+	if sv.lock != nil {
+		os.Remove(sv.lock.Name())
+		sv.lock.Close()
+		sv.lock = nil
+	}
+	untrackProcess(sv.pid)
+	return nil
+}
+
+// findDetached returns the record of the detached server called name in dir.
+func findDetached(dir, name string) (*pidfile, error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*.pid"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range names {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		var pf pidfile
+		if json.Unmarshal(data, &pf) == nil && pf.Detached && pf.Name == name {
+			return &pf, nil
+		}
+	}
+	return nil, fmt.Errorf("no detached server called %q", name)
+}
+
+// Reconnect attaches to the server detached under name by an earlier process.
+// The RuntimeDir, Timeout and Transport fields of opts are used to find the
+// server and check that it still responds. Closing the returned Server stops the dev
+// server; its output is not available to the new process.
+func Reconnect(name string, opts *Options) (*Server, error) {
+	opts = withDefaults(opts)
+	dir := runtimeDir(opts.RuntimeDir)
+	pf, err := findDetached(dir, name)
+	if err != nil {
+		return nil, err
+	}
+	// A reused PID means the server stopped and its pidfile is stale.
+	if !pf.running() {
+		os.Remove(pidfilePath(dir, pf.PID))
+		return nil, fmt.Errorf("detached server %q is no longer running", name)
+	}
+	opts.Name = name
+	logs, _ := newLogBuffer(nil)
+	sv := &Server{
+		appDir:    pf.AppDir,
+		opts:      opts,
+		pid:       pf.PID,
+		services:  map[string]string{"default": pf.ModuleURL},
+		admin:     &admin{url: pf.AdminURL},
+		logs:      logs,
+		AdminURL:  pf.AdminURL,
+		APIURL:    pf.APIURL,
+		ModuleURL: pf.ModuleURL,
+		state:     StateReady,
+	}
+	sv.admin.client = sv.httpClient()
+	sv.wait = func() error {
+		for pf.running() {
+			time.Sleep(100 * time.Millisecond)
+		}
+		return nil
+	}
+	if err := waitResponding(sv.httpClient(), sv.ModuleURL, time.Duration(opts.Timeout)*time.Second); err != nil {
+		return nil, fmt.Errorf("detached server %q is not responding: %v", name, err)
+	}
+	return sv, nil
+}
@@ -0,0 +1,62 @@
+package gaetest
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDetachRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gaetest")
+	if err != nil {
+		t.Fatalf("Got %v, expected nil", err)
+	}
+	defer os.RemoveAll(dir)
+
+	sv := &Server{opts: &Options{RuntimeDir: dir}, state: StateReady, pid: os.Getpid(), ModuleURL: "http://localhost:8080"}
+	if err := sv.Detach(); err == nil {
+		t.Fatalf("Detach returned nil without a name, expected an error")
+	}
+	sv.opts.Name = "warm"
+	if err := sv.Detach(); err != nil {
+		t.Fatalf("Detach returned %v, expected nil", err)
+	}
+	if err := sv.Close(); err != nil {
+		t.Fatalf("Close returned %v for a detached server, expected nil", err)
+	}
+
+	pf, err := findDetached(dir, "warm")
+	if err != nil {
+		t.Fatalf("findDetached returned %v, expected nil", err)
+	}
+	if pf.PID != os.Getpid() || pf.ModuleURL != "http://localhost:8080" {
+		t.Fatalf("got %+v, but expect the record of the detached server", pf)
+	}
+	if _, err := findDetached(dir, "cold"); err == nil {
+		t.Fatalf("findDetached returned nil for an unknown name, expected an error")
+	}
+}
+
+func TestReconnectReusedPID(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gaetest")
+	if err != nil {
+		t.Fatalf("Got %v, expected nil", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The PID is alive, but the process started after the server recorded.
+	pf := pidfile{PID: os.Getpid(), Started: "0", Name: "warm", Detached: true, ModuleURL: "http://localhost:8080"}
+	data, _ := json.Marshal(pf)
+	path := pidfilePath(dir, pf.PID)
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("WriteFile returned %v, expected nil", err)
+	}
+	if _, err := Reconnect("warm", &Options{RuntimeDir: dir}); err == nil || !strings.Contains(err.Error(), "no longer running") {
+		t.Fatalf("got %v, but expect the server not to be running", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("got %v, but expect the stale pidfile to be removed", err)
+	}
+}
@@ -0,0 +1,138 @@
+package gaetest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// endpointsRoot is the path the Endpoints framework serves APIs under.
+const endpointsRoot = "/_ah/api"
+
+// EndpointsAPI describes an API listed by the Endpoints discovery service.
+type EndpointsAPI struct {
+	Name             string `json:"name"`
+	Version          string `json:"version"`
+	Description      string `json:"description"`
+	DiscoveryRestURL string `json:"discoveryRestUrl"`
+}
+
+// EndpointsAPIs lists the APIs the app serves with the Endpoints framework,
+// as reported by its discovery service.
+func (sv *Server) EndpointsAPIs() ([]EndpointsAPI, error) {
+	var list struct {
+		Items []EndpointsAPI `json:"items"`
+	}
+	if err := getJSON(sv.Client(), sv.ModuleURL+endpointsRoot+"/discovery/v1/apis", &list); err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+// EndpointsClient calls the methods of an Endpoints API of the app.
+type EndpointsClient struct {
+	// BaseURL is the root of the API, e.g.
+	// http://localhost:8080/_ah/api/greeting/v1.
+	BaseURL string
+	// APIKey is sent as the key query parameter, if set.
+	APIKey string
+	// Token is sent as a bearer token in the Authorization header, if set.
+	// The dev server does not check tokens; the user is the one of
+	// Options.User.
+	Token string
+
+	client *http.Client
+	name   string
+	ver    string
+	root   string
+}
+
+// EndpointsClient returns a client for version of the Endpoints API called
+// name, making requests with the client returned by Client.
+func (sv *Server) EndpointsClient(name, version string) *EndpointsClient {
+	root := sv.ModuleURL + endpointsRoot
+	return &EndpointsClient{
+		BaseURL: root + "/" + name + "/" + version,
+		client:  sv.Client(),
+		name:    name,
+		ver:     version,
+		root:    root,
+	}
+}
+
+// Discovery fetches the discovery document of the API.
+func (c *EndpointsClient) Discovery() (map[string]interface{}, error) {
+	var doc map[string]interface{}
+	u := fmt.Sprintf("%s/discovery/v1/apis/%s/%s/rest", c.root, c.name, c.ver)
+	if err := getJSON(c.client, u, &doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// Call sends in as JSON, unless it is nil, with method to path relative to
+// BaseURL and decodes the JSON response into out, unless it is nil. Responses
+// with a status other than 2xx are returned as errors.
+func (c *EndpointsClient) Call(method, path string, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+	u := c.BaseURL + "/" + strings.TrimPrefix(path, "/")
+	if c.APIKey != "" {
+		sep := "?"
+		if strings.Contains(u, "?") {
+			sep = "&"
+		}
+		u += sep + "key=" + url.QueryEscape(c.APIKey)
+	}
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	return doJSON(c.client, req, out)
+}
+
+func getJSON(client *http.Client, u string, out interface{}) error {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	return doJSON(client, req, out)
+}
+
+// doJSON sends req and decodes the JSON response into out, unless it is nil.
+func doJSON(client *http.Client, req *http.Request, out interface{}) error {
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("%s %s: got status %d: %s", req.Method, req.URL.Path, res.StatusCode, bytes.TrimSpace(data))
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("%s %s: %v", req.Method, req.URL.Path, err)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package gaetest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEndpointsClient(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/_ah/api/discovery/v1/apis", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"items": [{"name": "greeting", "version": "v1", "discoveryRestUrl": "/rest"}]}`))
+	})
+	mux.HandleFunc("/_ah/api/discovery/v1/apis/greeting/v1/rest", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"name": "greeting", "resources": {}}`))
+	})
+	mux.HandleFunc("/_ah/api/greeting/v1/greet", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("key") != "k" || r.Header.Get("Authorization") != "Bearer t" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var in struct{ Name string }
+		json.NewDecoder(r.Body).Decode(&in)
+		json.NewEncoder(w).Encode(map[string]string{"message": "hello " + in.Name})
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	sv := &Server{ModuleURL: ts.URL, opts: withDefaults(nil)}
+
+	apis, err := sv.EndpointsAPIs()
+	if err != nil || len(apis) != 1 || apis[0].Name != "greeting" {
+		t.Fatalf("got %v, %v, but expect the greeting API", apis, err)
+	}
+	c := sv.EndpointsClient("greeting", "v1")
+	if doc, err := c.Discovery(); err != nil || doc["name"] != "greeting" {
+		t.Fatalf("got %v, %v, but expect the discovery document", doc, err)
+	}
+
+	var out struct{ Message string }
+	err = c.Call("POST", "greet", map[string]string{"name": "gopher"}, &out)
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("got %v, but expect status 401 to be reported", err)
+	}
+	c.APIKey, c.Token = "k", "t"
+	if err := c.Call("POST", "greet", map[string]string{"name": "gopher"}, &out); err != nil {
+		t.Fatalf("Call returned %v, expected nil", err)
+	}
+	if out.Message != "hello gopher" {
+		t.Fatalf("got %q, but expect %q", out.Message, "hello gopher")
+	}
+}
@@ -0,0 +1,368 @@
+package gaetest
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Entities are written to the datastore stub with datastore_v3.Put, through
+// the API server. The messages are those of the datastore_v3 service of the
+// Python SDK, whose entities and keys use groups.
+
+// Meanings of datastore properties.
+const (
+	meaningWhen = 7  // GD_WHEN, for time.Time
+	meaningText = 15 // TEXT, for unindexed strings
+)
+
+// maxIndexedString is the size of the longest string the datastore indexes.
+const maxIndexedString = 1500
+
+// Key is the key of a datastore entity.
+type Key struct {
+	Kind      string `json:"kind"`
+	ID        int64  `json:"id,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	Parent    *Key   `json:"parent,omitempty"`
+}
+
+func (k *Key) String() string {
+	id := k.Name
+	if id == "" {
+		id = strconv.FormatInt(k.ID, 10)
+	}
+	s := k.Kind + "," + id
+	if k.Parent != nil {
+		return k.Parent.String() + "/" + s
+	}
+	if k.Namespace != "" {
+		return k.Namespace + ":" + s
+	}
+	return s
+}
+
+// datastoreApp returns the app ID the datastore stub expects in keys.
+func (sv *Server) datastoreApp() string {
+	return "dev~" + sv.opts.AppID
+}
+
+// encodeReference encodes k as a Reference. A zero ID and empty Name leave
+// the key incomplete, for the datastore to allocate an ID.
+func encodeReference(app string, k *Key) []byte {
+	var path, ref pbMessage
+	var elems []*Key
+	for e := k; e != nil; e = e.Parent {
+		elems = append([]*Key{e}, elems...)
+	}
+	for _, e := range elems {
+		path.startGroup(1)
+		path.string(2, e.Kind)
+		if e.ID != 0 {
+			path.int64(3, e.ID)
+		}
+		if e.Name != "" {
+			path.string(4, e.Name)
+		}
+		path.endGroup(1)
+	}
+	ref.string(13, app)
+	ref.bytes(14, path.buf.Bytes())
+	if k.Namespace != "" {
+		ref.string(20, k.Namespace)
+	}
+	return ref.buf.Bytes()
+}
+
+// decodeReference decodes a Reference.
+func decodeReference(data []byte) (*Key, error) {
+	fields, err := parsePB(data)
+	if err != nil {
+		return nil, err
+	}
+	var k *Key
+	var ns string
+	for _, f := range fields {
+		switch f.num {
+		case 20:
+			ns = string(f.data)
+		case 14:
+			path, err := parsePB(f.data)
+			if err != nil {
+				return nil, err
+			}
+			for _, e := range path {
+				elem, err := parsePB(e.data)
+				if err != nil {
+					return nil, err
+				}
+				k = &Key{Parent: k}
+				for _, ef := range elem {
+					switch ef.num {
+					case 2:
+						k.Kind = string(ef.data)
+					case 3:
+						k.ID = int64(ef.varint)
+					case 4:
+						k.Name = string(ef.data)
+					}
+				}
+			}
+		}
+	}
+	if k == nil {
+		return nil, errors.New("reference without path")
+	}
+	for e := k; e != nil; e = e.Parent {
+		e.Namespace = ns
+	}
+	return k, nil
+}
+
+// encodeValue encodes v as a PropertyValue and returns its meaning.
+func encodeValue(v interface{}) ([]byte, int, error) {
+	var m pbMessage
+	meaning := 0
+	switch v := v.(type) {
+	case nil:
+	case string:
+		m.string(3, v)
+		if len(v) > maxIndexedString {
+			meaning = meaningText
+		}
+	case bool:
+		b := int64(0)
+		if v {
+			b = 1
+		}
+		m.int64(2, b)
+	case int:
+		m.int64(1, int64(v))
+	case int32:
+		m.int64(1, int64(v))
+	case int64:
+		m.int64(1, v)
+	case float64:
+		m.double(4, v)
+	case time.Time:
+		m.int64(1, v.UnixNano()/int64(time.Microsecond))
+		meaning = meaningWhen
+	default:
+		return nil, 0, fmt.Errorf("unsupported property type %T", v)
+	}
+	return m.buf.Bytes(), meaning, nil
+}
+
+// encodeEntity encodes an EntityProto with key k and properties props. Slice
+// values are stored as multiple values of their property.
+func encodeEntity(app string, k *Key, props map[string]interface{}) ([]byte, error) {
+	var e pbMessage
+	e.bytes(13, encodeReference(app, k))
+	e.bytes(16, nil) // entity group, filled in by the datastore
+	names := make([]string, 0, len(props))
+	for name := range props {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		values, multiple := props[name].([]interface{})
+		if !multiple {
+			if s, ok := props[name].([]string); ok {
+				for _, v := range s {
+					values = append(values, v)
+				}
+				multiple = true
+			} else {
+				values = []interface{}{props[name]}
+			}
+		}
+		for _, v := range values {
+			value, meaning, err := encodeValue(v)
+			if err != nil {
+				return nil, fmt.Errorf("property %s: %v", name, err)
+			}
+			var p pbMessage
+			if meaning != 0 {
+				p.int64(1, int64(meaning))
+			}
+			p.string(3, name)
+			mult := int64(0)
+			if multiple {
+				mult = 1
+			}
+			p.int64(4, mult)
+			p.bytes(5, value)
+			field := 14
+			if meaning == meaningText {
+				field = 15 // raw_property, not indexed
+			}
+			e.bytes(field, p.buf.Bytes())
+		}
+	}
+	return e.buf.Bytes(), nil
+}
+
+// decodeValue decodes a PropertyValue of a property with meaning.
+func decodeValue(data []byte, meaning int) (interface{}, error) {
+	fields, err := parsePB(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	f := fields[0]
+	switch f.num {
+	case 1:
+		if meaning == meaningWhen {
+			return time.Unix(0, int64(f.varint)*int64(time.Microsecond)).UTC(), nil
+		}
+		return int64(f.varint), nil
+	case 2:
+		return f.varint != 0, nil
+	case 3:
+		return string(f.data), nil
+	case 4:
+		return math.Float64frombits(f.varint), nil
+	}
+	return nil, fmt.Errorf("unsupported property value (field %d)", f.num)
+}
+
+// decodeEntity decodes an EntityProto into its key and properties. Multiple
+// valued properties are returned as []interface{}.
+func decodeEntity(data []byte) (*Key, map[string]interface{}, error) {
+	fields, err := parsePB(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	var key *Key
+	props := make(map[string]interface{})
+	for _, f := range fields {
+		switch f.num {
+		case 13:
+			if key, err = decodeReference(f.data); err != nil {
+				return nil, nil, err
+			}
+		case 14, 15:
+			pf, err := parsePB(f.data)
+			if err != nil {
+				return nil, nil, err
+			}
+			var name string
+			var meaning int
+			var multiple bool
+			var value []byte
+			for _, p := range pf {
+				switch p.num {
+				case 1:
+					meaning = int(p.varint)
+				case 3:
+					name = string(p.data)
+				case 4:
+					multiple = p.varint != 0
+				case 5:
+					value = p.data
+				}
+			}
+			v, err := decodeValue(value, meaning)
+			if err != nil {
+				return nil, nil, fmt.Errorf("property %s: %v", name, err)
+			}
+			if !multiple {
+				props[name] = v
+				continue
+			}
+			values, _ := props[name].([]interface{})
+			props[name] = append(values, v)
+		}
+	}
+	if key == nil {
+		return nil, nil, errors.New("entity without key")
+	}
+	return key, props, nil
+}
+
+// queryKind returns the encoded entities of kind in the default namespace.
+func (sv *Server) queryKind(kind string) ([][]byte, error) {
+	return sv.query("", kind, false)
+}
+
+// query returns the encoded entities of kind in namespace, with their keys
+// only if keysOnly is set.
+func (sv *Server) query(namespace, kind string, keysOnly bool) ([][]byte, error) {
+	var q pbMessage
+	q.string(1, sv.datastoreApp())
+	q.string(3, kind)
+	if keysOnly {
+		q.int64(21, 1)
+	}
+	if namespace != "" {
+		q.string(29, namespace)
+	}
+	method, req := "RunQuery", q.buf.Bytes()
+	var entities [][]byte
+	for {
+		data, err := sv.callAPI("datastore_v3", method, req)
+		if err != nil {
+			return nil, err
+		}
+		fields, err := parsePB(data)
+		if err != nil {
+			return nil, err
+		}
+		var cursor []byte
+		more := false
+		for _, f := range fields {
+			switch f.num {
+			case 1:
+				cursor = f.data
+			case 2:
+				entities = append(entities, f.data)
+			case 3:
+				more = f.varint != 0
+			}
+		}
+		if !more || cursor == nil {
+			return entities, nil
+		}
+		var next pbMessage
+		next.bytes(1, cursor)
+		next.int64(2, maxPutBatch)
+		method, req = "Next", next.buf.Bytes()
+	}
+}
+
+// putEntities stores entities with datastore_v3.Put and returns their keys.
+func (sv *Server) putEntities(entities [][]byte) ([]*Key, error) {
+	var req pbMessage
+	for _, e := range entities {
+		req.bytes(1, e)
+	}
+	data, err := sv.callAPI("datastore_v3", "Put", req.buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	fields, err := parsePB(data)
+	if err != nil {
+		return nil, err
+	}
+	var keys []*Key
+	for _, f := range fields {
+		if f.num != 1 {
+			continue
+		}
+		k, err := decodeReference(f.data)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	if len(keys) != len(entities) {
+		return nil, fmt.Errorf("datastore_v3.Put returned %d keys for %d entities", len(keys), len(entities))
+	}
+	return keys, nil
+}
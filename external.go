@@ -0,0 +1,39 @@
+package gaetest
+
+import (
+	"net/http/httptest"
+	"strings"
+)
+
+// externalEnvName returns the environment variable holding the URL of the
+// external stub called name, e.g. GAETEST_PAYMENTS_URL for "payments".
+func externalEnvName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, name)
+	return "GAETEST_" + strings.ToUpper(name) + "_URL"
+}
+
+// startExternals starts a test server for each handler in
+// Options.Externals and passes its URL to the app.
+func (sv *Server) startExternals() {
+	if len(sv.opts.Externals) == 0 {
+		return
+	}
+	sv.externals = make(map[string]string)
+	for name, h := range sv.opts.Externals {
+		ts := httptest.NewServer(h)
+		sv.cleanups = append(sv.cleanups, noError(ts.Close))
+		sv.externals[name] = ts.URL
+		sv.appEnv = append(sv.appEnv, externalEnvName(name)+"="+ts.URL)
+	}
+}
+
+// ExternalURL returns the URL of the stub started for the handler registered
+// as name in Options.Externals, or "" if there is none.
+func (sv *Server) ExternalURL(name string) string {
+	return sv.externals[name]
+}
@@ -0,0 +1,31 @@
+package gaetest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+func TestStartExternals(t *testing.T) {
+	sv := &Server{opts: &Options{Externals: map[string]http.Handler{
+		"payments-api": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, "paid")
+		}),
+	}}}
+	sv.startExternals()
+	defer sv.cleanup()
+
+	u := sv.ExternalURL("payments-api")
+	if expect := "GAETEST_PAYMENTS_API_URL=" + u; len(sv.appEnv) != 1 || sv.appEnv[0] != expect {
+		t.Fatalf("got %q, but expect [%q]", sv.appEnv, expect)
+	}
+	res, err := http.Get(u)
+	if err != nil {
+		t.Fatalf("Get returned %v, expected nil", err)
+	}
+	defer res.Body.Close()
+	if body, _ := ioutil.ReadAll(res.Body); string(body) != "paid" {
+		t.Fatalf("got %q, but expect %q", body, "paid")
+	}
+}
@@ -0,0 +1,145 @@
+package gaetest
+
+import (
+	"fmt"
+	"sync"
+)
+
+// maxPutBatch is the number of entities written by a datastore_v3.Put call.
+const maxPutBatch = 500
+
+// Sequence computes a property from the sequence number of an entity created
+// by a factory. The numbers start at 1 and grow for each entity of the kind,
+// across factories, so that sequences can make unique values:
+//
+//	sv.Factory("User").With("Email", gaetest.Sequence(func(n int) interface{} {
+//		return fmt.Sprintf("user%d@example.com", n)
+//	}))
+type Sequence func(n int) interface{}
+
+// factories holds the defaults and sequence numbers of the kinds of a server.
+type factories struct {
+	mu        sync.Mutex
+	defaults  map[string]map[string]interface{}
+	sequences map[string]int
+}
+
+// next reserves n sequence numbers of kind and returns the first.
+func (fs *factories) next(kind string, n int) int {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.sequences == nil {
+		fs.sequences = make(map[string]int)
+	}
+	first := fs.sequences[kind] + 1
+	fs.sequences[kind] += n
+	return first
+}
+
+// DefineFactory sets the default properties of the entities of kind created
+// with Factory. Values may be Sequences.
+func (sv *Server) DefineFactory(kind string, defaults map[string]interface{}) {
+	sv.factories.mu.Lock()
+	defer sv.factories.mu.Unlock()
+	if sv.factories.defaults == nil {
+		sv.factories.defaults = make(map[string]map[string]interface{})
+	}
+	sv.factories.defaults[kind] = defaults
+}
+
+// Factory creates datastore entities of a kind through the API server, for
+// tests to seed data without fixture files. Factories are immutable: With and
+// InNamespace return modified copies.
+type Factory struct {
+	sv        *Server
+	kind      string
+	namespace string
+	props     map[string]interface{}
+}
+
+// Factory returns a factory of entities of kind, with the properties set by
+// DefineFactory.
+func (sv *Server) Factory(kind string) *Factory {
+	sv.factories.mu.Lock()
+	defer sv.factories.mu.Unlock()
+	f := &Factory{sv: sv, kind: kind, props: make(map[string]interface{})}
+	for name, v := range sv.factories.defaults[kind] {
+		f.props[name] = v
+	}
+	return f
+}
+
+func (f *Factory) clone() *Factory {
+	c := *f
+	c.props = make(map[string]interface{}, len(f.props))
+	for name, v := range f.props {
+		c.props[name] = v
+	}
+	return &c
+}
+
+// With returns a factory setting property name to v, which may be a
+// Sequence.
+func (f *Factory) With(name string, v interface{}) *Factory {
+	c := f.clone()
+	c.props[name] = v
+	return c
+}
+
+// InNamespace returns a factory creating its entities in namespace ns.
+func (f *Factory) InNamespace(ns string) *Factory {
+	c := f.clone()
+	c.namespace = ns
+	return c
+}
+
+// Create creates an entity and returns its key.
+func (f *Factory) Create() (*Key, error) {
+	keys, err := f.CreateN(1)
+	if err != nil {
+		return nil, err
+	}
+	return keys[0], nil
+}
+
+// CreateN creates n entities and returns their keys, whose IDs are allocated
+// by the datastore.
+func (f *Factory) CreateN(n int) ([]*Key, error) {
+	first := f.sv.factories.next(f.kind, n)
+	app := f.sv.datastoreApp()
+	var keys []*Key
+	var batch [][]byte
+	for i := 0; i < n; i++ {
+		props := make(map[string]interface{}, len(f.props))
+		for name, v := range f.props {
+			if seq, ok := v.(Sequence); ok {
+				v = seq(first + i)
+			}
+			props[name] = v
+		}
+		e, err := encodeEntity(app, &Key{Kind: f.kind, Namespace: f.namespace}, props)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", f.kind, err)
+		}
+		batch = append(batch, e)
+		if len(batch) == maxPutBatch || i == n-1 {
+			put, err := f.sv.putEntities(batch)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", f.kind, err)
+			}
+			keys, batch = append(keys, put...), nil
+		}
+	}
+	return keys, nil
+}
+
+// Fixture returns a fixture creating n entities, for Server.Run.
+func (f *Factory) Fixture(n int) Fixture {
+	return Fixture{
+		Name: fmt.Sprintf("%d %s", n, f.kind),
+		Load: func(*Server) error {
+			_, err := f.CreateN(n)
+			return err
+		},
+	}
+}
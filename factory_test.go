@@ -0,0 +1,223 @@
+package gaetest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// storedEntity is an entity written to newDatastoreStub, with its properties
+// decoded to their strings, int64s and float64s.
+type storedEntity struct {
+	App   string
+	Key   Key
+	Props map[string][]interface{}
+	raw   []byte // encoded, with its complete key
+}
+
+// newDatastoreStub serves datastore_v3.Put, allocating IDs from 1 to
+// incomplete keys and replacing the entities with complete ones,
+// datastore_v3.Delete, and queries by kind and namespace, returning one entity
+// per batch.
+func newDatastoreStub(stored *[]storedEntity) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		fields, _ := parsePB(body)
+		var method string
+		var req []pbField
+		for _, f := range fields {
+			switch f.num {
+			case 3:
+				method = string(f.data)
+			case 4:
+				req, _ = parsePB(f.data)
+			}
+		}
+		var out pbMessage
+		switch method {
+		case "Put":
+			for _, f := range req {
+				e := putStubEntity(f.data, int64(len(*stored)+1))
+				replaced := false
+				for i := range *stored {
+					if (*stored)[i].Key.String() == e.Key.String() {
+						(*stored)[i], replaced = e, true
+					}
+				}
+				if !replaced {
+					*stored = append(*stored, e)
+				}
+				out.bytes(1, encodeReference(e.App, &e.Key))
+			}
+		case "Delete":
+			for _, f := range req {
+				k, _ := decodeReference(f.data)
+				for i := range *stored {
+					if (*stored)[i].Key.String() == k.String() {
+						*stored = append((*stored)[:i], (*stored)[i+1:]...)
+						break
+					}
+				}
+			}
+		case "RunQuery", "Next":
+			var kind, namespace string
+			offset := 0
+			for _, f := range req {
+				switch {
+				case method == "RunQuery" && f.num == 3:
+					kind = string(f.data)
+				case method == "RunQuery" && f.num == 29:
+					namespace = string(f.data)
+				case method == "Next" && f.num == 1:
+					cursor, _ := parsePB(f.data)
+					for _, cf := range cursor {
+						switch cf.num {
+						case 1:
+							kind = string(cf.data)
+						case 2:
+							offset = int(cf.varint)
+						case 3:
+							namespace = string(cf.data)
+						}
+					}
+				}
+			}
+			var results [][]byte
+			seen := make(map[string]bool)
+			for _, e := range *stored {
+				switch {
+				case kind == "__namespace__" && !seen[e.Key.Namespace]:
+					seen[e.Key.Namespace] = true
+					nk := &Key{Kind: "__namespace__", Name: e.Key.Namespace}
+					if nk.Name == "" {
+						nk.ID = 1
+					}
+					var k pbMessage
+					k.bytes(13, encodeReference("dev~testapp", nk))
+					results = append(results, k.buf.Bytes())
+				case e.Key.Namespace != namespace:
+				case kind == "__kind__" && !seen[e.Key.Kind]:
+					seen[e.Key.Kind] = true
+					var k pbMessage
+					k.bytes(13, encodeReference("dev~testapp", &Key{Kind: "__kind__", Name: e.Key.Kind, Namespace: namespace}))
+					results = append(results, k.buf.Bytes())
+				case e.Key.Kind == kind:
+					results = append(results, e.raw)
+				}
+			}
+			if offset < len(results) {
+				out.bytes(2, results[offset])
+			}
+			var cursor pbMessage
+			cursor.string(1, kind)
+			cursor.int64(2, int64(offset+1))
+			if namespace != "" {
+				cursor.string(3, namespace)
+			}
+			out.bytes(1, cursor.buf.Bytes())
+			more := int64(0)
+			if offset+1 < len(results) {
+				more = 1
+			}
+			out.int64(3, more)
+		}
+		var res pbMessage
+		res.bytes(1, out.buf.Bytes())
+		w.Write(res.buf.Bytes())
+	}))
+}
+
+// putStubEntity decodes the entity stored by newDatastoreStub, with ID id if
+// its key is incomplete.
+func putStubEntity(data []byte, id int64) storedEntity {
+	entity, _ := parsePB(data)
+	e := storedEntity{Props: make(map[string][]interface{})}
+	var raw pbMessage
+	for _, ef := range entity {
+		switch ef.num {
+		case 13:
+			k, _ := decodeReference(ef.data)
+			e.Key = *k
+			if e.Key.ID == 0 && e.Key.Name == "" {
+				e.Key.ID = id
+			}
+			ref, _ := parsePB(ef.data)
+			e.App = string(ref[0].data)
+			raw.bytes(13, encodeReference(e.App, &e.Key))
+			continue
+		case 14, 15:
+			prop, _ := parsePB(ef.data)
+			var name string
+			var value interface{}
+			for _, pf := range prop {
+				switch pf.num {
+				case 3:
+					name = string(pf.data)
+				case 5:
+					v, _ := parsePB(pf.data)
+					switch v[0].num {
+					case 1, 2:
+						value = int64(v[0].varint)
+					case 3:
+						value = string(v[0].data)
+					case 4:
+						value = math.Float64frombits(v[0].varint)
+					}
+				}
+			}
+			e.Props[name] = append(e.Props[name], value)
+		}
+		raw.bytes(ef.num, ef.data)
+	}
+	e.raw = raw.buf.Bytes()
+	return e
+}
+
+func TestFactory(t *testing.T) {
+	var stored []storedEntity
+	ts := newDatastoreStub(&stored)
+	defer ts.Close()
+	sv := &Server{APIURL: ts.URL, opts: withDefaults(nil)}
+	sv.DefineFactory("User", map[string]interface{}{
+		"Email": Sequence(func(n int) interface{} { return fmt.Sprintf("user%d@example.com", n) }),
+		"Admin": false,
+	})
+
+	keys, err := sv.Factory("User").With("Tags", []string{"a", "b"}).InNamespace("acme").CreateN(2)
+	if err != nil {
+		t.Fatalf("CreateN returned %v, expected nil", err)
+	}
+	if len(keys) != 2 || keys[1].String() != "acme:User,2" {
+		t.Fatalf("got %v, but expect 2 keys", keys)
+	}
+	created := time.Date(2016, 10, 2, 21, 48, 0, 0, time.UTC)
+	key, err := sv.Factory("User").With("Admin", true).With("Created", created).Create()
+	if err != nil || key.ID != 3 || key.Namespace != "" {
+		t.Fatalf("got %v, %v, but expect key 3", key, err)
+	}
+
+	expect := map[string][]interface{}{
+		"Email": {"user2@example.com"},
+		"Admin": {int64(0)},
+		"Tags":  {"a", "b"},
+	}
+	if stored[1].App != "dev~testapp" || !reflect.DeepEqual(stored[1].Props, expect) {
+		t.Fatalf("got %+v, but expect properties %v", stored[1], expect)
+	}
+	expect = map[string][]interface{}{
+		"Email":   {"user3@example.com"},
+		"Admin":   {int64(1)},
+		"Created": {created.UnixNano() / 1000},
+	}
+	if !reflect.DeepEqual(stored[2].Props, expect) {
+		t.Fatalf("got %v, but expect %v", stored[2].Props, expect)
+	}
+	if _, err := sv.Factory("User").With("Score", uint8(1)).Create(); err == nil {
+		t.Fatalf("Create returned nil for an unsupported type, expected an error")
+	}
+}
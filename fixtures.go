@@ -0,0 +1,318 @@
+package gaetest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Fixture files hold datastore entities as a JSON array of objects with a key
+// and properties:
+//
+//	[
+//	  {
+//	    "key": {"kind": "User", "id": 1},
+//	    "properties": {
+//	      "Email": "ann@example.com",
+//	      "Admin": false,
+//	      "Age": 42,
+//	      "Score": {"float": 9.5},
+//	      "Created": {"time": "2016-10-02T21:48:00Z"},
+//	      "Tags": ["a", "b"]
+//	    }
+//	  }
+//	]
+//
+// Strings, booleans and integers are plain JSON values; floats and times are
+// wrapped so that they keep their type. Arrays are multiple valued properties.
+//
+// LoadFixtures also reads the same structure written in YAML:
+//
+//	- key:
+//	    kind: User
+//	    id: 1
+//	  properties:
+//	    Email: ann@example.com
+//	    Age: 42
+//	    Score:
+//	      float: 9.5
+//	    Zip:
+//	      string: "02134"
+//
+// YAML scalars are untyped: true, false and null are booleans and null,
+// numbers are integers, anything else is a string. Strings that would read as
+// another type are wrapped with a string key.
+
+// FixtureEntity is an entity of a fixture file.
+type FixtureEntity struct {
+	Key        *Key                   `json:"key"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// fixtureValue converts a property value decoded by decodeEntity to its JSON
+// form.
+func fixtureValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case float64:
+		return map[string]float64{"float": v}
+	case time.Time:
+		return map[string]string{"time": v.Format(time.RFC3339Nano)}
+	case []interface{}:
+		values := make([]interface{}, len(v))
+		for i, e := range v {
+			values[i] = fixtureValue(e)
+		}
+		return values
+	}
+	return v
+}
+
+// propertyValue converts a property value of a fixture file, decoded with
+// json.Decoder.UseNumber, to the type it is stored as.
+func propertyValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case json.Number:
+		n, err := v.Int64()
+		if err != nil {
+			return nil, fmt.Errorf("%s is not an integer, write floats as {\"float\": %s}", v, v)
+		}
+		return n, nil
+	case map[string]interface{}:
+		if f, ok := v["float"].(json.Number); ok && len(v) == 1 {
+			return f.Float64()
+		}
+		if s, ok := v["time"].(string); ok && len(v) == 1 {
+			return time.Parse(time.RFC3339Nano, s)
+		}
+		if s, ok := v["string"].(string); ok && len(v) == 1 {
+			return s, nil
+		}
+		return nil, fmt.Errorf("unsupported value %v", v)
+	case []interface{}:
+		values := make([]interface{}, len(v))
+		for i, e := range v {
+			var err error
+			if values[i], err = propertyValue(e); err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	}
+	return v, nil
+}
+
+// jsonNumberRE matches the numbers of JSON.
+var jsonNumberRE = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+
+// yamlFixtures converts a YAML fixture file, as parsed by parseYAML, to its
+// JSON form.
+func yamlFixtures(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if _, ok := v["string"].(string); ok && len(v) == 1 {
+			return v // keep the string as written
+		}
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[k] = yamlFixtures(e)
+		}
+		return m
+	case []interface{}:
+		values := make([]interface{}, len(v))
+		for i, e := range v {
+			values[i] = yamlFixtures(e)
+		}
+		return values
+	case string:
+		switch {
+		case v == "true" || v == "false":
+			return v == "true"
+		case v == "null" || v == "~":
+			return nil
+		case jsonNumberRE.MatchString(v):
+			return json.Number(v)
+		}
+	}
+	return v
+}
+
+// decodeFixtures decodes the list of a fixture file, in JSON or YAML, into v.
+// Numbers are decoded as json.Number.
+func decodeFixtures(r io.Reader, v interface{}) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] != '[' {
+		doc, err := parseYAML(data)
+		if err != nil {
+			return err
+		}
+		if _, ok := doc.([]interface{}); !ok {
+			return errors.New("expected a list")
+		}
+		if data, err = json.Marshal(yamlFixtures(doc)); err != nil {
+			return err
+		}
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// readFixtures decodes the entities of a fixture file, in JSON or YAML.
+func readFixtures(r io.Reader) ([]FixtureEntity, error) {
+	var entities []FixtureEntity
+	if err := decodeFixtures(r, &entities); err != nil {
+		return nil, err
+	}
+	for i, e := range entities {
+		if e.Key == nil || e.Key.Kind == "" {
+			return nil, fmt.Errorf("entity %d: missing key kind", i+1)
+		}
+		for name, v := range e.Properties {
+			pv, err := propertyValue(v)
+			if err != nil {
+				return nil, fmt.Errorf("%v: property %s: %v", e.Key, name, err)
+			}
+			e.Properties[name] = pv
+		}
+	}
+	return entities, nil
+}
+
+// putFixtures stores entities, returning their keys.
+func (sv *Server) putFixtures(entities []FixtureEntity) ([]*Key, error) {
+	app := sv.datastoreApp()
+	var keys []*Key
+	for start := 0; start < len(entities); start += maxPutBatch {
+		end := start + maxPutBatch
+		if end > len(entities) {
+			end = len(entities)
+		}
+		var batch [][]byte
+		for _, e := range entities[start:end] {
+			data, err := encodeEntity(app, e.Key, e.Properties)
+			if err != nil {
+				return nil, fmt.Errorf("%v: %v", e.Key, err)
+			}
+			batch = append(batch, data)
+		}
+		put, err := sv.putEntities(batch)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, put...)
+	}
+	return keys, nil
+}
+
+// LoadFixtures stores the entities of a fixture file in the datastore,
+// through the API server. The file may be in JSON, as written by
+// ExportFixtures, or in YAML. Entities with an ID or name replace the stored
+// ones with the same key; the others get a new ID.
+func (sv *Server) LoadFixtures(r io.Reader) error {
+	entities, err := readFixtures(r)
+	if err != nil {
+		return fmt.Errorf("gaetest: fixtures: %v", err)
+	}
+	if _, err := sv.putFixtures(entities); err != nil {
+		return fmt.Errorf("gaetest: fixtures: %v", err)
+	}
+	return nil
+}
+
+// FixtureFile returns a Fixture loading the fixture file at path with
+// LoadFixtures, for Server.Run.
+func FixtureFile(path string) Fixture {
+	return Fixture{
+		Name: filepath.Base(path),
+		Load: func(sv *Server) error {
+			entities, err := readFixtureFile(path)
+			if err != nil {
+				return err
+			}
+			_, err = sv.putFixtures(entities)
+			return err
+		},
+	}
+}
+
+// readFixtureFile decodes the entities of the fixture file at path.
+func readFixtureFile(path string) ([]FixtureEntity, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	entities, err := readFixtures(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return entities, nil
+}
+
+// allKinds returns the kinds of the entities in the datastore, except the
+// internal ones.
+func (sv *Server) allKinds() ([]string, error) {
+	entities, err := sv.queryKind("__kind__")
+	if err != nil {
+		return nil, err
+	}
+	var kinds []string
+	for _, e := range entities {
+		key, _, err := decodeEntity(e)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(key.Name, "__") {
+			kinds = append(kinds, key.Name)
+		}
+	}
+	sort.Strings(kinds)
+	return kinds, nil
+}
+
+// ExportFixtures writes the entities of kinds in the default namespace to a
+// fixture file at path, every kind if kinds is empty. State set up by hand,
+// through the app or the admin server, can so be captured once and loaded by
+// later tests.
+func (sv *Server) ExportFixtures(kinds []string, path string) error {
+	if len(kinds) == 0 {
+		var err error
+		if kinds, err = sv.allKinds(); err != nil {
+			return fmt.Errorf("listing kinds: %v", err)
+		}
+	}
+	entities := []FixtureEntity{}
+	for _, kind := range kinds {
+		encoded, err := sv.queryKind(kind)
+		if err != nil {
+			return fmt.Errorf("%s: %v", kind, err)
+		}
+		for _, e := range encoded {
+			key, props, err := decodeEntity(e)
+			if err != nil {
+				return fmt.Errorf("%s: %v", kind, err)
+			}
+			for name, v := range props {
+				props[name] = fixtureValue(v)
+			}
+			entities = append(entities, FixtureEntity{Key: key, Properties: props})
+		}
+	}
+	data, err := json.MarshalIndent(entities, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
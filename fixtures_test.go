@@ -0,0 +1,143 @@
+package gaetest
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportFixtures(t *testing.T) {
+	var stored []storedEntity
+	ts := newDatastoreStub(&stored)
+	defer ts.Close()
+	sv := &Server{APIURL: ts.URL, opts: withDefaults(nil)}
+
+	created := time.Date(2016, 10, 2, 21, 48, 0, 0, time.UTC)
+	if _, err := sv.Factory("User").With("Email", "ann@example.com").With("Score", 9.5).With("Created", created).Create(); err != nil {
+		t.Fatalf("Create returned %v, expected nil", err)
+	}
+	if _, err := sv.Factory("Post").With("Tags", []string{"a", "b"}).CreateN(2); err != nil {
+		t.Fatalf("CreateN returned %v, expected nil", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "fixtures.json")
+	if err := sv.ExportFixtures(nil, path); err != nil {
+		t.Fatalf("ExportFixtures returned %v, expected nil", err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile returned %v, expected nil", err)
+	}
+	expect := `[
+  {
+    "key": {
+      "kind": "Post",
+      "id": 2
+    },
+    "properties": {
+      "Tags": [
+        "a",
+        "b"
+      ]
+    }
+  },
+  {
+    "key": {
+      "kind": "Post",
+      "id": 3
+    },
+    "properties": {
+      "Tags": [
+        "a",
+        "b"
+      ]
+    }
+  },
+  {
+    "key": {
+      "kind": "User",
+      "id": 1
+    },
+    "properties": {
+      "Created": {
+        "time": "2016-10-02T21:48:00Z"
+      },
+      "Email": "ann@example.com",
+      "Score": {
+        "float": 9.5
+      }
+    }
+  }
+]
+`
+	if string(data) != expect {
+		t.Fatalf("got %s, but expect %s", data, expect)
+	}
+}
+
+func TestLoadFixtures(t *testing.T) {
+	var stored []storedEntity
+	ts := newDatastoreStub(&stored)
+	defer ts.Close()
+	sv := &Server{APIURL: ts.URL, opts: withDefaults(nil)}
+
+	yaml := `# users
+- key:
+    kind: User
+    id: 7
+  properties:
+    Email: ann@example.com
+    Age: 42
+    Admin: true
+    Score:
+      float: 9.5
+    Zip:
+      string: "02134"
+    Tags: [a, b]
+- key:
+    kind: User
+  properties:
+    Email: bob@example.com
+`
+	if err := sv.LoadFixtures(strings.NewReader(yaml)); err != nil {
+		t.Fatalf("LoadFixtures returned %v, expected nil", err)
+	}
+	if len(stored) != 2 {
+		t.Fatalf("got %d entities, but expect 2", len(stored))
+	}
+	if expect := (Key{Kind: "User", ID: 7}); stored[0].Key != expect {
+		t.Fatalf("got %v, but expect %v", stored[0].Key, expect)
+	}
+	expect := map[string][]interface{}{
+		"Email": {"ann@example.com"},
+		"Age":   {int64(42)},
+		"Admin": {int64(1)},
+		"Score": {9.5},
+		"Zip":   {"02134"},
+		"Tags":  {"a", "b"},
+	}
+	if !reflect.DeepEqual(stored[0].Props, expect) {
+		t.Fatalf("got %v, but expect %v", stored[0].Props, expect)
+	}
+	if stored[1].Key.ID != 2 {
+		t.Fatalf("got ID %d, but expect 2", stored[1].Key.ID)
+	}
+
+	// The same entity in JSON replaces the stored one.
+	json := `[{"key": {"kind": "User", "id": 7}, "properties": {"Email": "ann@example.org"}}]`
+	if err := sv.LoadFixtures(strings.NewReader(json)); err != nil {
+		t.Fatalf("LoadFixtures returned %v, expected nil", err)
+	}
+	if got := stored[0].Props["Email"]; len(stored) != 2 || got[0] != "ann@example.org" {
+		t.Fatalf("got %v, but expect [ann@example.org]", got)
+	}
+
+	err := sv.LoadFixtures(strings.NewReader(`[{"key": {"kind": "User"}, "properties": {"Score": 1.5}}]`))
+	expectErr := `gaetest: fixtures: User,0: property Score: 1.5 is not an integer, write floats as {"float": 1.5}`
+	if err == nil || err.Error() != expectErr {
+		t.Fatalf("got %v, but expect %q", err, expectErr)
+	}
+}
@@ -0,0 +1,81 @@
+package gaetest
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// AcceptGzip makes the client ask for gzip-encoded responses and return them
+// as sent, without decompressing them, so that the Content-Encoding chosen by
+// the app can be checked with AssertGzip.
+func AcceptGzip() ClientOption {
+	return func(o *clientOptions) {
+		o.header.Set("Accept-Encoding", "gzip")
+		o.rawEncoding = true
+	}
+}
+
+// ReadBody reads and closes the body of res, decompressing it if it is
+// gzip-encoded.
+func ReadBody(res *http.Response) ([]byte, error) {
+	defer res.Body.Close()
+	var r io.Reader = res.Body
+	if res.Header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(res.Body)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	}
+	return ioutil.ReadAll(r)
+}
+
+// varies reports whether the Vary header of h lists name.
+func varies(h http.Header, name string) bool {
+	for _, v := range h["Vary"] {
+		for _, field := range strings.Split(v, ",") {
+			if f := strings.TrimSpace(field); f == "*" || strings.EqualFold(f, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AssertGzip checks that res, received by a client with AcceptGzip, is
+// gzip-encoded, lists Accept-Encoding in its Vary header so that caches keep
+// the encodings apart, and decompresses without errors. It returns the
+// decompressed body, reading and closing the body of res.
+func AssertGzip(t testing.TB, res *http.Response) []byte {
+	t.Helper()
+	if enc := res.Header.Get("Content-Encoding"); enc != "gzip" {
+		t.Errorf("got Content-Encoding %q, but expect %q", enc, "gzip")
+	}
+	if !varies(res.Header, "Accept-Encoding") {
+		t.Errorf("got Vary %q, but expect it to list Accept-Encoding", res.Header["Vary"])
+	}
+	body, err := ReadBody(res)
+	if err != nil {
+		t.Errorf("unable to read the gzip-encoded body: %v", err)
+	}
+	return body
+}
+
+// AssertNotGzip checks that res is not encoded, e.g. because the request did
+// not accept gzip. It returns the body, reading and closing the body of res.
+func AssertNotGzip(t testing.TB, res *http.Response) []byte {
+	t.Helper()
+	if enc := res.Header.Get("Content-Encoding"); enc != "" && enc != "identity" {
+		t.Errorf("got Content-Encoding %q, but expect no encoding", enc)
+	}
+	body, err := ReadBody(res)
+	if err != nil {
+		t.Errorf("unable to read the body: %v", err)
+	}
+	return body
+}
@@ -0,0 +1,53 @@
+package gaetest
+
+import (
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAssertGzip(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/plain" {
+			w.Header().Set("Vary", "Accept-Encoding")
+		}
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") || r.URL.Path == "/plain" {
+			w.Write([]byte("hello"))
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		zw := gzip.NewWriter(w)
+		zw.Write([]byte("hello"))
+		zw.Close()
+	}))
+	defer ts.Close()
+	sv := &Server{ModuleURL: ts.URL, opts: withDefaults(nil)}
+
+	res, err := sv.Client(AcceptGzip()).Get(ts.URL + "/")
+	if err != nil {
+		t.Fatalf("Get returned %v, expected nil", err)
+	}
+	if body := AssertGzip(t, res); string(body) != "hello" {
+		t.Fatalf("got %q, but expect %q", body, "hello")
+	}
+
+	res, err = sv.Client(AcceptGzip()).Get(ts.URL + "/plain")
+	if err != nil {
+		t.Fatalf("Get returned %v, expected nil", err)
+	}
+	ft := &fakeTB{}
+	AssertGzip(ft, res)
+	if len(ft.errors) != 2 {
+		t.Fatalf("got %q, but expect the encoding and Vary to be reported", ft.errors)
+	}
+
+	res, err = sv.Client().Get(ts.URL + "/")
+	if err != nil {
+		t.Fatalf("Get returned %v, expected nil", err)
+	}
+	if body := AssertNotGzip(t, res); string(body) != "hello" {
+		t.Fatalf("got %q, but expect %q", body, "hello")
+	}
+}
@@ -0,0 +1,68 @@
+package gaetest
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// requestLogRE matches the line dev_appserver.py logs for every request, e.g.
+//
+//	INFO     2016-10-02 21:48:20,110 module.py:788] default: "GET / HTTP/1.1" 200 2
+var requestLogRE = regexp.MustCompile(`\] (\S+): "(\S+) (\S+) [^"]*" (\d{3}) `)
+
+// HealthViolation is a server error response or a panic observed in the
+// output of dev_appserver.py.
+type HealthViolation struct {
+	// Line is the line of output the violation was found in.
+	Line string
+	// Service, Method, Path and Status describe the request that failed. They
+	// are empty for panics.
+	Service string
+	Method  string
+	Path    string
+	Status  int
+	Panic   bool
+}
+
+// requestLog is a request parsed from the output of dev_appserver.py.
+type requestLog struct {
+	service, method, path string
+	status                int
+}
+
+// parseRequestLog parses a request log line.
+func parseRequestLog(line string) (requestLog, bool) {
+	match := requestLogRE.FindStringSubmatch(line)
+	if match == nil {
+		return requestLog{}, false
+	}
+	status, _ := strconv.Atoi(match[4])
+	return requestLog{service: match[1], method: match[2], path: match[3], status: status}, true
+}
+
+// parseViolation returns the health violation reported by line, if any.
+func parseViolation(line string) (HealthViolation, bool) {
+	if strings.HasPrefix(strings.TrimSpace(line), "panic: ") {
+		return HealthViolation{Line: line, Panic: true}, true
+	}
+	if r, ok := parseRequestLog(line); ok && r.status >= 500 {
+		return HealthViolation{
+			Line:    line,
+			Service: r.service,
+			Method:  r.method,
+			Path:    r.path,
+			Status:  r.status,
+		}, true
+	}
+	return HealthViolation{}, false
+}
+
+// HealthViolations returns the 5xx responses and panics seen since the server
+// started, regardless of which test caused them. A suite can check it after
+// each test to catch errors nobody asserted on.
+func (sv *Server) HealthViolations() []HealthViolation {
+	sv.logs.mu.Lock()
+	defer sv.logs.mu.Unlock()
+	return append([]HealthViolation(nil), sv.logs.violations...)
+}
@@ -0,0 +1,25 @@
+package gaetest
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestHealthViolations(t *testing.T) {
+	b, _ := newLogBuffer(nil)
+	fmt.Fprint(b, `INFO     2016-10-02 21:48:20,110 module.py:788] default: "GET / HTTP/1.1" 200 2
+INFO     2016-10-02 21:48:21,110 module.py:788] worker: "POST /tasks/run?id=3 HTTP/1.1" 503 17
+panic: assignment to entry in nil map
+`)
+	sv := &Server{logs: b}
+	got := sv.HealthViolations()
+	if len(got) != 2 {
+		t.Fatalf("got %d violations, but expect 2", len(got))
+	}
+	if v := got[0]; v.Service != "worker" || v.Method != "POST" || v.Path != "/tasks/run?id=3" || v.Status != 503 {
+		t.Fatalf("got %+v, but expect the 503 of worker", v)
+	}
+	if !got[1].Panic {
+		t.Fatalf("got %+v, but expect a panic", got[1])
+	}
+}
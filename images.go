@@ -0,0 +1,224 @@
+package gaetest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif" // decoders for AssertServingURL
+	_ "image/jpeg"
+	_ "image/png"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// The images stub of the dev server reads images from the blobstore stub, so
+// images are seeded as blobs, uploaded through the upload handler of the dev
+// server like a browser would, instead of Cloud Storage objects.
+
+// seedBlobPath is the success path of the uploads of SeedBlob. The app does
+// not need to handle it: the blob is stored before the app is called.
+const seedBlobPath = "/_ah/gaetest/blob"
+
+// Values of OutputSettings.MimeType.
+var imageMimeTypes = map[string]int64{"png": 0, "jpeg": 1, "webp": 2}
+
+// SeedBlob stores data as a blob named filename in the blobstore stub and
+// returns its blob key. Filenames should be unique: when several blobs share
+// one, the key of the latest is returned.
+func (sv *Server) SeedBlob(filename, contentType string, data []byte) (string, error) {
+	var req pbMessage
+	req.string(1, seedBlobPath)
+	res, err := sv.callAPI("blobstore", "CreateUploadURL", req.buf.Bytes())
+	if err != nil {
+		return "", fmt.Errorf("gaetest: %v", err)
+	}
+	fields, err := parsePB(res)
+	if err != nil {
+		return "", err
+	}
+	var uploadURL string
+	for _, f := range fields {
+		if f.num == 1 {
+			uploadURL = string(f.data)
+		}
+	}
+	u, err := url.Parse(uploadURL)
+	if err != nil {
+		return "", fmt.Errorf("gaetest: invalid upload URL %q", uploadURL)
+	}
+	upload, err := sv.NewUploadRequest(u.RequestURI(), &Form{Files: []FormFile{{
+		Field:       "file",
+		Name:        filename,
+		ContentType: contentType,
+		Content:     bytes.NewReader(data),
+	}}})
+	if err != nil {
+		return "", err
+	}
+	resp, err := sv.httpClient().Do(upload)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	key, err := sv.blobKey(filename)
+	if err != nil {
+		return "", fmt.Errorf("gaetest: blob %s: %v", filename, err)
+	}
+	return key, nil
+}
+
+// blobKey returns the key of the latest blob named filename, looked up in the
+// __BlobInfo__ entities the blobstore stub keeps.
+func (sv *Server) blobKey(filename string) (string, error) {
+	entities, err := sv.queryKind("__BlobInfo__")
+	if err != nil {
+		return "", err
+	}
+	var key string
+	var latest time.Time
+	for _, data := range entities {
+		k, props, err := decodeEntity(data)
+		if err != nil {
+			return "", err
+		}
+		if props["filename"] != filename {
+			continue
+		}
+		creation, _ := props["creation"].(time.Time)
+		if key == "" || !creation.Before(latest) {
+			key, latest = k.Name, creation
+		}
+	}
+	if key == "" {
+		return "", errors.New("not stored by the upload handler")
+	}
+	return key, nil
+}
+
+// ImageTransform is a transformation of the images service, applied by
+// TransformImage.
+type ImageTransform struct {
+	// Width and Height resize the image, keeping its aspect ratio unless
+	// CropToFit is set. Zero leaves a dimension free.
+	Width, Height int
+	CropToFit     bool
+	// Rotate is clockwise, in degrees, a multiple of 90.
+	Rotate         int
+	HorizontalFlip bool
+	VerticalFlip   bool
+}
+
+func (tr ImageTransform) encode() []byte {
+	var m pbMessage
+	if tr.Width != 0 {
+		m.int64(1, int64(tr.Width))
+	}
+	if tr.Height != 0 {
+		m.int64(2, int64(tr.Height))
+	}
+	if tr.Rotate != 0 {
+		m.int64(3, int64(tr.Rotate))
+	}
+	if tr.HorizontalFlip {
+		m.int64(4, 1)
+	}
+	if tr.VerticalFlip {
+		m.int64(5, 1)
+	}
+	if tr.CropToFit {
+		m.int64(11, 1)
+	}
+	return m.buf.Bytes()
+}
+
+// TransformImage applies transforms, in order, to the blob with blobKey
+// through the images service and returns the image, encoded in format: png,
+// jpeg or webp.
+func (sv *Server) TransformImage(blobKey, format string, transforms ...ImageTransform) ([]byte, error) {
+	mimeType, ok := imageMimeTypes[format]
+	if !ok {
+		return nil, fmt.Errorf("gaetest: unknown image format %q", format)
+	}
+	var img, output, req pbMessage
+	img.bytes(1, nil)
+	img.string(2, blobKey)
+	req.bytes(1, img.buf.Bytes())
+	for _, tr := range transforms {
+		req.bytes(2, tr.encode())
+	}
+	output.int64(1, mimeType)
+	req.bytes(3, output.buf.Bytes())
+	data, err := sv.callAPI("images", "Transform", req.buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("gaetest: %v", err)
+	}
+	fields, err := parsePB(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range fields {
+		if f.num != 1 {
+			continue
+		}
+		imageData, err := parsePB(f.data)
+		if err != nil {
+			return nil, err
+		}
+		for _, imf := range imageData {
+			if imf.num == 1 {
+				return imf.data, nil
+			}
+		}
+	}
+	return nil, errors.New("gaetest: images.Transform: no image")
+}
+
+// ServingURL returns the serving URL of the image blob with blobKey, as
+// get_serving_url does in the app. Appending =s<size> resizes the image, and
+// -c crops it to a square.
+func (sv *Server) ServingURL(blobKey string) (string, error) {
+	var req pbMessage
+	req.string(1, blobKey)
+	data, err := sv.callAPI("images", "GetUrlBase", req.buf.Bytes())
+	if err != nil {
+		return "", fmt.Errorf("gaetest: %v", err)
+	}
+	fields, err := parsePB(data)
+	if err != nil {
+		return "", err
+	}
+	for _, f := range fields {
+		if f.num == 1 {
+			return string(f.data), nil
+		}
+	}
+	return "", errors.New("gaetest: images.GetUrlBase: no URL")
+}
+
+// AssertServingURL checks that servingURL, e.g. one produced by the app with
+// get_serving_url, serves an image of width by height pixels. A zero
+// dimension is not checked. Problems are reported with t.Errorf.
+func (sv *Server) AssertServingURL(t testing.TB, servingURL string, width, height int) {
+	t.Helper()
+	res, err := sv.httpClient().Get(servingURL)
+	if err != nil {
+		t.Errorf("%s: %v", servingURL, err)
+		return
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("%s: got status %d, but expect %d", servingURL, res.StatusCode, http.StatusOK)
+		return
+	}
+	config, format, err := image.DecodeConfig(res.Body)
+	if err != nil {
+		t.Errorf("%s: not an image: %v", servingURL, err)
+		return
+	}
+	if (width != 0 && config.Width != width) || (height != 0 && config.Height != height) {
+		t.Errorf("%s: got a %dx%d %s image, but expect %dx%d", servingURL, config.Width, config.Height, format, width, height)
+	}
+}
@@ -0,0 +1,160 @@
+package gaetest
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newImagesStub serves the blobstore upload handler, the blobstore, images and
+// datastore APIs and the serving URLs of images, holding uploaded blobs in
+// blobs by key. Transforms return the blob and record the encoded transforms.
+func newImagesStub(blobs map[string][]byte, transforms *[][]byte) *httptest.Server {
+	var ts *httptest.Server
+	var infos [][]byte
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/_ah/upload/"):
+			file, header, err := r.FormFile("file")
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			data, _ := ioutil.ReadAll(file)
+			key := "blob" + string(rune('0'+len(blobs)))
+			blobs[key] = data
+			info, _ := encodeEntity("dev~testapp", &Key{Kind: "__BlobInfo__", Name: key}, map[string]interface{}{
+				"filename": header.Filename,
+				"creation": time.Now(),
+			})
+			infos = append(infos, info)
+			http.NotFound(w, r) // the app does not handle the success path
+			return
+		case strings.HasPrefix(r.URL.Path, "/_ah/img/"):
+			data, ok := blobs[strings.TrimPrefix(r.URL.Path, "/_ah/img/")]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write(data)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		fields, _ := parsePB(body)
+		var method string
+		var req []pbField
+		for _, f := range fields {
+			switch f.num {
+			case 3:
+				method = string(f.data)
+			case 4:
+				req, _ = parsePB(f.data)
+			}
+		}
+		var out pbMessage
+		switch method {
+		case "CreateUploadURL":
+			out.string(1, ts.URL+"/_ah/upload/session1")
+		case "RunQuery":
+			for _, info := range infos {
+				out.bytes(2, info)
+			}
+			out.int64(3, 0)
+		case "GetUrlBase":
+			out.string(1, ts.URL+"/_ah/img/"+string(req[0].data))
+		case "Transform":
+			var key string
+			for _, f := range req {
+				switch f.num {
+				case 1:
+					img, _ := parsePB(f.data)
+					for _, imf := range img {
+						if imf.num == 2 {
+							key = string(imf.data)
+						}
+					}
+				case 2:
+					*transforms = append(*transforms, f.data)
+				}
+			}
+			var img pbMessage
+			img.bytes(1, blobs[key])
+			out.bytes(1, img.buf.Bytes())
+		}
+		var res pbMessage
+		res.bytes(1, out.buf.Bytes())
+		w.Write(res.buf.Bytes())
+	}))
+	return ts
+}
+
+// testPNG returns a blank PNG image of width by height pixels.
+func testPNG(t *testing.T, width, height int) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestImages(t *testing.T) {
+	blobs := make(map[string][]byte)
+	var transforms [][]byte
+	ts := newImagesStub(blobs, &transforms)
+	defer ts.Close()
+	sv := &Server{APIURL: ts.URL, ModuleURL: ts.URL, opts: withDefaults(nil)}
+
+	if _, err := sv.SeedBlob("other.png", "image/png", testPNG(t, 1, 1)); err != nil {
+		t.Fatalf("SeedBlob returned %v, expected nil", err)
+	}
+	key, err := sv.SeedBlob("logo.png", "image/png", testPNG(t, 4, 2))
+	if err != nil {
+		t.Fatalf("SeedBlob returned %v, expected nil", err)
+	}
+	if key != "blob1" {
+		t.Fatalf("got %q, but expect %q", key, "blob1")
+	}
+
+	data, err := sv.TransformImage(key, "png", ImageTransform{Width: 2}, ImageTransform{Rotate: 90})
+	if err != nil {
+		t.Fatalf("TransformImage returned %v, expected nil", err)
+	}
+	if !bytes.Equal(data, blobs[key]) {
+		t.Fatalf("got %d bytes, but expect the %d of the blob", len(data), len(blobs[key]))
+	}
+	if len(transforms) != 2 {
+		t.Fatalf("got %d transforms, but expect 2", len(transforms))
+	}
+	if _, err := sv.TransformImage(key, "bmp"); err == nil {
+		t.Fatal("TransformImage returned nil for format bmp, expected an error")
+	}
+
+	servingURL, err := sv.ServingURL(key)
+	if err != nil {
+		t.Fatalf("ServingURL returned %v, expected nil", err)
+	}
+	sv.AssertServingURL(t, servingURL, 4, 2)
+
+	ftb := &fakeTB{}
+	sv.AssertServingURL(ftb, servingURL, 32, 0)
+	sv.AssertServingURL(ftb, ts.URL+"/_ah/img/missing", 0, 0)
+	if len(ftb.errors) != 2 {
+		t.Fatalf("got %d errors, but expect 2: %q", len(ftb.errors), ftb.errors)
+	}
+}
+
+func TestSeedBlobNotStored(t *testing.T) {
+	ts := newImagesStub(make(map[string][]byte), new([][]byte))
+	defer ts.Close()
+	sv := &Server{APIURL: ts.URL, ModuleURL: ts.URL + "/elsewhere", opts: withDefaults(nil)}
+
+	if _, err := sv.SeedBlob("logo.png", "image/png", testPNG(t, 1, 1)); err == nil {
+		t.Fatal("SeedBlob returned nil, expected an error")
+	}
+}
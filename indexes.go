@@ -0,0 +1,99 @@
+package gaetest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// readIndexes returns the composite indexes defined in the index.yaml file at
+// path, formatted by formatIndex. A missing file defines no indexes.
+func readIndexes(path string) (map[string]bool, error) {
+	indexes := make(map[string]bool)
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return indexes, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	doc, err := parseYAML(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	root, _ := doc.(map[string]interface{})
+	list, _ := root["indexes"].([]interface{})
+	for _, entry := range list {
+		if m, ok := entry.(map[string]interface{}); ok {
+			indexes[formatIndex(m)] = true
+		}
+	}
+	return indexes, nil
+}
+
+// formatIndex formats an entry of index.yaml as e.g.
+// "Task(ancestor, done, -created)".
+func formatIndex(m map[string]interface{}) string {
+	var props []string
+	if a := yamlString(m, "ancestor"); a == "yes" || a == "true" {
+		props = append(props, "ancestor")
+	}
+	list, _ := m["properties"].([]interface{})
+	for _, entry := range list {
+		p, _ := entry.(map[string]interface{})
+		name := yamlString(p, "name")
+		if d := yamlString(p, "direction"); d == "desc" || d == "descending" {
+			name = "-" + name
+		}
+		props = append(props, name)
+	}
+	return fmt.Sprintf("%s(%s)", yamlString(m, "kind"), strings.Join(props, ", "))
+}
+
+// indexCheck records index.yaml as it was before the run.
+type indexCheck struct {
+	path     string
+	original []byte // nil if the file did not exist
+	before   map[string]bool
+}
+
+func newIndexCheck(appDir string) (*indexCheck, error) {
+	c := &indexCheck{path: filepath.Join(appDir, "index.yaml")}
+	data, err := ioutil.ReadFile(c.path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	c.original = data
+	if c.before, err = readIndexes(c.path); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// finish returns an error listing the indexes dev_appserver.py added to
+// index.yaml because queries of the run needed them, and restores the file.
+func (c *indexCheck) finish() error {
+	after, err := readIndexes(c.path)
+	if err != nil {
+		return err
+	}
+	if c.original == nil {
+		os.Remove(c.path)
+	} else if err := ioutil.WriteFile(c.path, c.original, 0644); err != nil {
+		return err
+	}
+	var missing []string
+	for index := range after {
+		if !c.before[index] {
+			missing = append(missing, index)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	sort.Strings(missing)
+	return fmt.Errorf("index.yaml is missing indexes required by queries: %s", strings.Join(missing, "; "))
+}
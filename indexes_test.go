@@ -0,0 +1,54 @@
+package gaetest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const committedIndexes = `indexes:
+- kind: Task
+  properties:
+  - name: done
+  - name: created
+    direction: desc
+`
+
+func TestIndexCheck(t *testing.T) {
+	appDir, err := ioutil.TempDir("", "gaetest")
+	if err != nil {
+		t.Fatalf("Got %v, expected nil", err)
+	}
+	defer os.RemoveAll(appDir)
+	path := filepath.Join(appDir, "index.yaml")
+	if err := ioutil.WriteFile(path, []byte(committedIndexes), 0644); err != nil {
+		t.Fatalf("Got %v, expected nil", err)
+	}
+
+	c, err := newIndexCheck(appDir)
+	if err != nil {
+		t.Fatalf("newIndexCheck returned %v, expected nil", err)
+	}
+	// What dev_appserver.py appends when a query needs another index.
+	generated := committedIndexes + `
+# AUTOGENERATED
+
+- kind: Comment
+  ancestor: yes
+  properties:
+  - name: posted
+`
+	if err := ioutil.WriteFile(path, []byte(generated), 0644); err != nil {
+		t.Fatalf("Got %v, expected nil", err)
+	}
+
+	err = c.finish()
+	expect := "index.yaml is missing indexes required by queries: Comment(ancestor, posted)"
+	if err == nil || err.Error() != expect {
+		t.Fatalf("got %v, but expect %q", err, expect)
+	}
+	if data, _ := ioutil.ReadFile(path); string(data) != committedIndexes {
+		t.Fatalf("got index.yaml %q, but expect it restored", data)
+	}
+}
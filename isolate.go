@@ -0,0 +1,31 @@
+package gaetest
+
+import "testing"
+
+// Isolate gives the test t a datastore and memcache of its own: both are
+// emptied now and again when t finishes, so that no data leaks from one test
+// into the next without restarting the server. Data seeded before the call is
+// lost, so tests have to seed what they need after it. Tests calling Isolate
+// must not run in parallel on the same server.
+func (sv *Server) Isolate(t testing.TB) {
+	t.Helper()
+	if err := sv.reset(); err != nil {
+		t.Fatalf("gaetest: unable to isolate %s: %v", t.Name(), err)
+	}
+	t.Cleanup(func() {
+		if err := sv.reset(); err != nil {
+			t.Errorf("gaetest: unable to clean up after %s: %v", t.Name(), err)
+		}
+	})
+}
+
+// reset empties the datastore, in every namespace, and memcache.
+func (sv *Server) reset() error {
+	if err := sv.requireRunning("reset"); err != nil {
+		return err
+	}
+	if err := sv.ResetDatastore(); err != nil {
+		return err
+	}
+	return sv.ResetMemcache()
+}
@@ -0,0 +1,26 @@
+package gaetest
+
+import "testing"
+
+func TestIsolate(t *testing.T) {
+	var stored []storedEntity
+	ts := newDatastoreStub(&stored)
+	defer ts.Close()
+	sv := &Server{APIURL: ts.URL, opts: withDefaults(nil), state: StateReady}
+
+	if _, err := sv.Factory("User").Create(); err != nil {
+		t.Fatalf("Create returned %v, expected nil", err)
+	}
+	t.Run("isolated", func(t *testing.T) {
+		sv.Isolate(t)
+		if len(stored) != 0 {
+			t.Fatalf("got %d entities, but expect the datastore to be emptied", len(stored))
+		}
+		if _, err := sv.Factory("Post").Create(); err != nil {
+			t.Fatalf("Create returned %v, expected nil", err)
+		}
+	})
+	if len(stored) != 0 {
+		t.Fatalf("got %d entities, but expect the datastore to be emptied again after the test", len(stored))
+	}
+}
@@ -0,0 +1,31 @@
+package gaetest
+
+// startLazy sets up the proxy that starts the server on the first request.
+func (sv *Server) startLazy() error {
+	p, err := newLazyProxy(sv.proxyAddr(), func() (string, error) {
+		sv.startMu.Lock()
+		defer sv.startMu.Unlock()
+		if err := sv.run(); err != nil {
+			return "", err
+		}
+		return sv.backend, nil
+	})
+	if err != nil {
+		return err
+	}
+	sv.proxy = p
+	sv.cleanups = append(sv.cleanups, noError(p.Close))
+	sv.ModuleURL = p.URL
+	return nil
+}
+
+// Start starts a server created with Options.Lazy if no request has done so
+// yet, and returns the error starting it, if any. It must be called before
+// using AdminURL, APIURL or any method other than requests to ModuleURL. It
+// does nothing for other servers.
+func (sv *Server) Start() error {
+	if !sv.opts.Lazy {
+		return nil
+	}
+	return sv.proxy.ready()
+}
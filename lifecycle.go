@@ -0,0 +1,59 @@
+package gaetest
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// serviceURL returns the URL of the service (module) called name. The empty
+// name stands for the default module.
+func (sv *Server) serviceURL(name string) (string, error) {
+	if name == "" {
+		return sv.ModuleURL, nil
+	}
+	u, ok := sv.services[name]
+	if !ok {
+		return "", fmt.Errorf("unknown service %q", name)
+	}
+	return u, nil
+}
+
+// sendInternal sends a request the dev server would dispatch on its own to
+// path of service.
+func (sv *Server) sendInternal(method, service, path string, header http.Header) (*http.Response, error) {
+	u, err := sv.serviceURL(service)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(method, u+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set(fakeIsAdminHeader, "1")
+	return sv.httpClient().Do(req)
+}
+
+// StartBackground sends the /_ah/background request App Engine issues to a
+// manual scaling instance of service when code calls runtime.RunInBackground.
+// requestID is passed in the X-AppEngine-BackgroundRequest header and has to
+// match the ID the app registered its background function under.
+func (sv *Server) StartBackground(service, requestID string) (*http.Response, error) {
+	return sv.sendInternal("GET", service, "/_ah/background", http.Header{
+		"X-Appengine-Backgroundrequest": {requestID},
+	})
+}
+
+// SendStart sends the /_ah/start request App Engine issues when it starts an
+// instance of a manual or basic scaling service.
+func (sv *Server) SendStart(service string) (*http.Response, error) {
+	return sv.sendInternal("GET", service, "/_ah/start", nil)
+}
+
+// SendStop sends the /_ah/stop request App Engine issues before it shuts down
+// an instance of a manual or basic scaling service.
+func (sv *Server) SendStop(service string) (*http.Response, error) {
+	return sv.sendInternal("GET", service, "/_ah/stop", nil)
+}
@@ -0,0 +1,48 @@
+package gaetest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStartBackground(t *testing.T) {
+	var got *http.Request
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+	}))
+	defer ts.Close()
+
+	sv := &Server{services: map[string]string{"worker": ts.URL}}
+	res, err := sv.StartBackground("worker", "bg-1")
+	if err != nil {
+		t.Fatalf("StartBackground returned %v, expected nil", err)
+	}
+	res.Body.Close()
+	if got.URL.Path != "/_ah/background" || got.Header.Get("X-AppEngine-BackgroundRequest") != "bg-1" {
+		t.Fatalf("got %s %v, but expect /_ah/background with ID bg-1", got.URL.Path, got.Header)
+	}
+	if _, err := sv.StartBackground("frontend", "bg-2"); err == nil {
+		t.Fatalf("StartBackground returned nil for an unknown service, expected an error")
+	}
+}
+
+func TestSendStartStop(t *testing.T) {
+	var paths []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+	}))
+	defer ts.Close()
+
+	sv := &Server{ModuleURL: ts.URL}
+	for _, send := range []func(string) (*http.Response, error){sv.SendStart, sv.SendStop} {
+		res, err := send("")
+		if err != nil {
+			t.Fatalf("got error %v, expected nil", err)
+		}
+		res.Body.Close()
+	}
+	if len(paths) != 2 || paths[0] != "/_ah/start" || paths[1] != "/_ah/stop" {
+		t.Fatalf("got %q, but expect /_ah/start and /_ah/stop", paths)
+	}
+}
@@ -0,0 +1,72 @@
+package gaetest
+
+import (
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+// Limits of App Engine that apps have to handle.
+const (
+	MaxRequestSize  = 32 << 20 // body of requests to the app
+	MaxResponseSize = 32 << 20 // body of responses of the app
+	MaxEntitySize   = 1 << 20  // datastore entity
+	MaxTaskPayload  = 10 << 20 // push task payload
+)
+
+// Payload returns n bytes of pseudo-random data. The data only depends on n,
+// and it does not compress, so that it keeps its size through gzip.
+func Payload(n int) []byte {
+	b := make([]byte, n)
+	rand.New(rand.NewSource(int64(n))).Read(b)
+	return b
+}
+
+// LimitResult is the outcome of a request sent by ProbeLimit.
+type LimitResult struct {
+	Size int
+	// Status of the response, 0 if the request failed.
+	Status int
+	// ResponseSize is the size of the body of the response.
+	ResponseSize int64
+	Err          error
+}
+
+// ProbeLimit sends requests to path with payloads of limit-1, limit and
+// limit+1 bytes and returns their outcome.
+func (sv *Server) ProbeLimit(method, path string, limit int) []LimitResult {
+	var results []LimitResult
+	for _, size := range []int{limit - 1, limit, limit + 1} {
+		r := LimitResult{Size: size}
+		req := sv.newRequest(method, path, Payload(size))
+		req.Header.Set("Content-Type", "application/octet-stream")
+		res, err := sv.httpClient().Do(req)
+		if err != nil {
+			r.Err = err
+		} else {
+			r.Status = res.StatusCode
+			r.ResponseSize, r.Err = io.Copy(ioutil.Discard, res.Body)
+			res.Body.Close()
+		}
+		results = append(results, r)
+	}
+	return results
+}
+
+// AssertLimit checks that the handler at path accepts payloads of up to limit
+// bytes and rejects larger ones with a 4xx status, rather than failing with a
+// 5xx status or dropping the connection.
+func (sv *Server) AssertLimit(t testing.TB, method, path string, limit int) {
+	t.Helper()
+	for _, r := range sv.ProbeLimit(method, path, limit) {
+		switch {
+		case r.Err != nil:
+			t.Errorf("%s %s with %d bytes: %v", method, path, r.Size, r.Err)
+		case r.Size <= limit && r.Status >= 400:
+			t.Errorf("%s %s with %d bytes: got status %d, but expect the payload to be accepted", method, path, r.Size, r.Status)
+		case r.Size > limit && (r.Status < 400 || r.Status >= 500):
+			t.Errorf("%s %s with %d bytes: got status %d, but expect a 4xx status", method, path, r.Size, r.Status)
+		}
+	}
+}
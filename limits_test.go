@@ -0,0 +1,59 @@
+package gaetest
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPayload(t *testing.T) {
+	if p := Payload(100); len(p) != 100 || !bytes.Equal(p, Payload(100)) {
+		t.Fatalf("got %d bytes, but expect the same 100 bytes every time", len(p))
+	}
+}
+
+func TestProbeLimit(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 1000))
+		if len(body) == 1000 && r.URL.Path == "/broken" {
+			http.Error(w, "boom", http.StatusInternalServerError)
+			return
+		}
+		w.Write(body)
+	}))
+	defer ts.Close()
+	sv := &Server{ModuleURL: ts.URL}
+
+	results := sv.ProbeLimit("POST", "/", 1000)
+	if len(results) != 3 || results[0].Status != 200 || results[1].ResponseSize != 1000 || results[2].Status != 200 {
+		t.Fatalf("got %+v, but expect 3 results", results)
+	}
+
+	ft := &fakeTB{}
+	sv.AssertLimit(ft, "POST", "/broken", 999)
+	expect := "POST /broken with 1000 bytes: got status 500, but expect a 4xx status"
+	if len(ft.errors) != 1 || ft.errors[0] != expect {
+		t.Fatalf("got %q, but expect %q", ft.errors, expect)
+	}
+}
+
+// fakeTB records the errors reported through it.
+type fakeTB struct {
+	testing.TB
+	errors []string
+}
+
+func (t *fakeTB) Helper() {}
+
+func (t *fakeTB) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func (t *fakeTB) Error(args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprint(args...))
+}
+
+func (t *fakeTB) Fail() {}
@@ -0,0 +1,98 @@
+package gaetest
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+// LogEntry is a line of output of dev_appserver.py.
+type LogEntry struct {
+	// Time is the timestamp of the lines dev_appserver.py logs itself, and
+	// the time the other lines were read.
+	Time time.Time
+	// Level is DEBUG, INFO, WARNING, ERROR or CRITICAL for the lines
+	// dev_appserver.py logs itself, empty for the output of the app.
+	Level string
+	// Source is the Python file and line logging the entry, e.g.
+	// "module.py:1500", or "app" for the output of the app.
+	Source  string
+	Message string
+}
+
+// logEntryRE splits the lines dev_appserver.py logs itself, see serverLogRE.
+var logEntryRE = regexp.MustCompile(`^(DEBUG|INFO|WARNING|ERROR|CRITICAL)\s+(\d{4}-\d\d-\d\d [\d:,.]+) (\S+\.py:\d+)\] (.*)$`)
+
+// logTimeLayout is the layout of the timestamps of dev_appserver.py.
+const logTimeLayout = "2006-01-02 15:04:05,000"
+
+// parseLogEntry parses line, read at now. prev is the entry of the line
+// before, if any: continuation lines of a server entry inherit its level and
+// source.
+func parseLogEntry(line string, prev *LogEntry, now time.Time) LogEntry {
+	if m := logEntryRE.FindStringSubmatch(line); m != nil {
+		t, err := time.ParseInLocation(logTimeLayout, m[2], time.Local)
+		if err != nil {
+			t = now
+		}
+		return LogEntry{Time: t, Level: m[1], Source: m[3], Message: m[4]}
+	}
+	if prev != nil && prev.Level != "" && strings.HasPrefix(line, " ") {
+		return LogEntry{Time: prev.Time, Level: prev.Level, Source: prev.Source, Message: line}
+	}
+	return LogEntry{Time: now, Source: "app", Message: line}
+}
+
+// entries returns a copy of the recorded entries at or after since.
+func (b *logBuffer) entries(since time.Time) []LogEntry {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var entries []LogEntry
+	older, newer := b.ordered()
+	for _, part := range [][]logLine{older, newer} {
+		for _, l := range part {
+			if !l.entry.Time.Before(since) {
+				entries = append(entries, l.entry)
+			}
+		}
+	}
+	return entries
+}
+
+func (b *logBuffer) subscribe(buffer int) (<-chan LogEntry, func()) {
+	c := make(chan LogEntry, buffer)
+	b.mu.Lock()
+	b.subs = append(b.subs, c)
+	b.mu.Unlock()
+	return c, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		for i, s := range b.subs {
+			if s == c {
+				b.subs = append(b.subs[:i], b.subs[i+1:]...)
+				close(c)
+				break
+			}
+		}
+	}
+}
+
+// Logs returns the recorded output of dev_appserver.py, the last 10000
+// lines, as entries.
+func (sv *Server) Logs() []LogEntry {
+	return sv.logs.entries(time.Time{})
+}
+
+// LogsSince returns the recorded entries with a Time at or after t, e.g.
+// those logged while a test ran.
+func (sv *Server) LogsSince(t time.Time) []LogEntry {
+	return sv.logs.entries(t)
+}
+
+// SubscribeLogs returns a channel receiving the entries logged from now on,
+// and a function to cancel the subscription, which closes the channel. The
+// channel buffers up to buffer entries; when it is full, entries are dropped
+// rather than holding up the output of dev_appserver.py.
+func (sv *Server) SubscribeLogs(buffer int) (<-chan LogEntry, func()) {
+	return sv.logs.subscribe(buffer)
+}
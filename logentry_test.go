@@ -0,0 +1,42 @@
+package gaetest
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLogEntries(t *testing.T) {
+	b, _ := newLogBuffer(nil)
+	c, cancel := b.subscribe(10)
+	start := time.Now()
+	fmt.Fprint(b, "WARNING  2016-10-02 21:48:16,694 module.py:1500] Detected file changes:\n  /app/main.go\napp says hi\n")
+
+	at := time.Date(2016, 10, 2, 21, 48, 16, 694000000, time.Local)
+	entries := b.entries(time.Time{})
+	expect := []LogEntry{
+		{Time: at, Level: "WARNING", Source: "module.py:1500", Message: "Detected file changes:"},
+		{Time: at, Level: "WARNING", Source: "module.py:1500", Message: "  /app/main.go"},
+	}
+	if len(entries) != 3 || !reflect.DeepEqual(entries[:2], expect) {
+		t.Fatalf("got %+v, but expect %+v", entries, expect)
+	}
+	if e := entries[2]; e.Level != "" || e.Source != "app" || e.Message != "app says hi" || e.Time.Before(start) {
+		t.Fatalf("got %+v, but expect an app entry", e)
+	}
+	if since := b.entries(start); len(since) != 1 || since[0].Message != "app says hi" {
+		t.Fatalf("got %+v, but expect the app entry only", since)
+	}
+
+	for i := 0; i < 3; i++ {
+		if e := <-c; e != entries[i] {
+			t.Fatalf("got %+v, but expect %+v", e, entries[i])
+		}
+	}
+	cancel()
+	fmt.Fprint(b, "after\n")
+	if _, ok := <-c; ok {
+		t.Fatalf("received an entry after cancelling the subscription")
+	}
+}
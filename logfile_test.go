@@ -0,0 +1,72 @@
+package gaetest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gaetest-logfile")
+	if err != nil {
+		t.Fatalf("TempDir returned %v, expected nil", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "dev_appserver.log")
+
+	r, err := openRotatingFile(path, 10, 0, 2)
+	if err != nil {
+		t.Fatalf("openRotatingFile returned %v, expected nil", err)
+	}
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := r.Write([]byte(line)); err != nil {
+			t.Fatalf("Write returned %v, expected nil", err)
+		}
+	}
+	r.Close()
+
+	for name, expect := range map[string]string{
+		"dev_appserver.log":   "fourth\n",
+		"dev_appserver.log.1": "third\n",
+		"dev_appserver.log.2": "second\n",
+	} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("ReadFile returned %v, expected nil", err)
+		}
+		if string(data) != expect {
+			t.Fatalf("got %q in %s, but expect %q", data, name, expect)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fatalf("got %v, but expect only 2 backups", err)
+	}
+}
+
+func TestRotatingFileMaxAge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gaetest-logfile")
+	if err != nil {
+		t.Fatalf("TempDir returned %v, expected nil", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "dev_appserver.log")
+
+	r, err := openRotatingFile(path, 0, time.Hour, 0)
+	if err != nil {
+		t.Fatalf("openRotatingFile returned %v, expected nil", err)
+	}
+	defer r.Close()
+	r.Write([]byte("old\n"))
+	r.opened = r.opened.Add(-2 * time.Hour)
+	r.Write([]byte("new\n"))
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile returned %v, expected nil", err)
+	}
+	if string(data) != "new\n" {
+		t.Fatalf("got %q, but expect %q", data, "new\n")
+	}
+}
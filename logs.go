@@ -0,0 +1,243 @@
+package gaetest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// maxLogLines is the number of lines of output kept by a logBuffer.
+const maxLogLines = 10000
+
+// defaultFailOnLogPatterns are used when Options.FailOnLogPattern is nil. They
+// match Go panics and lines logged at the ERROR level.
+var defaultFailOnLogPatterns = []string{`panic: `, `^ERROR\s`}
+
+// serverLogRE matches the lines dev_appserver.py logs itself. They name the
+// Python source file logging them, e.g.
+//
+//	INFO     2016-10-02 21:48:16,694 devappserver2.py:769] Skipping SDK update check.
+//
+// All other lines are written by the app.
+var serverLogRE = regexp.MustCompile(`^(DEBUG|INFO|WARNING|ERROR|CRITICAL)\s+\d{4}-\d\d-\d\d [\d:,.]+ \S+\.py:\d+\] `)
+
+// logLine is a line of output.
+type logLine struct {
+	text   string
+	server bool // logged by dev_appserver.py rather than the app
+	entry  LogEntry
+}
+
+// logBuffer records the lines written to it, keeping the last maxLogLines.
+// Lines matching one of the fail patterns are kept separately, regardless of
+// the limit.
+type logBuffer struct {
+	mu      sync.Mutex
+	partial []byte
+	lines   []logLine // a ring once full, oldest at head
+	head    int
+	fail    []*regexp.Regexp
+	failed  []string
+
+	violations []HealthViolation
+	classes    map[string]int // requests served, by class
+	crash      *crashDetector // nil unless enabled
+	subs       []chan LogEntry
+}
+
+func newLogBuffer(failPatterns []string) (*logBuffer, error) {
+	b := &logBuffer{classes: make(map[string]int)}
+	for _, p := range failPatterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid log pattern %q: %v", p, err)
+		}
+		b.fail = append(b.fail, re)
+	}
+	return b, nil
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.partial = append(b.partial, p...)
+	for {
+		i := bytes.IndexByte(b.partial, '\n')
+		if i < 0 {
+			break
+		}
+		b.add(strings.TrimSuffix(string(b.partial[:i]), "\r"))
+		b.partial = b.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+func (b *logBuffer) add(line string) {
+	if v, ok := parseViolation(line); ok {
+		b.violations = append(b.violations, v)
+	}
+	if r, ok := parseRequestLog(line); ok {
+		b.classes[requestClass(r.path)]++
+	}
+	if b.crash != nil {
+		b.crash.line(line)
+	}
+	for _, re := range b.fail {
+		if re.MatchString(line) {
+			b.failed = append(b.failed, line)
+			break
+		}
+	}
+	server := serverLogRE.MatchString(line)
+	last := b.last()
+	if !server && last != nil && strings.HasPrefix(line, " ") {
+		// Continuation lines, like the files listed after "Detected file
+		// changes:", belong to the line before them.
+		server = last.server
+	}
+	var prev *LogEntry
+	if last != nil {
+		prev = &last.entry
+	}
+	l := logLine{text: line, server: server, entry: parseLogEntry(line, prev, time.Now())}
+	if len(b.lines) < maxLogLines {
+		b.lines = append(b.lines, l)
+	} else {
+		b.lines[b.head] = l
+		b.head = (b.head + 1) % maxLogLines
+	}
+	for _, c := range b.subs {
+		select {
+		case c <- l.entry:
+		default: // the subscriber is not keeping up
+		}
+	}
+}
+
+// last returns the line recorded last, nil if there is none.
+func (b *logBuffer) last() *logLine {
+	if len(b.lines) == 0 {
+		return nil
+	}
+	return &b.lines[(b.head+len(b.lines)-1)%len(b.lines)]
+}
+
+// ordered returns the recorded lines, oldest first, in two parts sharing the
+// storage of the ring.
+func (b *logBuffer) ordered() (older, newer []logLine) {
+	return b.lines[b.head:], b.lines[:b.head]
+}
+
+// snapshot returns a copy of the recorded lines.
+func (b *logBuffer) snapshot() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	lines := make([]string, 0, len(b.lines))
+	older, newer := b.ordered()
+	for _, part := range [][]logLine{older, newer} {
+		for _, l := range part {
+			lines = append(lines, l.text)
+		}
+	}
+	return lines
+}
+
+// filter returns a copy of the recorded lines logged by dev_appserver.py if
+// server is true, or by the app otherwise.
+func (b *logBuffer) filter(server bool) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var lines []string
+	older, newer := b.ordered()
+	for _, part := range [][]logLine{older, newer} {
+		for _, l := range part {
+			if l.server == server {
+				lines = append(lines, l.text)
+			}
+		}
+	}
+	return lines
+}
+
+// failures returns an error describing the lines that matched a fail pattern.
+func (b *logBuffer) failures() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.failed) == 0 {
+		return nil
+	}
+	return fmt.Errorf("dev_appserver.py logged %d lines matching Options.FailOnLogPattern, the first was %q", len(b.failed), b.failed[0])
+}
+
+// fanout writes to every writer in sinks. Unlike io.MultiWriter it carries on
+// when one of them fails, since a broken sink must not stop the output of
+// dev_appserver.py from being read.
+type fanout []io.Writer
+
+func (f fanout) Write(p []byte) (int, error) {
+	for _, w := range f {
+		w.Write(p)
+	}
+	return len(p), nil
+}
+
+// testLogWriter logs every complete line written to it with t.Log.
+type testLogWriter struct {
+	t       testing.TB
+	mu      sync.Mutex
+	partial []byte
+}
+
+// TestLogWriter returns a writer logging every line written to it with t.Log,
+// for use in Options.LogSinks. The server must be closed before the test
+// ends.
+func TestLogWriter(t testing.TB) io.Writer {
+	return &testLogWriter{t: t}
+}
+
+func (w *testLogWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		w.t.Log(string(w.partial[:i]))
+		w.partial = w.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// AssertNoLogs reports an error to t for every line of dev_appserver.py
+// output recorded so far that matches pattern.
+func (sv *Server) AssertNoLogs(t testing.TB, pattern string) {
+	t.Helper()
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		t.Fatalf("invalid log pattern %q: %v", pattern, err)
+	}
+	for _, line := range sv.logs.snapshot() {
+		if re.MatchString(line) {
+			t.Errorf("dev_appserver.py logged %q, matching %q", line, pattern)
+		}
+	}
+}
+
+// AppLogs returns the recorded lines of output written by the app, e.g. with
+// the log package, leaving out the lines dev_appserver.py logs itself.
+func (sv *Server) AppLogs() []string {
+	return sv.logs.filter(false)
+}
+
+// ServerLogs returns the recorded lines of output logged by dev_appserver.py
+// itself, including the request log.
+func (sv *Server) ServerLogs() []string {
+	return sv.logs.filter(true)
+}
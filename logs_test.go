@@ -0,0 +1,144 @@
+package gaetest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLogBuffer(t *testing.T) {
+	b, err := newLogBuffer(defaultFailOnLogPatterns)
+	if err != nil {
+		t.Fatalf("newLogBuffer returned %v, expected nil", err)
+	}
+	fmt.Fprint(b, output)
+	if err := b.failures(); err != nil {
+		t.Fatalf("got %v, but expect no failures", err)
+	}
+	fmt.Fprint(b, "ERROR    2016-10-02 21:50:01,001 module.py:400] default: broken\npanic: runtime er")
+	fmt.Fprint(b, "ror\n")
+
+	lines := b.snapshot()
+	if expect := "panic: runtime error"; lines[len(lines)-1] != expect {
+		t.Fatalf("got %q, but expect %q", lines[len(lines)-1], expect)
+	}
+	expect := `dev_appserver.py logged 2 lines matching Options.FailOnLogPattern, the first was "ERROR    2016-10-02 21:50:01,001 module.py:400] default: broken"`
+	if err := b.failures(); err == nil || err.Error() != expect {
+		t.Fatalf("got %v, but expect %q", err, expect)
+	}
+
+	if _, err := newLogBuffer([]string{"("}); err == nil {
+		t.Fatalf("newLogBuffer returned nil for an invalid pattern, expected an error")
+	}
+}
+
+func TestLogBufferWraps(t *testing.T) {
+	b, err := newLogBuffer(nil)
+	if err != nil {
+		t.Fatalf("newLogBuffer returned %v, expected nil", err)
+	}
+	for i := 0; i < maxLogLines+4; i++ {
+		fmt.Fprintf(b, "line %d\n", i)
+	}
+	fmt.Fprint(b, "INFO     2016-10-02 21:50:01,001 module.py:400] Detected file changes:\n  app.go\n")
+
+	lines := b.snapshot()
+	if len(lines) != maxLogLines {
+		t.Fatalf("got %d lines, but expect %d", len(lines), maxLogLines)
+	}
+	for i, l := range lines[:maxLogLines-2] {
+		if expect := fmt.Sprintf("line %d", i+6); l != expect {
+			t.Fatalf("got %q at %d, but expect %q", l, i, expect)
+		}
+	}
+	// The continuation line follows the line before it across the wrap.
+	if server := b.filter(true); !reflect.DeepEqual(server, lines[maxLogLines-2:]) {
+		t.Fatalf("got %q, but expect the last 2 lines", server)
+	}
+	entries := b.entries(time.Time{})
+	if len(entries) != maxLogLines || entries[0].Message != "line 6" || entries[maxLogLines-1].Level != "INFO" {
+		t.Fatalf("got %d entries from %+v to %+v, but expect them in order", len(entries), entries[0], entries[len(entries)-1])
+	}
+}
+
+func TestLogBufferFilter(t *testing.T) {
+	b, err := newLogBuffer(nil)
+	if err != nil {
+		t.Fatalf("newLogBuffer returned %v, expected nil", err)
+	}
+	fmt.Fprint(b, "INFO     2016-10-02 21:48:16,694 devappserver2.py:769] Skipping SDK update check.\n")
+	fmt.Fprint(b, "2016/10/02 21:48:18 handling /\n")
+	fmt.Fprint(b, "INFO     2016-10-02 21:50:01,000 module.py:444] Detected file changes:\n  /app/main.go\n")
+
+	expect := []string{"2016/10/02 21:48:18 handling /"}
+	if app := b.filter(false); !reflect.DeepEqual(app, expect) {
+		t.Fatalf("got %q, but expect %q", app, expect)
+	}
+	if server := b.filter(true); len(server) != 3 || server[2] != "  /app/main.go" {
+		t.Fatalf("got %q, but expect the 3 lines of dev_appserver.py", server)
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestFanout(t *testing.T) {
+	var a, b bytes.Buffer
+	f := fanout{&a, failingWriter{}, &b}
+	if n, err := f.Write([]byte("line\n")); n != 5 || err != nil {
+		t.Fatalf("got %d, %v, but expect 5, nil", n, err)
+	}
+	if a.String() != "line\n" || b.String() != "line\n" {
+		t.Fatalf("got %q and %q, but expect both writers to get the line", a.String(), b.String())
+	}
+}
+
+func TestLazyLogs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gaetest")
+	if err != nil {
+		t.Fatalf("TempDir returned %v, expected nil", err)
+	}
+	defer os.RemoveAll(dir)
+	sv, err := New(dir, &Options{Lazy: true, ArtifactDir: dir})
+	if err != nil {
+		t.Fatalf("New returned %v, expected nil", err)
+	}
+	defer sv.Close()
+
+	// Nothing was logged before the server starts, but nothing panics.
+	if lines := sv.AppLogs(); len(lines) != 0 {
+		t.Fatalf("got %q, but expect no app logs", lines)
+	}
+	if lines := sv.ServerLogs(); len(lines) != 0 {
+		t.Fatalf("got %q, but expect no server logs", lines)
+	}
+	sv.AssertNoLogs(t, "ERROR")
+	if entries := sv.Logs(); len(entries) != 0 {
+		t.Fatalf("got %v, but expect no entries", entries)
+	}
+	if entries := sv.LogsSince(time.Now()); len(entries) != 0 {
+		t.Fatalf("got %v, but expect no entries", entries)
+	}
+	_, unsubscribe := sv.SubscribeLogs(1)
+	unsubscribe()
+	if v := sv.HealthViolations(); len(v) != 0 {
+		t.Fatalf("got %v, but expect no violations", v)
+	}
+	if classes := sv.RequestClasses(); len(classes) != 0 {
+		t.Fatalf("got %v, but expect no request classes", classes)
+	}
+	if err := sv.CrashLoop(); err != nil {
+		t.Fatalf("CrashLoop returned %v, expected nil", err)
+	}
+	if _, err := sv.WriteTriageBundle("lazy"); err != nil {
+		t.Fatalf("WriteTriageBundle returned %v, expected nil", err)
+	}
+}
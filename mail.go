@@ -0,0 +1,173 @@
+package gaetest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Attachment is a file attached to an InboundMail.
+type Attachment struct {
+	Name        string
+	ContentType string // defaults to application/octet-stream
+	Data        []byte
+}
+
+// InboundMail is a message delivered to the app's inbound mail handlers.
+type InboundMail struct {
+	From        string
+	To          []string
+	Cc          []string
+	Subject     string
+	Body        string // plain text body
+	HTMLBody    string
+	Attachments []Attachment
+}
+
+// Bytes returns the message in RFC 822 format, as App Engine posts it.
+func (m *InboundMail) Bytes() ([]byte, error) {
+	if m.From == "" || len(m.To) == 0 {
+		return nil, errors.New("mail: From and To are required")
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", m.From)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(m.To, ", "))
+	if len(m.Cc) > 0 {
+		fmt.Fprintf(&buf, "Cc: %s\r\n", strings.Join(m.Cc, ", "))
+	}
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+
+	mixed := multipart.NewWriter(&buf)
+	fmt.Fprintf(&buf, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", mixed.Boundary())
+
+	// The bodies go into a multipart/alternative part of their own.
+	var alt bytes.Buffer
+	altw := multipart.NewWriter(&alt)
+	if err := writeTextPart(altw, "text/plain", m.Body); err != nil {
+		return nil, err
+	}
+	if m.HTMLBody != "" {
+		if err := writeTextPart(altw, "text/html", m.HTMLBody); err != nil {
+			return nil, err
+		}
+	}
+	altw.Close()
+	part, err := mixed.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {"multipart/alternative; boundary=" + altw.Boundary()},
+	})
+	if err != nil {
+		return nil, err
+	}
+	part.Write(alt.Bytes())
+
+	for _, a := range m.Attachments {
+		ctype := a.ContentType
+		if ctype == "" {
+			ctype = "application/octet-stream"
+		}
+		part, err := mixed.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {mime.FormatMediaType(ctype, map[string]string{"name": a.Name})},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": a.Name})},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		writeBase64(part, a.Data)
+	}
+	mixed.Close()
+	return buf.Bytes(), nil
+}
+
+func writeTextPart(w *multipart.Writer, ctype, text string) error {
+	part, err := w.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {ctype + "; charset=utf-8"},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	if err != nil {
+		return err
+	}
+	writeBase64(part, []byte(text))
+	return nil
+}
+
+// writeBase64 writes data base64 encoded in lines of 76 characters.
+func writeBase64(w io.Writer, data []byte) {
+	enc := base64.StdEncoding.EncodeToString(data)
+	for len(enc) > 76 {
+		w.Write([]byte(enc[:76] + "\r\n"))
+		enc = enc[76:]
+	}
+	w.Write([]byte(enc + "\r\n"))
+}
+
+// SendMail posts m to /_ah/mail/<address> for every recipient in To, the way
+// App Engine delivers inbound mail. It returns the response to the last
+// delivery.
+func (sv *Server) SendMail(m *InboundMail) (*http.Response, error) {
+	msg, err := m.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	var res *http.Response
+	for _, to := range m.To {
+		if res != nil {
+			res.Body.Close()
+		}
+		req := sv.newRequest("POST", "/_ah/mail/"+url.PathEscape(to), msg)
+		req.Header.Set("Content-Type", "message/rfc822")
+		req.Header.Set(fakeIsAdminHeader, "1")
+		if res, err = sv.httpClient().Do(req); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// Bounce is a notification about a message the app sent that could not be
+// delivered.
+type Bounce struct {
+	// Original is the message that bounced.
+	Original *InboundMail
+	// Notification is the bounce message sent by the receiving mail server.
+	Notification *InboundMail
+}
+
+// SendBounce posts b to /_ah/bounce the way App Engine delivers bounce
+// notifications.
+func (sv *Server) SendBounce(b *Bounce) (*http.Response, error) {
+	if b.Original == nil || b.Notification == nil {
+		return nil, errors.New("mail: Original and Notification are required")
+	}
+	raw, err := b.Original.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for prefix, m := range map[string]*InboundMail{"original": b.Original, "notification": b.Notification} {
+		w.WriteField(prefix+"-from", m.From)
+		w.WriteField(prefix+"-to", strings.Join(m.To, ", "))
+		w.WriteField(prefix+"-cc", strings.Join(m.Cc, ", "))
+		w.WriteField(prefix+"-subject", m.Subject)
+		w.WriteField(prefix+"-text", m.Body)
+	}
+	w.WriteField("raw-message", string(raw))
+	w.Close()
+
+	req := sv.newRequest("POST", "/_ah/bounce", body.Bytes())
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	req.Header.Set(fakeIsAdminHeader, "1")
+	return sv.httpClient().Do(req)
+}
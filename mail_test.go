@@ -0,0 +1,67 @@
+package gaetest
+
+import (
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+func TestSendMail(t *testing.T) {
+	var got *mail.Message
+	var path string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		var err error
+		if got, err = mail.ReadMessage(r.Body); err != nil {
+			t.Errorf("ReadMessage returned %v, expected nil", err)
+		}
+	}))
+	defer ts.Close()
+
+	sv := &Server{ModuleURL: ts.URL}
+	res, err := sv.SendMail(&InboundMail{
+		From:        "bob@example.com",
+		To:          []string{"support@gaetest.appspotmail.com"},
+		Subject:     "Help",
+		Body:        "Please help.",
+		Attachments: []Attachment{{Name: "log.txt", ContentType: "text/plain", Data: []byte("error")}},
+	})
+	if err != nil {
+		t.Fatalf("SendMail returned %v, expected nil", err)
+	}
+	res.Body.Close()
+	if expect := "/_ah/mail/support@gaetest.appspotmail.com"; path != expect {
+		t.Fatalf("got path %q, but expect %q", path, expect)
+	}
+	if got.Header.Get("Subject") != "Help" {
+		t.Fatalf("got subject %q, but expect %q", got.Header.Get("Subject"), "Help")
+	}
+
+	_, params, err := mime.ParseMediaType(got.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("ParseMediaType returned %v, expected nil", err)
+	}
+	mr := multipart.NewReader(got.Body, params["boundary"])
+	var parts []string
+	for {
+		p, err := mr.NextPart()
+		if err != nil {
+			break
+		}
+		parts = append(parts, p.Header.Get("Content-Type"))
+		if p.FileName() == "log.txt" {
+			data, _ := ioutil.ReadAll(p)
+			if !strings.Contains(string(data), "ZXJyb3I=") {
+				t.Fatalf("got attachment %q, but expect base64 of %q", data, "error")
+			}
+		}
+	}
+	if len(parts) != 2 || !strings.HasPrefix(parts[0], "multipart/alternative") {
+		t.Fatalf("got parts %q, but expect a body and an attachment", parts)
+	}
+}
@@ -0,0 +1,148 @@
+package gaetest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"testing"
+)
+
+// Migration describes a datastore migration checked by VerifyMigration.
+type Migration struct {
+	// Before and After are fixture files, see ExportFixtures: the entities
+	// to migrate and those expected once the migration ran.
+	Before, After string
+
+	// Run runs the migration, e.g. by calling the migration code of the app
+	// or by requesting one of its endpoints.
+	Run func(sv *Server) error
+	// Path, used when Run is nil, is requested with a POST on the app to run
+	// the migration. The response must have a 2xx status.
+	Path string
+}
+
+// VerifyMigration loads the Before fixtures, runs the migration and compares
+// the entities of the kinds in the After fixtures with them. Every missing,
+// unexpected or different entity is reported with t.Errorf. Entities left in
+// the datastore by other tests are reported as unexpected, so VerifyMigration
+// is best called after Isolate.
+func (sv *Server) VerifyMigration(t testing.TB, m Migration) {
+	t.Helper()
+	diffs, err := sv.verifyMigration(m)
+	if err != nil {
+		t.Fatalf("gaetest: migration: %v", err)
+	}
+	for _, d := range diffs {
+		t.Errorf("migration: %s", d)
+	}
+}
+
+func (sv *Server) verifyMigration(m Migration) ([]string, error) {
+	before, err := readFixtureFile(m.Before)
+	if err != nil {
+		return nil, err
+	}
+	after, err := readFixtureFile(m.After)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := sv.putFixtures(before); err != nil {
+		return nil, fmt.Errorf("loading %s: %v", m.Before, err)
+	}
+	run := m.Run
+	if run == nil {
+		run = func(sv *Server) error { return sv.postMigration(m.Path) }
+	}
+	if err := run(sv); err != nil {
+		return nil, fmt.Errorf("running: %v", err)
+	}
+
+	expected := make(map[string]map[string]interface{})
+	kinds := make(map[string]bool)
+	for _, e := range after {
+		expected[e.Key.String()] = e.Properties
+		kinds[e.Key.Kind] = true
+	}
+	actual := make(map[string]map[string]interface{})
+	for kind := range kinds {
+		encoded, err := sv.queryKind(kind)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", kind, err)
+		}
+		for _, data := range encoded {
+			key, props, err := decodeEntity(data)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", kind, err)
+			}
+			actual[key.String()] = props
+		}
+	}
+
+	var diffs []string
+	for key, props := range expected {
+		got, ok := actual[key]
+		if !ok {
+			diffs = append(diffs, key+": missing")
+			continue
+		}
+		diffs = append(diffs, diffProperties(key, got, props)...)
+	}
+	for key := range actual {
+		if _, ok := expected[key]; !ok {
+			diffs = append(diffs, key+": unexpected")
+		}
+	}
+	sort.Strings(diffs)
+	return diffs, nil
+}
+
+// diffProperties compares the properties of the entity at key, in their
+// fixture form.
+func diffProperties(key string, got, expect map[string]interface{}) []string {
+	var diffs []string
+	for name, v := range expect {
+		g, ok := got[name]
+		switch {
+		case !ok:
+			diffs = append(diffs, fmt.Sprintf("%s: property %s: missing, expect %s", key, name, fixtureJSON(v)))
+		case fixtureJSON(g) != fixtureJSON(v):
+			diffs = append(diffs, fmt.Sprintf("%s: property %s: got %s, but expect %s", key, name, fixtureJSON(g), fixtureJSON(v)))
+		}
+	}
+	for name, v := range got {
+		if _, ok := expect[name]; !ok {
+			diffs = append(diffs, fmt.Sprintf("%s: property %s: unexpected %s", key, name, fixtureJSON(v)))
+		}
+	}
+	return diffs
+}
+
+// fixtureJSON formats a property value as in fixture files.
+func fixtureJSON(v interface{}) string {
+	data, err := json.Marshal(fixtureValue(v))
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
+
+// postMigration runs the migration at path with a POST on the app.
+func (sv *Server) postMigration(path string) error {
+	if path == "" {
+		return errors.New("neither Run nor Path is set")
+	}
+	res, err := sv.Client().Do(sv.newRequest("POST", path, nil))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	body, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("POST %s: got status %d: %s", path, res.StatusCode, bytes.TrimSpace(body))
+	}
+	return nil
+}
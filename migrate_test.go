@@ -0,0 +1,75 @@
+package gaetest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeFixtureFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("WriteFile returned %v, expected nil", err)
+	}
+	return path
+}
+
+func TestVerifyMigration(t *testing.T) {
+	var stored []storedEntity
+	ts := newDatastoreStub(&stored)
+	defer ts.Close()
+	sv := &Server{APIURL: ts.URL, opts: withDefaults(nil)}
+
+	m := Migration{
+		Before: writeFixtureFile(t, "before.json", `[
+  {"key": {"kind": "User", "id": 1}, "properties": {"Name": "Ann Lee", "Score": {"float": 9.5}}},
+  {"key": {"kind": "User", "id": 2}, "properties": {"Name": "Bob"}}
+]`),
+		After: writeFixtureFile(t, "after.json", `[
+  {"key": {"kind": "User", "id": 1}, "properties": {"First": "Ann", "Last": "Lee", "Score": {"float": 9.5}}},
+  {"key": {"kind": "User", "id": 2}, "properties": {"First": "Bob"}},
+  {"key": {"kind": "User", "id": 3}, "properties": {"First": "Cy"}}
+]`),
+		// Migrates the first user only.
+		Run: func(sv *Server) error {
+			_, err := sv.putFixtures([]FixtureEntity{{
+				Key:        &Key{Kind: "User", ID: 1},
+				Properties: map[string]interface{}{"First": "Ann", "Last": "Lee", "Score": 9.5},
+			}})
+			return err
+		},
+	}
+	diffs, err := sv.verifyMigration(m)
+	if err != nil {
+		t.Fatalf("verifyMigration returned %v, expected nil", err)
+	}
+	expect := []string{
+		`User,2: property First: missing, expect "Bob"`,
+		`User,2: property Name: unexpected "Bob"`,
+		`User,3: missing`,
+	}
+	if !reflect.DeepEqual(diffs, expect) {
+		t.Fatalf("got %q, but expect %q", diffs, expect)
+	}
+}
+
+func TestVerifyMigrationPath(t *testing.T) {
+	app := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no such migration", http.StatusNotFound)
+	}))
+	defer app.Close()
+	var stored []storedEntity
+	ts := newDatastoreStub(&stored)
+	defer ts.Close()
+	sv := &Server{APIURL: ts.URL, ModuleURL: app.URL, opts: withDefaults(nil)}
+
+	empty := writeFixtureFile(t, "empty.json", `[]`)
+	_, err := sv.verifyMigration(Migration{Before: empty, After: empty, Path: "/migrate"})
+	expect := "running: POST /migrate: got status 404: no such migration"
+	if err == nil || err.Error() != expect {
+		t.Fatalf("got %v, but expect %q", err, expect)
+	}
+}
@@ -0,0 +1,114 @@
+package gaetest
+
+// Error codes of the modules service.
+const (
+	ModulesInvalidModule    = 1
+	ModulesInvalidVersion   = 2
+	ModulesInvalidInstances = 3
+	ModulesTransientError   = 4
+	ModulesUnexpectedState  = 5
+)
+
+// Modules controls the modules (services) of the app through the modules
+// service stub of the dev server, like the Modules API does from within the
+// app. Errors of the stub are returned as *APIError, with one of the Modules
+// error codes.
+type Modules struct {
+	sv *Server
+}
+
+// Modules returns the controller of the modules of the app.
+func (sv *Server) Modules() *Modules {
+	return &Modules{sv: sv}
+}
+
+func (m *Modules) call(method string, req *pbMessage) ([]pbField, error) {
+	data, err := m.sv.callAPI("modules", method, req.buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return parsePB(data)
+}
+
+// moduleRequest returns a request naming module and, if not empty, version.
+func moduleRequest(module, version string) *pbMessage {
+	req := &pbMessage{}
+	req.string(1, module)
+	if version != "" {
+		req.string(2, version)
+	}
+	return req
+}
+
+// List returns the names of the modules of the app.
+func (m *Modules) List() ([]string, error) {
+	fields, err := m.call("GetModules", &pbMessage{})
+	if err != nil {
+		return nil, err
+	}
+	var modules []string
+	for _, f := range fields {
+		if f.num == 1 {
+			modules = append(modules, string(f.data))
+		}
+	}
+	return modules, nil
+}
+
+// DefaultVersion returns the version of module serving by default.
+func (m *Modules) DefaultVersion(module string) (string, error) {
+	fields, err := m.call("GetDefaultVersion", moduleRequest(module, ""))
+	if err != nil {
+		return "", err
+	}
+	for _, f := range fields {
+		if f.num == 1 {
+			return string(f.data), nil
+		}
+	}
+	return "", nil
+}
+
+// NumInstances returns the number of instances of a manual scaling module. An
+// empty version stands for the default version.
+func (m *Modules) NumInstances(module, version string) (int, error) {
+	fields, err := m.call("GetNumInstances", moduleRequest(module, version))
+	if err != nil {
+		return 0, err
+	}
+	for _, f := range fields {
+		if f.num == 1 {
+			return int(f.varint), nil
+		}
+	}
+	return 0, nil
+}
+
+// SetNumInstances sets the number of instances of a manual scaling module. An
+// empty version stands for the default version.
+func (m *Modules) SetNumInstances(module, version string, instances int) error {
+	req := moduleRequest(module, version)
+	req.int64(3, int64(instances))
+	_, err := m.call("SetNumInstances", req)
+	return err
+}
+
+// Start starts version of module. An empty version stands for the default
+// version.
+func (m *Modules) Start(module, version string) error {
+	if version == "" {
+		var err error
+		if version, err = m.DefaultVersion(module); err != nil {
+			return err
+		}
+	}
+	_, err := m.call("StartModule", moduleRequest(module, version))
+	return err
+}
+
+// Stop stops version of module. An empty version stands for the default
+// version.
+func (m *Modules) Stop(module, version string) error {
+	_, err := m.call("StopModule", moduleRequest(module, version))
+	return err
+}
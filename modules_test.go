@@ -0,0 +1,92 @@
+package gaetest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// newModulesStub serves a modules service with one manual scaling module,
+// "worker", running 2 instances of version "v1".
+func newModulesStub(calls *[]string) *httptest.Server {
+	instances := int64(2)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		fields, _ := parsePB(body)
+		var method string
+		var req []pbField
+		for _, f := range fields {
+			switch f.num {
+			case 3:
+				method = string(f.data)
+			case 4:
+				req, _ = parsePB(f.data)
+			}
+		}
+		*calls = append(*calls, method)
+		var module string
+		for _, f := range req {
+			if f.num == 1 {
+				module = string(f.data)
+			}
+		}
+		res, out := &pbMessage{}, &pbMessage{}
+		if module != "" && module != "worker" {
+			var appErr pbMessage
+			appErr.int64(1, ModulesInvalidModule)
+			appErr.string(2, "unknown module")
+			res.bytes(3, appErr.buf.Bytes())
+			w.Write(res.buf.Bytes())
+			return
+		}
+		switch method {
+		case "GetModules":
+			out.string(1, "default")
+			out.string(1, "worker")
+		case "GetDefaultVersion":
+			out.string(1, "v1")
+		case "GetNumInstances":
+			out.int64(1, instances)
+		case "SetNumInstances":
+			for _, f := range req {
+				if f.num == 3 {
+					instances = int64(f.varint)
+				}
+			}
+		}
+		res.bytes(1, out.buf.Bytes())
+		w.Write(res.buf.Bytes())
+	}))
+}
+
+func TestModules(t *testing.T) {
+	var calls []string
+	ts := newModulesStub(&calls)
+	defer ts.Close()
+	m := (&Server{APIURL: ts.URL}).Modules()
+
+	modules, err := m.List()
+	if err != nil || !reflect.DeepEqual(modules, []string{"default", "worker"}) {
+		t.Fatalf("got %q, %v, but expect the default and worker modules", modules, err)
+	}
+	if err := m.SetNumInstances("worker", "", 5); err != nil {
+		t.Fatalf("SetNumInstances returned %v, expected nil", err)
+	}
+	if n, err := m.NumInstances("worker", ""); n != 5 || err != nil {
+		t.Fatalf("got %d, %v, but expect 5 instances", n, err)
+	}
+	calls = nil
+	if err := m.Start("worker", ""); err != nil {
+		t.Fatalf("Start returned %v, expected nil", err)
+	}
+	if expect := []string{"GetDefaultVersion", "StartModule"}; !reflect.DeepEqual(calls, expect) {
+		t.Fatalf("got calls %q, but expect %q", calls, expect)
+	}
+
+	err = m.Stop("mailer", "")
+	if apiErr, ok := err.(*APIError); !ok || apiErr.Code != ModulesInvalidModule {
+		t.Fatalf("got %v, but expect an INVALID_MODULE error", err)
+	}
+}
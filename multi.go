@@ -0,0 +1,116 @@
+package gaetest
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// NewMulti launches an instance of dev_appserver.py running several services
+// of an app, routed by the dispatch.yaml in dispatchDir. Each entry of
+// serviceDirs is the directory holding the app.yaml of a service, or the path
+// of a service's YAML file. dispatchDir may be empty for apps without
+// dispatch rules.
+//
+// NewMulti waits for every service to start, as if they were listed in
+// Options.ExpectServices. Server.ModuleURL points at the dispatcher, or at the
+// default service without dispatch.yaml; Server.ServiceURL returns the URL of
+// each service. The index.yaml of the default service is the one checked with
+// Options.CheckIndexes.
+func NewMulti(dispatchDir string, serviceDirs []string, opts *Options) (*Server, error) {
+	o := *withDefaults(opts)
+	opts = &o
+	configs, appDir, names, err := multiConfigs(dispatchDir, serviceDirs)
+	if err != nil {
+		return nil, err
+	}
+	expect := append([]string(nil), opts.ExpectServices...)
+	for _, name := range names {
+		if !contains(expect, name) {
+			expect = append(expect, name)
+		}
+	}
+	opts.ExpectServices = expect
+	return start(&Server{appDir: appDir, configs: configs, opts: opts})
+}
+
+// multiConfigs returns the YAML files passed to dev_appserver.py for NewMulti,
+// the directory of the default service and the names of the services.
+func multiConfigs(dispatchDir string, serviceDirs []string) (configs []string, appDir string, names []string, err error) {
+	if len(serviceDirs) == 0 {
+		return nil, "", nil, errors.New("no services")
+	}
+	for _, dir := range serviceDirs {
+		path := dir
+		if fi, err := os.Stat(dir); err != nil {
+			return nil, "", nil, err
+		} else if fi.IsDir() {
+			path = filepath.Join(dir, "app.yaml")
+		}
+		name, err := serviceName(path)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		if contains(names, name) {
+			return nil, "", nil, fmt.Errorf("%s: service %q is defined twice", path, name)
+		}
+		if name == "default" {
+			appDir = filepath.Dir(path)
+		}
+		configs = append(configs, path)
+		names = append(names, name)
+	}
+	if appDir == "" {
+		return nil, "", nil, fmt.Errorf("none of %v defines the default service", configs)
+	}
+	if dispatchDir != "" {
+		path := filepath.Join(dispatchDir, "dispatch.yaml")
+		if _, err := os.Stat(path); err != nil {
+			return nil, "", nil, err
+		}
+		configs = append(configs, path)
+	}
+	return configs, appDir, names, nil
+}
+
+// serviceName returns the service defined by the YAML file at path, from its
+// service or older module key.
+func serviceName(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	doc, err := parseYAML(data)
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", path, err)
+	}
+	root, ok := doc.(map[string]interface{})
+	if !ok {
+		return "", fmt.Errorf("%s: expected a mapping", path)
+	}
+	if name := yamlString(root, "service"); name != "" {
+		return name, nil
+	}
+	if name := yamlString(root, "module"); name != "" {
+		return name, nil
+	}
+	return "default", nil
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// ServiceURL returns the URL the service (module) called name runs at, or ""
+// if the server runs no such service. Requests sent to it bypass the
+// dispatcher and the proxy of Options.Proxy.
+func (sv *Server) ServiceURL(name string) string {
+	return sv.services[name]
+}
@@ -0,0 +1,82 @@
+package gaetest
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMultiConfigs(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"frontend/app.yaml":      "runtime: go111\n",
+		"worker/worker.yaml":     "runtime: go111\nservice: worker\n",
+		"legacy/app.yaml":        "runtime: go\nmodule: legacy\n",
+		"dispatch/dispatch.yaml": "dispatch:\n- url: \"*/work/*\"\n  service: worker\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("WriteFile returned %v, expected nil", err)
+		}
+	}
+
+	configs, appDir, names, err := multiConfigs(filepath.Join(dir, "dispatch"), []string{
+		filepath.Join(dir, "frontend"),
+		filepath.Join(dir, "worker", "worker.yaml"),
+		filepath.Join(dir, "legacy"),
+	})
+	if err != nil {
+		t.Fatalf("multiConfigs returned %v, expected nil", err)
+	}
+	expect := []string{
+		filepath.Join(dir, "frontend", "app.yaml"),
+		filepath.Join(dir, "worker", "worker.yaml"),
+		filepath.Join(dir, "legacy", "app.yaml"),
+		filepath.Join(dir, "dispatch", "dispatch.yaml"),
+	}
+	if !reflect.DeepEqual(configs, expect) {
+		t.Fatalf("got %q, but expect %q", configs, expect)
+	}
+	if expect := filepath.Join(dir, "frontend"); appDir != expect {
+		t.Fatalf("got %q, but expect %q", appDir, expect)
+	}
+	if expect := []string{"default", "worker", "legacy"}; !reflect.DeepEqual(names, expect) {
+		t.Fatalf("got %q, but expect %q", names, expect)
+	}
+
+	_, _, _, err = multiConfigs("", []string{filepath.Join(dir, "legacy")})
+	if err == nil {
+		t.Fatalf("multiConfigs returned nil, expected an error for a missing default service")
+	}
+}
+
+const dispatchOutput = `
+INFO     2016-10-02 21:48:16,776 api_server.py:205] Starting API server at: http://localhost:36415
+INFO     2016-10-02 21:48:16,903 dispatcher.py:185] Starting dispatcher running at: http://localhost:8080
+INFO     2016-10-02 21:48:16,904 dispatcher.py:197] Starting module "default" running at: http://localhost:8081
+INFO     2016-10-02 21:48:16,904 dispatcher.py:197] Starting module "worker" running at: http://localhost:8082
+INFO     2016-10-02 21:48:16,905 admin_server.py:116] Starting admin server at: http://localhost:8000
+`
+
+func TestGetURLsDispatcher(t *testing.T) {
+	ep, err := getURLs(bytes.NewBufferString(dispatchOutput), time.Second, &Options{})
+	if err != nil {
+		t.Fatalf("got error %q", err)
+	}
+	if expect := "http://localhost:8080"; ep.dispatcher != expect {
+		t.Fatalf("got %q, but expect %q", ep.dispatcher, expect)
+	}
+	sv := &Server{services: ep.services}
+	if got, expect := sv.ServiceURL("worker"), "http://localhost:8082"; got != expect {
+		t.Fatalf("got %q, but expect %q", got, expect)
+	}
+	if got := sv.ServiceURL("missing"); got != "" {
+		t.Fatalf("got %q, but expect %q", got, "")
+	}
+}
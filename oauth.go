@@ -0,0 +1,147 @@
+package gaetest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+)
+
+// OAuthStub is an OAuth 2 authorization server issuing predictable codes and
+// tokens: the n-th code issued is "code-n", the n-th access token is
+// "access-token-n" and the n-th refresh token is "refresh-token-n". It lets
+// apps doing three-legged OAuth against Google complete the flow hermetically.
+//
+// The stub serves /authorize, which redirects back to redirect_uri with a code
+// without asking anything, /token, which exchanges codes and refresh tokens,
+// and /userinfo, which reports Email for any valid access token.
+type OAuthStub struct {
+	// URL of the stub, e.g. http://127.0.0.1:45321.
+	URL string
+	// Email reported by /userinfo.
+	Email string
+
+	srv *httptest.Server
+
+	mu     sync.Mutex
+	seq    int
+	codes  map[string]bool
+	tokens map[string]bool
+}
+
+// NewOAuthStub starts an OAuth 2 stub server. It is started automatically when
+// Options.OAuthStub is set.
+func NewOAuthStub() *OAuthStub {
+	s := &OAuthStub{
+		Email:  "test@example.com",
+		codes:  make(map[string]bool),
+		tokens: make(map[string]bool),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/authorize", s.authorize)
+	mux.HandleFunc("/token", s.token)
+	mux.HandleFunc("/userinfo", s.userinfo)
+	s.srv = httptest.NewServer(mux)
+	s.URL = s.srv.URL
+	return s
+}
+
+// Close shuts the stub down.
+func (s *OAuthStub) Close() {
+	s.srv.Close()
+}
+
+// env returns the environment variables exposing the stub to the app.
+func (s *OAuthStub) env() []string {
+	return []string{
+		"GAETEST_OAUTH_AUTH_URL=" + s.URL + "/authorize",
+		"GAETEST_OAUTH_TOKEN_URL=" + s.URL + "/token",
+		"GAETEST_OAUTH_USERINFO_URL=" + s.URL + "/userinfo",
+	}
+}
+
+func (s *OAuthStub) next(prefix string) string {
+	s.seq++
+	return fmt.Sprintf("%s-%d", prefix, s.seq)
+}
+
+func (s *OAuthStub) authorize(w http.ResponseWriter, r *http.Request) {
+	redirect, err := url.Parse(r.FormValue("redirect_uri"))
+	if err != nil || !redirect.IsAbs() {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	code := s.next("code")
+	s.codes[code] = true
+	s.mu.Unlock()
+
+	q := redirect.Query()
+	q.Set("code", code)
+	if state := r.FormValue("state"); state != "" {
+		q.Set("state", state)
+	}
+	redirect.RawQuery = q.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (s *OAuthStub) token(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r.FormValue("grant_type") {
+	case "authorization_code":
+		code := r.FormValue("code")
+		if !s.codes[code] {
+			writeOAuthError(w, "invalid_grant")
+			return
+		}
+		delete(s.codes, code) // codes are single use
+	case "refresh_token":
+		if !s.tokens[r.FormValue("refresh_token")] {
+			writeOAuthError(w, "invalid_grant")
+			return
+		}
+	case "client_credentials":
+	default:
+		writeOAuthError(w, "unsupported_grant_type")
+		return
+	}
+	access, refresh := s.next("access-token"), s.next("refresh-token")
+	s.tokens[access], s.tokens[refresh] = true, true
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token":  access,
+		"refresh_token": refresh,
+		"token_type":    "Bearer",
+		"expires_in":    3600,
+	})
+}
+
+func (s *OAuthStub) userinfo(w http.ResponseWriter, r *http.Request) {
+	var token string
+	if _, err := fmt.Sscanf(r.Header.Get("Authorization"), "Bearer %s", &token); err != nil {
+		token = r.FormValue("access_token")
+	}
+	s.mu.Lock()
+	valid := s.tokens[token]
+	s.mu.Unlock()
+	if !valid {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"email": s.Email, "email_verified": true})
+}
+
+func writeOAuthError(w http.ResponseWriter, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]string{"error": code})
+}
+
+// OAuthStub returns the OAuth 2 stub started for Options.OAuthStub, or nil.
+func (sv *Server) OAuthStub() *OAuthStub {
+	return sv.oauth
+}
@@ -0,0 +1,59 @@
+package gaetest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestOAuthStubFlow(t *testing.T) {
+	s := NewOAuthStub()
+	defer s.Close()
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	res, err := client.Get(s.URL + "/authorize?state=xyz&redirect_uri=" +
+		url.QueryEscape("http://localhost:8080/oauth2callback"))
+	if err != nil {
+		t.Fatalf("Get returned %v, expected nil", err)
+	}
+	res.Body.Close()
+	loc, err := res.Location()
+	if err != nil {
+		t.Fatalf("Location returned %v, expected nil", err)
+	}
+	if code, state := loc.Query().Get("code"), loc.Query().Get("state"); code != "code-1" || state != "xyz" {
+		t.Fatalf("got code %q state %q, but expect %q and %q", code, state, "code-1", "xyz")
+	}
+
+	exchange := func() (int, map[string]interface{}) {
+		res, err := http.PostForm(s.URL+"/token", url.Values{"grant_type": {"authorization_code"}, "code": {"code-1"}})
+		if err != nil {
+			t.Fatalf("PostForm returned %v, expected nil", err)
+		}
+		defer res.Body.Close()
+		var tok map[string]interface{}
+		json.NewDecoder(res.Body).Decode(&tok)
+		return res.StatusCode, tok
+	}
+	status, tok := exchange()
+	if status != http.StatusOK || tok["access_token"] != "access-token-2" {
+		t.Fatalf("got %d %v, but expect access-token-2", status, tok)
+	}
+	if status, _ := exchange(); status != http.StatusBadRequest {
+		t.Fatalf("got status %d reusing a code, but expect %d", status, http.StatusBadRequest)
+	}
+
+	req, _ := http.NewRequest("GET", s.URL+"/userinfo", nil)
+	req.Header.Set("Authorization", "Bearer access-token-2")
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Do returned %v, expected nil", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d, but expect %d", res.StatusCode, http.StatusOK)
+	}
+}
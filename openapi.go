@@ -0,0 +1,395 @@
+package gaetest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// APICallResult is the outcome of a request ExerciseOpenAPI generated for an
+// operation. The operation passed if Problems is empty.
+type APICallResult struct {
+	Method   string
+	Path     string // path template of the operation, e.g. /users/{id}
+	Status   int
+	Problems []string
+}
+
+// openAPIMethods are the operations of a path item, in the order they are
+// exercised.
+var openAPIMethods = []string{"get", "head", "options", "post", "put", "patch", "delete"}
+
+// maxSchemaDepth bounds the recursion into schemas, which may refer to
+// themselves.
+const maxSchemaDepth = 8
+
+// apiDoc is an OpenAPI 3 or Swagger 2 document, in JSON or in the subset of
+// YAML understood by parseYAML. YAML scalars are strings, so values are
+// compared by their text.
+type apiDoc struct {
+	root map[string]interface{}
+}
+
+func loadAPIDoc(path string) (*apiDoc, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		if doc, err = parseYAML(data); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	root, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: expected an object", path)
+	}
+	if _, ok := root["paths"].(map[string]interface{}); !ok {
+		return nil, fmt.Errorf("%s: no paths", path)
+	}
+	return &apiDoc{root: root}, nil
+}
+
+// basePath returns the path the operations are relative to.
+func (d *apiDoc) basePath() string {
+	if s, ok := d.root["basePath"].(string); ok {
+		return strings.TrimSuffix(s, "/")
+	}
+	if servers, ok := d.root["servers"].([]interface{}); ok && len(servers) > 0 {
+		if m, ok := servers[0].(map[string]interface{}); ok {
+			if u, err := url.Parse(yamlString(m, "url")); err == nil {
+				return strings.TrimSuffix(u.Path, "/")
+			}
+		}
+	}
+	return ""
+}
+
+// resolve follows the $ref of a schema, parameter or response, if any.
+func (d *apiDoc) resolve(v interface{}) map[string]interface{} {
+	m, _ := v.(map[string]interface{})
+	for i := 0; i < maxSchemaDepth && m != nil; i++ {
+		ref, ok := m["$ref"].(string)
+		if !ok {
+			return m
+		}
+		var target interface{} = d.root
+		for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+			node, _ := target.(map[string]interface{})
+			target = node[strings.Replace(strings.Replace(part, "~1", "/", -1), "~0", "~", -1)]
+		}
+		m, _ = target.(map[string]interface{})
+	}
+	return m
+}
+
+func truthy(v interface{}) bool {
+	return v == true || v == "true"
+}
+
+// sample returns a value valid for schema: its example, default or first
+// enum value if given, or a value generated from its type otherwise.
+func (d *apiDoc) sample(schema interface{}, depth int) interface{} {
+	s := d.resolve(schema)
+	if s == nil || depth > maxSchemaDepth {
+		return nil
+	}
+	if v, ok := s["example"]; ok {
+		return v
+	}
+	if v, ok := s["default"]; ok {
+		return v
+	}
+	if enum, ok := s["enum"].([]interface{}); ok && len(enum) > 0 {
+		return enum[0]
+	}
+	if all, ok := s["allOf"].([]interface{}); ok {
+		obj := make(map[string]interface{})
+		for _, sub := range all {
+			if m, ok := d.sample(sub, depth+1).(map[string]interface{}); ok {
+				for k, v := range m {
+					obj[k] = v
+				}
+			}
+		}
+		return obj
+	}
+	for _, key := range []string{"oneOf", "anyOf"} {
+		if alts, ok := s[key].([]interface{}); ok && len(alts) > 0 {
+			return d.sample(alts[0], depth+1)
+		}
+	}
+	switch yamlString(s, "type") {
+	case "integer", "number":
+		return 1
+	case "boolean":
+		return true
+	case "array":
+		return []interface{}{d.sample(s["items"], depth+1)}
+	case "object", "":
+		obj := make(map[string]interface{})
+		props, _ := s["properties"].(map[string]interface{})
+		for name, prop := range props {
+			obj[name] = d.sample(prop, depth+1)
+		}
+		return obj
+	}
+	switch yamlString(s, "format") {
+	case "date-time":
+		return "2006-01-02T15:04:05Z"
+	case "date":
+		return "2006-01-02"
+	case "email":
+		return "test@example.com"
+	case "uuid":
+		return "123e4567-e89b-12d3-a456-426614174000"
+	}
+	return "test"
+}
+
+// validate records where v does not match schema.
+func (d *apiDoc) validate(schema interface{}, v interface{}, path string, problems *[]string, depth int) {
+	s := d.resolve(schema)
+	if s == nil || depth > maxSchemaDepth {
+		return
+	}
+	if all, ok := s["allOf"].([]interface{}); ok {
+		for _, sub := range all {
+			d.validate(sub, v, path, problems, depth+1)
+		}
+	}
+	if v == nil {
+		if !truthy(s["nullable"]) && s["type"] != nil {
+			*problems = append(*problems, fmt.Sprintf("%s: got null, expected %s", path, s["type"]))
+		}
+		return
+	}
+	if enum, ok := s["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			if fmt.Sprint(e) == fmt.Sprint(v) {
+				found = true
+			}
+		}
+		if !found {
+			*problems = append(*problems, fmt.Sprintf("%s: %v is not one of %v", path, v, enum))
+		}
+	}
+	typ := yamlString(s, "type")
+	ok := true
+	switch typ {
+	case "string":
+		_, ok = v.(string)
+	case "integer":
+		f, isNum := v.(float64)
+		ok = isNum && f == float64(int64(f))
+	case "number":
+		_, ok = v.(float64)
+	case "boolean":
+		_, ok = v.(bool)
+	case "array":
+		items, isArray := v.([]interface{})
+		ok = isArray
+		for i, item := range items {
+			d.validate(s["items"], item, fmt.Sprintf("%s[%d]", path, i), problems, depth+1)
+		}
+	case "object":
+		_, ok = v.(map[string]interface{})
+	}
+	if !ok {
+		*problems = append(*problems, fmt.Sprintf("%s: got %v, expected %s", path, v, typ))
+		return
+	}
+	obj, isObject := v.(map[string]interface{})
+	if !isObject {
+		return
+	}
+	required, _ := s["required"].([]interface{})
+	for _, name := range required {
+		if _, ok := obj[fmt.Sprint(name)]; !ok {
+			*problems = append(*problems, fmt.Sprintf("%s.%v: missing", path, name))
+		}
+	}
+	props, _ := s["properties"].(map[string]interface{})
+	names := make([]string, 0, len(props))
+	for name := range props {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if pv, ok := obj[name]; ok {
+			d.validate(props[name], pv, path+"."+name, problems, depth+1)
+		}
+	}
+}
+
+// apiOperation is an operation of the document with a request generated for
+// it.
+type apiOperation struct {
+	method, path string
+	op           map[string]interface{}
+	url          string
+	header       http.Header
+	body         []byte
+}
+
+// operations returns the operations of the document, sorted by path, with
+// sample values filled into their parameters and bodies.
+func (d *apiDoc) operations(baseURL string) []*apiOperation {
+	paths := d.root["paths"].(map[string]interface{})
+	names := make([]string, 0, len(paths))
+	for name := range paths {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var ops []*apiOperation
+	for _, name := range names {
+		item := d.resolve(paths[name])
+		if item == nil {
+			continue
+		}
+		shared, _ := item["parameters"].([]interface{})
+		for _, method := range openAPIMethods {
+			op, ok := item[method].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			params, _ := op["parameters"].([]interface{})
+			ops = append(ops, d.request(baseURL, method, name, op, append(append([]interface{}(nil), shared...), params...)))
+		}
+	}
+	return ops
+}
+
+func (d *apiDoc) request(baseURL, method, path string, op map[string]interface{}, params []interface{}) *apiOperation {
+	o := &apiOperation{method: strings.ToUpper(method), path: path, op: op, header: make(http.Header)}
+	filled := path
+	query := url.Values{}
+	for _, p := range params {
+		param := d.resolve(p)
+		if param == nil {
+			continue
+		}
+		// Swagger 2 puts the type on the parameter, OpenAPI 3 in a schema.
+		schema := param["schema"]
+		if schema == nil || yamlString(param, "in") != "body" && param["type"] != nil {
+			schema = param
+		}
+		value := d.sample(schema, 0)
+		if v, ok := param["example"]; ok {
+			value = v
+		}
+		name := yamlString(param, "name")
+		switch yamlString(param, "in") {
+		case "path":
+			filled = strings.Replace(filled, "{"+name+"}", url.PathEscape(fmt.Sprint(value)), -1)
+		case "query":
+			if truthy(param["required"]) {
+				query.Set(name, fmt.Sprint(value))
+			}
+		case "header":
+			if truthy(param["required"]) {
+				o.header.Set(name, fmt.Sprint(value))
+			}
+		case "body":
+			o.body, _ = json.Marshal(value)
+			o.header.Set("Content-Type", "application/json")
+		}
+	}
+	if rb := d.resolve(op["requestBody"]); rb != nil {
+		if content, ok := rb["content"].(map[string]interface{}); ok {
+			if media, ok := content["application/json"].(map[string]interface{}); ok {
+				o.body, _ = json.Marshal(d.sample(media["schema"], 0))
+				o.header.Set("Content-Type", "application/json")
+			}
+		}
+	}
+	o.url = baseURL + d.basePath() + filled
+	if len(query) > 0 {
+		o.url += "?" + query.Encode()
+	}
+	return o
+}
+
+// response returns the documented response for status, if any.
+func (d *apiDoc) response(op map[string]interface{}, status int) (map[string]interface{}, bool) {
+	responses, _ := op["responses"].(map[string]interface{})
+	code := fmt.Sprint(status)
+	for _, key := range []string{code, code[:1] + "XX", code[:1] + "xx", "default"} {
+		if r, ok := responses[key]; ok {
+			return d.resolve(r), true
+		}
+	}
+	return nil, false
+}
+
+// responseSchema returns the schema of the JSON body of a response.
+func responseSchema(r map[string]interface{}) interface{} {
+	if content, ok := r["content"].(map[string]interface{}); ok {
+		if media, ok := content["application/json"].(map[string]interface{}); ok {
+			return media["schema"]
+		}
+		return nil
+	}
+	return r["schema"]
+}
+
+// ExerciseOpenAPI sends a request to the app for every operation of the
+// OpenAPI 3 or Swagger 2 document at path, using the client returned by
+// Client. Parameters and bodies are filled from the examples, defaults or
+// types in the document; optional query parameters and headers are left out.
+// The responses must have a documented status, and JSON bodies must match the
+// documented schema: types, required properties and enums are checked.
+func (sv *Server) ExerciseOpenAPI(path string) ([]APICallResult, error) {
+	doc, err := loadAPIDoc(path)
+	if err != nil {
+		return nil, err
+	}
+	client := sv.Client()
+	var results []APICallResult
+	for _, o := range doc.operations(sv.ModuleURL) {
+		result := APICallResult{Method: o.method, Path: o.path}
+		req, err := http.NewRequest(o.method, o.url, bytes.NewReader(o.body))
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %v", o.method, o.path, err)
+		}
+		for k, v := range o.header {
+			req.Header[k] = v
+		}
+		res, err := client.Do(req)
+		if err != nil {
+			result.Problems = append(result.Problems, err.Error())
+			results = append(results, result)
+			continue
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		result.Status = res.StatusCode
+		if err != nil {
+			result.Problems = append(result.Problems, err.Error())
+			results = append(results, result)
+			continue
+		}
+		documented, ok := doc.response(o.op, res.StatusCode)
+		if !ok {
+			result.Problems = append(result.Problems, fmt.Sprintf("status %d is not documented", res.StatusCode))
+		}
+		schema := responseSchema(documented)
+		if schema != nil && o.method != "HEAD" && strings.Contains(res.Header.Get("Content-Type"), "json") {
+			var v interface{}
+			if err := json.Unmarshal(body, &v); err != nil {
+				result.Problems = append(result.Problems, fmt.Sprintf("invalid JSON body: %v", err))
+			} else {
+				doc.validate(schema, v, "$", &result.Problems, 0)
+			}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
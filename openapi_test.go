@@ -0,0 +1,124 @@
+package gaetest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testOpenAPI = `{
+  "openapi": "3.0.0",
+  "servers": [{"url": "http://api.example.com/v1"}],
+  "paths": {
+    "/users/{id}": {
+      "parameters": [{"name": "id", "in": "path", "required": true, "schema": {"type": "integer", "example": 7}}],
+      "get": {
+        "responses": {
+          "200": {"content": {"application/json": {"schema": {"$ref": "#/components/schemas/User"}}}},
+          "404": {"description": "not found"}
+        }
+      }
+    },
+    "/users": {
+      "post": {
+        "requestBody": {"content": {"application/json": {"schema": {"$ref": "#/components/schemas/User"}}}},
+        "responses": {"201": {"description": "created"}}
+      }
+    }
+  },
+  "components": {
+    "schemas": {
+      "User": {
+        "type": "object",
+        "required": ["id", "name"],
+        "properties": {"id": {"type": "integer"}, "name": {"type": "string"}, "role": {"type": "string", "enum": ["admin", "member"]}}
+      }
+    }
+  }
+}`
+
+const testSwagger = `swagger: "2.0"
+basePath: /v1
+paths:
+  /ping:
+    get:
+      parameters:
+        - name: q
+          in: query
+          required: true
+          type: string
+      responses:
+        "2XX":
+          description: ok
+`
+
+func writeAPIDoc(t *testing.T, name, doc string) (string, func()) {
+	dir, err := ioutil.TempDir("", "gaetest-openapi")
+	if err != nil {
+		t.Fatalf("TempDir returned %v, expected nil", err)
+	}
+	path := filepath.Join(dir, name)
+	ioutil.WriteFile(path, []byte(doc), 0644)
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func TestExerciseOpenAPI(t *testing.T) {
+	var requests []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests = append(requests, r.Method+" "+r.URL.String()+" "+string(body))
+		if r.Method == "POST" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id": "7", "role": "owner"}`))
+	}))
+	defer ts.Close()
+	path, remove := writeAPIDoc(t, "openapi.json", testOpenAPI)
+	defer remove()
+
+	sv := &Server{ModuleURL: ts.URL, opts: withDefaults(nil)}
+	results, err := sv.ExerciseOpenAPI(path)
+	if err != nil {
+		t.Fatalf("ExerciseOpenAPI returned %v, expected nil", err)
+	}
+	expect := []APICallResult{
+		{Method: "POST", Path: "/users", Status: 200, Problems: []string{"status 200 is not documented"}},
+		{Method: "GET", Path: "/users/{id}", Status: 200, Problems: []string{
+			"$.name: missing",
+			"$.id: got 7, expected integer",
+			"$.role: owner is not one of [admin member]",
+		}},
+	}
+	if !reflect.DeepEqual(results, expect) {
+		t.Fatalf("got %+v, but expect %+v", results, expect)
+	}
+	if requests[1] != "GET /v1/users/7 " {
+		t.Fatalf("got %q, but expect the example to be used for the id", requests[1])
+	}
+}
+
+func TestExerciseSwaggerYAML(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/ping" || r.URL.Query().Get("q") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer ts.Close()
+	path, remove := writeAPIDoc(t, "swagger.yaml", testSwagger)
+	defer remove()
+
+	sv := &Server{ModuleURL: ts.URL, opts: withDefaults(nil)}
+	results, err := sv.ExerciseOpenAPI(path)
+	if err != nil {
+		t.Fatalf("ExerciseOpenAPI returned %v, expected nil", err)
+	}
+	if len(results) != 1 || results[0].Status != 200 || len(results[0].Problems) != 0 {
+		t.Fatalf("got %+v, but expect a passing GET /ping", results)
+	}
+}
@@ -0,0 +1,97 @@
+package gaetest
+
+// Option configures a server started by NewServer. Options are applied in
+// order, so later ones override earlier ones.
+type Option func(*Options)
+
+// WithOptions starts from a copy of opts, e.g. shared defaults that the
+// following options refine.
+func WithOptions(opts *Options) Option {
+	return func(o *Options) {
+		if opts != nil {
+			*o = *opts
+		}
+	}
+}
+
+// WithDevAppServer sets Options.DevAppServer.
+func WithDevAppServer(path string) Option {
+	return func(o *Options) { o.DevAppServer = path }
+}
+
+// WithHost sets Options.Host.
+func WithHost(host string) Option {
+	return func(o *Options) { o.Host = host }
+}
+
+// WithPort sets Options.Port.
+func WithPort(port int) Option {
+	return func(o *Options) { o.Port = port }
+}
+
+// WithAdminPort sets Options.AdminPort.
+func WithAdminPort(port int) Option {
+	return func(o *Options) { o.AdminPort = port }
+}
+
+// WithTimeout sets Options.Timeout, in seconds.
+func WithTimeout(seconds int) Option {
+	return func(o *Options) { o.Timeout = seconds }
+}
+
+// WithAppID sets Options.AppID.
+func WithAppID(appID string) Option {
+	return func(o *Options) { o.AppID = appID }
+}
+
+// WithDebug sets Options.Debug.
+func WithDebug(debug bool) Option {
+	return func(o *Options) { o.Debug = debug }
+}
+
+// WithExpectServices adds services to Options.ExpectServices.
+func WithExpectServices(names ...string) Option {
+	return func(o *Options) {
+		// Copy, the slice may be shared with the Options of WithOptions.
+		o.ExpectServices = append(append([]string(nil), o.ExpectServices...), names...)
+	}
+}
+
+// WithExtraArgs adds arguments to Options.ExtraArgs.
+func WithExtraArgs(args ...string) Option {
+	return func(o *Options) {
+		o.ExtraArgs = append(append([]string(nil), o.ExtraArgs...), args...)
+	}
+}
+
+// WithAppEnv sets the environment variable name of the app, see
+// Options.AppEnv.
+func WithAppEnv(name, value string) Option {
+	return func(o *Options) {
+		env := make(map[string]string, len(o.AppEnv)+1)
+		for k, v := range o.AppEnv {
+			env[k] = v
+		}
+		env[name] = value
+		o.AppEnv = env
+	}
+}
+
+// buildOptions applies opts to empty Options.
+func buildOptions(opts []Option) *Options {
+	o := &Options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// NewServer is like New, with the options given as Option values instead of
+// an Options struct:
+//
+//	sv, err := gaetest.NewServer("app", gaetest.WithPort(8080), gaetest.WithDebug(true))
+//
+// Unset options keep their defaults.
+func NewServer(appDir string, opts ...Option) (*Server, error) {
+	return New(appDir, buildOptions(opts))
+}
@@ -0,0 +1,29 @@
+package gaetest
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBuildOptions(t *testing.T) {
+	base := &Options{Port: 9000, AppEnv: map[string]string{"MODE": "test"}, ExpectServices: []string{"default"}}
+	opts := buildOptions([]Option{
+		WithOptions(base),
+		WithPort(8080),
+		WithDebug(true),
+		WithAppEnv("REGION", "eu"),
+		WithExpectServices("worker"),
+	})
+	expect := &Options{
+		Port:           8080,
+		Debug:          true,
+		AppEnv:         map[string]string{"MODE": "test", "REGION": "eu"},
+		ExpectServices: []string{"default", "worker"},
+	}
+	if !reflect.DeepEqual(opts, expect) {
+		t.Fatalf("got %+v, but expect %+v", opts, expect)
+	}
+	if len(base.AppEnv) != 1 || len(base.ExpectServices) != 1 || base.Port != 9000 {
+		t.Fatalf("got %+v, but expect the base options to be left alone", base)
+	}
+}
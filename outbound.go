@@ -0,0 +1,203 @@
+package gaetest
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OutboundRequest is a request the app made to an external host through the
+// outbound proxy.
+type OutboundRequest struct {
+	Time   time.Time
+	Method string
+	// URL of the request. For HTTPS traffic, which is tunneled and cannot be
+	// inspected, it only holds the scheme and host.
+	URL    string
+	Header http.Header
+	Body   []byte
+	// Status of the response, 0 if the request failed or was tunneled.
+	Status int
+	// Stubbed reports whether the request was answered by a stub.
+	Stubbed bool
+}
+
+// outboundProxy is a forward proxy for the outbound traffic of the app. It
+// answers requests to stubbed hosts itself, forwards all others and records
+// everything.
+type outboundProxy struct {
+	srv       *httptest.Server
+	transport *http.Transport
+
+	mu    sync.Mutex
+	stubs map[string]http.Handler
+	log   []OutboundRequest
+}
+
+func newOutboundProxy() *outboundProxy {
+	p := &outboundProxy{
+		transport: &http.Transport{Proxy: nil},
+		stubs:     make(map[string]http.Handler),
+	}
+	p.srv = httptest.NewServer(p)
+	return p
+}
+
+func (p *outboundProxy) Close() {
+	p.srv.Close()
+	p.transport.CloseIdleConnections()
+}
+
+// env returns the environment variables routing HTTP traffic through the
+// proxy.
+func (p *outboundProxy) env() []string {
+	var env []string
+	for _, name := range []string{"HTTP_PROXY", "HTTPS_PROXY"} {
+		env = append(env, name+"="+p.srv.URL, strings.ToLower(name)+"="+p.srv.URL)
+	}
+	return append(env, "NO_PROXY=localhost,127.0.0.1", "no_proxy=localhost,127.0.0.1")
+}
+
+func (p *outboundProxy) stub(host string, h http.Handler) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if h == nil {
+		delete(p.stubs, host)
+		return
+	}
+	p.stubs[host] = h
+}
+
+// lookup returns the stub for host, which may include a port.
+func (p *outboundProxy) lookup(host string) http.Handler {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if h, ok := p.stubs[host]; ok {
+		return h
+	}
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		return p.stubs[name]
+	}
+	return nil
+}
+
+func (p *outboundProxy) record(r OutboundRequest) {
+	p.mu.Lock()
+	p.log = append(p.log, r)
+	p.mu.Unlock()
+}
+
+func (p *outboundProxy) requests() []OutboundRequest {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]OutboundRequest(nil), p.log...)
+}
+
+func (p *outboundProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "CONNECT" {
+		p.tunnel(w, r)
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	rec := OutboundRequest{
+		Time:   time.Now(),
+		Method: r.Method,
+		URL:    r.URL.String(),
+		Header: r.Header,
+		Body:   body,
+	}
+	defer func() { p.record(rec) }()
+
+	if h := p.lookup(r.URL.Host); h != nil {
+		rw := httptest.NewRecorder()
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		r.RequestURI = r.URL.RequestURI()
+		h.ServeHTTP(rw, r)
+		rec.Status, rec.Stubbed = rw.Code, true
+		copyHeader(w.Header(), rw.Header())
+		w.WriteHeader(rw.Code)
+		w.Write(rw.Body.Bytes())
+		return
+	}
+
+	out, err := http.NewRequest(r.Method, r.URL.String(), bytes.NewReader(body))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	copyHeader(out.Header, r.Header)
+	out.Header.Del("Proxy-Connection")
+	res, err := p.transport.RoundTrip(out)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer res.Body.Close()
+	rec.Status = res.StatusCode
+	copyHeader(w.Header(), res.Header)
+	w.WriteHeader(res.StatusCode)
+	io.Copy(w, res.Body)
+}
+
+// tunnel relays a CONNECT request to its destination.
+func (p *outboundProxy) tunnel(w http.ResponseWriter, r *http.Request) {
+	p.record(OutboundRequest{Time: time.Now(), Method: r.Method, URL: "https://" + r.Host, Header: r.Header})
+	dst, err := net.DialTimeout("tcp", r.Host, 10*time.Second)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		dst.Close()
+		http.Error(w, "hijacking not supported", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	src, _, err := hj.Hijack()
+	if err != nil {
+		dst.Close()
+		return
+	}
+	go func() {
+		io.Copy(dst, src)
+		dst.Close()
+	}()
+	io.Copy(src, dst)
+	src.Close()
+}
+
+func copyHeader(dst, src http.Header) {
+	for k, vv := range src {
+		for _, v := range vv {
+			dst.Add(k, v)
+		}
+	}
+}
+
+// StubHost makes the outbound proxy answer the app's requests to host with h
+// instead of forwarding them. host may include a port to only match requests
+// to that port. A nil h removes the stub. It requires Options.InterceptOutbound.
+func (sv *Server) StubHost(host string, h http.Handler) error {
+	if sv.outbound == nil {
+		return errors.New("gaetest: StubHost requires Options.InterceptOutbound")
+	}
+	sv.outbound.stub(host, h)
+	return nil
+}
+
+// OutboundRequests returns the requests the app made through the outbound
+// proxy, in order. It returns nil unless Options.InterceptOutbound is set.
+func (sv *Server) OutboundRequests() []OutboundRequest {
+	if sv.outbound == nil {
+		return nil
+	}
+	return sv.outbound.requests()
+}
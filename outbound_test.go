@@ -0,0 +1,63 @@
+package gaetest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestOutboundProxy(t *testing.T) {
+	p := newOutboundProxy()
+	defer p.Close()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "upstream")
+	}))
+	defer upstream.Close()
+
+	p.stub("api.example.com", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+		fmt.Fprint(w, "stubbed "+r.URL.Path)
+	}))
+
+	proxyURL, _ := url.Parse(p.srv.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	get := func(u string) (int, string) {
+		res, err := client.Get(u)
+		if err != nil {
+			t.Fatalf("Get(%q) returned %v, expected nil", u, err)
+		}
+		defer res.Body.Close()
+		body, _ := ioutil.ReadAll(res.Body)
+		return res.StatusCode, string(body)
+	}
+
+	if status, body := get("http://api.example.com/v1/items"); status != http.StatusTeapot || body != "stubbed /v1/items" {
+		t.Fatalf("got %d %q, but expect the stub to answer", status, body)
+	}
+	if status, body := get(upstream.URL + "/x"); status != http.StatusOK || body != "upstream" {
+		t.Fatalf("got %d %q, but expect the request to be forwarded", status, body)
+	}
+
+	reqs := p.requests()
+	if len(reqs) != 2 {
+		t.Fatalf("got %d requests, but expect 2", len(reqs))
+	}
+	if !reqs[0].Stubbed || reqs[0].URL != "http://api.example.com/v1/items" || reqs[1].Stubbed {
+		t.Fatalf("got %+v, but expect the first request stubbed", reqs)
+	}
+}
+
+func TestOutboundDisabled(t *testing.T) {
+	sv := &Server{}
+	expect := "gaetest: StubHost requires Options.InterceptOutbound"
+	if err := sv.StubHost("api.example.com", http.NotFoundHandler()); err == nil || err.Error() != expect {
+		t.Fatalf("got %v, but expect %q", err, expect)
+	}
+	if reqs := sv.OutboundRequests(); reqs != nil {
+		t.Fatalf("got %v, but expect nil", reqs)
+	}
+}
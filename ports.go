@@ -0,0 +1,53 @@
+package gaetest
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// portReleaseTimeout is how long Close waits for the ports of dev_appserver.py
+// to be released when Options.VerifyPortsReleased is set.
+const portReleaseTimeout = 2 * time.Second
+
+// serverAddrs returns the host:port addresses dev_appserver.py listened on.
+func (sv *Server) serverAddrs() []string {
+	urls := []string{sv.AdminURL, sv.APIURL}
+	for _, u := range sv.services {
+		urls = append(urls, u)
+	}
+	var addrs []string
+	for _, u := range urls {
+		if parsed, err := url.Parse(u); err == nil && parsed.Host != "" {
+			addrs = append(addrs, parsed.Host)
+		}
+	}
+	return addrs
+}
+
+// waitPortsReleased tries to listen on every address in addrs until it
+// succeeds or timeout expires, and reports the addresses still in use.
+func waitPortsReleased(addrs []string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		var busy []string
+		for _, addr := range addrs {
+			l, err := net.Listen("tcp", addr)
+			if err != nil {
+				busy = append(busy, addr)
+				continue
+			}
+			l.Close()
+		}
+		if len(busy) == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("ports still in use after Close: %s", strings.Join(busy, ", "))
+		}
+		addrs = busy
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,37 @@
+package gaetest
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWaitPortsReleased(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen returned %v, expected nil", err)
+	}
+	addr := l.Addr().String()
+
+	err = waitPortsReleased([]string{addr}, 200*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), addr) {
+		t.Fatalf("got %v, but expect %s to be reported in use", err, addr)
+	}
+	l.Close()
+	if err := waitPortsReleased([]string{addr}, 200*time.Millisecond); err != nil {
+		t.Fatalf("Got %v, expected nil", err)
+	}
+}
+
+func TestServerAddrs(t *testing.T) {
+	sv := &Server{
+		AdminURL: "http://localhost:8000",
+		APIURL:   "http://localhost:51234",
+		services: map[string]string{"default": "http://localhost:8080"},
+	}
+	addrs := sv.serverAddrs()
+	if len(addrs) != 3 || addrs[0] != "localhost:8000" || addrs[2] != "localhost:8080" {
+		t.Fatalf("got %q, but expect the admin, API and module addresses", addrs)
+	}
+}
@@ -0,0 +1,24 @@
+package gaetest
+
+import "errors"
+
+// processGroup is dev_appserver.py and the processes it starts, the API server
+// and the instances of the app, which are killed together: they form a
+// process group on unix and a job object on Windows. A group is identified by
+// the PID of dev_appserver.py, which is what pidfiles record, so that other
+// processes find it too.
+//
+// The platform files provide:
+//
+//	prepareProcessGroup(cmd)  called before cmd is started
+//	startProcessGroup(p)      called once p is started, returns its group
+//	processGroup.kill()       kills the processes of the group
+//	processGroup.alive()      reports whether any of them still runs
+//	alive(pid)                reports whether the process pid exists
+//	processStarted(pid)       returns when pid started, which tells it from a
+//	                          later process reusing pid
+//	lockFile(f), unlockFile(f)
+type processGroup int
+
+// errLocked is returned by lockFile when another process holds the lock.
+var errLocked = errors.New("locked by another process")
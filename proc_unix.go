@@ -0,0 +1,75 @@
+//go:build unix
+
+package gaetest
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// errGroupGone is returned by processGroup.kill when no process is left.
+var errGroupGone error = syscall.ESRCH
+
+// prepareProcessGroup makes cmd the leader of a new process group.
+func prepareProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+func startProcessGroup(p *os.Process) (processGroup, error) {
+	return processGroup(p.Pid), nil
+}
+
+func (g processGroup) kill() error {
+	return syscall.Kill(-int(g), syscall.SIGKILL)
+}
+
+func (g processGroup) alive() bool {
+	// Signal 0 to the group fails once all its processes are gone.
+	err := syscall.Kill(-int(g), 0)
+	return err == nil || err == syscall.EPERM
+}
+
+// alive reports whether a process with pid exists.
+func alive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+// processStarted returns the start time of pid: in clock ticks since boot
+// where /proc exists, as printed by ps elsewhere.
+func processStarted(pid int) (string, error) {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		out, err := exec.Command("ps", "-o", "lstart=", "-p", strconv.Itoa(pid)).Output()
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(out)), nil
+	}
+	// The command name, in parentheses, may contain spaces. The start time
+	// is the 22nd field, the 20th after it.
+	fields := strings.Fields(string(data[strings.LastIndexByte(string(data), ')')+1:]))
+	if len(fields) < 20 {
+		return "", errors.New("unexpected /proc stat format")
+	}
+	return fields[19], nil
+}
+
+// lockFile takes an exclusive lock on f without waiting.
+func lockFile(f *os.File) error {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return errLocked
+	}
+	return err
+}
+
+func unlockFile(f *os.File) {
+	syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
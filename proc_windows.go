@@ -0,0 +1,187 @@
+//go:build windows
+
+package gaetest
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// On Windows, the group is a job object named after the PID of
+// dev_appserver.py. The processes it starts join its job. A job object lives
+// as long as a handle to it is open: once the process that created it exits,
+// only dev_appserver.py itself can be found and killed.
+
+var (
+	kernel32                      = syscall.NewLazyDLL("kernel32.dll")
+	procCreateJobObject           = kernel32.NewProc("CreateJobObjectW")
+	procOpenJobObject             = kernel32.NewProc("OpenJobObjectW")
+	procAssignProcessToJobObject  = kernel32.NewProc("AssignProcessToJobObject")
+	procTerminateJobObject        = kernel32.NewProc("TerminateJobObject")
+	procQueryInformationJobObject = kernel32.NewProc("QueryInformationJobObject")
+	procLockFileEx                = kernel32.NewProc("LockFileEx")
+	procUnlockFileEx              = kernel32.NewProc("UnlockFileEx")
+)
+
+const (
+	jobObjectQuery                      = 0x0004
+	jobObjectTerminate                  = 0x0008
+	jobObjectBasicAccountingInformation = 1
+	processTerminate                    = 0x0001
+	processSetQuota                     = 0x0100
+	processQueryLimitedInformation      = 0x1000
+	lockfileFailImmediately             = 0x0001
+	lockfileExclusiveLock               = 0x0002
+	errorLockViolation                  = syscall.Errno(33)
+	stillActive                         = 259
+)
+
+// errGroupGone is returned by processGroup.kill when no process is left.
+var errGroupGone = errors.New("no such process")
+
+// jobs holds the job objects created by this process by PID. They are never
+// closed, which would destroy them while the processes of detached servers
+// run.
+var jobs = struct {
+	sync.Mutex
+	handles map[int]syscall.Handle
+}{handles: make(map[int]syscall.Handle)}
+
+// jobName returns the name of the job object of the group of pid.
+func jobName(pid int) *uint16 {
+	name, _ := syscall.UTF16PtrFromString(fmt.Sprintf(`Local\gaetest-%d`, pid))
+	return name
+}
+
+// prepareProcessGroup keeps cmd from receiving the Ctrl-C of the console.
+func prepareProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+func startProcessGroup(p *os.Process) (processGroup, error) {
+	job, _, err := procCreateJobObject.Call(0, uintptr(unsafe.Pointer(jobName(p.Pid))))
+	if job == 0 {
+		return 0, fmt.Errorf("CreateJobObject: %v", err)
+	}
+	h, err := syscall.OpenProcess(processSetQuota|processTerminate, false, uint32(p.Pid))
+	if err != nil {
+		syscall.CloseHandle(syscall.Handle(job))
+		return 0, fmt.Errorf("OpenProcess: %v", err)
+	}
+	defer syscall.CloseHandle(h)
+	if ok, _, err := procAssignProcessToJobObject.Call(job, uintptr(h)); ok == 0 {
+		syscall.CloseHandle(syscall.Handle(job))
+		return 0, fmt.Errorf("AssignProcessToJobObject: %v", err)
+	}
+	jobs.Lock()
+	jobs.handles[p.Pid] = syscall.Handle(job)
+	jobs.Unlock()
+	return processGroup(p.Pid), nil
+}
+
+// job returns a handle to the job object of g and a function releasing it.
+func (g processGroup) job() (syscall.Handle, func(), error) {
+	jobs.Lock()
+	h, ok := jobs.handles[int(g)]
+	jobs.Unlock()
+	if ok {
+		return h, func() {}, nil
+	}
+	r, _, err := procOpenJobObject.Call(jobObjectQuery|jobObjectTerminate, 0, uintptr(unsafe.Pointer(jobName(int(g)))))
+	if r == 0 {
+		return 0, nil, err
+	}
+	h = syscall.Handle(r)
+	return h, func() { syscall.CloseHandle(h) }, nil
+}
+
+func (g processGroup) kill() error {
+	h, release, err := g.job()
+	if err != nil {
+		if !alive(int(g)) {
+			return errGroupGone
+		}
+		p, err := os.FindProcess(int(g))
+		if err != nil {
+			return err
+		}
+		return p.Kill()
+	}
+	defer release()
+	if ok, _, err := procTerminateJobObject.Call(uintptr(h), 1); ok == 0 {
+		return fmt.Errorf("TerminateJobObject: %v", err)
+	}
+	return nil
+}
+
+func (g processGroup) alive() bool {
+	h, release, err := g.job()
+	if err != nil {
+		return alive(int(g))
+	}
+	defer release()
+	// JOBOBJECT_BASIC_ACCOUNTING_INFORMATION
+	var info struct {
+		TotalUserTime, TotalKernelTime                       int64
+		ThisPeriodTotalUserTime, ThisPeriodTotalKernelTime   int64
+		TotalPageFaultCount, TotalProcesses, ActiveProcesses uint32
+		TotalTerminatedProcesses                             uint32
+	}
+	ok, _, _ := procQueryInformationJobObject.Call(uintptr(h), jobObjectBasicAccountingInformation,
+		uintptr(unsafe.Pointer(&info)), unsafe.Sizeof(info), 0)
+	if ok == 0 {
+		return alive(int(g))
+	}
+	return info.ActiveProcesses > 0
+}
+
+// alive reports whether a process with pid exists.
+func alive(pid int) bool {
+	h, err := syscall.OpenProcess(processQueryLimitedInformation, false, uint32(pid))
+	if err != nil {
+		return err == syscall.ERROR_ACCESS_DENIED
+	}
+	defer syscall.CloseHandle(h)
+	var code uint32
+	if err := syscall.GetExitCodeProcess(h, &code); err != nil {
+		return true
+	}
+	return code == stillActive
+}
+
+// processStarted returns the creation time of pid.
+func processStarted(pid int) (string, error) {
+	h, err := syscall.OpenProcess(processQueryLimitedInformation, false, uint32(pid))
+	if err != nil {
+		return "", err
+	}
+	defer syscall.CloseHandle(h)
+	var creation, exit, kernel, user syscall.Filetime
+	if err := syscall.GetProcessTimes(h, &creation, &exit, &kernel, &user); err != nil {
+		return "", err
+	}
+	return fmt.Sprint(creation.Nanoseconds()), nil
+}
+
+// lockFile takes an exclusive lock on f without waiting.
+func lockFile(f *os.File) error {
+	var ol syscall.Overlapped
+	ok, _, err := procLockFileEx.Call(f.Fd(), lockfileExclusiveLock|lockfileFailImmediately, 0, 1, 0, uintptr(unsafe.Pointer(&ol)))
+	if ok == 0 {
+		if err == errorLockViolation {
+			return errLocked
+		}
+		return err
+	}
+	return nil
+}
+
+func unlockFile(f *os.File) {
+	var ol syscall.Overlapped
+	procUnlockFileEx.Call(f.Fd(), 0, 1, 0, uintptr(unsafe.Pointer(&ol)))
+}
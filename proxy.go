@@ -0,0 +1,337 @@
+package gaetest
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// appProxy is a reverse proxy in front of the default module. It records
+// latency and status statistics for every path.
+type appProxy struct {
+	rp  *httputil.ReverseProxy
+	l   net.Listener
+	srv *http.Server
+	URL string
+
+	// start, if set, launches the server on the first request and returns
+	// the URL to proxy to.
+	start    func() (string, error)
+	once     sync.Once
+	startErr error
+
+	// healthy, if set, returns an error when requests should not be
+	// proxied, e.g. because the app is crash looping.
+	healthy func() error
+	// idleTimeout, if set, aborts the requests whose response sends nothing
+	// for that long.
+	idleTimeout time.Duration
+
+	mu       sync.Mutex
+	stats    map[string]*pathStats
+	inflight int
+	name     string // of the app, in traces
+	tracer   *tracer
+}
+
+// pathStats accumulates the requests to a path.
+type pathStats struct {
+	latencies []time.Duration
+	statuses  map[int]int
+}
+
+func newAppProxy(addr, target string) (*appProxy, error) {
+	p, err := listenAppProxy(addr)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.setTarget(target); err != nil {
+		p.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// newLazyProxy returns a proxy calling start on the first request, which is
+// held until start returns the URL to proxy to.
+func newLazyProxy(addr string, start func() (string, error)) (*appProxy, error) {
+	p, err := listenAppProxy(addr)
+	if err != nil {
+		return nil, err
+	}
+	p.start = start
+	return p, nil
+}
+
+func listenAppProxy(addr string) (*appProxy, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	p := &appProxy{
+		l:     l,
+		URL:   "http://" + l.Addr().String(),
+		stats: make(map[string]*pathStats),
+	}
+	p.srv = &http.Server{Handler: p}
+	go p.srv.Serve(l)
+	return p, nil
+}
+
+func (p *appProxy) setTarget(target string) error {
+	u, err := url.Parse(target)
+	if err != nil {
+		return err
+	}
+	p.rp = httputil.NewSingleHostReverseProxy(u)
+	director := p.rp.Director
+	p.rp.Director = func(r *http.Request) {
+		director(r)
+		r.Host = u.Host
+	}
+	return nil
+}
+
+// ready starts the server of a lazy proxy, once.
+func (p *appProxy) ready() error {
+	p.once.Do(func() {
+		if p.start == nil {
+			return
+		}
+		target, err := p.start()
+		if err == nil {
+			err = p.setTarget(target)
+		}
+		p.startErr = err
+	})
+	return p.startErr
+}
+
+func (p *appProxy) Close() {
+	p.srv.Close()
+}
+
+// drain stops accepting connections and waits up to timeout for the requests
+// in flight to complete.
+func (p *appProxy) drain(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := p.srv.Shutdown(ctx); err != nil {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return fmt.Errorf("proxy: %d requests still in flight after %v", p.inflight, timeout)
+	}
+	return nil
+}
+
+func (p *appProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := p.ready(); err != nil {
+		http.Error(w, "unable to start dev_appserver.py: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	if p.healthy != nil {
+		if err := p.healthy(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+	}
+	p.mu.Lock()
+	name, tr := p.name, p.tracer
+	p.inflight++
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		p.inflight--
+		p.mu.Unlock()
+	}()
+	var id string
+	if tr != nil {
+		if id = r.Header.Get(TraceHeader); id == "" {
+			id = tr.newID()
+			r.Header.Set(TraceHeader, id)
+		}
+		w.Header().Set(TraceHeader, id)
+	}
+	start := time.Now()
+	sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+	// Record the request even if the reverse proxy aborts it.
+	defer func() {
+		p.record(r.URL.Path, sw.status, time.Since(start))
+		if tr != nil {
+			tr.add(Hop{Trace: id, App: name, Method: r.Method, Path: r.URL.Path, Status: sw.status, Start: start, Duration: time.Since(start)})
+		}
+	}()
+	if p.idleTimeout > 0 {
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		sw.idle = time.AfterFunc(p.idleTimeout, cancel)
+		sw.idleTimeout = p.idleTimeout
+		defer sw.idle.Stop()
+		r = r.WithContext(ctx)
+	}
+	p.rp.ServeHTTP(sw, r)
+}
+
+// trace makes the proxy record its requests in tr as hops to the app called
+// name.
+func (p *appProxy) trace(name string, tr *tracer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.name, p.tracer = name, tr
+}
+
+func (p *appProxy) record(path string, status int, d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := p.stats[path]
+	if s == nil {
+		s = &pathStats{statuses: make(map[int]int)}
+		p.stats[path] = s
+	}
+	s.latencies = append(s.latencies, d)
+	s.statuses[status]++
+}
+
+// statusWriter records the status code written to a ResponseWriter.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+
+	// idle, if set, is reset by every write, see appProxy.idleTimeout.
+	idle        *time.Timer
+	idleTimeout time.Duration
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	if w.idle != nil {
+		w.idle.Reset(w.idleTimeout)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijacking not supported")
+	}
+	// Upgraded connections (WebSockets) are not subject to the idle
+	// timeout: their traffic does not go through Write.
+	if w.idle != nil {
+		w.idle.Stop()
+	}
+	return hj.Hijack()
+}
+
+// PathStats summarizes the requests made to a path through the proxy.
+type PathStats struct {
+	Path     string        `json:"path"`
+	Count    int           `json:"count"`
+	Statuses map[int]int   `json:"statuses"`
+	Min      time.Duration `json:"min_ns"`
+	Mean     time.Duration `json:"mean_ns"`
+	P50      time.Duration `json:"p50_ns"`
+	P95      time.Duration `json:"p95_ns"`
+	Max      time.Duration `json:"max_ns"`
+}
+
+// TrafficReport holds the statistics of every path requested through the
+// proxy, sorted by path.
+type TrafficReport []PathStats
+
+func (p *appProxy) report() TrafficReport {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var report TrafficReport
+	for path, s := range p.stats {
+		lat := append([]time.Duration(nil), s.latencies...)
+		sort.Slice(lat, func(i, j int) bool { return lat[i] < lat[j] })
+		var total time.Duration
+		for _, d := range lat {
+			total += d
+		}
+		statuses := make(map[int]int)
+		for code, n := range s.statuses {
+			statuses[code] = n
+		}
+		report = append(report, PathStats{
+			Path:     path,
+			Count:    len(lat),
+			Statuses: statuses,
+			Min:      lat[0],
+			Mean:     total / time.Duration(len(lat)),
+			P50:      percentile(lat, 50),
+			P95:      percentile(lat, 95),
+			Max:      lat[len(lat)-1],
+		})
+	}
+	sort.Slice(report, func(i, j int) bool { return report[i].Path < report[j].Path })
+	return report
+}
+
+// percentile returns the p-th percentile of the sorted durations.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	i := (len(sorted)*p+99)/100 - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+// WriteJSON writes the report as a JSON array. Durations are in nanoseconds.
+func (r TrafficReport) WriteJSON(w io.Writer) error {
+	return json.NewEncoder(w).Encode(r)
+}
+
+// WriteCSV writes the report as CSV with a header line. Durations are in
+// milliseconds; statuses are not included.
+func (r TrafficReport) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"path", "count", "errors", "min_ms", "mean_ms", "p50_ms", "p95_ms", "max_ms"})
+	ms := func(d time.Duration) string {
+		return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64)
+	}
+	for _, s := range r {
+		var errs int
+		for code, n := range s.Statuses {
+			if code >= 500 {
+				errs += n
+			}
+		}
+		cw.Write([]string{s.Path, strconv.Itoa(s.Count), strconv.Itoa(errs),
+			ms(s.Min), ms(s.Mean), ms(s.P50), ms(s.P95), ms(s.Max)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// TrafficReport returns per path latency and status statistics of the
+// requests made through the proxy. It requires Options.Proxy.
+func (sv *Server) TrafficReport() TrafficReport {
+	if sv.proxy == nil {
+		return nil
+	}
+	return sv.proxy.report()
+}
@@ -0,0 +1,204 @@
+package gaetest
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAppProxyReport(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+
+	p, err := newAppProxy("127.0.0.1:0", ts.URL)
+	if err != nil {
+		t.Fatalf("newAppProxy returned %v, expected nil", err)
+	}
+	defer p.Close()
+	for _, path := range []string{"/", "/fail", "/?q=1", "/fail"} {
+		res, err := http.Get(p.URL + path)
+		if err != nil {
+			t.Fatalf("Get returned %v, expected nil", err)
+		}
+		res.Body.Close()
+	}
+
+	report := p.report()
+	if len(report) != 2 {
+		t.Fatalf("got %d paths, but expect 2", len(report))
+	}
+	if s := report[0]; s.Path != "/" || s.Count != 2 || s.Statuses[200] != 2 {
+		t.Fatalf("got %+v, but expect 2 OK requests to /", s)
+	}
+	if s := report[1]; s.Path != "/fail" || s.Statuses[500] != 2 || s.Min > s.Max {
+		t.Fatalf("got %+v, but expect 2 failed requests to /fail", s)
+	}
+
+	var buf bytes.Buffer
+	if err := report.WriteCSV(&buf); err != nil {
+		t.Fatalf("WriteCSV returned %v, expected nil", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[2], "/fail,2,2,") {
+		t.Fatalf("got %q, but expect a header and 2 rows", lines)
+	}
+}
+
+func TestLazyProxy(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	var starts int
+	p, err := newLazyProxy("127.0.0.1:0", func() (string, error) {
+		starts++
+		return ts.URL, nil
+	})
+	if err != nil {
+		t.Fatalf("newLazyProxy returned %v, expected nil", err)
+	}
+	defer p.Close()
+	if starts != 0 {
+		t.Fatalf("got %d starts, but expect none before the first request", starts)
+	}
+	for i := 0; i < 2; i++ {
+		res, err := http.Get(p.URL)
+		if err != nil {
+			t.Fatalf("Get returned %v, expected nil", err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("got status %d, but expect %d", res.StatusCode, http.StatusOK)
+		}
+	}
+	if starts != 1 {
+		t.Fatalf("got %d starts, but expect 1", starts)
+	}
+}
+
+func TestLazyProxyStartError(t *testing.T) {
+	p, err := newLazyProxy("127.0.0.1:0", func() (string, error) {
+		return "", errors.New("timeout starting child process")
+	})
+	if err != nil {
+		t.Fatalf("newLazyProxy returned %v, expected nil", err)
+	}
+	defer p.Close()
+	res, err := http.Get(p.URL)
+	if err != nil {
+		t.Fatalf("Get returned %v, expected nil", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadGateway {
+		t.Fatalf("got status %d, but expect %d", res.StatusCode, http.StatusBadGateway)
+	}
+}
+
+func TestAppProxyDrain(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		fmt.Fprint(w, "done")
+	}))
+	defer ts.Close()
+
+	p, err := newAppProxy("127.0.0.1:0", ts.URL)
+	if err != nil {
+		t.Fatalf("newAppProxy returned %v, expected nil", err)
+	}
+	defer p.Close()
+	body := make(chan string, 1)
+	go func() {
+		res, err := http.Get(p.URL + "/slow")
+		if err != nil {
+			body <- err.Error()
+			return
+		}
+		defer res.Body.Close()
+		data, _ := ioutil.ReadAll(res.Body)
+		body <- string(data)
+	}()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		p.mu.Lock()
+		n := p.inflight
+		p.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the request never reached the proxy")
+		}
+	}
+
+	expect := "proxy: 1 requests still in flight after 50ms"
+	if err := p.drain(50 * time.Millisecond); err == nil || err.Error() != expect {
+		t.Fatalf("got %v, but expect %q", err, expect)
+	}
+	if _, err := http.Get(p.URL); err == nil {
+		t.Fatalf("Get returned nil after drain, expected an error")
+	}
+	time.AfterFunc(50*time.Millisecond, func() { close(release) })
+	if err := p.drain(5 * time.Second); err != nil {
+		t.Fatalf("drain returned %v, expected nil", err)
+	}
+	if got := <-body; got != "done" {
+		t.Fatalf("got %q, but expect %q", got, "done")
+	}
+}
+
+func TestAppProxyStreaming(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gap := 20 * time.Millisecond
+		if r.URL.Path == "/stall" {
+			gap = time.Second
+		}
+		for i := 0; i < 3; i++ {
+			fmt.Fprintf(w, "event %d\n", i)
+			w.(http.Flusher).Flush()
+			select {
+			case <-time.After(gap):
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}))
+	defer ts.Close()
+
+	p, err := newAppProxy("127.0.0.1:0", ts.URL)
+	if err != nil {
+		t.Fatalf("newAppProxy returned %v, expected nil", err)
+	}
+	defer p.Close()
+	p.idleTimeout = 200 * time.Millisecond
+
+	res, err := http.Get(p.URL + "/events")
+	if err != nil {
+		t.Fatalf("Get returned %v, expected nil", err)
+	}
+	line, err := bufio.NewReader(res.Body).ReadString('\n')
+	if err != nil || line != "event 0\n" {
+		t.Fatalf("got %q, %v, but expect the first event before the response ends", line, err)
+	}
+	res.Body.Close()
+
+	res, err = http.Get(p.URL + "/stall")
+	if err != nil {
+		t.Fatalf("Get returned %v, expected nil", err)
+	}
+	defer res.Body.Close()
+	start := time.Now()
+	body, err := ioutil.ReadAll(res.Body)
+	if err == nil || string(body) != "event 0\n" || time.Since(start) > 900*time.Millisecond {
+		t.Fatalf("got %q, %v after %v, but expect the stalled stream to be aborted", body, err, time.Since(start))
+	}
+}
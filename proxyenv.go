@@ -0,0 +1,94 @@
+package gaetest
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ProxyEnv says what happens to the proxy settings (HTTP_PROXY, HTTPS_PROXY,
+// ALL_PROXY and NO_PROXY, in either case) gaetest inherits from its
+// environment. Proxies meant for the outside world break the requests
+// between the harness, dev_appserver.py and the app, which all run locally.
+type ProxyEnv int
+
+const (
+	// ProxyEnvBypassLocal keeps the proxies, for the outbound requests of the
+	// app, but adds the local hosts and Options.Host to the NO_PROXY of
+	// dev_appserver.py. The harness does not use a proxy for Options.Host.
+	ProxyEnvBypassLocal ProxyEnv = iota
+	// ProxyEnvStrip removes the proxy settings from the environment of
+	// dev_appserver.py. The harness does not use a proxy for Options.Host.
+	ProxyEnvStrip
+	// ProxyEnvInherit passes the proxy settings on unchanged, and leaves the
+	// harness to use them like any Go program.
+	ProxyEnvInherit
+)
+
+// proxyVars are the environment variables holding proxy settings, upper-cased.
+var proxyVars = map[string]bool{"HTTP_PROXY": true, "HTTPS_PROXY": true, "ALL_PROXY": true, "NO_PROXY": true}
+
+// localHosts are the hosts never reached through a proxy.
+var localHosts = []string{"localhost", "127.0.0.1", "::1"}
+
+// childProxyEnv applies mode to env, the environment inherited by
+// dev_appserver.py, whose servers listen on host.
+func childProxyEnv(env []string, mode ProxyEnv, host string) []string {
+	if mode == ProxyEnvInherit {
+		return env
+	}
+	var kept, noProxy []string
+	for _, kv := range env {
+		name := kv
+		if i := strings.IndexByte(kv, '='); i >= 0 {
+			name = kv[:i]
+		}
+		if !proxyVars[strings.ToUpper(name)] {
+			kept = append(kept, kv)
+			continue
+		}
+		if mode == ProxyEnvBypassLocal {
+			if strings.ToUpper(name) != "NO_PROXY" {
+				kept = append(kept, kv)
+			} else if v := strings.TrimSpace(kv[len(name)+1:]); v != "" {
+				noProxy = append(noProxy, strings.Split(v, ",")...)
+			}
+		}
+	}
+	if mode == ProxyEnvStrip {
+		return kept
+	}
+	seen := make(map[string]bool)
+	var hosts []string
+	for _, h := range append(append(noProxy, localHosts...), host) {
+		if h = strings.TrimSpace(h); h != "" && !seen[h] {
+			seen[h] = true
+			hosts = append(hosts, h)
+		}
+	}
+	// Python reads no_proxy first, Go reads NO_PROXY first.
+	v := strings.Join(hosts, ",")
+	return append(kept, "NO_PROXY="+v, "no_proxy="+v)
+}
+
+// bypassProxy returns the Proxy function of the transport of the harness: the
+// proxy of the environment, except for host.
+func bypassProxy(host string) func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		if strings.EqualFold(req.URL.Hostname(), host) {
+			return nil, nil
+		}
+		return http.ProxyFromEnvironment(req)
+	}
+}
+
+// isLoopback reports whether host is a name or address of the local machine
+// that Go never reaches through a proxy.
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
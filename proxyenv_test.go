@@ -0,0 +1,44 @@
+package gaetest
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestChildProxyEnv(t *testing.T) {
+	env := []string{"PATH=/bin", "HTTP_PROXY=http://proxy:3128", "https_proxy=http://proxy:3128", "no_proxy=corp.example.com, localhost"}
+
+	got := childProxyEnv(env, ProxyEnvBypassLocal, "devbox")
+	noProxy := "corp.example.com,localhost,127.0.0.1,::1,devbox"
+	expect := []string{"PATH=/bin", "HTTP_PROXY=http://proxy:3128", "https_proxy=http://proxy:3128", "NO_PROXY=" + noProxy, "no_proxy=" + noProxy}
+	if !reflect.DeepEqual(got, expect) {
+		t.Fatalf("got %q, but expect %q", got, expect)
+	}
+	if got, expect := childProxyEnv(env, ProxyEnvStrip, "devbox"), []string{"PATH=/bin"}; !reflect.DeepEqual(got, expect) {
+		t.Fatalf("got %q, but expect %q", got, expect)
+	}
+	if got := childProxyEnv(env, ProxyEnvInherit, "devbox"); !reflect.DeepEqual(got, env) {
+		t.Fatalf("got %q, but expect %q", got, env)
+	}
+}
+
+func TestTransportBypassesProxy(t *testing.T) {
+	sv := &Server{opts: withDefaults(nil)}
+	if sv.transport() != http.DefaultTransport {
+		t.Fatal("got a custom transport for localhost, but expect http.DefaultTransport")
+	}
+	sv = &Server{opts: withDefaults(&Options{Host: "devbox"})}
+	transport := sv.transport()
+	if transport == http.DefaultTransport || sv.transport() != transport {
+		t.Fatal("expected a single custom transport for devbox")
+	}
+	req, _ := http.NewRequest("GET", "http://DevBox:8080/", nil)
+	if u, err := transport.Proxy(req); u != nil || err != nil {
+		t.Fatalf("got proxy %v (%v), but expect none for devbox", u, err)
+	}
+	sv = &Server{opts: withDefaults(&Options{Host: "devbox", ProxyEnv: ProxyEnvInherit})}
+	if sv.transport() != http.DefaultTransport {
+		t.Fatal("got a custom transport with ProxyEnvInherit, but expect http.DefaultTransport")
+	}
+}
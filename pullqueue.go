@@ -0,0 +1,199 @@
+package gaetest
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// Pull queue tasks are managed through the taskqueue service of the API
+// server, the way workers running outside App Engine use the REST API of pull
+// queues.
+
+// pullMode is the PULL value of TaskQueueMode.Mode.
+const pullMode = 1
+
+// PullTask is a task of a pull queue.
+type PullTask struct {
+	Name    string
+	Payload []byte
+	Tag     string
+	// RetryCount is the number of times the task was leased.
+	RetryCount int
+	// ETA is the time the lease of the task expires.
+	ETA time.Time
+}
+
+// usecTime converts microseconds since the epoch to a time.
+func usecTime(usec int64) time.Time {
+	return time.Unix(0, usec*int64(time.Microsecond))
+}
+
+// AddPullTask adds a task carrying payload to the pull queue, tagged with tag
+// if not empty, and returns the name the stub chose for it.
+func (sv *Server) AddPullTask(queue string, payload []byte, tag string) (string, error) {
+	var add, req pbMessage
+	add.string(1, queue)
+	add.string(2, "")
+	add.int64(3, time.Now().UnixNano()/int64(time.Microsecond))
+	add.bytes(9, payload)
+	add.int64(20, pullMode)
+	if tag != "" {
+		add.string(22, tag)
+	}
+	req.bytes(1, add.buf.Bytes())
+	data, err := sv.callAPI("taskqueue", "BulkAdd", req.buf.Bytes())
+	if err != nil {
+		return "", err
+	}
+	fields, err := parsePB(data)
+	if err != nil {
+		return "", err
+	}
+	for _, f := range fields {
+		if f.num != 1 {
+			continue
+		}
+		result, err := parsePB(f.data)
+		if err != nil {
+			return "", err
+		}
+		var name string
+		for _, rf := range result {
+			switch rf.num {
+			case 2:
+				if rf.varint != 0 {
+					return "", fmt.Errorf("taskqueue.BulkAdd: error %d", rf.varint)
+				}
+			case 3:
+				name = string(rf.data)
+			}
+		}
+		return name, nil
+	}
+	return "", errors.New("taskqueue.BulkAdd: no task result")
+}
+
+// LeasePullTasks leases up to max tasks of the pull queue for lease, like a
+// worker would. Leasing a task increments its RetryCount.
+func (sv *Server) LeasePullTasks(queue string, lease time.Duration, max int) ([]PullTask, error) {
+	var req pbMessage
+	req.string(1, queue)
+	req.double(2, lease.Seconds())
+	req.int64(3, int64(max))
+	data, err := sv.callAPI("taskqueue", "QueryAndOwnTasks", req.buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	fields, err := parsePB(data)
+	if err != nil {
+		return nil, err
+	}
+	var tasks []PullTask
+	for _, f := range fields {
+		if f.num != 1 {
+			continue
+		}
+		group, err := parsePB(f.data)
+		if err != nil {
+			return nil, err
+		}
+		var task PullTask
+		for _, tf := range group {
+			switch tf.num {
+			case 2:
+				task.Name = string(tf.data)
+			case 3:
+				task.ETA = usecTime(int64(tf.varint))
+			case 4:
+				task.RetryCount = int(tf.varint)
+			case 5:
+				task.Payload = tf.data
+			case 6:
+				task.Tag = string(tf.data)
+			}
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, nil
+}
+
+// ModifyPullTaskLease extends or shortens the lease of task, leased by
+// LeasePullTasks, to lease from now and returns the task with its new ETA. A
+// zero lease returns the task to the queue at once.
+func (sv *Server) ModifyPullTaskLease(queue string, task PullTask, lease time.Duration) (PullTask, error) {
+	var req pbMessage
+	req.string(1, queue)
+	req.string(2, task.Name)
+	req.int64(3, task.ETA.UnixNano()/int64(time.Microsecond))
+	req.double(4, lease.Seconds())
+	data, err := sv.callAPI("taskqueue", "ModifyTaskLease", req.buf.Bytes())
+	if err != nil {
+		return task, err
+	}
+	fields, err := parsePB(data)
+	if err != nil {
+		return task, err
+	}
+	for _, f := range fields {
+		if f.num == 1 {
+			task.ETA = usecTime(int64(f.varint))
+		}
+	}
+	return task, nil
+}
+
+// DeletePullTasks deletes the named tasks of the pull queue, as a worker does
+// once it processed them.
+func (sv *Server) DeletePullTasks(queue string, names ...string) error {
+	var req pbMessage
+	req.string(1, queue)
+	for _, name := range names {
+		req.string(2, name)
+	}
+	data, err := sv.callAPI("taskqueue", "Delete", req.buf.Bytes())
+	if err != nil {
+		return err
+	}
+	fields, err := parsePB(data)
+	if err != nil {
+		return err
+	}
+	i := 0
+	for _, f := range fields {
+		if f.num != 3 {
+			continue
+		}
+		if f.varint != 0 {
+			return fmt.Errorf("taskqueue.Delete: task %s: error %d", names[i], f.varint)
+		}
+		i++
+	}
+	return nil
+}
+
+// AssertLeaseExpires checks that task, leased by LeasePullTasks, becomes
+// available again once its lease expires: it waits until the ETA of the task
+// and leases the queue again, which must return the task with a higher
+// RetryCount. The task is left leased for a second. Problems are reported with
+// t.Errorf.
+func (sv *Server) AssertLeaseExpires(t testing.TB, queue string, task PullTask) {
+	t.Helper()
+	time.Sleep(time.Until(task.ETA))
+	tasks, err := sv.LeasePullTasks(queue, time.Second, 1000)
+	if err != nil {
+		t.Errorf("%s: leasing %s again: %v", queue, task.Name, err)
+		return
+	}
+	for _, leased := range tasks {
+		if leased.Name != task.Name {
+			continue
+		}
+		if leased.RetryCount <= task.RetryCount {
+			t.Errorf("%s: task %s leased again with retry count %d, expected more than %d", queue, task.Name, leased.RetryCount, task.RetryCount)
+		}
+		return
+	}
+	t.Errorf("%s: task %s is not available after its lease expired", queue, task.Name)
+}
@@ -0,0 +1,135 @@
+package gaetest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// stubPullTask is a task held by newPullQueueStub.
+type stubPullTask struct {
+	name    string
+	payload []byte
+	retries int
+	eta     int64 // microseconds
+}
+
+// newPullQueueStub serves the pull queue methods of the taskqueue service for
+// a single queue.
+func newPullQueueStub() *httptest.Server {
+	var tasks []*stubPullTask
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		fields, _ := parsePB(body)
+		var method string
+		var req []pbField
+		for _, f := range fields {
+			switch f.num {
+			case 3:
+				method = string(f.data)
+			case 4:
+				req, _ = parsePB(f.data)
+			}
+		}
+		now := time.Now().UnixNano() / int64(time.Microsecond)
+		var out pbMessage
+		switch method {
+		case "BulkAdd":
+			add, _ := parsePB(req[0].data)
+			task := &stubPullTask{name: fmt.Sprintf("task%d", len(tasks)+1)}
+			for _, f := range add {
+				if f.num == 9 {
+					task.payload = f.data
+				}
+			}
+			tasks = append(tasks, task)
+			var result pbMessage
+			result.int64(2, 0)
+			result.string(3, task.name)
+			out.bytes(1, result.buf.Bytes())
+		case "QueryAndOwnTasks":
+			lease := math.Float64frombits(req[1].varint)
+			for _, task := range tasks {
+				if task.eta > now {
+					continue
+				}
+				task.retries++
+				task.eta = now + int64(lease*1e6)
+				out.startGroup(1)
+				out.string(2, task.name)
+				out.int64(3, task.eta)
+				out.int64(4, int64(task.retries))
+				out.bytes(5, task.payload)
+				out.endGroup(1)
+			}
+		case "ModifyTaskLease":
+			lease := math.Float64frombits(req[3].varint)
+			for _, task := range tasks {
+				if task.name == string(req[1].data) {
+					task.eta = now + int64(lease*1e6)
+					out.int64(1, task.eta)
+				}
+			}
+		case "Delete":
+			for _, f := range req[1:] {
+				code := int64(1) // UNKNOWN_QUEUE stands in for a missing task
+				for i, task := range tasks {
+					if task.name == string(f.data) {
+						tasks = append(tasks[:i], tasks[i+1:]...)
+						code = 0
+						break
+					}
+				}
+				out.int64(3, code)
+			}
+		}
+		var res pbMessage
+		res.bytes(1, out.buf.Bytes())
+		w.Write(res.buf.Bytes())
+	}))
+}
+
+func TestPullQueue(t *testing.T) {
+	ts := newPullQueueStub()
+	defer ts.Close()
+	sv := &Server{APIURL: ts.URL}
+
+	name, err := sv.AddPullTask("pull", []byte("work"), "")
+	if err != nil {
+		t.Fatalf("AddPullTask returned %v, expected nil", err)
+	}
+	tasks, err := sv.LeasePullTasks("pull", 50*time.Millisecond, 10)
+	if err != nil {
+		t.Fatalf("LeasePullTasks returned %v, expected nil", err)
+	}
+	if len(tasks) != 1 || tasks[0].Name != name || string(tasks[0].Payload) != "work" || tasks[0].RetryCount != 1 {
+		t.Fatalf("got %+v, but expect %s to be leased", tasks, name)
+	}
+	if again, _ := sv.LeasePullTasks("pull", time.Second, 10); len(again) != 0 {
+		t.Fatalf("got %+v, but expect the leased task to be unavailable", again)
+	}
+
+	ft := &fakeTB{}
+	sv.AssertLeaseExpires(ft, "pull", tasks[0])
+	if len(ft.errors) != 0 {
+		t.Fatalf("AssertLeaseExpires reported %q, expected nothing", ft.errors)
+	}
+
+	task, err := sv.ModifyPullTaskLease("pull", tasks[0], 0)
+	if err != nil {
+		t.Fatalf("ModifyPullTaskLease returned %v, expected nil", err)
+	}
+	if time.Until(task.ETA) > 0 {
+		t.Fatalf("got ETA %v, but expect the lease to be cancelled", task.ETA)
+	}
+	if err := sv.DeletePullTasks("pull", name); err != nil {
+		t.Fatalf("DeletePullTasks returned %v, expected nil", err)
+	}
+	if err := sv.DeletePullTasks("pull", name); err == nil {
+		t.Fatalf("DeletePullTasks returned nil for a deleted task, expected an error")
+	}
+}
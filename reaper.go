@@ -0,0 +1,222 @@
+package gaetest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pidfile describes a dev_appserver.py process launched by the package. One is
+// written for every running Server so that the servers can be discovered by
+// tools, and processes left behind by crashed test binaries can be found. The
+// owner holds an exclusive lock on the matching lockfile while the server
+// runs; the lock is released by the system when the owner dies.
+type pidfile struct {
+	PID       int    `json:"pid"`               // process (group) of dev_appserver.py
+	Started   string `json:"started,omitempty"` // see processStarted
+	Owner     int    `json:"owner"`             // process that launched it
+	AppDir    string `json:"app_dir"`
+	ModuleURL string `json:"module_url,omitempty"`
+	AdminURL  string `json:"admin_url,omitempty"`
+	APIURL    string `json:"api_url,omitempty"`
+	Name      string `json:"name,omitempty"`
+	// Detached servers are meant to outlive their owner.
+	Detached bool `json:"detached,omitempty"`
+}
+
+// running reports whether the process of pf runs and is the one pf was written
+// for, rather than an unrelated process reusing its PID. A process whose start
+// time is unknown is taken to be the one.
+func (pf *pidfile) running() bool {
+	if !alive(pf.PID) {
+		return false
+	}
+	started, err := processStarted(pf.PID)
+	return err != nil || pf.Started == "" || started == pf.Started
+}
+
+// runtimeDir returns dir, or the default directory for pidfiles and lockfiles
+// if dir is empty.
+func runtimeDir(dir string) string {
+	if dir == "" {
+		return filepath.Join(os.TempDir(), "gaetest")
+	}
+	return dir
+}
+
+func pidfilePath(dir string, pid int) string {
+	return filepath.Join(dir, fmt.Sprintf("%d.pid", pid))
+}
+
+func lockfilePath(dir string, pid int) string {
+	return filepath.Join(dir, fmt.Sprintf("%d.lock", pid))
+}
+
+// writePidfile records the running child process, taking the lock the first
+// time it is called. It is called again once the URLs are known.
+func (sv *Server) writePidfile() error {
+	dir := runtimeDir(sv.opts.RuntimeDir)
+	pid := sv.pid
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if sv.lock == nil && !sv.detached {
+		f, err := os.OpenFile(lockfilePath(dir, pid), os.O_CREATE|os.O_RDWR, 0644)
+		if err != nil {
+			return err
+		}
+		if err := lockFile(f); err != nil {
+			f.Close()
+			return fmt.Errorf("unable to lock %s: %v", f.Name(), err)
+		}
+		sv.lock = f
+	}
+	// Without a start time, ReapOrphans never kills the process.
+	started, _ := processStarted(pid)
+	data, err := json.Marshal(pidfile{
+		PID:       pid,
+		Started:   started,
+		Owner:     os.Getpid(),
+		AppDir:    sv.appDir,
+		ModuleURL: sv.ModuleURL,
+		AdminURL:  sv.AdminURL,
+		APIURL:    sv.APIURL,
+		Name:      sv.opts.Name,
+		Detached:  sv.detached,
+	})
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file first so readers never see a partial file.
+	tmp := pidfilePath(dir, pid) + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, pidfilePath(dir, pid))
+}
+
+func (sv *Server) removePidfile() {
+	dir := runtimeDir(sv.opts.RuntimeDir)
+	os.Remove(pidfilePath(dir, sv.pid))
+	if sv.lock != nil {
+		// Windows does not remove open files.
+		sv.lock.Close()
+		os.Remove(sv.lock.Name())
+		sv.lock = nil
+	}
+}
+
+// locked reports whether the lockfile at path is held by a live process.
+func locked(path string) bool {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	if err := lockFile(f); err != nil {
+		return err == errLocked
+	}
+	unlockFile(f)
+	return false
+}
+
+// ReapOrphans kills the dev_appserver.py processes, and their children, left
+// behind by test binaries that exited without closing their Servers. Detached
+// servers are left alone. Orphans are found through the pidfiles and lockfiles
+// the package writes for every Server in dir, which defaults to the default of
+// Options.RuntimeDir if empty.
+// A process is only killed if its start time matches the one recorded, so that
+// an unrelated process reusing the PID survives; otherwise the stale files are
+// just removed. It returns the process IDs it killed.
+func ReapOrphans(dir string) ([]int, error) {
+	dir = runtimeDir(dir)
+	names, err := filepath.Glob(filepath.Join(dir, "*.pid"))
+	if err != nil {
+		return nil, err
+	}
+	var killed []int
+	for _, name := range names {
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			continue // removed by its owner in the meantime
+		}
+		var pf pidfile
+		if err := json.Unmarshal(data, &pf); err != nil || pf.PID <= 0 {
+			os.Remove(name)
+			continue
+		}
+		if locked(lockfilePath(dir, pf.PID)) || pf.Detached && pf.running() {
+			continue
+		}
+		if started, err := processStarted(pf.PID); err == nil && pf.Started != "" && started == pf.Started {
+			if err := processGroup(pf.PID).kill(); err != nil && err != errGroupGone {
+				return killed, fmt.Errorf("unable to kill %d (%s): %v", pf.PID, pf.AppDir, err)
+			}
+			killed = append(killed, pf.PID)
+		}
+		os.Remove(name)
+		os.Remove(lockfilePath(dir, pf.PID))
+	}
+	return killed, nil
+}
+
+// launched records the process groups of the dev_appserver.py processes
+// started by this process, and their app directories, for
+// AssertNoLeakedProcesses. Detached servers are removed.
+var launched = struct {
+	sync.Mutex
+	groups map[int]string
+}{groups: make(map[int]string)}
+
+func trackProcess(pid int, appDir string) {
+	launched.Lock()
+	defer launched.Unlock()
+	launched.groups[pid] = appDir
+}
+
+func untrackProcess(pid int) {
+	launched.Lock()
+	defer launched.Unlock()
+	delete(launched.groups, pid)
+}
+
+// leakedProcesses returns the tracked process groups that still have live
+// processes once timeout expires.
+func leakedProcesses(timeout time.Duration) []int {
+	deadline := time.Now().Add(timeout)
+	for {
+		var leaked []int
+		launched.Lock()
+		for pid := range launched.groups {
+			if processGroup(pid).alive() {
+				leaked = append(leaked, pid)
+			}
+		}
+		launched.Unlock()
+		if len(leaked) == 0 || time.Now().After(deadline) {
+			sort.Ints(leaked)
+			return leaked
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// AssertNoLeakedProcesses reports an error to t for every dev_appserver.py
+// process, or process it started, still running although all the Servers of
+// the test binary were closed. Detached servers are not reported. Call it
+// after closing the servers, e.g. at the end of TestMain.
+func AssertNoLeakedProcesses(t testing.TB) {
+	t.Helper()
+	for _, pid := range leakedProcesses(time.Second) {
+		launched.Lock()
+		appDir := launched.groups[pid]
+		launched.Unlock()
+		t.Errorf("dev_appserver.py process group %d (%s) is still running", pid, appDir)
+	}
+}
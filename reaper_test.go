@@ -0,0 +1,116 @@
+package gaetest
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestReapOrphans(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gaetest")
+	if err != nil {
+		t.Fatalf("Got %v, expected nil", err)
+	}
+	defer os.RemoveAll(dir)
+
+	start := func() *Server {
+		child := exec.Command("sleep", "30")
+		prepareProcessGroup(child)
+		if err := child.Start(); err != nil {
+			t.Skipf("unable to run sleep: %v", err)
+		}
+		if _, err := startProcessGroup(child.Process); err != nil {
+			t.Fatalf("startProcessGroup returned %v, expected nil", err)
+		}
+		sv := &Server{child: child, pid: child.Process.Pid, appDir: "/tmp/app", opts: &Options{RuntimeDir: dir}}
+		if err := sv.writePidfile(); err != nil {
+			t.Fatalf("writePidfile returned %v, expected nil", err)
+		}
+		return sv
+	}
+	running, orphan, reused := start(), start(), start()
+	defer running.kill()
+	defer orphan.child.Process.Kill()
+	defer reused.kill()
+	// Releasing the lock is what happens when the owner dies.
+	orphan.lock.Close()
+	reused.lock.Close()
+	// The PID of reused now belongs to another process.
+	path := pidfilePath(dir, reused.pid)
+	data, _ := ioutil.ReadFile(path)
+	var stale pidfile
+	if err := json.Unmarshal(data, &stale); err != nil || stale.Started == "" {
+		t.Fatalf("got pidfile %s (%v), but expect it to record the start time", data, err)
+	}
+	stale.Started = "0"
+	data, _ = json.Marshal(stale)
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("WriteFile returned %v, expected nil", err)
+	}
+
+	data, _ = ioutil.ReadFile(pidfilePath(dir, running.child.Process.Pid))
+	var pf pidfile
+	if err := json.Unmarshal(data, &pf); err != nil || pf.Owner != os.Getpid() || pf.AppDir != "/tmp/app" {
+		t.Fatalf("got pidfile %s (%v), but expect it to name this process and the app", data, err)
+	}
+
+	killed, err := ReapOrphans(dir)
+	if err != nil {
+		t.Fatalf("ReapOrphans returned %v, expected nil", err)
+	}
+	if len(killed) != 1 || killed[0] != orphan.child.Process.Pid {
+		t.Fatalf("got %v, but expect only %d to be killed", killed, orphan.child.Process.Pid)
+	}
+	done := make(chan error, 1)
+	go func() { done <- orphan.child.Wait() }()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("orphan %d still running", orphan.child.Process.Pid)
+	}
+	if _, err := os.Stat(pidfilePath(dir, running.child.Process.Pid)); err != nil {
+		t.Fatalf("got %v, but expect the pidfile of the running server to be kept", err)
+	}
+	if !alive(reused.pid) {
+		t.Fatalf("process %d reusing a recorded PID was killed", reused.pid)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("got %v, but expect the stale pidfile to be removed", err)
+	}
+}
+
+func TestProcessStarted(t *testing.T) {
+	started, err := processStarted(os.Getpid())
+	if err != nil || started == "" {
+		t.Fatalf("processStarted returned %q, %v, expected a start time", started, err)
+	}
+	if again, _ := processStarted(os.Getpid()); again != started {
+		t.Fatalf("got %q, but expect %q", again, started)
+	}
+}
+
+func TestLeakedProcesses(t *testing.T) {
+	child := exec.Command("sleep", "30")
+	prepareProcessGroup(child)
+	if err := child.Start(); err != nil {
+		t.Skipf("unable to run sleep: %v", err)
+	}
+	if _, err := startProcessGroup(child.Process); err != nil {
+		t.Fatalf("startProcessGroup returned %v, expected nil", err)
+	}
+	pid := child.Process.Pid
+	trackProcess(pid, "/tmp/app")
+	defer untrackProcess(pid)
+
+	if leaked := leakedProcesses(0); len(leaked) != 1 || leaked[0] != pid {
+		t.Fatalf("got %v, but expect %d to be reported", leaked, pid)
+	}
+	child.Process.Kill()
+	child.Wait()
+	if leaked := leakedProcesses(time.Second); len(leaked) != 0 {
+		t.Fatalf("got %v, but expect no leaked processes", leaked)
+	}
+}
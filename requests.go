@@ -0,0 +1,100 @@
+package gaetest
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// fakeIsAdminHeader marks requests dispatched by the dev server itself (tasks,
+// cron jobs) so that handlers restricted with "login: admin" accept them.
+const fakeIsAdminHeader = "X-AppEngine-Fake-Is-Admin"
+
+// taskSeq numbers the tasks created by NewTaskRequest.
+var taskSeq int64
+
+// newRequest creates a request to path on the module server. It panics if the
+// resulting URL is invalid, like httptest.NewRequest.
+func (sv *Server) newRequest(method, path string, body []byte) *http.Request {
+	req, err := http.NewRequest(method, sv.ModuleURL+path, bytes.NewReader(body))
+	if err != nil {
+		panic(fmt.Sprintf("gaetest: invalid request path %q: %v", path, err))
+	}
+	return req
+}
+
+// NewTaskRequest returns a POST request to path carrying payload the way App
+// Engine delivers push tasks of queue: X-AppEngine-QueueName,
+// X-AppEngine-TaskName, X-AppEngine-TaskRetryCount,
+// X-AppEngine-TaskExecutionCount and X-AppEngine-TaskETA are set. Handlers that
+// validate these headers can be exercised by sending the request with any
+// client.
+func (sv *Server) NewTaskRequest(queue, path string, payload []byte) *http.Request {
+	return sv.newTaskRequest(queue, path, payload, 0)
+}
+
+func (sv *Server) newTaskRequest(queue, path string, payload []byte, retries int) *http.Request {
+	req := sv.newRequest("POST", path, payload)
+	eta := float64(time.Now().UnixNano()) / float64(time.Second)
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("X-AppEngine-QueueName", queue)
+	req.Header.Set("X-AppEngine-TaskName", fmt.Sprintf("task%d", atomic.AddInt64(&taskSeq, 1)))
+	req.Header.Set("X-AppEngine-TaskRetryCount", strconv.Itoa(retries))
+	req.Header.Set("X-AppEngine-TaskExecutionCount", strconv.Itoa(retries))
+	req.Header.Set("X-AppEngine-TaskETA", strconv.FormatFloat(eta, 'f', 6, 64))
+	req.Header.Set(fakeIsAdminHeader, "1")
+	return req
+}
+
+// NewInternalRequest returns a request to path the dev server treats as
+// internal traffic, like the requests it dispatches itself: it is let through
+// handlers restricted with "login: admin" without a logged in user. Use it to
+// test handlers that reject external traffic.
+func (sv *Server) NewInternalRequest(method, path string, body []byte) *http.Request {
+	req := sv.newRequest(method, path, body)
+	req.Header.Set(fakeIsAdminHeader, "1")
+	return req
+}
+
+// NewCronRequest returns a GET request to path the way the cron service issues
+// it: X-Appengine-Cron is set and the request is marked as coming from the dev
+// server itself, which lets it through handlers restricted with "login: admin".
+func (sv *Server) NewCronRequest(path string) *http.Request {
+	req := sv.newRequest("GET", path, nil)
+	req.Header.Set("X-Appengine-Cron", "true")
+	req.Header.Set(fakeIsAdminHeader, "1")
+	return req
+}
+
+// Geo is the location App Engine derives from the client IP address of a
+// request.
+type Geo struct {
+	Country string // ISO 3166-1 alpha-2 code, e.g. "US"
+	Region  string // ISO 3166-2 subdivision code within Country, e.g. "ca"
+	City    string // e.g. "mountain view"
+	// Latitude and longitude of the city; both zero leaves them out.
+	Lat, Long float64
+}
+
+// SetGeo sets the X-AppEngine-Country, X-AppEngine-Region, X-AppEngine-City
+// and X-AppEngine-CityLatLong headers of req the way production does, so that
+// handlers depending on the location of the client can be tested. Region and
+// City are lowercased, as in production. Empty fields are left out.
+func SetGeo(req *http.Request, g Geo) {
+	if g.Country != "" {
+		req.Header.Set("X-AppEngine-Country", strings.ToUpper(g.Country))
+	}
+	if g.Region != "" {
+		req.Header.Set("X-AppEngine-Region", strings.ToLower(g.Region))
+	}
+	if g.City != "" {
+		req.Header.Set("X-AppEngine-City", strings.ToLower(g.City))
+	}
+	if g.Lat != 0 || g.Long != 0 {
+		req.Header.Set("X-AppEngine-CityLatLong", strconv.FormatFloat(g.Lat, 'f', -1, 64)+","+strconv.FormatFloat(g.Long, 'f', -1, 64))
+	}
+}
@@ -0,0 +1,67 @@
+package gaetest
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewTaskRequest(t *testing.T) {
+	sv := &Server{ModuleURL: "http://localhost:8080"}
+	req := sv.NewTaskRequest("mail", "/tasks/send", []byte("payload"))
+	if expect := "http://localhost:8080/tasks/send"; req.URL.String() != expect {
+		t.Fatalf("got %q, but expect %q", req.URL, expect)
+	}
+	for header, expect := range map[string]string{
+		"X-AppEngine-QueueName":      "mail",
+		"X-AppEngine-TaskRetryCount": "0",
+		"X-AppEngine-Fake-Is-Admin":  "1",
+	} {
+		if got := req.Header.Get(header); got != expect {
+			t.Fatalf("got %s %q, but expect %q", header, got, expect)
+		}
+	}
+	if req.Header.Get("X-AppEngine-TaskName") == "" || req.Header.Get("X-AppEngine-TaskETA") == "" {
+		t.Fatalf("got headers %v, but expect a task name and ETA", req.Header)
+	}
+	if body, _ := ioutil.ReadAll(req.Body); string(body) != "payload" {
+		t.Fatalf("got body %q, but expect %q", body, "payload")
+	}
+}
+
+func TestNewCronRequest(t *testing.T) {
+	sv := &Server{ModuleURL: "http://localhost:8080"}
+	req := sv.NewCronRequest("/tasks/summary")
+	if req.Method != "GET" || req.URL.Path != "/tasks/summary" {
+		t.Fatalf("got %s %s, but expect GET /tasks/summary", req.Method, req.URL.Path)
+	}
+	if got := req.Header.Get("X-Appengine-Cron"); got != "true" {
+		t.Fatalf("got X-Appengine-Cron %q, but expect %q", got, "true")
+	}
+}
+
+func TestSetGeo(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	SetGeo(req, Geo{Country: "us", Region: "CA", City: "Mountain View", Lat: 37.386051, Long: -122.083851})
+	for header, expect := range map[string]string{
+		"X-AppEngine-Country":     "US",
+		"X-AppEngine-Region":      "ca",
+		"X-AppEngine-City":        "mountain view",
+		"X-AppEngine-CityLatLong": "37.386051,-122.083851",
+	} {
+		if got := req.Header.Get(header); got != expect {
+			t.Fatalf("got %s %q, but expect %q", header, got, expect)
+		}
+	}
+}
+
+func TestNewInternalRequest(t *testing.T) {
+	sv := &Server{ModuleURL: "http://localhost:8080"}
+	req := sv.NewInternalRequest("POST", "/internal/sync", nil)
+	if req.Method != "POST" || req.URL.Path != "/internal/sync" {
+		t.Fatalf("got %s %s, but expect POST /internal/sync", req.Method, req.URL.Path)
+	}
+	if got := req.Header.Get(fakeIsAdminHeader); got != "1" {
+		t.Fatalf("got %s %q, but expect %q", fakeIsAdminHeader, got, "1")
+	}
+}
@@ -0,0 +1,206 @@
+package gaetest
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Unlike FlushMemcache, which drives the admin server, the Reset functions call
+// the APIs of the dev server directly: they cover every namespace and do not
+// page through the admin pages, which makes them fast enough to run between
+// the tests sharing a server.
+
+// maxSearchBatch is the number of documents listed and deleted by a call to
+// the search API.
+const maxSearchBatch = 200
+
+// ResetDatastore deletes the entities of every kind in every namespace
+// through the API server.
+func (sv *Server) ResetDatastore() error {
+	namespaces, err := sv.query("", "__namespace__", true)
+	if err != nil {
+		return fmt.Errorf("gaetest: listing namespaces: %v", err)
+	}
+	for _, data := range namespaces {
+		key, _, err := decodeEntity(data)
+		if err != nil {
+			return err
+		}
+		// The default namespace is listed with ID 1 and no name.
+		if err := sv.resetNamespace(key.Name); err != nil {
+			return fmt.Errorf("gaetest: namespace %q: %v", key.Name, err)
+		}
+	}
+	return nil
+}
+
+// resetNamespace deletes the entities of every kind in namespace.
+func (sv *Server) resetNamespace(namespace string) error {
+	kinds, err := sv.query(namespace, "__kind__", true)
+	if err != nil {
+		return err
+	}
+	for _, data := range kinds {
+		k, _, err := decodeEntity(data)
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(k.Name, "__") {
+			continue
+		}
+		entities, err := sv.query(namespace, k.Name, true)
+		if err != nil {
+			return fmt.Errorf("%s: %v", k.Name, err)
+		}
+		for start := 0; start < len(entities); start += maxPutBatch {
+			end := start + maxPutBatch
+			if end > len(entities) {
+				end = len(entities)
+			}
+			var req pbMessage
+			for _, e := range entities[start:end] {
+				key, _, err := decodeEntity(e)
+				if err != nil {
+					return err
+				}
+				req.bytes(6, encodeReference(sv.datastoreApp(), key))
+			}
+			if _, err := sv.callAPI("datastore_v3", "Delete", req.buf.Bytes()); err != nil {
+				return fmt.Errorf("%s: %v", k.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// ResetMemcache removes all items from memcache through the API server.
+func (sv *Server) ResetMemcache() error {
+	if _, err := sv.callAPI("memcache", "FlushAll", nil); err != nil {
+		return fmt.Errorf("gaetest: %v", err)
+	}
+	return nil
+}
+
+// ResetSearchIndexes deletes the documents of every search index of the
+// default namespace through the API server. The indexes themselves are left,
+// empty.
+func (sv *Server) ResetSearchIndexes() error {
+	var params, req pbMessage
+	params.int64(1, 0) // fetch_schema
+	params.int64(2, 1000)
+	req.bytes(1, params.buf.Bytes())
+	data, err := sv.callAPI("search", "ListIndexes", req.buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("gaetest: %v", err)
+	}
+	fields, err := searchResponse(data)
+	if err != nil {
+		return fmt.Errorf("gaetest: search.ListIndexes: %v", err)
+	}
+	for _, f := range fields {
+		if f.num != 2 {
+			continue
+		}
+		metadata, err := parsePB(f.data)
+		if err != nil {
+			return err
+		}
+		for _, mf := range metadata {
+			if mf.num == 1 {
+				if err := sv.resetSearchIndex(mf.data); err != nil {
+					return fmt.Errorf("gaetest: search index %s: %v", searchIndexName(mf.data), err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// resetSearchIndex deletes the documents of the index described by the
+// encoded IndexSpec spec, a batch at a time.
+func (sv *Server) resetSearchIndex(spec []byte) error {
+	for {
+		var params, req pbMessage
+		params.bytes(1, spec)
+		params.int64(4, maxSearchBatch)
+		params.int64(5, 1) // keys_only
+		req.bytes(1, params.buf.Bytes())
+		data, err := sv.callAPI("search", "ListDocuments", req.buf.Bytes())
+		if err != nil {
+			return err
+		}
+		fields, err := searchResponse(data)
+		if err != nil {
+			return fmt.Errorf("search.ListDocuments: %v", err)
+		}
+		var del pbMessage
+		n := 0
+		for _, f := range fields {
+			if f.num != 2 {
+				continue
+			}
+			doc, err := parsePB(f.data)
+			if err != nil {
+				return err
+			}
+			for _, df := range doc {
+				if df.num == 1 {
+					del.bytes(1, df.data)
+					n++
+				}
+			}
+		}
+		if n == 0 {
+			return nil
+		}
+		del.bytes(2, spec)
+		var delReq pbMessage
+		delReq.bytes(1, del.buf.Bytes())
+		if _, err := sv.callAPI("search", "DeleteDocument", delReq.buf.Bytes()); err != nil {
+			return err
+		}
+	}
+}
+
+// searchResponse decodes the response of a search API call, checking the
+// code of its RequestStatus.
+func searchResponse(data []byte) ([]pbField, error) {
+	fields, err := parsePB(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range fields {
+		if f.num != 1 {
+			continue
+		}
+		status, err := parsePB(f.data)
+		if err != nil {
+			return nil, err
+		}
+		var code uint64
+		var detail string
+		for _, sf := range status {
+			switch sf.num {
+			case 1:
+				code = sf.varint
+			case 2:
+				detail = string(sf.data)
+			}
+		}
+		if code != 0 {
+			return nil, fmt.Errorf("status %d: %s", code, detail)
+		}
+	}
+	return fields, nil
+}
+
+// searchIndexName returns the name in an encoded IndexSpec.
+func searchIndexName(spec []byte) string {
+	fields, _ := parsePB(spec)
+	for _, f := range fields {
+		if f.num == 1 {
+			return string(f.data)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,147 @@
+package gaetest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestResetDatastore(t *testing.T) {
+	var stored []storedEntity
+	ts := newDatastoreStub(&stored)
+	defer ts.Close()
+	sv := &Server{APIURL: ts.URL, opts: withDefaults(nil)}
+
+	if _, err := sv.Factory("User").CreateN(3); err != nil {
+		t.Fatalf("CreateN returned %v, expected nil", err)
+	}
+	if _, err := sv.Factory("Post").Create(); err != nil {
+		t.Fatalf("Create returned %v, expected nil", err)
+	}
+	if _, err := sv.Factory("Post").InNamespace("acme").CreateN(2); err != nil {
+		t.Fatalf("CreateN returned %v, expected nil", err)
+	}
+	if err := sv.ResetDatastore(); err != nil {
+		t.Fatalf("ResetDatastore returned %v, expected nil", err)
+	}
+	if len(stored) != 0 {
+		t.Fatalf("got %d entities, but expect none: %+v", len(stored), stored)
+	}
+}
+
+// newSearchStub serves a search API holding the IDs of docs, by index name,
+// and records the calls made to it.
+func newSearchStub(docs map[string][]string, calls *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		fields, _ := parsePB(body)
+		var service, method string
+		var req []pbField
+		for _, f := range fields {
+			switch f.num {
+			case 2:
+				service = string(f.data)
+			case 3:
+				method = string(f.data)
+			case 4:
+				req, _ = parsePB(f.data)
+			}
+		}
+		*calls = append(*calls, service+"."+method)
+		var params []pbField
+		if len(req) > 0 {
+			params, _ = parsePB(req[0].data)
+		}
+		var out pbMessage
+		var status pbMessage
+		status.int64(1, 0)
+		out.bytes(1, status.buf.Bytes())
+		switch method {
+		case "ListIndexes":
+			for name := range docs {
+				var spec, metadata pbMessage
+				spec.string(1, name)
+				metadata.bytes(1, spec.buf.Bytes())
+				out.bytes(2, metadata.buf.Bytes())
+			}
+		case "ListDocuments":
+			name := searchIndexName(params[0].data)
+			for i, id := range docs[name] {
+				if i == 2 { // a small batch, to exercise paging
+					break
+				}
+				var doc pbMessage
+				doc.string(1, id)
+				out.bytes(2, doc.buf.Bytes())
+			}
+		case "IndexDocument":
+			var name string
+			var ids []string
+			for _, f := range params {
+				switch f.num {
+				case 1:
+					doc, _ := parsePB(f.data)
+					ids = append(ids, string(doc[0].data))
+				case 3:
+					name = searchIndexName(f.data)
+				}
+			}
+			docs[name] = append(docs[name], ids...)
+		case "DeleteDocument":
+			var name string
+			deleted := make(map[string]bool)
+			for _, f := range params {
+				switch f.num {
+				case 1:
+					deleted[string(f.data)] = true
+				case 2:
+					name = searchIndexName(f.data)
+				}
+			}
+			var left []string
+			for _, id := range docs[name] {
+				if !deleted[id] {
+					left = append(left, id)
+				}
+			}
+			docs[name] = left
+		}
+		var res pbMessage
+		res.bytes(1, out.buf.Bytes())
+		w.Write(res.buf.Bytes())
+	}))
+}
+
+func TestResetSearchIndexes(t *testing.T) {
+	docs := map[string][]string{"products": {"a", "b", "c"}}
+	var calls []string
+	ts := newSearchStub(docs, &calls)
+	defer ts.Close()
+	sv := &Server{APIURL: ts.URL}
+
+	if err := sv.ResetSearchIndexes(); err != nil {
+		t.Fatalf("ResetSearchIndexes returned %v, expected nil", err)
+	}
+	if len(docs["products"]) != 0 {
+		t.Fatalf("got %q, but expect no documents left", docs["products"])
+	}
+	expect := []string{
+		"search.ListIndexes",
+		"search.ListDocuments", "search.DeleteDocument",
+		"search.ListDocuments", "search.DeleteDocument",
+		"search.ListDocuments",
+	}
+	if !reflect.DeepEqual(calls, expect) {
+		t.Fatalf("got %q, but expect %q", calls, expect)
+	}
+
+	calls = nil
+	if err := sv.ResetMemcache(); err != nil {
+		t.Fatalf("ResetMemcache returned %v, expected nil", err)
+	}
+	if expect := []string{"memcache.FlushAll"}; !reflect.DeepEqual(calls, expect) {
+		t.Fatalf("got %q, but expect %q", calls, expect)
+	}
+}
@@ -0,0 +1,195 @@
+package gaetest
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakesFile is the file under Options.ArtifactDir the statistics of the
+// retried tests are appended to, one JSON object per line.
+const flakesFile = "flakes.jsonl"
+
+// attempt is the testing.TB passed to the function retried by Server.Retry. It
+// records failures instead of reporting them to the test.
+type attempt struct {
+	testing.TB
+	n int
+
+	mu       sync.Mutex
+	failed   bool
+	skipped  bool
+	messages []string
+}
+
+func (a *attempt) fail(msg string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.failed = true
+	if msg != "" {
+		a.messages = append(a.messages, msg)
+	}
+}
+
+func (a *attempt) Fail() {
+	a.fail("")
+}
+
+func (a *attempt) FailNow() {
+	a.fail("")
+	runtime.Goexit()
+}
+
+func (a *attempt) Error(args ...interface{}) {
+	a.fail(fmt.Sprint(args...))
+}
+
+func (a *attempt) Errorf(format string, args ...interface{}) {
+	a.fail(fmt.Sprintf(format, args...))
+}
+
+func (a *attempt) Fatal(args ...interface{}) {
+	a.Error(args...)
+	runtime.Goexit()
+}
+
+func (a *attempt) Fatalf(format string, args ...interface{}) {
+	a.Errorf(format, args...)
+	runtime.Goexit()
+}
+
+// SkipNow ends the attempt; Retry then skips the test.
+func (a *attempt) SkipNow() {
+	a.mu.Lock()
+	a.skipped = true
+	a.mu.Unlock()
+	runtime.Goexit()
+}
+
+func (a *attempt) Skip(args ...interface{}) {
+	a.Log(args...)
+	a.SkipNow()
+}
+
+func (a *attempt) Skipf(format string, args ...interface{}) {
+	a.Logf(format, args...)
+	a.SkipNow()
+}
+
+func (a *attempt) Skipped() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.skipped
+}
+
+func (a *attempt) Log(args ...interface{}) {
+	a.TB.Log(fmt.Sprintf("attempt %d: ", a.n) + fmt.Sprint(args...))
+}
+
+func (a *attempt) Logf(format string, args ...interface{}) {
+	a.TB.Logf("attempt %d: "+format, append([]interface{}{a.n}, args...)...)
+}
+
+func (a *attempt) Failed() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.failed
+}
+
+// run runs fn, which may call runtime.Goexit, on a goroutine of its own.
+func (a *attempt) run(fn func(t testing.TB)) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn(a)
+	}()
+	<-done
+}
+
+// flakeRecord is a line of flakesFile.
+type flakeRecord struct {
+	Test     string     `json:"test"`
+	Time     time.Time  `json:"time"`
+	Attempts int        `json:"attempts"`
+	Passed   bool       `json:"passed"`
+	Failures [][]string `json:"failures"` // messages of the failed attempts
+}
+
+// Retry runs fn up to attempts times, until it passes, for tests known to be
+// flaky. The datastore and memcache are reset before every attempt after the
+// first, and a line naming the attempt is added to the output of
+// dev_appserver.py so that its logs can be told apart. Failures of attempts
+// followed by a passing one are only logged; if every attempt fails, the
+// failures of the last one are reported to t. When more than one attempt was
+// needed and Options.ArtifactDir is set, the outcome is appended to
+// flakes.jsonl in ArtifactDir, so that flaky tests stay visible. Retry
+// reports whether an attempt passed. attempts must be at least 1.
+func (sv *Server) Retry(t testing.TB, attempts int, fn func(t testing.TB)) bool {
+	t.Helper()
+	if attempts < 1 {
+		t.Fatalf("gaetest: Retry needs at least 1 attempt, got %d", attempts)
+	}
+	rec := flakeRecord{Test: t.Name(), Time: time.Now()}
+	var last *attempt
+	for i := 1; i <= attempts; i++ {
+		if i > 1 {
+			if err := sv.reset(); err != nil {
+				t.Fatalf("gaetest: unable to reset before attempt %d: %v", i, err)
+			}
+		}
+		if sv.logs != nil {
+			fmt.Fprintf(sv.logs, "gaetest: %s: attempt %d of %d\n", t.Name(), i, attempts)
+		}
+		last = &attempt{TB: t, n: i}
+		last.run(fn)
+		rec.Attempts = i
+		if last.Skipped() {
+			t.SkipNow()
+		}
+		if !last.Failed() {
+			rec.Passed = true
+			break
+		}
+		rec.Failures = append(rec.Failures, last.messages)
+		if i < attempts {
+			t.Logf("attempt %d of %d failed: %s", i, attempts, strings.Join(last.messages, "; "))
+		}
+	}
+	if !rec.Passed {
+		for _, msg := range last.messages {
+			t.Error(msg)
+		}
+		t.Fail()
+	}
+	if rec.Attempts > 1 && sv.opts != nil && sv.opts.ArtifactDir != "" {
+		if err := appendFlake(filepath.Join(sv.opts.ArtifactDir, flakesFile), rec); err != nil {
+			t.Logf("gaetest: unable to record flake statistics: %v", err)
+		}
+	}
+	return rec.Passed
+}
+
+func appendFlake(path string, rec flakeRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
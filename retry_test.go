@@ -0,0 +1,72 @@
+package gaetest
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRetry(t *testing.T) {
+	var stored []storedEntity
+	ts := newDatastoreStub(&stored)
+	defer ts.Close()
+	logs, _ := newLogBuffer(nil)
+	dir := t.TempDir()
+	opts := withDefaults(&Options{ArtifactDir: dir})
+	sv := &Server{APIURL: ts.URL, logs: logs, opts: opts, state: StateReady}
+
+	calls := 0
+	passed := sv.Retry(t, 3, func(t testing.TB) {
+		calls++
+		if len(stored) != 0 {
+			t.Fatalf("got %d entities, but expect a reset before attempt %d", len(stored), calls)
+		}
+		if _, err := sv.Factory("User").Create(); err != nil {
+			t.Fatalf("Create returned %v, expected nil", err)
+		}
+		if calls < 3 {
+			t.Fatalf("flake %d", calls)
+		}
+	})
+	if !passed || calls != 3 || len(stored) != 1 {
+		t.Fatalf("got %t after %d calls and %d entities, but expect a pass after 3 calls and the entity of the last", passed, calls, len(stored))
+	}
+	if lines := logs.snapshot(); len(lines) != 3 || lines[2] != "gaetest: TestRetry: attempt 3 of 3" {
+		t.Fatalf("got %q, but expect a line per attempt", lines)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, flakesFile))
+	if err != nil {
+		t.Fatalf("ReadFile returned %v, expected nil", err)
+	}
+	var rec flakeRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		t.Fatalf("Unmarshal returned %v, expected nil", err)
+	}
+	if expect := [][]string{{"flake 1"}, {"flake 2"}}; rec.Attempts != 3 || !rec.Passed || !reflect.DeepEqual(rec.Failures, expect) {
+		t.Fatalf("got %+v, but expect 3 attempts with failures %q", rec, expect)
+	}
+
+	ft := &fakeTB{TB: t}
+	if sv.Retry(ft, 2, func(t testing.TB) { t.Errorf("broken") }) {
+		t.Fatalf("Retry returned true, expected false")
+	}
+	if expect := []string{"broken"}; !reflect.DeepEqual(ft.errors, expect) {
+		t.Fatalf("got %q, but expect %q", ft.errors, expect)
+	}
+	data, _ = ioutil.ReadFile(filepath.Join(dir, flakesFile))
+	if n := strings.Count(string(data), "\n"); n != 2 {
+		t.Fatalf("got %d records, but expect 2", n)
+	}
+
+	// An attempt records the Fatalf instead of failing this test.
+	a := &attempt{TB: t}
+	a.run(func(t testing.TB) {
+		sv.Retry(t, 0, func(t testing.TB) {})
+	})
+	if expect := []string{"gaetest: Retry needs at least 1 attempt, got 0"}; !a.Failed() || !reflect.DeepEqual(a.messages, expect) {
+		t.Fatalf("got %q, but expect %q", a.messages, expect)
+	}
+}
@@ -0,0 +1,216 @@
+package gaetest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Search fixture files hold the documents of search indexes as a list of
+// objects with an index name, a document ID and fields, in JSON or in YAML
+// like entity fixtures:
+//
+//	[
+//	  {
+//	    "index": "products",
+//	    "id": "p1",
+//	    "fields": {
+//	      "Title": "Red running shoe",
+//	      "Price": 89.5,
+//	      "SKU": {"atom": "RS-42"},
+//	      "Description": {"html": "<p>Light and <b>fast</b></p>"},
+//	      "Added": {"date": "2016-10-02T21:48:00Z"},
+//	      "Store": {"geo": [48.85, 2.35]},
+//	      "Tags": ["running", "red"]
+//	    }
+//	  }
+//	]
+//
+// Strings are text fields and numbers are number fields; other types are
+// wrapped. Arrays repeat a field.
+
+// SearchFixture is a document of a search fixture file.
+type SearchFixture struct {
+	Index  string                 `json:"index"`
+	ID     string                 `json:"id"`
+	Fields map[string]interface{} `json:"fields"`
+}
+
+// Values of FieldValue.ContentType.
+const (
+	searchText   = 0
+	searchHTML   = 1
+	searchAtom   = 2
+	searchDate   = 3
+	searchNumber = 4
+	searchGeo    = 5
+)
+
+// maxIndexBatch is the number of documents indexed by a search.IndexDocument
+// call.
+const maxIndexBatch = 200
+
+// searchEpoch is the origin of the default rank of documents.
+var searchEpoch = time.Date(2011, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// encodeSearchValue encodes a field value of a search fixture as a
+// FieldValue.
+func encodeSearchValue(v interface{}) ([]byte, error) {
+	var m pbMessage
+	switch v := v.(type) {
+	case string:
+		m.int64(1, searchText)
+		m.string(3, v)
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return nil, err
+		}
+		m.int64(1, searchNumber)
+		m.string(3, strconv.FormatFloat(f, 'e', -1, 64))
+	case map[string]interface{}:
+		if len(v) != 1 {
+			return nil, fmt.Errorf("unsupported value %v", v)
+		}
+		for typ, x := range v {
+			switch typ {
+			case "text", "html", "atom":
+				m.int64(1, map[string]int64{"text": searchText, "html": searchHTML, "atom": searchAtom}[typ])
+				m.string(3, fmt.Sprint(x))
+			case "date":
+				s, _ := x.(string)
+				t, err := time.Parse(time.RFC3339Nano, s)
+				if err != nil {
+					if t, err = time.Parse("2006-01-02", s); err != nil {
+						return nil, fmt.Errorf("invalid date %q", s)
+					}
+				}
+				m.int64(1, searchDate)
+				m.string(3, strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10))
+			case "geo":
+				lat, lng, ok := geoPoint(x)
+				if !ok {
+					return nil, fmt.Errorf("invalid geo %v, expected [lat, lng]", x)
+				}
+				m.int64(1, searchGeo)
+				m.startGroup(4)
+				m.double(5, lat)
+				m.double(6, lng)
+				m.endGroup(4)
+			default:
+				return nil, fmt.Errorf("unsupported value %v", v)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported value %v", v)
+	}
+	return m.buf.Bytes(), nil
+}
+
+// geoPoint decodes the [lat, lng] array of a geo field.
+func geoPoint(v interface{}) (lat, lng float64, ok bool) {
+	coords, _ := v.([]interface{})
+	if len(coords) != 2 {
+		return 0, 0, false
+	}
+	var latLng [2]float64
+	for i, c := range coords {
+		n, _ := c.(json.Number)
+		f, err := n.Float64()
+		if err != nil {
+			return 0, 0, false
+		}
+		latLng[i] = f
+	}
+	lat, lng = latLng[0], latLng[1]
+	return lat, lng, lat >= -90 && lat <= 90 && lng >= -180 && lng <= 180
+}
+
+// encodeSearchDocument encodes d as a Document.
+func encodeSearchDocument(d SearchFixture) ([]byte, error) {
+	var doc pbMessage
+	doc.string(1, d.ID)
+	names := make([]string, 0, len(d.Fields))
+	for name := range d.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		values, ok := d.Fields[name].([]interface{})
+		if !ok {
+			values = []interface{}{d.Fields[name]}
+		}
+		for _, v := range values {
+			value, err := encodeSearchValue(v)
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %v", name, err)
+			}
+			var field pbMessage
+			field.string(1, name)
+			field.bytes(2, value)
+			doc.bytes(3, field.buf.Bytes())
+		}
+	}
+	doc.int64(4, int64(time.Since(searchEpoch)/time.Second))
+	return doc.buf.Bytes(), nil
+}
+
+// LoadSearchFixtures indexes the documents of a search fixture file, in JSON
+// or YAML, through the API server. Documents replace the indexed ones with the
+// same ID. Indexes are created as needed, in the default namespace.
+func (sv *Server) LoadSearchFixtures(r io.Reader) error {
+	var docs []SearchFixture
+	if err := decodeFixtures(r, &docs); err != nil {
+		return fmt.Errorf("gaetest: search fixtures: %v", err)
+	}
+	byIndex := make(map[string][][]byte)
+	var indexes []string
+	for i, d := range docs {
+		if d.Index == "" {
+			return fmt.Errorf("gaetest: search fixtures: document %d: missing index", i+1)
+		}
+		doc, err := encodeSearchDocument(d)
+		if err != nil {
+			return fmt.Errorf("gaetest: search fixtures: %s/%s: %v", d.Index, d.ID, err)
+		}
+		if byIndex[d.Index] == nil {
+			indexes = append(indexes, d.Index)
+		}
+		byIndex[d.Index] = append(byIndex[d.Index], doc)
+	}
+	for _, index := range indexes {
+		if err := sv.indexDocuments(index, byIndex[index]); err != nil {
+			return fmt.Errorf("gaetest: search index %s: %v", index, err)
+		}
+	}
+	return nil
+}
+
+// indexDocuments adds the encoded documents to index, a batch at a time.
+func (sv *Server) indexDocuments(index string, docs [][]byte) error {
+	var spec pbMessage
+	spec.string(1, index)
+	for start := 0; start < len(docs); start += maxIndexBatch {
+		end := start + maxIndexBatch
+		if end > len(docs) {
+			end = len(docs)
+		}
+		var params, req pbMessage
+		for _, doc := range docs[start:end] {
+			params.bytes(1, doc)
+		}
+		params.bytes(3, spec.buf.Bytes())
+		req.bytes(1, params.buf.Bytes())
+		data, err := sv.callAPI("search", "IndexDocument", req.buf.Bytes())
+		if err != nil {
+			return err
+		}
+		if _, err := searchResponse(data); err != nil {
+			return fmt.Errorf("search.IndexDocument: %v", err)
+		}
+	}
+	return nil
+}
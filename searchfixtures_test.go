@@ -0,0 +1,84 @@
+package gaetest
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadSearchFixtures(t *testing.T) {
+	docs := make(map[string][]string)
+	var calls []string
+	ts := newSearchStub(docs, &calls)
+	defer ts.Close()
+	sv := &Server{APIURL: ts.URL}
+
+	yaml := `- index: products
+  id: p1
+  fields:
+    Title: Red running shoe
+    Price: 89.5
+    SKU:
+      atom: RS-42
+- index: stores
+  id: s1
+  fields:
+    Store:
+      geo: [48.85, 2.35]
+- index: products
+  id: p2
+  fields:
+    Tags: [running, red]
+`
+	if err := sv.LoadSearchFixtures(strings.NewReader(yaml)); err != nil {
+		t.Fatalf("LoadSearchFixtures returned %v, expected nil", err)
+	}
+	expect := map[string][]string{"products": {"p1", "p2"}, "stores": {"s1"}}
+	if !reflect.DeepEqual(docs, expect) {
+		t.Fatalf("got %v, but expect %v", docs, expect)
+	}
+	if expect := []string{"search.IndexDocument", "search.IndexDocument"}; !reflect.DeepEqual(calls, expect) {
+		t.Fatalf("got %q, but expect %q", calls, expect)
+	}
+
+	err := sv.LoadSearchFixtures(strings.NewReader(`[{"index": "stores", "id": "s2", "fields": {"Store": {"geo": [91, 0]}}}]`))
+	expectErr := "gaetest: search fixtures: stores/s2: field Store: invalid geo [91 0], expected [lat, lng]"
+	if err == nil || err.Error() != expectErr {
+		t.Fatalf("got %v, but expect %q", err, expectErr)
+	}
+}
+
+func TestEncodeSearchValue(t *testing.T) {
+	tests := []struct {
+		value     interface{}
+		typ       uint64
+		stringVal string
+	}{
+		{"shoe", searchText, "shoe"},
+		{json.Number("89.5"), searchNumber, "8.95e+01"},
+		{map[string]interface{}{"html": "<b>x</b>"}, searchHTML, "<b>x</b>"},
+		{map[string]interface{}{"date": "2016-10-02"}, searchDate, "1475366400000"},
+	}
+	for _, test := range tests {
+		data, err := encodeSearchValue(test.value)
+		if err != nil {
+			t.Fatalf("encodeSearchValue(%v) returned %v, expected nil", test.value, err)
+		}
+		fields, _ := parsePB(data)
+		if len(fields) != 2 || fields[0].varint != test.typ || string(fields[1].data) != test.stringVal {
+			t.Fatalf("%v: got %v, but expect type %d and %q", test.value, fields, test.typ, test.stringVal)
+		}
+	}
+
+	data, err := encodeSearchValue(map[string]interface{}{"geo": []interface{}{json.Number("48.85"), json.Number("2.35")}})
+	if err != nil {
+		t.Fatalf("encodeSearchValue returned %v, expected nil", err)
+	}
+	fields, _ := parsePB(data)
+	geo, _ := parsePB(fields[1].data)
+	if fields[0].varint != searchGeo || math.Float64frombits(geo[0].varint) != 48.85 || math.Float64frombits(geo[1].varint) != 2.35 {
+		t.Fatalf("got %v, but expect a geo point", fields)
+	}
+}
@@ -8,16 +8,22 @@ package gaetest
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"regexp"
-	"syscall"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -40,23 +46,310 @@ type Options struct {
 	// Timeout in seconds used to wait for appserver startup and close. Defaults
 	// to 15s.
 	Timeout int
+	// ID of the app, passed to the argument --application. Defaults to
+	// "testapp".
+	AppID string
 	// Print debug output.
 	Debug bool
+	// Wait for an instance of every module to start before returning from New.
+	// dev_appserver.py announces the module URL as soon as the dispatcher has
+	// registered it, which is before any instance is able to serve requests.
+	// The server is considered started when an "Instance PID" line has been
+	// logged for each module.
+	WaitForInstances bool
+	// Names of the services (modules) that must be running before New returns.
+	// New fails, naming the missing services, if any of them is not started by
+	// dev_appserver.py or does not respond to HTTP requests within Timeout.
+	// The services are waited for in parallel, along with the default module.
+	ExpectServices []string
+	// Enable the interactive console in the admin server. The value is passed
+	// to the argument --enable_console.
+	EnableConsole bool
+	// Capture push tasks instead of letting the dev server execute them. The
+	// argument --enable_task_running=false is passed and tasks stay in their
+	// queues until they are delivered with Server.DeliverTask.
+	CaptureTasks bool
+	// User logged in for the whole run. Clients returned by Server.Client
+	// carry the user's login cookie and the OAuth part of the Users stub
+	// reports the user as the current OAuth user.
+	User *User
+	// Start an OAuth 2 stub server (see OAuthStub) for the app. Its endpoints
+	// are passed to the app in the environment variables
+	// GAETEST_OAUTH_AUTH_URL, GAETEST_OAUTH_TOKEN_URL and
+	// GAETEST_OAUTH_USERINFO_URL.
+	OAuthStub bool
+	// Route the outbound HTTP traffic of the app (urlfetch) through a forward
+	// proxy run by the harness, using the HTTP_PROXY and HTTPS_PROXY
+	// environment variables. Requests can then be stubbed per host with
+	// Server.StubHost and inspected with Server.OutboundRequests.
+	InterceptOutbound bool
+	// Stubs for the third-party APIs the app calls, keyed by name. Each
+	// handler is served by its own test server whose URL is passed to the app
+	// in the environment variable GAETEST_<NAME>_URL, where <NAME> is the
+	// upper-cased name, and returned by Server.ExternalURL.
+	Externals map[string]http.Handler
+	// Maximum number of instances dev_appserver.py runs per module. The value
+	// is passed to the argument --max_module_instances. Defaults to no limit.
+	MaxModuleInstances int
+	// Check that index.yaml defines every composite index the queries made
+	// during the run need. dev_appserver.py adds missing indexes to
+	// index.yaml; with CheckIndexes set, Close undoes these additions and
+	// returns an error listing them.
+	CheckIndexes bool
+	// Regular expressions matched against every line of dev_appserver.py
+	// output, which includes the logs of the app. If any line matches, Close
+	// returns an error, so that tests fail when the app panics or logs errors
+	// even if its responses looked fine. Defaults to patterns matching panics
+	// and ERROR lines; set it to an empty slice to disable the check.
+	FailOnLogPattern []string
+	// Put a reverse proxy run by the harness in front of the default module.
+	// Server.ModuleURL then points at the proxy, which records the latency and
+	// status of every request for Server.TrafficReport.
+	Proxy bool
+	// Directory diagnostic artifacts such as triage bundles are written to.
+	ArtifactDir string
+	// Kill the dev_appserver.py processes left behind by earlier test runs
+	// (see ReapOrphans) before starting the server. Orphans often hold the
+	// ports a new server needs.
+	ReapOrphans bool
+	// Directory holding the pidfile and lockfile written for every running
+	// server. Defaults to a gaetest directory under os.TempDir.
+	RuntimeDir string
+	// Name of the server, required to Detach it and Reconnect to it later.
+	Name string
+	// Address to serve the control API on, e.g. "localhost:0" (see
+	// Server.ControlURL). Empty disables it.
+	ControlAddr string
+	// Restart the app when its files change, reporting every reload on
+	// Server.Ready.
+	Watch bool
+	// Let dev_appserver.py restart the app when its files change. Implied by
+	// Watch.
+	AutomaticRestart bool
+	// Writers receiving the output of dev_appserver.py as it is written, in
+	// addition to os.Stderr if Debug is set. See TestLogWriter.
+	LogSinks []io.Writer
+	// File the output of dev_appserver.py is appended to. It is rotated once
+	// it grows beyond LogFileMaxSize bytes or gets older than LogFileMaxAge,
+	// keeping LogFileBackups rotated files, named LogFile.1 (the newest) to
+	// LogFile.N. Zero limits are not enforced.
+	LogFile        string
+	LogFileMaxSize int64
+	LogFileMaxAge  time.Duration
+	LogFileBackups int
+	// Make Close check that the ports of dev_appserver.py are released,
+	// retrying briefly, so that the next server does not fail to bind them.
+	VerifyPortsReleased bool
+	// Make New return at once, with ModuleURL pointing at a proxy that
+	// starts dev_appserver.py on the first request, holding it until the
+	// server is ready. Test binaries whose tests are all skipped then pay no
+	// startup cost. Implies Proxy. See Server.Start.
+	Lazy bool
+	// Go build cache (GOCACHE) of the app, kept across runs so that the app
+	// and its dependencies are not compiled from scratch every time. It
+	// defaults to the GOCACHE of the environment if set, or gaetest-gocache
+	// in os.TempDir otherwise.
+	GoCache string
+	// Make New call Server.VerifyBackends before returning.
+	VerifyBackends bool
+	// Environment variables passed to the app.
+	AppEnv map[string]string
+	// Port of the proxy enabled with Proxy. If 0, a free port is picked.
+	ProxyPort int
+	// Time zone (TZ) of dev_appserver.py and the app, e.g. "Europe/Paris".
+	Timezone string
+	// Locale (LANG and LC_ALL) of dev_appserver.py and the app, e.g.
+	// "fr_FR.UTF-8".
+	Locale string
+	// Clock of the app: frozen at FrozenTime if it is not zero, otherwise
+	// off by ClockOffset from the real time. The Go runtime reads the time
+	// from the kernel, so it cannot be faked from outside the app: the
+	// settings are passed to the app in GAETEST_FROZEN_TIME (RFC 3339) and
+	// GAETEST_CLOCK_OFFSET (a time.Duration string), for the app to honor.
+	FrozenTime  time.Time
+	ClockOffset time.Duration
+	// Detect crash loops: once the server started, CrashLoopRestarts
+	// instance starts within CrashLoopWindow (one minute if 0) make
+	// Server.CrashLoop return an error. Zero disables the detection.
+	CrashLoopRestarts int
+	CrashLoopWindow   time.Duration
+	// Close the proxy gracefully: Close stops accepting new requests and
+	// waits up to DrainTimeout for those in flight before quitting
+	// dev_appserver.py. It requires Proxy or Lazy.
+	DrainTimeout time.Duration
+	// Abort the requests through the proxy whose response sends nothing for
+	// StreamIdleTimeout. The proxy passes streamed responses (server-sent
+	// events, chunked long polls) on as the app writes them; the timeout
+	// bounds those that stall. Upgraded connections are not affected. Zero
+	// means no timeout. It requires Proxy or Lazy.
+	StreamIdleTimeout time.Duration
+	// Seed for the random choices of the app, passed to it in
+	// GAETEST_RANDOM_SEED for apps that seed their generators with it. If 0,
+	// a seed is picked from the clock. The seed is returned by
+	// Server.RandomSeed and written to triage bundles, so that failed runs
+	// can be reproduced by setting it.
+	AppRandomSeed int64
+	// Record the App Engine API calls of the app. The API server is moved
+	// to a free port and a proxy recording the calls takes its place in the
+	// API_HOST and API_PORT environment variables of the app. The calls are
+	// returned by Server.APICalls and can be delayed or failed with
+	// Server.InjectAPIFault and Server.SetAPIQuota.
+	RecordAPICalls bool
+	// Additional arguments for dev_appserver.py, e.g. "--require_indexes=yes",
+	// passed after the ones the package generates. New fails if an argument
+	// sets a flag the package already sets; use the corresponding option
+	// instead.
+	ExtraArgs []string
+	// Path polled on the default module once dev_appserver.py announced its
+	// servers, e.g. "/_ah/health": New returns when it answers with a 2xx
+	// status. Without ReadyPath, the root of the module is polled until it
+	// answers with any status below 500, so that apps not serving it still
+	// start.
+	ReadyPath string
+	// Time the default module has to become ready. Defaults to Timeout.
+	ReadyTimeout time.Duration
+	// Time after New the harness gives up on the server: it kills
+	// dev_appserver.py and closes the server, and the API calls and the
+	// requests of Server.Client still pending or made later fail with
+	// ErrSuiteDeadline. It keeps a wedged dev server from hanging go test
+	// until its own timeout. Timeout is lowered to fit in it. Zero means no
+	// deadline.
+	SuiteDeadline time.Duration
+	// Directory holding the data of the stubs (datastore, blobstore, search
+	// indexes and logs), passed to --storage_path. It is created if needed
+	// and left in place by Close. Defaults to a temporary directory removed
+	// by Close. Servers running at the same time must not share it.
+	StoragePath string
+	// Start with the data left in StoragePath by the previous server, e.g. a
+	// datastore seeded once for several test binaries, instead of clearing
+	// the datastore and the search indexes. It requires StoragePath.
+	PreserveData bool
+	// Transport of the requests the harness makes on its own: API calls,
+	// admin server operations, readiness probes, the /quit of Close and the
+	// requests sent on behalf of App Engine, e.g. to run tasks. It is not
+	// used by the clients returned by Server.Client. Defaults to
+	// http.DefaultTransport.
+	Transport http.RoundTripper
+	// What happens to the proxy settings of the environment, see ProxyEnv.
+	// Defaults to ProxyEnvBypassLocal.
+	ProxyEnv ProxyEnv
+	// Hosts the app may connect to with the Sockets API, as "host" or
+	// "host:port", e.g. the Addr of an EchoServer. Connections to other
+	// hosts fail with the PERMISSION_DENIED error of the remote_socket
+	// service. The check is made by the proxy of RecordAPICalls, which
+	// setting it installs. Empty allows every host.
+	SocketAllowedHosts []string
+	// SocketRedirects maps hosts, "host" or "host:port", to the "host:port"
+	// the app's Sockets API connections to them go to instead, e.g. the Addr
+	// of an EchoServer. remote_socket lookups of a redirected host name
+	// resolve to the address of its target, and redirected connections are
+	// allowed whatever SocketAllowedHosts says. Entries naming a port take
+	// precedence. Setting it installs the proxy of RecordAPICalls.
+	SocketRedirects map[string]string
 }
 
 type Server struct {
-	appDir    string
-	opts      *Options
-	child     *exec.Cmd
-	AdminURL  string
-	APIURL    string
-	ModuleURL string
+	appDir      string
+	configs     []string // passed to dev_appserver.py instead of appDir, see NewMulti
+	opts        *Options
+	child       *exec.Cmd
+	pid         int          // process (group) of dev_appserver.py
+	wait        func() error // waits for the process to exit
+	detached    bool
+	services    map[string]string
+	admin       *admin
+	oauth       *OAuthStub
+	outbound    *outboundProxy
+	api         *apiProxy
+	externals   map[string]string
+	indexes     *indexCheck
+	logs        *logBuffer
+	proxy       *appProxy
+	backend     string // URL of the default module, behind the proxy if any
+	seed        int64  // passed to the app, see Options.AppRandomSeed
+	factories   factories
+	deadLetters deadLetters
+	captured    capturedTasks
+	stopWatch   func() // stops the goroutine of NewContext
+	watcher     *watcher
+	args        []string // command line of dev_appserver.py
+	storage     string   // directory holding the stub data
+	lock        *os.File // lockfile held while the server runs
+	timings     timings
+	suite       context.Context // done at Options.SuiteDeadline
+	localOnce   sync.Once
+	local       *http.Transport // see transport
+	env         []string        // environment variables added for dev_appserver.py
+	appEnv      []string        // environment variables passed to the app
+	cleanups    []func() error  // run by Close, in reverse order
+	stateMu     sync.Mutex
+	state       State
+	startMu     sync.Mutex // held while the server starts, see Close
+	restarting  bool       // set while the control API restarts the server
+	control     *http.Server
+	controlMu   sync.Mutex // serializes the requests to the control API
+	AdminURL    string
+	APIURL      string
+	ModuleURL   string
+	// ControlURL is the URL of the control API, if Options.ControlAddr is
+	// set.
+	ControlURL string
 }
 
 // New launches an instance dev_appserver to run the app at appDir. If opts is
 // nil the default values are used. If New returns without errors,
 // Server.ModuleURL contains the endpoint to run the tests against.
 func New(appDir string, opts *Options) (*Server, error) {
+	return start(&Server{appDir: appDir, opts: withDefaults(opts)})
+}
+
+// start runs the server sv, or prepares it to run on the first request if
+// Options.Lazy is set.
+func start(sv *Server) (*Server, error) {
+	sv.startMu.Lock()
+	defer sv.startMu.Unlock()
+	return sv, sv.launch()
+}
+
+// launch runs the server, or prepares it to run on the first request if
+// Options.Lazy is set, releasing its resources if it fails. It is called with
+// startMu held.
+func (sv *Server) launch() error {
+	if sv.opts.SuiteDeadline > 0 {
+		sv.startSuiteDeadline()
+	}
+	// The logs are read before a lazy server starts, so they exist first.
+	err := sv.newLogs()
+	if err == nil && sv.opts.Lazy {
+		err = sv.startLazy()
+	} else if err == nil {
+		err = sv.run()
+	}
+	if err != nil {
+		sv.cleanup()
+	}
+	return err
+}
+
+// newLogs creates the buffer recording the output of dev_appserver.py.
+func (sv *Server) newLogs() error {
+	failPatterns := sv.opts.FailOnLogPattern
+	if failPatterns == nil {
+		failPatterns = defaultFailOnLogPatterns
+	}
+	logs, err := newLogBuffer(failPatterns)
+	if err != nil {
+		return err
+	}
+	if sv.opts.CrashLoopRestarts > 0 {
+		logs.crash = newCrashDetector(sv.opts.CrashLoopRestarts, sv.opts.CrashLoopWindow)
+	}
+	sv.logs = logs
+	return nil
+}
+
+// withDefaults returns opts with the default values filled in.
+func withDefaults(opts *Options) *Options {
 	if opts == nil {
 		opts = &Options{}
 	}
@@ -69,22 +362,55 @@ func New(appDir string, opts *Options) (*Server, error) {
 	if opts.Timeout == 0 {
 		opts.Timeout = 15
 	}
-	sv := &Server{appDir: appDir, opts: opts}
-	return sv, sv.run()
+	if opts.AppID == "" {
+		opts.AppID = "testapp"
+	}
+	return opts
 }
 
 var apiServerAddrRE = regexp.MustCompile(`Starting API server at: (\S+)`)
-var moduleServerAddrRE = regexp.MustCompile(`Starting module ".+" running at: (\S+)`)
+var moduleServerAddrRE = regexp.MustCompile(`Starting module "(.+)" running at: (\S+)`)
+var dispatcherAddrRE = regexp.MustCompile(`Starting dispatcher running at: (\S+)`)
 var adminServerAddrRE = regexp.MustCompile(`Starting admin server at: (\S+)`)
+var instanceStartedRE = regexp.MustCompile(`Instance PID: (\d+)`)
+
+// endpoints holds the URLs scanned from the output of dev_appserver.py.
+type endpoints struct {
+	api, module, admin string
+	// dispatcher is the URL routing requests with dispatch.yaml, if any.
+	dispatcher string
+	// services maps module names to the URLs they are running at.
+	services map[string]string
+}
 
-func getURLs(reader io.Reader, timeout time.Duration) (string, string, string, error) {
+// missing returns the names in expect that have no URL in services.
+func (ep *endpoints) missing(expect []string) []string {
+	var names []string
+	for _, name := range expect {
+		if _, ok := ep.services[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func getURLs(reader io.Reader, timeout time.Duration, opts *Options) (*endpoints, error) {
 	var (
-		api, module, admin string
-		errc               = make(chan error, 1)
+		ep        = &endpoints{services: make(map[string]string)}
+		instances int
+		errc      = make(chan error, 1)
 	)
 
 	scanned := func() bool {
-		return (api != "" && module != "" && admin != "")
+		if ep.api == "" || ep.module == "" || ep.admin == "" {
+			return false
+		}
+		// dev_appserver.py announces every module before the admin server, so
+		// there is no point waiting for services that have not shown up yet.
+		if len(ep.missing(opts.ExpectServices)) > 0 {
+			return true
+		}
+		return !opts.WaitForInstances || instances >= len(ep.services)
 	}
 
 	go func() { // scan stderr for patterns
@@ -94,13 +420,22 @@ func getURLs(reader io.Reader, timeout time.Duration) (string, string, string, e
 		// loop.
 		for !scanned() && s.Scan() {
 			if match := apiServerAddrRE.FindStringSubmatch(s.Text()); match != nil {
-				api = match[1]
+				ep.api = match[1]
 			}
 			if match := moduleServerAddrRE.FindStringSubmatch(s.Text()); match != nil {
-				module = match[1]
+				ep.services[match[1]] = match[2]
+				if ep.module == "" || match[1] == "default" {
+					ep.module = match[2]
+				}
+			}
+			if match := dispatcherAddrRE.FindStringSubmatch(s.Text()); match != nil {
+				ep.dispatcher = match[1]
+			}
+			if instanceStartedRE.MatchString(s.Text()) {
+				instances++
 			}
 			if match := adminServerAddrRE.FindStringSubmatch(s.Text()); match != nil {
-				admin = match[1]
+				ep.admin = match[1]
 			}
 		}
 		errc <- s.Err()
@@ -108,50 +443,322 @@ func getURLs(reader io.Reader, timeout time.Duration) (string, string, string, e
 
 	select {
 	case <-time.After(timeout):
-		return "", "", "", fmt.Errorf("timeout starting child process")
+		return nil, fmt.Errorf("timeout starting child process")
 	case err := <-errc:
 		if err != nil {
-			return "", "", "", fmt.Errorf("error reading server stderr: %v", err)
+			return nil, fmt.Errorf("error reading server stderr: %v", err)
 		}
 	}
 
-	if admin == "" {
-		return "", "", "", errors.New("unable to find admin server URL")
+	if ep.admin == "" {
+		return nil, errors.New("unable to find admin server URL")
+	}
+	if ep.module == "" {
+		return nil, errors.New("unable to find module server URL")
+	}
+	if ep.api == "" {
+		return nil, errors.New("unable to find api server URL")
+	}
+	if missing := ep.missing(opts.ExpectServices); len(missing) > 0 {
+		return nil, fmt.Errorf("expected services not started: %s", strings.Join(missing, ", "))
+	}
+	if opts.WaitForInstances && instances < len(ep.services) {
+		return nil, fmt.Errorf("only %d of %d module instances started", instances, len(ep.services))
+	}
+
+	return ep, nil
+}
+
+// httpClient returns the client of the requests the harness makes on its own,
+// see Options.Transport.
+func (sv *Server) httpClient() *http.Client {
+	if sv.opts != nil && sv.opts.Transport != nil {
+		return &http.Client{Transport: sv.opts.Transport}
 	}
-	if module == "" {
-		return "", "", "", errors.New("unable to find module server URL")
+	if t := sv.transport(); t != http.DefaultTransport {
+		return &http.Client{Transport: t}
 	}
-	if api == "" {
-		return "", "", "", errors.New("unable to find api server URL")
+	return http.DefaultClient
+}
+
+// transport returns the transport of the requests to the dev server: one
+// that does not use a proxy for Options.Host, unless Options.ProxyEnv is
+// ProxyEnvInherit or Go bypasses proxies for the host anyway.
+func (sv *Server) transport() *http.Transport {
+	if sv.opts == nil || sv.opts.ProxyEnv == ProxyEnvInherit || isLoopback(sv.opts.Host) {
+		return http.DefaultTransport.(*http.Transport)
+	}
+	sv.localOnce.Do(func() {
+		sv.local = http.DefaultTransport.(*http.Transport).Clone()
+		sv.local.Proxy = bypassProxy(sv.opts.Host)
+	})
+	return sv.local
+}
+
+// waitResponding polls url with client until the server answers with any HTTP
+// response or timeout expires.
+func waitResponding(client *http.Client, url string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		res, err := client.Get(url)
+		if err == nil {
+			res.Body.Close()
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(100 * time.Millisecond)
 	}
+}
+
+// maxReadyBackoff bounds the delay between the polls of waitReady.
+const maxReadyBackoff = time.Second
 
-	return api, module, admin, nil
+// waitReady polls url with client, doubling the delay between polls, until the server
+// answers with a 2xx status, or any status below 500 unless requireOK is set,
+// or timeout expires.
+func waitReady(client *http.Client, url string, requireOK bool, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	backoff := 50 * time.Millisecond
+	for {
+		res, err := client.Get(url)
+		if err == nil {
+			res.Body.Close()
+			if res.StatusCode/100 == 2 || (!requireOK && res.StatusCode < 500) {
+				return nil
+			}
+			err = fmt.Errorf("status %s", res.Status)
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			return err
+		}
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > maxReadyBackoff {
+			backoff = maxReadyBackoff
+		}
+	}
 }
 
-func (sv *Server) run() error {
+// reapOrphans kills the processes left behind by crashed test binaries if
+// Options.ReapOrphans is set.
+func (sv *Server) reapOrphans() error {
+	if !sv.opts.ReapOrphans {
+		return nil
+	}
+	killed, err := ReapOrphans(sv.opts.RuntimeDir)
+	if err != nil {
+		return err
+	}
+	if len(killed) > 0 && sv.opts.Debug {
+		log.Printf("killed orphaned dev_appserver.py processes %v", killed)
+	}
+	return nil
+}
+
+// checkIndexes records index.yaml before dev_appserver.py changes it if
+// Options.CheckIndexes is set.
+func (sv *Server) checkIndexes() error {
+	if !sv.opts.CheckIndexes {
+		return nil
+	}
+	indexes, err := newIndexCheck(sv.appDir)
+	if err != nil {
+		return err
+	}
+	sv.indexes = indexes
+	return nil
+}
+
+// prepareEnv sets up the storage, the fixtures and the environment of the
+// app.
+func (sv *Server) prepareEnv() error {
+	if err := sv.prepareStorage(); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(sv.opts.AppEnv))
+	for name := range sv.opts.AppEnv {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		sv.appEnv = append(sv.appEnv, name+"="+sv.opts.AppEnv[name])
+	}
+	sv.appEnv = append(sv.appEnv, clockEnv(sv.opts)...)
+	if sv.seed = sv.opts.AppRandomSeed; sv.seed == 0 {
+		sv.seed = time.Now().UnixNano()
+	}
+	sv.appEnv = append(sv.appEnv, "GAETEST_RANDOM_SEED="+strconv.FormatInt(sv.seed, 10))
+	if sv.opts.Timezone != "" {
+		sv.env = append(sv.env, "TZ="+sv.opts.Timezone)
+	}
+	sv.env = append(sv.env, localeEnv(sv.opts.Locale)...)
+	sv.appEnv = append(sv.appEnv, localeEnv(sv.opts.Locale)...)
+	return sv.startFixtures()
+}
+
+// startFixtures starts the servers the app is configured to talk to.
+func (sv *Server) startFixtures() error {
+	sv.startExternals()
+	if sv.opts.OAuthStub {
+		sv.oauth = NewOAuthStub()
+		sv.cleanups = append(sv.cleanups, noError(sv.oauth.Close))
+		sv.appEnv = append(sv.appEnv, sv.oauth.env()...)
+	}
+	if sv.opts.InterceptOutbound {
+		sv.outbound = newOutboundProxy()
+		sv.cleanups = append(sv.cleanups, noError(sv.outbound.Close))
+		// urlfetch is served by dev_appserver.py itself, but apps may also
+		// talk to the network directly.
+		sv.env = append(sv.env, sv.outbound.env()...)
+		sv.appEnv = append(sv.appEnv, sv.outbound.env()...)
+	}
+	if sv.opts.RecordAPICalls || len(sv.opts.SocketAllowedHosts) > 0 || len(sv.opts.SocketRedirects) > 0 {
+		redirects, err := newSocketRedirects(sv.opts.SocketRedirects)
+		if err != nil {
+			return err
+		}
+		if sv.api, err = newAPIProxy(sv.opts.Host); err != nil {
+			return err
+		}
+		sv.api.allowedHosts = sv.opts.SocketAllowedHosts
+		sv.api.redirects = redirects
+		sv.cleanups = append(sv.cleanups, noError(sv.api.Close))
+		sv.appEnv = append(sv.appEnv, sv.api.env()...)
+	}
+	return nil
+}
+
+// parallel runs fns concurrently and returns their errors, in the order of
+// fns.
+func parallel(fns ...func() error) []error {
+	errs := make([]error, len(fns))
+	var wg sync.WaitGroup
+	for i, fn := range fns {
+		wg.Add(1)
+		go func(i int, fn func() error) {
+			defer wg.Done()
+			errs[i] = fn()
+		}(i, fn)
+	}
+	wg.Wait()
+	return errs
+}
+
+// noError adapts a cleanup function that cannot fail.
+func noError(f func()) func() error {
+	return func() error {
+		f()
+		return nil
+	}
+}
+
+// cleanup releases the resources held by the fixtures, returning the errors
+// it ran into.
+func (sv *Server) cleanup() multiError {
+	var errs multiError
+	for i := len(sv.cleanups) - 1; i >= 0; i-- {
+		if err := sv.cleanups[i](); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	sv.cleanups = nil
+	return errs
+}
+
+func (sv *Server) run() (err error) {
+	if err := sv.transition(StateStarting); err != nil {
+		return err
+	}
+	defer func() {
+		if err == nil && sv.transition(StateReady) != nil {
+			sv.kill()
+			err = errClosedStarting
+		}
+		if err != nil {
+			if sv.pid != 0 {
+				// The process was killed: reap it.
+				sv.wait()
+				sv.setProcessID(0)
+			}
+			sv.transition(StateFailed)
+		}
+	}()
+	sv.timings.Started = time.Now()
+	defer func() { sv.timings.Startup = time.Since(sv.timings.Started) }()
+
 	serverPath, err := exec.LookPath(sv.opts.DevAppServer)
 	if err != nil {
 		return err
 	}
+	// The steps preparing the disk run alongside the fixtures, which the app
+	// needs the URLs of: all of them are done before it is launched.
+	cache := goCache(sv.opts.GoCache)
+	prepared := make(chan []error, 1)
+	go func() {
+		prepared <- parallel(sv.reapOrphans, sv.checkIndexes, func() error {
+			return os.MkdirAll(cache, 0755)
+		})
+	}()
+	err = sv.prepareEnv()
+	for _, perr := range <-prepared {
+		if perr != nil {
+			return perr
+		}
+	}
+	if err != nil {
+		return err
+	}
+	sv.env = append(sv.env, "GOCACHE="+cache)
 
 	args := []string{
-		"--automatic_restart=false",
+		fmt.Sprintf("--automatic_restart=%t", sv.opts.AutomaticRestart || sv.opts.Watch),
 		"--skip_sdk_update_check=true",
-		"--clear_datastore=true",
-		"--clear_search_indexes=true",
+		fmt.Sprintf("--application=%s", sv.opts.AppID),
+		fmt.Sprintf("--clear_datastore=%t", !sv.opts.PreserveData),
+		fmt.Sprintf("--clear_search_indexes=%t", !sv.opts.PreserveData),
 		"--datastore_consistency_policy=consistent",
 		fmt.Sprintf("--host=%s", sv.opts.Host),
 		fmt.Sprintf("--admin_host=%s", sv.opts.Host),
 		fmt.Sprintf("--port=%d", sv.opts.Port),
 		fmt.Sprintf("--admin_port=%d", sv.opts.AdminPort),
-		sv.appDir,
+		fmt.Sprintf("--storage_path=%s", sv.storage),
+		fmt.Sprintf("--datastore_path=%s", filepath.Join(sv.storage, "datastore.db")),
+		fmt.Sprintf("--blobstore_path=%s", filepath.Join(sv.storage, "blobs")),
+		fmt.Sprintf("--logs_path=%s", filepath.Join(sv.storage, "logs.db")),
+		fmt.Sprintf("--search_indexes_path=%s", filepath.Join(sv.storage, "search_indexes")),
+	}
+	if sv.opts.EnableConsole {
+		args = append(args, "--enable_console=true")
+	}
+	if sv.opts.CaptureTasks {
+		args = append(args, "--enable_task_running=false")
+	}
+	if sv.api != nil {
+		args = append(args, fmt.Sprintf("--api_port=%d", sv.api.port))
+	}
+	if sv.opts.MaxModuleInstances > 0 {
+		args = append(args, fmt.Sprintf("--max_module_instances=%d", sv.opts.MaxModuleInstances))
+	}
+	for _, kv := range sv.appEnv {
+		args = append(args, "--env_var="+kv)
+	}
+	if args, err = appendExtraArgs(args, sv.opts.ExtraArgs); err != nil {
+		return err
+	}
+	if sv.configs != nil {
+		args = append(args, sv.configs...)
+	} else {
+		args = append(args, sv.appDir)
 	}
 
 	if sv.opts.Debug {
 		log.Printf("running %s %v\n\n", serverPath, args)
 	}
 
+	sv.args = append([]string{serverPath}, args...)
 	sv.child = exec.Command(serverPath, args...)
+	sv.child.Env = sv.childEnv()
 
 	// print stdout, stderr only if debug is set.
 	stdout := ioutil.Discard
@@ -166,61 +773,369 @@ func (sv *Server) run() error {
 		return err
 	}
 
+	sinks := []io.Writer{sv.logs}
+	if sv.opts.Watch {
+		timeout := time.Duration(sv.opts.Timeout) * time.Second
+		sv.watcher = newWatcher(func() string { return sv.ModuleURL }, timeout, sv.httpClient())
+		sv.cleanups = append(sv.cleanups, noError(sv.watcher.Close))
+		sinks = append(sinks, sv.watcher)
+	}
 	if sv.opts.Debug {
-		stderr = io.TeeReader(stderr, os.Stderr)
+		sinks = append(sinks, os.Stderr)
+	}
+	if sv.opts.LogFile != "" {
+		f, err := openRotatingFile(sv.opts.LogFile, sv.opts.LogFileMaxSize, sv.opts.LogFileMaxAge, sv.opts.LogFileBackups)
+		if err != nil {
+			return err
+		}
+		sv.cleanups = append(sv.cleanups, f.Close)
+		sinks = append(sinks, f)
 	}
+	sinks = append(sinks, sv.opts.LogSinks...)
+	stderr = io.TeeReader(stderr, fanout(sinks))
 
-	sv.child.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	prepareProcessGroup(sv.child)
 	if err := sv.child.Start(); err != nil {
 		return err
 	}
+	if _, err := startProcessGroup(sv.child.Process); err != nil {
+		sv.child.Process.Kill()
+		return err
+	}
+	sv.timings.Spawn = time.Since(sv.timings.Started)
+	sv.wait = sv.child.Wait
+	sv.setProcessID(sv.child.Process.Pid)
+	trackProcess(sv.pid, sv.appDir)
+	if err := sv.writePidfile(); err != nil {
+		sv.kill()
+		return err
+	}
+	if sv.closing() {
+		sv.kill()
+		return errClosedStarting
+	}
 
-	sv.APIURL, sv.ModuleURL, sv.AdminURL, err = getURLs(stderr, time.Duration(sv.opts.Timeout)*time.Second)
+	timeout := time.Duration(sv.opts.Timeout) * time.Second
+	ep, err := getURLs(stderr, timeout, sv.opts)
 	if err != nil {
 		sv.kill()
+		return err
 	}
-	return err
+	// Keep reading, so that the output is recorded and dev_appserver.py
+	// does not block writing to a full pipe.
+	go io.Copy(ioutil.Discard, stderr)
+	sv.logs.arm()
+	if ep.dispatcher != "" {
+		// Requests to the dispatcher are routed like on App Engine.
+		ep.module = ep.dispatcher
+	}
+	sv.APIURL, sv.AdminURL, sv.backend = ep.api, ep.admin, ep.module
+	if sv.proxy == nil {
+		sv.ModuleURL = ep.module
+	}
+	sv.services = ep.services
+	sv.admin = &admin{url: sv.AdminURL, client: sv.httpClient()}
+	if err := sv.writePidfile(); err != nil {
+		sv.kill()
+		return err
+	}
+	if sv.opts.Proxy && sv.proxy == nil {
+		if sv.proxy, err = newAppProxy(sv.proxyAddr(), sv.ModuleURL); err != nil {
+			sv.kill()
+			return err
+		}
+		sv.cleanups = append(sv.cleanups, noError(sv.proxy.Close))
+		sv.ModuleURL = sv.proxy.URL
+	}
+	if sv.proxy != nil {
+		sv.proxy.idleTimeout = sv.opts.StreamIdleTimeout
+	}
+	if sv.proxy != nil && sv.logs.crash != nil {
+		sv.proxy.healthy = sv.logs.crashLoop
+	}
+
+	// The URLs are scanned from the output of dev_appserver.py, whose format
+	// changes between SDK releases; only a response proves the app runs.
+	readyTimeout := sv.opts.ReadyTimeout
+	if readyTimeout == 0 {
+		readyTimeout = timeout
+	}
+	// The default module, the other services and the backends are waited
+	// for at once.
+	checks := []func() error{func() error {
+		if err := waitReady(sv.httpClient(), sv.backend+sv.opts.ReadyPath, sv.opts.ReadyPath != "", readyTimeout); err != nil {
+			return fmt.Errorf("app not ready at %s: %v", sv.backend+sv.opts.ReadyPath, err)
+		}
+		return nil
+	}}
+	for _, name := range sv.opts.ExpectServices {
+		name := name
+		checks = append(checks, func() error {
+			if err := waitResponding(sv.httpClient(), sv.services[name], timeout); err != nil {
+				return fmt.Errorf("service %q is not responding: %v", name, err)
+			}
+			return nil
+		})
+	}
+	if sv.opts.VerifyBackends {
+		checks = append(checks, func() error {
+			ctx, cancel := context.WithTimeout(context.Background(), verifyBackendsTimeout)
+			defer cancel()
+			return sv.VerifyBackends(ctx)
+		})
+	}
+	for _, err := range parallel(checks...) {
+		if err != nil {
+			sv.kill()
+			return err
+		}
+	}
+	if sv.opts.ControlAddr != "" {
+		if err := sv.startControl(); err != nil {
+			sv.kill()
+			return err
+		}
+	}
+	return nil
 }
 
-func (sv *Server) kill() {
-	// kill all processes in the same gid
-	if err := syscall.Kill(-sv.child.Process.Pid, syscall.SIGKILL); err != nil && sv.opts.Debug {
-		log.Printf("syscall.Kill: got %v, expected nil", err)
+// prepareStorage sets up the directory holding the stub data, Options.StoragePath
+// or a temporary directory keeping the data of each server apart, instead of
+// sharing the default location under the user's home directory.
+func (sv *Server) prepareStorage() error {
+	if sv.opts.PreserveData && sv.opts.StoragePath == "" {
+		return errors.New("gaetest: PreserveData requires StoragePath")
 	}
+	if sv.opts.StoragePath != "" {
+		sv.storage = sv.opts.StoragePath
+		return os.MkdirAll(sv.storage, 0755)
+	}
+	var err error
+	if sv.storage, err = ioutil.TempDir("", "gaetest-storage"); err != nil {
+		return err
+	}
+	sv.cleanups = append(sv.cleanups, func() error {
+		if sv.detached {
+			return nil
+		}
+		return os.RemoveAll(sv.storage)
+	})
+	return nil
 }
 
-// Close kills the child dev_appserver process, releasing its resources.
-func (sv *Server) Close() error {
-	if sv.child.Process == nil {
+// clockEnv returns the environment variables setting the time zone and clock
+// of the app.
+func clockEnv(opts *Options) []string {
+	var env []string
+	if opts.Timezone != "" {
+		env = append(env, "TZ="+opts.Timezone)
+	}
+	if !opts.FrozenTime.IsZero() {
+		env = append(env, "GAETEST_FROZEN_TIME="+opts.FrozenTime.Format(time.RFC3339Nano))
+	}
+	if opts.ClockOffset != 0 {
+		env = append(env, "GAETEST_CLOCK_OFFSET="+opts.ClockOffset.String())
+	}
+	return env
+}
+
+// localeEnv returns the environment variables setting locale, if any.
+func localeEnv(locale string) []string {
+	if locale == "" {
 		return nil
 	}
+	return []string{"LANG=" + locale, "LC_ALL=" + locale}
+}
+
+// proxyAddr returns the address the proxy listens on.
+func (sv *Server) proxyAddr() string {
+	return net.JoinHostPort(sv.opts.Host, strconv.Itoa(sv.opts.ProxyPort))
+}
+
+// goCache returns the build cache directory to use given Options.GoCache.
+func goCache(dir string) string {
+	if dir == "" {
+		dir = os.Getenv("GOCACHE")
+	}
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "gaetest-gocache")
+	}
+	return dir
+}
+
+// childEnv returns the environment of the dev_appserver.py process.
+func (sv *Server) childEnv() []string {
+	env := childProxyEnv(os.Environ(), sv.opts.ProxyEnv, sv.opts.Host)
+	if sv.opts.User != nil {
+		env = append(env, sv.opts.User.env()...)
+	}
+	return append(env, sv.env...)
+}
+
+// processID returns the process (group) of dev_appserver.py, or 0. Other
+// goroutines, e.g. the one of Options.SuiteDeadline, read it with processID
+// while the server starts.
+func (sv *Server) processID() int {
+	sv.stateMu.Lock()
+	defer sv.stateMu.Unlock()
+	return sv.pid
+}
+
+func (sv *Server) setProcessID(pid int) {
+	sv.stateMu.Lock()
+	defer sv.stateMu.Unlock()
+	sv.pid = pid
+}
+
+func (sv *Server) kill() error {
+	// kill all processes in the same group
+	err := processGroup(sv.pid).kill()
+	if err != nil && sv.opts.Debug {
+		log.Printf("kill: got %v, expected nil", err)
+	}
+	sv.removePidfile()
+	if err != nil {
+		return fmt.Errorf("unable to kill child process: %v", err)
+	}
+	return nil
+}
+
+// Close kills the child dev_appserver process, releasing its resources. It
+// returns all the errors it ran into, as a single error. Closing a server that
+// is already stopped, or being stopped by another call, does nothing. Closing a
+// server that is starting makes the startup fail.
+func (sv *Server) Close() error {
+	for {
+		err := sv.transition(StateStopping)
+		if err == nil {
+			break
+		}
+		if !sv.closing() {
+			return err
+		}
+		if !sv.isRestarting() {
+			return nil
+		}
+		// The restart of the control API stops the server too, but starts
+		// it again: wait for it, then close what it started.
+		sv.startMu.Lock()
+		sv.startMu.Unlock()
+	}
+	defer sv.transition(StateStopped)
+	// Wait for the startup in progress, if any, to notice and clean up.
+	sv.startMu.Lock()
+	sv.startMu.Unlock()
+	return sv.shutdown().err()
+}
+
+// shutdown stops dev_appserver.py and releases the resources of the server,
+// returning the errors it ran into. It is called in StateStopping.
+func (sv *Server) shutdown() multiError {
+	if sv.stopWatch != nil {
+		sv.stopWatch()
+	}
+	var errs multiError
+	if sv.proxy != nil && sv.opts.DrainTimeout > 0 {
+		if err := sv.proxy.drain(sv.opts.DrainTimeout); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if sv.pid != 0 && !sv.detached {
+		errs = append(errs, sv.stop()...)
+		if sv.opts.VerifyPortsReleased {
+			if err := waitPortsReleased(sv.serverAddrs(), portReleaseTimeout); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return append(errs, sv.cleanup()...)
+}
 
+// stop asks dev_appserver.py to quit, killing it if it does not, and runs the
+// checks made at shutdown.
+func (sv *Server) stop() multiError {
+	var errs multiError
 	errc := make(chan error, 1)
 
 	if sv.opts.Debug {
-		log.Printf("attempting to stop %s", sv.child.Path)
+		log.Printf("attempting to stop dev_appserver.py (pid %d)", sv.pid)
 	}
 
+	// The process is gone, or killed, once stop returns: a later run must
+	// not wait for it.
+	wait := sv.wait
+	defer func() {
+		sv.setProcessID(0)
+		sv.wait = nil
+	}()
 	go func() {
-		errc <- sv.child.Wait()
+		err := wait()
+		sv.removePidfile()
+		errc <- err
 	}()
 
 	if sv.opts.Debug {
 		log.Printf("calling /quit handler on the admin server")
 	}
-	res, err := http.Get(sv.AdminURL + "/quit")
+	res, err := sv.httpClient().Get(sv.AdminURL + "/quit")
 	if err != nil {
-		sv.kill()
-		return fmt.Errorf("unable to call /quit handler: %v", err)
+		errs = append(errs, fmt.Errorf("unable to call /quit handler: %v", err))
+		if err := sv.kill(); err != nil {
+			errs = append(errs, err)
+		}
+		return errs
 	}
 	res.Body.Close()
 
 	select {
 	case <-time.After(time.Duration(sv.opts.Timeout) * time.Second):
-		sv.kill()
-		return errors.New("timeout killing child process")
+		errs = append(errs, errors.New("timeout killing child process"))
+		if err := sv.kill(); err != nil {
+			errs = append(errs, err)
+		}
+		return errs
 	case err := <-errc:
-		return err
+		if err != nil {
+			errs = append(errs, err)
+		}
 	}
-	return nil
+	if sv.indexes != nil {
+		if err := sv.indexes.finish(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := sv.logs.failures(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := sv.logs.crashLoop(); err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// multiError holds the errors of an operation that carries on after failing.
+type multiError []error
+
+func (m multiError) Error() string {
+	if len(m) == 1 {
+		return m[0].Error()
+	}
+	msgs := make([]string, len(m))
+	for i, err := range m {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d errors: %s", len(m), strings.Join(msgs, "; "))
+}
+
+// err returns m as an error, or nil if it holds no errors.
+func (m multiError) err() error {
+	if len(m) == 0 {
+		return nil
+	}
+	return m
+}
+
+// RandomSeed returns the seed passed to the app, see Options.AppRandomSeed.
+func (sv *Server) RandomSeed() int64 {
+	return sv.seed
 }
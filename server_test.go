@@ -6,8 +6,11 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -20,34 +23,259 @@ INFO     2016-10-02 21:48:16,905 admin_server.py:116] Starting admin server at:
 `
 
 func TestGetURLsOK(t *testing.T) {
-	api, module, admin, err := getURLs(bytes.NewBufferString(output), time.Second)
+	ep, err := getURLs(bytes.NewBufferString(output), time.Second, &Options{})
 	if err != nil {
 		t.Fatalf("got error %q", err)
 	}
-	if expect := "http://localhost:36415"; api != expect {
-		t.Fatalf("got %q, but expect %q", api, expect)
+	if expect := "http://localhost:36415"; ep.api != expect {
+		t.Fatalf("got %q, but expect %q", ep.api, expect)
 	}
-	if expect := "http://localhost:8080"; module != expect {
-		t.Fatalf("got %q, but expect %q", module, expect)
+	if expect := "http://localhost:8080"; ep.module != expect {
+		t.Fatalf("got %q, but expect %q", ep.module, expect)
 	}
-	if expect := "http://localhost:8000"; admin != expect {
-		t.Fatalf("got %q, but expect %q", admin, expect)
+	if expect := "http://localhost:8000"; ep.admin != expect {
+		t.Fatalf("got %q, but expect %q", ep.admin, expect)
+	}
+}
+
+const instanceOutput = output + `INFO     2016-10-02 21:48:18,110 instance.py:294] Instance PID: 4242
+`
+
+func TestGetURLsWaitForInstances(t *testing.T) {
+	opts := &Options{WaitForInstances: true}
+	ep, err := getURLs(bytes.NewBufferString(instanceOutput), time.Second, opts)
+	if err != nil {
+		t.Fatalf("got error %q", err)
+	}
+	if expect := "http://localhost:8080"; ep.module != expect {
+		t.Fatalf("got %q, but expect %q", ep.module, expect)
+	}
+
+	_, err = getURLs(bytes.NewBufferString(output), time.Second, opts)
+	expect := errors.New("only 0 of 1 module instances started")
+	if err == nil || err.Error() != expect.Error() {
+		t.Fatalf("got %#v, but expect %#v", err, expect)
+	}
+}
+
+const multiOutput = `
+INFO     2016-10-02 21:48:16,776 api_server.py:205] Starting API server at: http://localhost:36415
+INFO     2016-10-02 21:48:16,904 dispatcher.py:197] Starting module "default" running at: http://localhost:8080
+INFO     2016-10-02 21:48:16,904 dispatcher.py:197] Starting module "worker" running at: http://localhost:8081
+INFO     2016-10-02 21:48:16,905 admin_server.py:116] Starting admin server at: http://localhost:8000
+`
+
+func TestGetURLsExpectServices(t *testing.T) {
+	opts := &Options{ExpectServices: []string{"default", "worker"}}
+	ep, err := getURLs(bytes.NewBufferString(multiOutput), time.Second, opts)
+	if err != nil {
+		t.Fatalf("got error %q", err)
+	}
+	if expect := "http://localhost:8081"; ep.services["worker"] != expect {
+		t.Fatalf("got %q, but expect %q", ep.services["worker"], expect)
+	}
+
+	opts.ExpectServices = []string{"default", "frontend", "backend"}
+	_, err = getURLs(bytes.NewBufferString(multiOutput), time.Second, opts)
+	expect := errors.New("expected services not started: frontend, backend")
+	if err == nil || err.Error() != expect.Error() {
+		t.Fatalf("got %#v, but expect %#v", err, expect)
 	}
 }
 
 func TestTimeout(t *testing.T) {
 	pr, _ := io.Pipe()
-	_, _, _, err := getURLs(pr, time.Second)
+	_, err := getURLs(pr, time.Second, &Options{})
 	expect := fmt.Errorf("timeout starting child process")
 	if err.Error() != expect.Error() {
 		t.Fatalf("got %#v, but expect %#v", err, expect)
 	}
 }
 
+func TestWaitReady(t *testing.T) {
+	polls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		polls++
+		switch {
+		case r.URL.Path == "/":
+			http.NotFound(w, r)
+		case polls < 3:
+			http.Error(w, "warming up", http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	if err := waitReady(http.DefaultClient, ts.URL+"/_ah/health", true, 5*time.Second); err != nil {
+		t.Fatalf("waitReady returned %v, expected nil", err)
+	}
+	if polls != 3 {
+		t.Fatalf("got %d polls, but expect 3", polls)
+	}
+	if err := waitReady(http.DefaultClient, ts.URL, false, time.Second); err != nil {
+		t.Fatalf("waitReady returned %v, expected nil", err)
+	}
+	expect := "status 404 Not Found"
+	if err := waitReady(http.DefaultClient, ts.URL, true, 200*time.Millisecond); err == nil || err.Error() != expect {
+		t.Fatalf("got %v, but expect %q", err, expect)
+	}
+}
+
+// countingTransport counts the requests it sends.
+type countingTransport struct {
+	requests int
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.requests++
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestTransport(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	transport := &countingTransport{}
+	sv := &Server{APIURL: ts.URL, opts: withDefaults(&Options{Transport: transport})}
+	sv.admin = &admin{url: ts.URL, client: sv.httpClient()}
+
+	if _, err := sv.callAPI("memcache", "FlushAll", nil); err != nil {
+		t.Fatalf("callAPI returned %v, expected nil", err)
+	}
+	if _, err := sv.admin.get(adminMemcachePath, nil); err != nil {
+		t.Fatalf("get returned %v, expected nil", err)
+	}
+	if err := waitReady(sv.httpClient(), ts.URL, true, time.Second); err != nil {
+		t.Fatalf("waitReady returned %v, expected nil", err)
+	}
+	if transport.requests != 3 {
+		t.Fatalf("got %d requests, but expect 3", transport.requests)
+	}
+}
+
+func TestCloseErrors(t *testing.T) {
+	sv := &Server{opts: withDefaults(nil)}
+	sv.cleanups = []func() error{
+		func() error { return errors.New("first") },
+		func() error { return nil },
+		func() error { return errors.New("second") },
+	}
+	expect := "2 errors: second; first"
+	if err := sv.Close(); err == nil || err.Error() != expect {
+		t.Fatalf("got %v, but expect %q", err, expect)
+	}
+	if err := sv.Close(); err != nil {
+		t.Fatalf("Got %v, expected nil", err)
+	}
+}
+
+func TestParallel(t *testing.T) {
+	// Each function waits for the other: they only return if run at once.
+	a, b := make(chan bool), make(chan bool)
+	errs := parallel(func() error {
+		close(a)
+		<-b
+		return errors.New("first")
+	}, func() error {
+		close(b)
+		<-a
+		return nil
+	})
+	if len(errs) != 2 || errs[0] == nil || errs[0].Error() != "first" || errs[1] != nil {
+		t.Fatalf("got %v, but expect the errors in order", errs)
+	}
+}
+
+func TestStartupExpectServices(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gaetest")
+	if err != nil {
+		t.Fatalf("TempDir returned %v, expected nil", err)
+	}
+	defer os.RemoveAll(dir)
+	sv, err := New(dir, &Options{
+		DevAppServer:   fakeDevAppServer(t, dir),
+		RuntimeDir:     dir,
+		StoragePath:    dir,
+		ReapOrphans:    true,
+		Timeout:        10,
+		ExpectServices: []string{"default", "worker"},
+		Externals:      map[string]http.Handler{"billing": http.NotFoundHandler()},
+	})
+	if err != nil {
+		t.Fatalf("New returned %v, expected nil", err)
+	}
+	defer sv.Close()
+	if len(sv.services) != 2 || sv.services["worker"] == "" {
+		t.Fatalf("got services %v, but expect default and worker", sv.services)
+	}
+	if sv.ExternalURL("billing") == "" {
+		t.Fatalf("got no URL for the billing external, but expect it started before the app")
+	}
+}
+
+func TestPrepareStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gaetest")
+	if err != nil {
+		t.Fatalf("Got %v, expected nil", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "data")
+
+	sv := &Server{opts: withDefaults(&Options{StoragePath: path, PreserveData: true})}
+	if err := sv.prepareStorage(); err != nil {
+		t.Fatalf("prepareStorage returned %v, expected nil", err)
+	}
+	if sv.storage != path {
+		t.Fatalf("got %q, but expect %q", sv.storage, path)
+	}
+	if err := sv.cleanup().err(); err != nil {
+		t.Fatalf("cleanup returned %v, expected nil", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("got %v, but expect the storage to be kept", err)
+	}
+
+	sv = &Server{opts: withDefaults(&Options{PreserveData: true})}
+	if err := sv.prepareStorage(); err == nil {
+		t.Fatal("prepareStorage returned nil without StoragePath, expected an error")
+	}
+}
+
+func TestGoCache(t *testing.T) {
+	defer os.Setenv("GOCACHE", os.Getenv("GOCACHE"))
+	os.Setenv("GOCACHE", "")
+	if dir, expect := goCache(""), filepath.Join(os.TempDir(), "gaetest-gocache"); dir != expect {
+		t.Fatalf("got %q, but expect %q", dir, expect)
+	}
+	if dir := goCache("/tmp/cache"); dir != "/tmp/cache" {
+		t.Fatalf("got %q, but expect %q", dir, "/tmp/cache")
+	}
+}
+
+func TestClockEnv(t *testing.T) {
+	env := clockEnv(&Options{
+		Timezone:    "Asia/Tokyo",
+		FrozenTime:  time.Date(2016, 10, 2, 21, 48, 0, 0, time.UTC),
+		ClockOffset: 90 * time.Minute,
+	})
+	expect := []string{"TZ=Asia/Tokyo", "GAETEST_FROZEN_TIME=2016-10-02T21:48:00Z", "GAETEST_CLOCK_OFFSET=1h30m0s"}
+	if !reflect.DeepEqual(env, expect) {
+		t.Fatalf("got %q, but expect %q", env, expect)
+	}
+}
+
+func TestLocaleEnv(t *testing.T) {
+	if env := localeEnv(""); env != nil {
+		t.Fatalf("got %q, but expect no variables", env)
+	}
+	expect := []string{"LANG=de_DE.UTF-8", "LC_ALL=de_DE.UTF-8"}
+	if env := localeEnv("de_DE.UTF-8"); !reflect.DeepEqual(env, expect) {
+		t.Fatalf("got %q, but expect %q", env, expect)
+	}
+}
+
 func TestScannerErr(t *testing.T) {
 	pr, _ := io.Pipe()
 	pr.CloseWithError(errors.New("scanner error"))
-	_, _, _, err := getURLs(pr, 500*time.Millisecond)
+	_, err := getURLs(pr, 500*time.Millisecond, &Options{})
 	expect := errors.New("error reading server stderr: io: read/write on closed pipe")
 	if err.Error() != expect.Error() {
 		t.Fatalf("got %#v, but expect %#v", err, expect)
@@ -0,0 +1,114 @@
+package gaetest
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// smokeLogLines is the number of lines of output logged when a smoke check
+// fails.
+const smokeLogLines = 20
+
+// SmokeCheck is a quick check of the running app, for Server.Smoke.
+type SmokeCheck struct {
+	Name  string
+	Check func(sv *Server, client *http.Client) error
+}
+
+// smokeGet requests path from the default module and fails on server errors.
+func smokeGet(client *http.Client, sv *Server, path string) (*http.Response, error) {
+	res, err := client.Get(sv.ModuleURL + path)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+	if res.StatusCode >= 500 {
+		return res, fmt.Errorf("GET %s: got status %d", path, res.StatusCode)
+	}
+	return res, nil
+}
+
+// RootResponds checks that / is served without a server error.
+func RootResponds() SmokeCheck {
+	return SmokeCheck{Name: "root responds", Check: func(sv *Server, client *http.Client) error {
+		_, err := smokeGet(client, sv, "/")
+		return err
+	}}
+}
+
+// HealthOK checks that /_ah/health answers 200 OK.
+func HealthOK() SmokeCheck {
+	return SmokeCheck{Name: "health ok", Check: func(sv *Server, client *http.Client) error {
+		res, err := smokeGet(client, sv, "/_ah/health")
+		if err != nil {
+			return err
+		}
+		if res.StatusCode != http.StatusOK {
+			return fmt.Errorf("GET /_ah/health: got status %d", res.StatusCode)
+		}
+		return nil
+	}}
+}
+
+// NoServerErrors checks that none of paths is answered with a 5xx status.
+func NoServerErrors(paths ...string) SmokeCheck {
+	return SmokeCheck{Name: "no server errors", Check: func(sv *Server, client *http.Client) error {
+		var msgs []string
+		for _, path := range paths {
+			if _, err := smokeGet(client, sv, path); err != nil {
+				msgs = append(msgs, err.Error())
+			}
+		}
+		if len(msgs) > 0 {
+			return fmt.Errorf("%s", strings.Join(msgs, "; "))
+		}
+		return nil
+	}}
+}
+
+// WarmupUnder checks that the /_ah/warmup request App Engine sends to new
+// instances completes without a server error within d.
+func WarmupUnder(d time.Duration) SmokeCheck {
+	return SmokeCheck{Name: "warmup", Check: func(sv *Server, client *http.Client) error {
+		req := sv.newRequest("GET", "/_ah/warmup", nil)
+		req.Header.Set(fakeIsAdminHeader, "1")
+		start := time.Now()
+		res, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+		if took := time.Since(start); took > d {
+			return fmt.Errorf("warmup took %v, more than %v", took, d)
+		}
+		if res.StatusCode >= 500 {
+			return fmt.Errorf("GET /_ah/warmup: got status %d", res.StatusCode)
+		}
+		return nil
+	}}
+}
+
+// Smoke runs checks against the app with the client returned by Client, as a
+// first gate before heavier tests. Every failed check is reported to t, along
+// with the last lines of dev_appserver.py output.
+func (sv *Server) Smoke(t testing.TB, checks []SmokeCheck) {
+	t.Helper()
+	client := sv.Client()
+	failed := false
+	for _, c := range checks {
+		if err := c.Check(sv, client); err != nil {
+			t.Errorf("smoke check %q failed: %v", c.Name, err)
+			failed = true
+		}
+	}
+	if failed {
+		lines := sv.logs.snapshot()
+		if len(lines) > smokeLogLines {
+			lines = lines[len(lines)-smokeLogLines:]
+		}
+		t.Logf("last lines of dev_appserver.py output:\n%s", strings.Join(lines, "\n"))
+	}
+}
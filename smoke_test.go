@@ -0,0 +1,41 @@
+package gaetest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSmokeChecks(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/_ah/health":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case "/broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/_ah/warmup":
+			if r.Header.Get(fakeIsAdminHeader) != "1" {
+				w.WriteHeader(http.StatusForbidden)
+			}
+		}
+	}))
+	defer ts.Close()
+	sv := &Server{ModuleURL: ts.URL, opts: withDefaults(nil)}
+	client := sv.Client()
+
+	if err := RootResponds().Check(sv, client); err != nil {
+		t.Fatalf("Got %v, expected nil", err)
+	}
+	if err := WarmupUnder(5*time.Second).Check(sv, client); err != nil {
+		t.Fatalf("Got %v, expected nil", err)
+	}
+	if err := HealthOK().Check(sv, client); err == nil || !strings.Contains(err.Error(), "503") {
+		t.Fatalf("got %v, but expect status 503 to be reported", err)
+	}
+	expect := "GET /broken: got status 500"
+	if err := NoServerErrors("/", "/broken").Check(sv, client); err == nil || err.Error() != expect {
+		t.Fatalf("got %v, but expect %q", err, expect)
+	}
+}
@@ -0,0 +1,249 @@
+package gaetest
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// remoteSocketPermissionDenied is the PERMISSION_DENIED code of
+// RemoteSocketServiceError.
+const remoteSocketPermissionDenied = 5
+
+// remoteAddrFields are the fields of the requests of the remote_socket service
+// holding the AddressPort they connect to, by method.
+var remoteAddrFields = map[string]int{"Connect": 2, "CreateSocket": 6}
+
+// socketRedirect is an entry of Options.SocketRedirects.
+type socketRedirect struct {
+	from string // host or host:port
+	ip   net.IP
+	port int
+}
+
+// newSocketRedirects parses Options.SocketRedirects, resolving the targets,
+// and orders the entries naming a port first so that they take precedence.
+func newSocketRedirects(redirects map[string]string) ([]socketRedirect, error) {
+	var rs []socketRedirect
+	for from, to := range redirects {
+		host, port, err := net.SplitHostPort(to)
+		if err != nil {
+			return nil, fmt.Errorf("invalid socket redirect target %q: %v", to, err)
+		}
+		r := socketRedirect{from: from}
+		if r.port, err = strconv.Atoi(port); err != nil {
+			return nil, fmt.Errorf("invalid port in socket redirect target %q", to)
+		}
+		if r.ip = net.ParseIP(host); r.ip == nil {
+			ips, err := net.LookupIP(host)
+			if err != nil {
+				return nil, err
+			}
+			r.ip = ips[0]
+		}
+		if ip4 := r.ip.To4(); ip4 != nil {
+			r.ip = ip4
+		}
+		rs = append(rs, r)
+	}
+	sort.Slice(rs, func(i, j int) bool {
+		_, _, erri := net.SplitHostPort(rs[i].from)
+		_, _, errj := net.SplitHostPort(rs[j].from)
+		if (erri == nil) != (errj == nil) {
+			return erri == nil
+		}
+		return rs[i].from < rs[j].from
+	})
+	return rs, nil
+}
+
+// socketAddr decodes the AddressPort data: the port, the packed address and
+// the host name the app resolved, if any.
+func socketAddr(data []byte) (host, ip, port string) {
+	fields, _ := parsePB(data)
+	for _, f := range fields {
+		switch f.num {
+		case 1:
+			port = strconv.FormatUint(f.varint, 10)
+		case 2:
+			ip = net.IP(f.data).String()
+		case 3:
+			host = string(f.data)
+		}
+	}
+	return host, ip, port
+}
+
+// redirectSocket returns the request of the remote_socket call c with the
+// address it connects to replaced by the target of Options.SocketRedirects,
+// and whether an entry applies.
+func (p *apiProxy) redirectSocket(c *APICall) ([]byte, bool) {
+	num, ok := remoteAddrFields[c.Method]
+	if len(p.redirects) == 0 || c.Service != "remote_socket" || !ok {
+		return nil, false
+	}
+	fields, _ := parsePB(c.Request)
+	for _, f := range fields {
+		if f.num != num {
+			continue
+		}
+		host, ip, port := socketAddr(f.data)
+		for _, r := range p.redirects {
+			if !matchAddr(r.from, host, ip, port) {
+				continue
+			}
+			var addr pbMessage
+			addr.int64(1, int64(r.port))
+			addr.bytes(2, r.ip)
+			req, err := replaceField(c.Request, num, addr.buf.Bytes())
+			if err != nil {
+				return nil, false
+			}
+			return req, true
+		}
+	}
+	return nil, false
+}
+
+// resolveRedirect returns the ResolveReply to the remote_socket.Resolve call c
+// if Options.SocketRedirects redirects the name it looks up, nil otherwise.
+func (p *apiProxy) resolveRedirect(c *APICall) []byte {
+	if len(p.redirects) == 0 || c.Service != "remote_socket" || c.Method != "Resolve" {
+		return nil
+	}
+	var name string
+	fields, _ := parsePB(c.Request)
+	for _, f := range fields {
+		if f.num == 1 {
+			name = string(f.data)
+		}
+	}
+	// The entry of the host takes precedence over those of its ports, which
+	// come first.
+	var ip net.IP
+	for _, r := range p.redirects {
+		h, _, err := net.SplitHostPort(r.from)
+		if err != nil {
+			h = r.from
+		}
+		if name != "" && h == name && (ip == nil || err != nil) {
+			ip = r.ip
+		}
+	}
+	if ip == nil {
+		return nil
+	}
+	var res pbMessage
+	res.bytes(2, ip)
+	return res.buf.Bytes()
+}
+
+// deniedSocket returns the address the remote_socket call c connects to if
+// Options.SocketAllowedHosts does not allow it, "" otherwise.
+func (p *apiProxy) deniedSocket(c *APICall) string {
+	num, ok := remoteAddrFields[c.Method]
+	if len(p.allowedHosts) == 0 || c.Service != "remote_socket" || !ok {
+		return ""
+	}
+	fields, _ := parsePB(c.Request)
+	for _, f := range fields {
+		if f.num != num {
+			continue
+		}
+		host, ip, port := socketAddr(f.data)
+		if !hostAllowed(p.allowedHosts, host, ip, port) {
+			if host == "" {
+				host = ip
+			}
+			return net.JoinHostPort(host, port)
+		}
+	}
+	return ""
+}
+
+// hostAllowed reports whether allowed, entries of Options.SocketAllowedHosts,
+// lets the app connect to port of host, the name the app resolved if any, or
+// ip.
+func hostAllowed(allowed []string, host, ip, port string) bool {
+	for _, a := range allowed {
+		if matchAddr(a, host, ip, port) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchAddr reports whether pattern, a host or host:port, names port of host
+// or ip.
+func matchAddr(pattern, host, ip, port string) bool {
+	h, p, err := net.SplitHostPort(pattern)
+	if err != nil {
+		h, p = pattern, ""
+	}
+	return (h == host || h == ip) && (p == "" || p == port)
+}
+
+// EchoServer is a TCP server writing back everything it receives. The sockets
+// stub of dev_appserver.py opens real connections on behalf of the app, so an
+// EchoServer on the loopback interface is a convenient peer for apps using the
+// App Engine Sockets API.
+type EchoServer struct {
+	// Addr is the host:port the server listens on.
+	Addr string
+
+	l  net.Listener
+	wg sync.WaitGroup
+
+	mu    sync.Mutex
+	conns map[net.Conn]bool
+}
+
+// NewEchoServer starts an EchoServer on a random port of the loopback
+// interface.
+func NewEchoServer() (*EchoServer, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &EchoServer{Addr: l.Addr().String(), l: l, conns: make(map[net.Conn]bool)}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+func (s *EchoServer) serve() {
+	defer s.wg.Done()
+	for {
+		c, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[c] = true
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			io.Copy(c, c)
+			c.Close()
+			s.mu.Lock()
+			delete(s.conns, c)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// Close stops the server and closes all open connections.
+func (s *EchoServer) Close() error {
+	err := s.l.Close()
+	s.mu.Lock()
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
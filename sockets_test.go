@@ -0,0 +1,163 @@
+package gaetest
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEchoServer(t *testing.T) {
+	s, err := NewEchoServer()
+	if err != nil {
+		t.Fatalf("NewEchoServer returned %v, expected nil", err)
+	}
+	defer s.Close()
+
+	c, err := net.Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatalf("Dial returned %v, expected nil", err)
+	}
+	defer c.Close()
+	c.Write([]byte("ping\n"))
+	line, err := bufio.NewReader(c).ReadString('\n')
+	if err != nil {
+		t.Fatalf("ReadString returned %v, expected nil", err)
+	}
+	if line != "ping\n" {
+		t.Fatalf("got %q, but expect %q", line, "ping\n")
+	}
+}
+
+func TestSocketAllowedHosts(t *testing.T) {
+	var calls []string
+	ts := newModulesStub(&calls)
+	defer ts.Close()
+	p, err := newAPIProxy("127.0.0.1")
+	if err != nil {
+		t.Fatalf("newAPIProxy returned %v, expected nil", err)
+	}
+	defer p.Close()
+	p.target = ts.URL
+	p.allowedHosts = []string{"127.0.0.1:7", "db.example.com"}
+	sv := &Server{APIURL: p.srv.URL + "/rpc_http", api: p}
+
+	connect := func(host string, ip net.IP, port int64) error {
+		var addr, req pbMessage
+		addr.int64(1, port)
+		addr.bytes(2, ip)
+		if host != "" {
+			addr.string(3, host)
+		}
+		req.string(1, "socket")
+		req.bytes(2, addr.buf.Bytes())
+		_, err := sv.callAPI("remote_socket", "Connect", req.buf.Bytes())
+		return err
+	}
+	for _, test := range []struct {
+		host    string
+		ip      net.IP
+		port    int64
+		allowed bool
+	}{
+		{"", net.IPv4(127, 0, 0, 1).To4(), 7, true},
+		{"", net.IPv4(127, 0, 0, 1).To4(), 8, false},
+		{"db.example.com", net.IPv4(10, 0, 0, 1).To4(), 5432, true},
+		{"mail.example.com", net.IPv4(10, 0, 0, 2).To4(), 25, false},
+	} {
+		// The stub answers the allowed calls with an error of its own.
+		err := connect(test.host, test.ip, test.port)
+		apiErr, denied := err.(*APIError)
+		if denied = denied && apiErr.Code == remoteSocketPermissionDenied; denied == test.allowed {
+			t.Fatalf("%s %v:%d: got %v, but expect allowed to be %t", test.host, test.ip, test.port, err, test.allowed)
+		}
+	}
+	if len(calls) != 2 {
+		t.Fatalf("got %d calls, but expect only the allowed ones to reach the stub", len(calls))
+	}
+}
+
+func TestSocketRedirects(t *testing.T) {
+	var forwarded []byte
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		fields, _ := parsePB(body)
+		for _, f := range fields {
+			if f.num == 4 {
+				forwarded = f.data
+			}
+		}
+		var res pbMessage
+		res.bytes(1, nil)
+		w.Write(res.buf.Bytes())
+	}))
+	defer ts.Close()
+	p, err := newAPIProxy("127.0.0.1")
+	if err != nil {
+		t.Fatalf("newAPIProxy returned %v, expected nil", err)
+	}
+	defer p.Close()
+	p.target = ts.URL
+	p.allowedHosts = []string{"127.0.0.1:7"}
+	if p.redirects, err = newSocketRedirects(map[string]string{
+		"db.example.com":      "127.0.0.1:5432",
+		"db.example.com:6379": "127.0.0.2:6380",
+	}); err != nil {
+		t.Fatalf("newSocketRedirects returned %v, expected nil", err)
+	}
+	sv := &Server{APIURL: p.srv.URL + "/rpc_http", api: p}
+
+	var resolve pbMessage
+	resolve.string(1, "db.example.com")
+	res, err := sv.callAPI("remote_socket", "Resolve", resolve.buf.Bytes())
+	if err != nil {
+		t.Fatalf("Resolve returned %v, expected nil", err)
+	}
+	if fields, _ := parsePB(res); len(fields) != 1 || fields[0].num != 2 || net.IP(fields[0].data).String() != "127.0.0.1" {
+		t.Fatalf("got ResolveReply %q, but expect the packed address 127.0.0.1", res)
+	}
+	if forwarded != nil {
+		t.Fatalf("got Resolve forwarded, but expect the proxy to answer it")
+	}
+
+	for _, test := range []struct {
+		port   int64
+		expect string
+	}{
+		{5432, "127.0.0.1:5432"},
+		{6379, "127.0.0.2:6380"},
+	} {
+		var addr, req pbMessage
+		addr.int64(1, test.port)
+		addr.bytes(2, net.IPv4(127, 0, 0, 1).To4())
+		addr.string(3, "db.example.com")
+		req.string(1, "socket")
+		req.bytes(2, addr.buf.Bytes())
+		req.int64(3, 30)
+		// The redirect is allowed although SocketAllowedHosts does not list it.
+		if _, err := sv.callAPI("remote_socket", "Connect", req.buf.Bytes()); err != nil {
+			t.Fatalf("Connect returned %v, expected nil", err)
+		}
+		fields, _ := parsePB(forwarded)
+		if len(fields) != 3 || string(fields[0].data) != "socket" || fields[2].varint != 30 {
+			t.Fatalf("got forwarded request %q, but expect the other fields to be kept", forwarded)
+		}
+		if host, ip, port := socketAddr(fields[1].data); host != "" || net.JoinHostPort(ip, port) != test.expect {
+			t.Fatalf("got %s %s:%s, but expect %s", host, ip, port, test.expect)
+		}
+	}
+	calls := p.calls()
+	if len(calls) != 3 || !calls[1].Injected {
+		t.Fatalf("got %+v, but expect 3 injected calls", calls)
+	}
+	if fields, _ := parsePB(calls[1].Request); len(fields) != 3 || !bytes.Contains(fields[1].data, []byte("db.example.com")) {
+		t.Fatalf("got %q recorded, but expect the app's request", calls[1].Request)
+	}
+
+	if _, err := newSocketRedirects(map[string]string{"db.example.com": "127.0.0.1"}); err == nil {
+		t.Fatalf("newSocketRedirects of a target without port returned nil, expected an error")
+	}
+}
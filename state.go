@@ -0,0 +1,107 @@
+package gaetest
+
+import (
+	"errors"
+	"fmt"
+)
+
+// State is a stage in the lifecycle of a Server.
+type State int
+
+const (
+	// StateConfigured is the state of a server created with Options.Lazy
+	// that has not started yet.
+	StateConfigured State = iota
+	// StateStarting is the state while dev_appserver.py starts.
+	StateStarting
+	// StateReady is the state of a running server.
+	StateReady
+	// StateDegraded is the state of a running server whose app is crash
+	// looping, see Options.CrashLoopRestarts.
+	StateDegraded
+	// StateStopping is the state while Close runs.
+	StateStopping
+	// StateStopped is the state once Close returned.
+	StateStopped
+	// StateFailed is the state of a server that did not start.
+	StateFailed
+)
+
+var stateNames = []string{"configured", "starting", "ready", "degraded", "stopping", "stopped", "failed"}
+
+func (s State) String() string {
+	if s < 0 || int(s) >= len(stateNames) {
+		return fmt.Sprintf("State(%d)", int(s))
+	}
+	return stateNames[s]
+}
+
+// errClosedStarting is returned by a startup interrupted by Close.
+var errClosedStarting = errors.New("gaetest: server closed while starting")
+
+// transitions lists the states each state can move to. StateDegraded is not
+// stored but derived from StateReady by Status. A stopped server is only
+// configured again by the restart of the control API.
+var transitions = map[State][]State{
+	StateConfigured: {StateStarting, StateStopping},
+	StateStarting:   {StateReady, StateFailed, StateStopping},
+	StateReady:      {StateStopping},
+	StateFailed:     {StateStopping},
+	StateStopping:   {StateStopped},
+	StateStopped:    {StateConfigured},
+}
+
+// Status returns the current state of the server.
+func (sv *Server) Status() State {
+	sv.stateMu.Lock()
+	state := sv.state
+	sv.stateMu.Unlock()
+	if state == StateReady && sv.logs != nil && sv.CrashLoop() != nil {
+		return StateDegraded
+	}
+	return state
+}
+
+// transition moves the server to state to, failing if the current state does
+// not allow it.
+func (sv *Server) transition(to State) error {
+	sv.stateMu.Lock()
+	defer sv.stateMu.Unlock()
+	for _, s := range transitions[sv.state] {
+		if s == to {
+			sv.state = to
+			return nil
+		}
+	}
+	return fmt.Errorf("gaetest: server cannot go from %v to %v", sv.state, to)
+}
+
+// closing reports whether Close was called, e.g. while the server starts.
+func (sv *Server) closing() bool {
+	s := sv.Status()
+	return s == StateStopping || s == StateStopped
+}
+
+// isRestarting reports whether the control API restarts the server, which
+// goes through StateStopping without being closed.
+func (sv *Server) isRestarting() bool {
+	sv.stateMu.Lock()
+	defer sv.stateMu.Unlock()
+	return sv.restarting
+}
+
+// setRestarting records whether the control API restarts the server.
+func (sv *Server) setRestarting(restarting bool) {
+	sv.stateMu.Lock()
+	sv.restarting = restarting
+	sv.stateMu.Unlock()
+}
+
+// requireRunning returns an error naming op unless the server is ready or
+// degraded.
+func (sv *Server) requireRunning(op string) error {
+	if s := sv.Status(); s != StateReady && s != StateDegraded {
+		return fmt.Errorf("gaetest: cannot %s a server that is %v", op, s)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package gaetest
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestStatus(t *testing.T) {
+	b, err := newLogBuffer(nil)
+	if err != nil {
+		t.Fatalf("newLogBuffer returned %v, expected nil", err)
+	}
+	sv := &Server{opts: withDefaults(nil), logs: b}
+	if s := sv.Status(); s != StateConfigured {
+		t.Fatalf("got %v, but expect %v", s, StateConfigured)
+	}
+	expect := "gaetest: cannot reset a server that is configured"
+	if err := sv.reset(); err == nil || err.Error() != expect {
+		t.Fatalf("got %v, but expect %q", err, expect)
+	}
+	expect = "gaetest: server cannot go from configured to ready"
+	if err := sv.transition(StateReady); err == nil || err.Error() != expect {
+		t.Fatalf("got %v, but expect %q", err, expect)
+	}
+
+	for _, s := range []State{StateStarting, StateReady} {
+		if err := sv.transition(s); err != nil {
+			t.Fatalf("transition returned %v, expected nil", err)
+		}
+	}
+	b.crash = newCrashDetector(1, 0)
+	b.arm()
+	fmt.Fprint(b, "INFO     2016-10-02 21:48:18,000 instance.py:280] Instance PID: 2\n")
+	if s := sv.Status(); s != StateDegraded {
+		t.Fatalf("got %v, but expect %v", s, StateDegraded)
+	}
+
+	if err := sv.Close(); err != nil {
+		t.Fatalf("Close returned %v, expected nil", err)
+	}
+	if s := sv.Status(); s != StateStopped {
+		t.Fatalf("got %v, but expect %v", s, StateStopped)
+	}
+}
+
+func TestCloseStarting(t *testing.T) {
+	sv := &Server{opts: withDefaults(nil)}
+	if err := sv.transition(StateStarting); err != nil {
+		t.Fatalf("transition returned %v, expected nil", err)
+	}
+	sv.startMu.Lock()
+	closed := make(chan error)
+	go func() { closed <- sv.Close() }()
+	for sv.Status() != StateStopping {
+		time.Sleep(time.Millisecond)
+	}
+	if err := sv.Close(); err != nil {
+		t.Fatalf("concurrent Close returned %v, expected nil", err)
+	}
+	if err := sv.transition(StateReady); err == nil {
+		t.Fatalf("transition to %v succeeded while closing, expected an error", StateReady)
+	}
+	sv.startMu.Unlock()
+	if err := <-closed; err != nil {
+		t.Fatalf("Close returned %v, expected nil", err)
+	}
+	if s := sv.Status(); s != StateStopped {
+		t.Fatalf("got %v, but expect %v", s, StateStopped)
+	}
+}
@@ -0,0 +1,71 @@
+package gaetest
+
+import (
+	"net/http"
+	"regexp"
+	"testing"
+)
+
+// NamespaceHeader is the header carrying the namespace of the clients passed
+// by Server.Run. Apps opt in to the isolation by using it as the namespace of
+// the request, e.g. with appengine.Namespace.
+const NamespaceHeader = "X-Gaetest-Namespace"
+
+// Fixture is data loaded before a subtest started by Server.Run.
+type Fixture struct {
+	Name string
+	Load func(sv *Server) error
+}
+
+// Client is an HTTP client for the app, scoped to a namespace.
+type Client struct {
+	*http.Client
+	BaseURL   string
+	Namespace string
+}
+
+// URL returns the URL of path on the app.
+func (c *Client) URL(path string) string {
+	return c.BaseURL + path
+}
+
+// Namespace makes the client send ns in the NamespaceHeader header.
+func Namespace(ns string) ClientOption {
+	return func(o *clientOptions) {
+		o.header.Set(NamespaceHeader, ns)
+	}
+}
+
+// invalidNamespaceRE matches the characters not allowed in namespaces.
+var invalidNamespaceRE = regexp.MustCompile(`[^0-9A-Za-z._-]`)
+
+// namespaceFor derives a valid namespace from the name of a test.
+func namespaceFor(test string) string {
+	ns := invalidNamespaceRE.ReplaceAllString(test, "_")
+	if len(ns) > 100 {
+		ns = ns[len(ns)-100:]
+	}
+	return ns
+}
+
+// Run runs fn as the subtest name of t, like t.Run. The datastore and memcache
+// are reset as by Isolate, then the fixtures are loaded in order, and fn gets
+// a client whose namespace is derived from the name of the subtest. Run
+// reports whether the subtest succeeded.
+func (sv *Server) Run(t *testing.T, name string, fixtures []Fixture, fn func(t *testing.T, c *Client)) bool {
+	t.Helper()
+	return t.Run(name, func(t *testing.T) {
+		sv.Isolate(t)
+		for _, f := range fixtures {
+			if err := f.Load(sv); err != nil {
+				t.Fatalf("gaetest: unable to load fixture %s: %v", f.Name, err)
+			}
+		}
+		ns := namespaceFor(t.Name())
+		fn(t, &Client{
+			Client:    sv.Client(Namespace(ns)),
+			BaseURL:   sv.ModuleURL,
+			Namespace: ns,
+		})
+	})
+}
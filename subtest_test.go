@@ -0,0 +1,51 @@
+package gaetest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	var stored []storedEntity
+	ts := newDatastoreStub(&stored)
+	defer ts.Close()
+	app := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get(NamespaceHeader)))
+	}))
+	defer app.Close()
+	sv := &Server{APIURL: ts.URL, ModuleURL: app.URL, opts: withDefaults(nil), state: StateReady}
+	if _, err := sv.Factory("User").Create(); err != nil {
+		t.Fatalf("Create returned %v, expected nil", err)
+	}
+
+	var loaded []string
+	fixtures := []Fixture{
+		{Name: "users", Load: func(*Server) error { loaded = append(loaded, "users"); return nil }},
+		{Name: "posts", Load: func(*Server) error { loaded = append(loaded, "posts"); return nil }},
+	}
+	ok := sv.Run(t, "list posts", fixtures, func(t *testing.T, c *Client) {
+		if len(stored) != 0 || len(loaded) != 2 {
+			t.Fatalf("got %d entities and fixtures %q, but expect a reset then both fixtures", len(stored), loaded)
+		}
+		if _, err := sv.Factory("Post").Create(); err != nil {
+			t.Fatalf("Create returned %v, expected nil", err)
+		}
+		res, err := c.Get(c.URL("/"))
+		if err != nil {
+			t.Fatalf("Get returned %v, expected nil", err)
+		}
+		defer res.Body.Close()
+		body, _ := ioutil.ReadAll(res.Body)
+		if expect := "TestRun_list_posts"; string(body) != expect || c.Namespace != expect {
+			t.Fatalf("got %q, but expect %q", body, expect)
+		}
+	})
+	if !ok {
+		t.Fatalf("Run returned false, expected true")
+	}
+	if len(stored) != 0 {
+		t.Fatalf("got %d entities, but expect a reset after the subtest", len(stored))
+	}
+}
@@ -0,0 +1,257 @@
+package gaetest
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"sync"
+)
+
+const adminTaskQueuePath = "/taskqueue"
+
+// The task queue page links to a page per queue, which renders a row for
+// every task with a form to run or delete it. The tasks themselves are listed
+// through the taskqueue service of the API server, which returns their
+// payloads unaltered, while the page only renders them as text.
+var queueLinkRE = regexp.MustCompile(`href="/taskqueue/queue/([^"?#]+)"`)
+
+// maxQueryTasks is the number of tasks listed by a call to
+// taskqueue.QueryTasks.
+const maxQueryTasks = 100
+
+// taskETALayout is the layout of Task.ETA, in UTC.
+const taskETALayout = "2006-01-02 15:04:05"
+
+// Values of TaskQueueQueryTasksResponse.Task.RequestMethod.
+var taskMethods = map[uint64]string{1: "GET", 2: "POST", 3: "HEAD", 4: "PUT", 5: "DELETE"}
+
+// Task is a push task waiting in a queue of the dev server.
+type Task struct {
+	Queue   string
+	Name    string
+	Method  string
+	URL     string
+	ETA     string
+	Payload []byte
+}
+
+// capturedTasks is the list last returned by CapturedTasks, which DeliverTask
+// indexes.
+type capturedTasks struct {
+	mu    sync.Mutex
+	tasks []Task
+}
+
+// parseTasks decodes the tasks of queue in data, an encoded
+// TaskQueueQueryTasksResponse, along with their ETAs in microseconds.
+func parseTasks(queue string, data []byte) ([]Task, []int64, error) {
+	fields, err := parsePB(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	var tasks []Task
+	var etas []int64
+	for _, f := range fields {
+		if f.num != 1 {
+			continue
+		}
+		group, err := parsePB(f.data)
+		if err != nil {
+			return nil, nil, err
+		}
+		task := Task{Queue: queue}
+		var eta int64
+		for _, tf := range group {
+			switch tf.num {
+			case 2:
+				task.Name = string(tf.data)
+			case 3:
+				eta = int64(tf.varint)
+				task.ETA = usecTime(eta).UTC().Format(taskETALayout)
+			case 4:
+				task.URL = string(tf.data)
+			case 5:
+				task.Method = taskMethods[tf.varint]
+			case 11:
+				task.Payload = tf.data
+			}
+		}
+		tasks = append(tasks, task)
+		etas = append(etas, eta)
+	}
+	return tasks, etas, nil
+}
+
+// queues returns the names of the task queues, sorted.
+func (a *admin) queues() ([]string, error) {
+	page, err := a.get(adminTaskQueuePath, nil)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var names []string
+	for _, match := range queueLinkRE.FindAllStringSubmatch(page, -1) {
+		name, err := url.PathUnescape(match[1])
+		if err != nil || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// queueTasks returns the tasks waiting in queue, ordered by ETA and name.
+func (sv *Server) queueTasks(queue string) ([]Task, error) {
+	var tasks []Task
+	var startName string
+	var startETA int64
+	for {
+		var req pbMessage
+		req.string(2, queue)
+		if startName != "" {
+			req.string(3, startName)
+			req.int64(4, startETA)
+		}
+		req.int64(5, maxQueryTasks)
+		data, err := sv.callAPI("taskqueue", "QueryTasks", req.buf.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("queue %s: %v", queue, err)
+		}
+		page, etas, err := parseTasks(queue, data)
+		if err != nil {
+			return nil, fmt.Errorf("queue %s: %v", queue, err)
+		}
+		n := len(page)
+		// A page starts with the last task of the page before.
+		if startName != "" && n > 0 && page[0].Name == startName {
+			page, etas = page[1:], etas[1:]
+		}
+		tasks = append(tasks, page...)
+		if n < maxQueryTasks || len(page) == 0 {
+			return tasks, nil
+		}
+		startName, startETA = page[len(page)-1].Name, etas[len(etas)-1]
+	}
+}
+
+// runTask executes task immediately, removing it from its queue.
+func (a *admin) runTask(task Task) error {
+	return a.post(adminTaskQueuePath+"/queue/"+url.PathEscape(task.Queue), url.Values{
+		"action:runtask": {"Run"},
+		"task_name":      {task.Name},
+	})
+}
+
+// CapturedTasks returns the push tasks waiting in all queues, ordered by queue
+// name and then by ETA and name. Tasks only accumulate when
+// Options.CaptureTasks is set; otherwise the dev server delivers them on its
+// own.
+func (sv *Server) CapturedTasks() ([]Task, error) {
+	queues, err := sv.admin.queues()
+	if err != nil {
+		return nil, err
+	}
+	var tasks []Task
+	for _, queue := range queues {
+		qt, err := sv.queueTasks(queue)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, qt...)
+	}
+	sv.captured.mu.Lock()
+	sv.captured.tasks = tasks
+	sv.captured.mu.Unlock()
+	return append([]Task(nil), tasks...), nil
+}
+
+// DeliverTask makes the dev server execute the i-th task of the list last
+// returned by CapturedTasks, which it calls first if it was never called. The
+// task is run by name, so DeliverTask fails rather than run another task if
+// it is no longer waiting, e.g. because it was already delivered.
+func (sv *Server) DeliverTask(i int) error {
+	sv.captured.mu.Lock()
+	tasks := sv.captured.tasks
+	sv.captured.mu.Unlock()
+	if tasks == nil {
+		var err error
+		if tasks, err = sv.CapturedTasks(); err != nil {
+			return err
+		}
+	}
+	if i < 0 || i >= len(tasks) {
+		return fmt.Errorf("task %d out of range, %d tasks captured", i, len(tasks))
+	}
+	task := tasks[i]
+	waiting, err := sv.queueTasks(task.Queue)
+	if err != nil {
+		return err
+	}
+	for _, t := range waiting {
+		if t.Name == task.Name {
+			return sv.admin.runTask(task)
+		}
+	}
+	return fmt.Errorf("task %s is not waiting in queue %s", task.Name, task.Queue)
+}
+
+// purgeQueue deletes all the tasks of queue.
+func (a *admin) purgeQueue(queue string) error {
+	return a.post(adminTaskQueuePath, url.Values{
+		"action:purgequeue": {"Purge Queue"},
+		"queue_name":        {queue},
+	})
+}
+
+// maxDrainRounds bounds the listings of TaskQueue.RunAll, in case tasks keep
+// enqueuing tasks.
+const maxDrainRounds = 100
+
+// TaskQueue is a push queue of the dev server, inspected and driven through
+// the admin server.
+type TaskQueue struct {
+	sv   *Server
+	name string
+}
+
+// TaskQueue returns the push queue called name.
+func (sv *Server) TaskQueue(name string) *TaskQueue {
+	return &TaskQueue{sv: sv, name: name}
+}
+
+// Tasks returns the tasks waiting in the queue, ordered by ETA and name.
+func (q *TaskQueue) Tasks() ([]Task, error) {
+	return q.sv.queueTasks(q.name)
+}
+
+// Purge deletes the tasks waiting in the queue without running them.
+func (q *TaskQueue) Purge() error {
+	return q.sv.admin.purgeQueue(q.name)
+}
+
+// RunAll runs the tasks waiting in the queue, and those they add to it, until
+// the queue is empty, and returns the number of tasks run. Tasks are run one
+// at a time, ordered by ETA and name. It requires
+// Options.CaptureTasks, like DeliverTask.
+func (q *TaskQueue) RunAll() (int, error) {
+	n := 0
+	for round := 0; round < maxDrainRounds; round++ {
+		tasks, err := q.Tasks()
+		if err != nil {
+			return n, err
+		}
+		if len(tasks) == 0 {
+			return n, nil
+		}
+		for _, task := range tasks {
+			if err := q.sv.admin.runTask(task); err != nil {
+				return n, fmt.Errorf("task %s: %v", task.Name, err)
+			}
+			n++
+		}
+	}
+	return n, fmt.Errorf("queue %s is not empty after running %d tasks", q.name, n)
+}
@@ -0,0 +1,187 @@
+package gaetest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// stubTask is a task waiting in newTaskQueueStub.
+type stubTask struct {
+	name    string
+	payload []byte
+}
+
+// newTaskQueueStub serves the task queue pages of the admin server, whose
+// forms run, delete and purge the tasks of queues, and taskqueue.QueryTasks.
+// run is called with the name of every task run.
+func newTaskQueueStub(queues map[string][]stubTask, run func(queue, name string)) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Header.Get("Content-Type") == "application/octet-stream":
+			body, _ := ioutil.ReadAll(r.Body)
+			fields, _ := parsePB(body)
+			req, _ := parsePB(fields[2].data)
+			var queue, start string
+			max := 1
+			for _, f := range req {
+				switch f.num {
+				case 2:
+					queue = string(f.data)
+				case 3:
+					start = string(f.data)
+				case 5:
+					max = int(f.varint)
+				}
+			}
+			var out pbMessage
+			n := 0
+			for _, task := range queues[queue] {
+				if start != "" && task.name < start || n == max {
+					continue
+				}
+				n++
+				out.startGroup(1)
+				out.string(2, task.name)
+				out.int64(3, 1475445000000000)
+				out.string(4, "/work?id="+task.name)
+				out.int64(5, 2)
+				out.bytes(11, task.payload)
+				out.endGroup(1)
+			}
+			var res pbMessage
+			res.bytes(1, out.buf.Bytes())
+			w.Write(res.buf.Bytes())
+		case r.Method == "POST":
+			r.ParseForm()
+			if queue := r.FormValue("queue_name"); r.FormValue("action:purgequeue") != "" {
+				delete(queues, queue)
+				return
+			}
+			queue := r.URL.Path[len("/taskqueue/queue/"):]
+			name := r.FormValue("task_name")
+			for i, task := range queues[queue] {
+				if task.name == name {
+					queues[queue] = append(queues[queue][:i:i], queues[queue][i+1:]...)
+					break
+				}
+			}
+			if r.FormValue("action:runtask") != "" {
+				run(queue, name)
+			}
+		case r.URL.Path == "/taskqueue":
+			for queue := range queues {
+				fmt.Fprintf(w, `<a href="/taskqueue/queue/%s">%s</a>`, queue, queue)
+			}
+		default:
+			fmt.Fprint(w, `<input type="hidden" name="xsrf_token" value="s3cr3t">`)
+		}
+	}))
+}
+
+func TestParseTasks(t *testing.T) {
+	var res pbMessage
+	res.startGroup(1)
+	res.string(2, "task1")
+	res.int64(3, 1475445000000000)
+	res.string(4, "/work?id=1")
+	res.int64(5, 2)
+	res.bytes(11, []byte("id=1&n=2\x00\xff"))
+	res.endGroup(1)
+	tasks, etas, err := parseTasks("default", res.buf.Bytes())
+	if err != nil {
+		t.Fatalf("parseTasks returned %v, expected nil", err)
+	}
+	expect := []Task{{Queue: "default", Name: "task1", Method: "POST", URL: "/work?id=1",
+		ETA: "2016-10-02 21:50:00", Payload: []byte("id=1&n=2\x00\xff")}}
+	if !reflect.DeepEqual(tasks, expect) {
+		t.Fatalf("got %+v, but expect %+v", tasks, expect)
+	}
+	if expect := []int64{1475445000000000}; !reflect.DeepEqual(etas, expect) {
+		t.Fatalf("got ETAs %v, but expect %v", etas, expect)
+	}
+}
+
+func TestDeliverTask(t *testing.T) {
+	queues := map[string][]stubTask{
+		"default": {{name: "task1"}, {name: "task2", payload: []byte{0, 1, 0xff}}},
+		"mail":    {{name: "task3"}},
+	}
+	var ran []string
+	ts := newTaskQueueStub(queues, func(queue, name string) { ran = append(ran, queue+" "+name) })
+	defer ts.Close()
+	sv := &Server{APIURL: ts.URL, admin: &admin{url: ts.URL}}
+
+	tasks, err := sv.CapturedTasks()
+	if err != nil {
+		t.Fatalf("CapturedTasks returned %v, expected nil", err)
+	}
+	if len(tasks) != 3 || tasks[1].Name != "task2" || tasks[2].Queue != "mail" {
+		t.Fatalf("got %+v, but expect task1 and task2 of queue default and task3 of queue mail", tasks)
+	}
+	if expect := []byte{0, 1, 0xff}; !reflect.DeepEqual(tasks[1].Payload, expect) {
+		t.Fatalf("got payload %q, but expect %q", tasks[1].Payload, expect)
+	}
+	if err := sv.DeliverTask(1); err != nil {
+		t.Fatalf("DeliverTask returned %v, expected nil", err)
+	}
+	// The indexes stay those of the list returned by CapturedTasks.
+	if err := sv.DeliverTask(2); err != nil {
+		t.Fatalf("DeliverTask returned %v, expected nil", err)
+	}
+	if expect := []string{"default task2", "mail task3"}; !reflect.DeepEqual(ran, expect) {
+		t.Fatalf("got %q, but expect %q", ran, expect)
+	}
+	if err := sv.DeliverTask(1); err == nil {
+		t.Fatalf("DeliverTask of a delivered task returned nil, expected an error")
+	}
+	if err := sv.DeliverTask(3); err == nil {
+		t.Fatalf("DeliverTask(3) returned nil, expected an error")
+	}
+	if len(ran) != 2 {
+		t.Fatalf("got %q, but expect no other task to run", ran)
+	}
+}
+
+func TestTaskQueue(t *testing.T) {
+	queues := map[string][]stubTask{"default": {{name: "task1"}, {name: "task2"}, {name: "task3"}}}
+	var ran []string
+	ts := newTaskQueueStub(queues, func(queue, name string) {
+		ran = append(ran, name)
+		if name == "task1" {
+			queues[queue] = append(queues[queue], stubTask{name: "task4"})
+		}
+	})
+	defer ts.Close()
+	sv := &Server{APIURL: ts.URL, admin: &admin{url: ts.URL}}
+	q := sv.TaskQueue("default")
+
+	tasks, err := q.Tasks()
+	if err != nil {
+		t.Fatalf("Tasks returned %v, expected nil", err)
+	}
+	if len(tasks) != 3 || tasks[0].Name != "task1" || tasks[2].Name != "task3" || tasks[0].Queue != "default" {
+		t.Fatalf("got %+v, but expect task1 to task3 of queue default", tasks)
+	}
+	n, err := q.RunAll()
+	if err != nil {
+		t.Fatalf("RunAll returned %v, expected nil", err)
+	}
+	if expect := []string{"task1", "task2", "task3", "task4"}; n != 4 || !reflect.DeepEqual(ran, expect) {
+		t.Fatalf("got %d tasks run, %q, but expect %q", n, ran, expect)
+	}
+
+	queues["default"] = []stubTask{{name: "task5"}}
+	if err := q.Purge(); err != nil {
+		t.Fatalf("Purge returned %v, expected nil", err)
+	}
+	if tasks, _ := q.Tasks(); len(tasks) != 0 {
+		t.Fatalf("got %d tasks, but expect none", len(tasks))
+	}
+	if len(ran) != 4 {
+		t.Fatalf("got %q, but expect task5 to be purged without running", ran)
+	}
+}
@@ -0,0 +1,233 @@
+package gaetest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RetryParameters are the retry_parameters of a queue in queue.yaml.
+type RetryParameters struct {
+	// TaskRetryLimit is the number of retries, -1 for no limit.
+	TaskRetryLimit int
+	// TaskAgeLimit is the time after the first attempt beyond which a task
+	// is not retried, zero for no limit. When both limits are set, a task is
+	// retried until both are reached.
+	TaskAgeLimit time.Duration
+	MinBackoff   time.Duration
+	MaxBackoff   time.Duration
+	MaxDoublings int
+}
+
+// defaultRetryParameters are those of the queues without retry_parameters.
+var defaultRetryParameters = RetryParameters{
+	TaskRetryLimit: -1,
+	MinBackoff:     100 * time.Millisecond,
+	MaxBackoff:     time.Hour,
+	MaxDoublings:   16,
+}
+
+// backoff returns the delay App Engine waits before retrying a task that
+// failed retries times already: it doubles MaxDoublings times, then grows
+// linearly, up to MaxBackoff.
+func (p RetryParameters) backoff(retries int) time.Duration {
+	var d float64
+	if retries < p.MaxDoublings {
+		d = float64(p.MinBackoff) * math.Pow(2, float64(retries))
+	} else {
+		d = float64(p.MinBackoff) * math.Pow(2, float64(p.MaxDoublings)) * float64(retries-p.MaxDoublings+1)
+	}
+	if d > float64(p.MaxBackoff) {
+		return p.MaxBackoff
+	}
+	return time.Duration(d)
+}
+
+// retries reports whether a task that failed retries times, age after its
+// first attempt, is retried.
+func (p RetryParameters) retries(retries int, age time.Duration) bool {
+	count := p.TaskRetryLimit < 0 || retries < p.TaskRetryLimit
+	aged := p.TaskAgeLimit == 0 || age < p.TaskAgeLimit
+	switch {
+	case p.TaskRetryLimit >= 0 && p.TaskAgeLimit > 0:
+		return count || aged
+	case p.TaskAgeLimit > 0:
+		return aged
+	}
+	return count
+}
+
+// QueueRetryParameters returns the retry parameters of queue, as set by the
+// queue.yaml of the app at appDir. Queues not listed there, and apps without
+// queue.yaml, use the defaults of App Engine.
+func QueueRetryParameters(appDir, queue string) (RetryParameters, error) {
+	p := defaultRetryParameters
+	path := filepath.Join(appDir, "queue.yaml")
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return p, nil
+	}
+	if err != nil {
+		return p, err
+	}
+	doc, err := parseYAML(data)
+	if err != nil {
+		return p, fmt.Errorf("%s: %v", path, err)
+	}
+	root, _ := doc.(map[string]interface{})
+	entries, _ := root["queue"].([]interface{})
+	for _, entry := range entries {
+		m, ok := entry.(map[string]interface{})
+		if !ok || yamlString(m, "name") != queue {
+			continue
+		}
+		rp, _ := m["retry_parameters"].(map[string]interface{})
+		if err := parseRetryParameters(&p, rp); err != nil {
+			return p, fmt.Errorf("%s: queue %s: %v", path, queue, err)
+		}
+	}
+	return p, nil
+}
+
+func parseRetryParameters(p *RetryParameters, m map[string]interface{}) error {
+	var err error
+	if s := yamlString(m, "task_retry_limit"); s != "" {
+		if p.TaskRetryLimit, err = strconv.Atoi(s); err != nil {
+			return fmt.Errorf("invalid task_retry_limit %q", s)
+		}
+	}
+	if s := yamlString(m, "task_age_limit"); s != "" {
+		if p.TaskAgeLimit, err = parseQueueDuration(s); err != nil {
+			return fmt.Errorf("invalid task_age_limit %q", s)
+		}
+	}
+	for _, f := range []struct {
+		key string
+		d   *time.Duration
+	}{{"min_backoff_seconds", &p.MinBackoff}, {"max_backoff_seconds", &p.MaxBackoff}} {
+		if s := yamlString(m, f.key); s != "" {
+			secs, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return fmt.Errorf("invalid %s %q", f.key, s)
+			}
+			*f.d = time.Duration(secs * float64(time.Second))
+		}
+	}
+	if s := yamlString(m, "max_doublings"); s != "" {
+		if p.MaxDoublings, err = strconv.Atoi(s); err != nil {
+			return fmt.Errorf("invalid max_doublings %q", s)
+		}
+	}
+	return nil
+}
+
+// parseQueueDuration parses the durations of queue.yaml, a number followed by
+// s, m, h or d.
+func parseQueueDuration(s string) (time.Duration, error) {
+	units := map[byte]time.Duration{'s': time.Second, 'm': time.Minute, 'h': time.Hour, 'd': 24 * time.Hour}
+	unit, ok := units[s[len(s)-1]]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit in %q", s)
+	}
+	n, err := strconv.ParseFloat(s[:len(s)-1], 64)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(n * float64(unit)), nil
+}
+
+// TaskAttempt is an execution of a task by RunTaskWithRetries.
+type TaskAttempt struct {
+	RetryCount int
+	Status     int
+	// Backoff is the delay App Engine would wait before the next attempt,
+	// zero after the last one.
+	Backoff time.Duration
+}
+
+// RunTaskWithRetries executes task, a captured task returned by
+// CapturedTasks, the way App Engine retries failing tasks: it is sent to the
+// app until it succeeds with a 2xx status or the retry_parameters of its queue
+// in queue.yaml give up, with X-AppEngine-TaskRetryCount and
+// X-AppEngine-TaskExecutionCount increasing at every attempt. The backoffs
+// between the attempts are computed but not waited for; they count towards
+// task_age_limit. maxAttempts bounds the attempts of queues retrying forever.
+// The task is then removed from its queue; if its queue gave up, it is
+// recorded in DeadLetters.
+//
+// It requires Options.CaptureTasks, which keeps the dev server from running
+// the task on its own.
+func (sv *Server) RunTaskWithRetries(task Task, maxAttempts int) ([]TaskAttempt, error) {
+	params, err := QueueRetryParameters(sv.appDir, task.Queue)
+	if err != nil {
+		return nil, err
+	}
+	var attempts []TaskAttempt
+	var age time.Duration
+	for retries := 0; retries < maxAttempts; retries++ {
+		req := sv.newTaskRequest(task.Queue, task.URL, task.Payload, retries)
+		if task.Method != "" {
+			req.Method = task.Method
+		}
+		req.Header.Set("X-AppEngine-TaskName", task.Name)
+		res, err := sv.httpClient().Do(req)
+		if err != nil {
+			return attempts, err
+		}
+		res.Body.Close()
+		attempts = append(attempts, TaskAttempt{RetryCount: retries, Status: res.StatusCode})
+		if res.StatusCode/100 == 2 || retries == maxAttempts-1 {
+			break
+		}
+		if !params.retries(retries, age) {
+			sv.deadLetters.add(DeadLetter{Task: task, Attempts: attempts, Time: time.Now()})
+			break
+		}
+		backoff := params.backoff(retries)
+		attempts[len(attempts)-1].Backoff = backoff
+		age += backoff
+	}
+	return attempts, sv.admin.deleteTask(task)
+}
+
+// deleteTask removes task from its queue.
+func (a *admin) deleteTask(task Task) error {
+	return a.post(adminTaskQueuePath+"/queue/"+url.PathEscape(task.Queue), url.Values{
+		"action:deletetask": {"Delete"},
+		"task_name":         {task.Name},
+	})
+}
+
+// DeadLetter is a task RunTaskWithRetries gave up on: every attempt allowed
+// by the retry_parameters of its queue failed.
+type DeadLetter struct {
+	Task     Task
+	Attempts []TaskAttempt
+	Time     time.Time
+}
+
+// deadLetters is the list of the tasks given up on.
+type deadLetters struct {
+	mu      sync.Mutex
+	letters []DeadLetter
+}
+
+func (d *deadLetters) add(l DeadLetter) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.letters = append(d.letters, l)
+}
+
+// DeadLetters returns the tasks RunTaskWithRetries gave up on, in order,
+// with their payload and the status of every attempt.
+func (sv *Server) DeadLetters() []DeadLetter {
+	sv.deadLetters.mu.Lock()
+	defer sv.deadLetters.mu.Unlock()
+	return append([]DeadLetter(nil), sv.deadLetters.letters...)
+}
@@ -0,0 +1,83 @@
+package gaetest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRetryParametersBackoff(t *testing.T) {
+	p := RetryParameters{MinBackoff: time.Second, MaxBackoff: 10 * time.Second, MaxDoublings: 2}
+	var got []time.Duration
+	for retries := 0; retries < 6; retries++ {
+		got = append(got, p.backoff(retries))
+	}
+	expect := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}
+	if !reflect.DeepEqual(got, expect) {
+		t.Fatalf("got %v, but expect %v", got, expect)
+	}
+}
+
+func TestRunTaskWithRetries(t *testing.T) {
+	dir := t.TempDir()
+	queueYAML := `queue:
+- name: mail
+  rate: 1/s
+  retry_parameters:
+    task_retry_limit: 2
+    min_backoff_seconds: 0.5
+    max_doublings: 3
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "queue.yaml"), []byte(queueYAML), 0644); err != nil {
+		t.Fatalf("WriteFile returned %v, expected nil", err)
+	}
+	var headers []string
+	app := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = append(headers, r.Method+" "+r.URL.Path+" "+r.Header.Get("X-AppEngine-TaskName")+" "+r.Header.Get("X-AppEngine-TaskRetryCount"))
+		http.Error(w, "try again", http.StatusInternalServerError)
+	}))
+	defer app.Close()
+	var posts []*http.Request
+	ts := newAdminStub(&posts)
+	defer ts.Close()
+	sv := &Server{appDir: dir, ModuleURL: app.URL, admin: &admin{url: ts.URL}}
+
+	task := Task{Queue: "mail", Name: "task7", Method: "POST", URL: "/send"}
+	attempts, err := sv.RunTaskWithRetries(task, 10)
+	if err != nil {
+		t.Fatalf("RunTaskWithRetries returned %v, expected nil", err)
+	}
+	expect := []TaskAttempt{
+		{RetryCount: 0, Status: 500, Backoff: 500 * time.Millisecond},
+		{RetryCount: 1, Status: 500, Backoff: time.Second},
+		{RetryCount: 2, Status: 500},
+	}
+	if !reflect.DeepEqual(attempts, expect) {
+		t.Fatalf("got %+v, but expect %+v", attempts, expect)
+	}
+	expectHeaders := []string{"POST /send task7 0", "POST /send task7 1", "POST /send task7 2"}
+	if !reflect.DeepEqual(headers, expectHeaders) {
+		t.Fatalf("got %q, but expect %q", headers, expectHeaders)
+	}
+	if len(posts) != 1 || posts[0].FormValue("action:deletetask") == "" || posts[0].FormValue("task_name") != "task7" {
+		t.Fatalf("got %d posts, but expect the task to be deleted", len(posts))
+	}
+
+	dead := sv.DeadLetters()
+	if len(dead) != 1 || dead[0].Task.Name != "task7" || !reflect.DeepEqual(dead[0].Attempts, expect) {
+		t.Fatalf("got %+v, but expect task7 as a dead letter", dead)
+	}
+
+	// The default queue retries until maxAttempts.
+	task.Queue = "default"
+	if attempts, err = sv.RunTaskWithRetries(task, 4); err != nil || len(attempts) != 4 || attempts[3].Backoff != 0 {
+		t.Fatalf("got %+v and %v, but expect 4 attempts", attempts, err)
+	}
+	if len(sv.DeadLetters()) != 1 {
+		t.Fatalf("got %d dead letters, but expect tasks cut short by maxAttempts not to be recorded", len(sv.DeadLetters()))
+	}
+}
@@ -0,0 +1,129 @@
+package gaetest
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// App is an app of a topology started by NewTopology.
+type App struct {
+	// Name identifies the app. The other apps find its URL in the
+	// GAETEST_<NAME>_URL environment variable, like Options.Externals.
+	Name    string
+	Dir     string
+	Options *Options
+}
+
+// Topology is a set of apps, each run by its own dev_appserver.py, that call
+// each other.
+type Topology struct {
+	servers map[string]*Server
+	tracer  *tracer
+}
+
+// freePort returns a port on host nothing listens on at the moment.
+func freePort(host string) (int, error) {
+	l, err := net.Listen("tcp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	_, port, err := net.SplitHostPort(l.Addr().String())
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(port)
+}
+
+// NewTopology starts the apps in parallel. The ports of the default modules,
+// or of the proxies of the apps with Options.Proxy, are chosen up front,
+// unless set in Options.Port or ProxyPort, so that every app can be passed the
+// URLs of the others. Requests to apps with a proxy are traced, see Trace. If
+// an app fails to start, the others are closed.
+func NewTopology(apps []App) (*Topology, error) {
+	opts := make([]*Options, len(apps))
+	urls := make(map[string]string)
+	for i, app := range apps {
+		if _, dup := urls[app.Name]; dup || app.Name == "" {
+			return nil, fmt.Errorf("invalid or duplicate app name %q", app.Name)
+		}
+		o := *withDefaults(app.Options)
+		var err error
+		if o.Port == 0 {
+			if o.Port, err = freePort(o.Host); err != nil {
+				return nil, err
+			}
+		}
+		port := o.Port
+		if o.Proxy {
+			if o.ProxyPort == 0 {
+				if o.ProxyPort, err = freePort(o.Host); err != nil {
+					return nil, err
+				}
+			}
+			port = o.ProxyPort
+		}
+		opts[i] = &o
+		urls[app.Name] = "http://" + net.JoinHostPort(o.Host, strconv.Itoa(port))
+	}
+	for i, app := range apps {
+		env := make(map[string]string)
+		for name, value := range opts[i].AppEnv {
+			env[name] = value
+		}
+		for name, u := range urls {
+			if name != app.Name {
+				env[externalEnvName(name)] = u
+			}
+		}
+		opts[i].AppEnv = env
+	}
+
+	tp := &Topology{servers: make(map[string]*Server), tracer: &tracer{}}
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs multiError
+	)
+	for i, app := range apps {
+		wg.Add(1)
+		go func(app App, opts *Options) {
+			defer wg.Done()
+			sv, err := New(app.Dir, opts)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %v", app.Name, err))
+				return
+			}
+			tp.servers[app.Name] = sv
+			if sv.proxy != nil {
+				sv.proxy.trace(app.Name, tp.tracer)
+			}
+		}(app, opts[i])
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		tp.Close()
+		return nil, errs
+	}
+	return tp, nil
+}
+
+// Server returns the server running the app called name, or nil.
+func (tp *Topology) Server(name string) *Server {
+	return tp.servers[name]
+}
+
+// Close closes the servers of all the apps.
+func (tp *Topology) Close() error {
+	var errs multiError
+	for name, sv := range tp.servers {
+		if err := sv.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", name, err))
+		}
+	}
+	return errs.err()
+}
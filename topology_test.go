@@ -0,0 +1,26 @@
+package gaetest
+
+import (
+	"net"
+	"strconv"
+	"testing"
+)
+
+func TestFreePort(t *testing.T) {
+	port, err := freePort("127.0.0.1")
+	if err != nil {
+		t.Fatalf("freePort returned %v, expected nil", err)
+	}
+	l, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		t.Fatalf("got %v, but expect port %d to be free", err, port)
+	}
+	l.Close()
+}
+
+func TestNewTopologyDuplicateName(t *testing.T) {
+	_, err := NewTopology([]App{{Name: "api", Dir: "a"}, {Name: "api", Dir: "b"}})
+	if err == nil || err.Error() != `invalid or duplicate app name "api"` {
+		t.Fatalf("got %v, but expect the duplicate name to be reported", err)
+	}
+}
@@ -0,0 +1,81 @@
+package gaetest
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// TraceHeader carries the ID of the trace a request belongs to. The proxies of
+// a Topology set it on the requests entering the topology and on their
+// responses. Apps propagate it by copying it from incoming requests to the
+// requests they make to the other apps.
+const TraceHeader = "X-Gaetest-Trace"
+
+// Hop is a request to an app of a Topology, recorded by its proxy.
+type Hop struct {
+	Trace    string
+	App      string
+	Method   string
+	Path     string
+	Status   int
+	Start    time.Time
+	Duration time.Duration
+	// Depth is the number of hops of the trace the hop was made during: 0
+	// for the request entering the topology, 1 for the requests its handler
+	// made, and so on.
+	Depth int
+}
+
+// tracer records the hops of the traces of a Topology.
+type tracer struct {
+	mu   sync.Mutex
+	seq  int
+	hops []Hop
+}
+
+func (tr *tracer) newID() string {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	tr.seq++
+	return "trace-" + strconv.Itoa(tr.seq)
+}
+
+func (tr *tracer) add(h Hop) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	tr.hops = append(tr.hops, h)
+}
+
+// trace returns the call graph of the trace id: its hops, in the order they
+// started, with their depth set.
+func (tr *tracer) trace(id string) []Hop {
+	tr.mu.Lock()
+	var hops []Hop
+	for _, h := range tr.hops {
+		if h.Trace == id {
+			hops = append(hops, h)
+		}
+	}
+	tr.mu.Unlock()
+	sort.Slice(hops, func(i, j int) bool { return hops[i].Start.Before(hops[j].Start) })
+	for i := range hops {
+		end := hops[i].Start.Add(hops[i].Duration)
+		for j := 0; j < i; j++ {
+			// A hop is nested in the earlier hops still running when it
+			// ends.
+			if !hops[j].Start.Add(hops[j].Duration).Before(end) {
+				hops[i].Depth++
+			}
+		}
+	}
+	return hops
+}
+
+// Trace returns the requests made to the apps of the topology as part of the
+// trace id, taken from the TraceHeader of a response, in the order they
+// started. Only the apps with Options.Proxy are traced.
+func (tp *Topology) Trace(id string) []Hop {
+	return tp.tracer.trace(id)
+}
@@ -0,0 +1,55 @@
+package gaetest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTrace(t *testing.T) {
+	tr := &tracer{}
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+	back, err := newAppProxy("127.0.0.1:0", backend.URL)
+	if err != nil {
+		t.Fatalf("newAppProxy returned %v, expected nil", err)
+	}
+	defer back.Close()
+	back.trace("back", tr)
+
+	frontend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, _ := http.NewRequest("GET", back.URL+"/items", nil)
+		req.Header.Set(TraceHeader, r.Header.Get(TraceHeader))
+		if res, err := http.DefaultClient.Do(req); err == nil {
+			res.Body.Close()
+		}
+	}))
+	defer frontend.Close()
+	front, err := newAppProxy("127.0.0.1:0", frontend.URL)
+	if err != nil {
+		t.Fatalf("newAppProxy returned %v, expected nil", err)
+	}
+	defer front.Close()
+	front.trace("front", tr)
+
+	res, err := http.Get(front.URL + "/page")
+	if err != nil {
+		t.Fatalf("Get returned %v, expected nil", err)
+	}
+	res.Body.Close()
+	id := res.Header.Get(TraceHeader)
+	if id == "" {
+		t.Fatalf("got no %s header, but expect the trace ID", TraceHeader)
+	}
+
+	hops := tr.trace(id)
+	if len(hops) != 2 {
+		t.Fatalf("got %d hops, but expect 2", len(hops))
+	}
+	if h := hops[0]; h.App != "front" || h.Path != "/page" || h.Depth != 0 {
+		t.Fatalf("got %+v, but expect the request to front first", h)
+	}
+	if h := hops[1]; h.App != "back" || h.Path != "/items" || h.Depth != 1 {
+		t.Fatalf("got %+v, but expect the nested request to back", h)
+	}
+}
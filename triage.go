@@ -0,0 +1,92 @@
+package gaetest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// triageLogLines is the number of lines of output written to a triage bundle.
+const triageLogLines = 500
+
+// timings records how long the phases of starting the server took.
+type timings struct {
+	Started time.Time     `json:"started"`
+	Spawn   time.Duration `json:"spawn_ns"`   // until the process was running
+	Startup time.Duration `json:"startup_ns"` // until New returned
+}
+
+var unsafeNameRE = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// WriteTriageBundle writes what is needed to diagnose a failed test into a new
+// directory under Options.ArtifactDir, named after name, and returns its path:
+// the last lines of dev_appserver.py output, startup timings, the requests
+// recorded by the proxies, health violations and a summary of the environment,
+// including the random seed of the app.
+func (sv *Server) WriteTriageBundle(name string) (string, error) {
+	if sv.opts.ArtifactDir == "" {
+		return "", fmt.Errorf("Options.ArtifactDir is not set")
+	}
+	dir := filepath.Join(sv.opts.ArtifactDir,
+		unsafeNameRE.ReplaceAllString(name, "_")+"-"+time.Now().Format("20060102-150405.000"))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	lines := sv.logs.snapshot()
+	if len(lines) > triageLogLines {
+		lines = lines[len(lines)-triageLogLines:]
+	}
+	files := map[string]interface{}{
+		"timings.json":    sv.timings,
+		"violations.json": sv.HealthViolations(),
+	}
+	if sv.proxy != nil {
+		files["traffic.json"] = sv.TrafficReport()
+	}
+	if sv.outbound != nil {
+		files["outbound.json"] = sv.OutboundRequests()
+	}
+	for file, v := range files {
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return "", err
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, file), data, 0644); err != nil {
+			return "", err
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "output.log"), []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		return "", err
+	}
+	env := fmt.Sprintf("go: %s %s/%s\ncommand: %s\napp: %s\nmodule: %s\nadmin: %s\napi: %s\nrandom seed: %d\n",
+		runtime.Version(), runtime.GOOS, runtime.GOARCH, strings.Join(sv.args, " "),
+		sv.appDir, sv.ModuleURL, sv.AdminURL, sv.APIURL, sv.seed)
+	if err := ioutil.WriteFile(filepath.Join(dir, "environment.txt"), []byte(env), 0644); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// Triage arranges for a triage bundle to be written with WriteTriageBundle
+// when t fails, so that failures in CI can be diagnosed after the fact.
+func (sv *Server) Triage(t testing.TB) {
+	t.Cleanup(func() {
+		if !t.Failed() {
+			return
+		}
+		dir, err := sv.WriteTriageBundle(t.Name())
+		if err != nil {
+			t.Logf("unable to write triage bundle: %v", err)
+			return
+		}
+		t.Logf("triage bundle written to %s (app random seed %d)", dir, sv.seed)
+	})
+}
@@ -0,0 +1,47 @@
+package gaetest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteTriageBundle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gaetest")
+	if err != nil {
+		t.Fatalf("Got %v, expected nil", err)
+	}
+	defer os.RemoveAll(dir)
+
+	logs, _ := newLogBuffer(nil)
+	for i := 0; i < triageLogLines+10; i++ {
+		fmt.Fprintf(logs, "line %d\n", i)
+	}
+	sv := &Server{opts: &Options{ArtifactDir: dir}, logs: logs, seed: 42}
+	bundle, err := sv.WriteTriageBundle("TestSignup/new user")
+	if err != nil {
+		t.Fatalf("WriteTriageBundle returned %v, expected nil", err)
+	}
+	if !strings.HasPrefix(filepath.Base(bundle), "TestSignup_new_user-") {
+		t.Fatalf("got bundle %q, but expect it named after the test", bundle)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(bundle, "output.log"))
+	if err != nil {
+		t.Fatalf("Got %v, expected nil", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != triageLogLines || lines[0] != "line 10" {
+		t.Fatalf("got %d lines starting with %q, but expect %d starting with %q", len(lines), lines[0], triageLogLines, "line 10")
+	}
+	for _, file := range []string{"timings.json", "violations.json", "environment.txt"} {
+		if _, err := os.Stat(filepath.Join(bundle, file)); err != nil {
+			t.Fatalf("Got %v, expected %s in the bundle", err, file)
+		}
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(bundle, "environment.txt")); !strings.Contains(string(data), "random seed: 42\n") {
+		t.Fatalf("got %q, but expect the random seed", data)
+	}
+}
@@ -0,0 +1,154 @@
+package gaetest
+
+import (
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"sort"
+)
+
+// FormFile is a file of an upload form.
+type FormFile struct {
+	Field       string
+	Name        string
+	ContentType string // defaults to application/octet-stream
+	Content     io.Reader
+	// Size of Content. It can be left 0 when Content is a *bytes.Reader, a
+	// *strings.Reader, a *bytes.Buffer or an *os.File.
+	Size int64
+}
+
+// size returns the size of the content of f.
+func (f *FormFile) size() (int64, error) {
+	if f.Size > 0 {
+		return f.Size, nil
+	}
+	switch c := f.Content.(type) {
+	case interface{ Len() int }:
+		return int64(c.Len()), nil
+	case *os.File:
+		fi, err := c.Stat()
+		if err != nil {
+			return 0, err
+		}
+		return fi.Size(), nil
+	}
+	return 0, fmt.Errorf("upload: unknown size of file %q, set FormFile.Size", f.Name)
+}
+
+// Form is a multipart/form-data request body.
+type Form struct {
+	Fields map[string]string
+	Files  []FormFile
+	// Progress, if set, is called as the body is sent with the number of
+	// bytes sent so far and the size of the body.
+	Progress func(sent, total int64)
+}
+
+// write writes the form to w, with the content of the files unless dry is
+// set, and returns the sum of the sizes of the files.
+func (f *Form) write(w *multipart.Writer, dry bool) (int64, error) {
+	names := make([]string, 0, len(f.Fields))
+	for name := range f.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := w.WriteField(name, f.Fields[name]); err != nil {
+			return 0, err
+		}
+	}
+	var total int64
+	for i := range f.Files {
+		file := &f.Files[i]
+		ctype := file.ContentType
+		if ctype == "" {
+			ctype = "application/octet-stream"
+		}
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Disposition", fmt.Sprintf(`form-data; name=%q; filename=%q`, file.Field, file.Name))
+		h.Set("Content-Type", ctype)
+		part, err := w.CreatePart(h)
+		if err != nil {
+			return 0, err
+		}
+		size, err := file.size()
+		if err != nil {
+			return 0, err
+		}
+		total += size
+		if dry {
+			continue
+		}
+		if n, err := io.Copy(part, file.Content); err != nil {
+			return 0, err
+		} else if n != size {
+			return 0, fmt.Errorf("upload: file %q has %d bytes, expected %d", file.Name, n, size)
+		}
+	}
+	return total, w.Close()
+}
+
+// countingWriter counts the bytes written to it.
+type countingWriter int64
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	*c += countingWriter(len(p))
+	return len(p), nil
+}
+
+// progressReader reports the bytes read through it.
+type progressReader struct {
+	r           io.Reader
+	sent, total int64
+	progress    func(sent, total int64)
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.sent += int64(n)
+		r.progress(r.sent, r.total)
+	}
+	return n, err
+}
+
+// NewUploadRequest returns a POST request to path with form as its body. The
+// body is streamed rather than buffered, with its length computed beforehand:
+// dev_appserver.py does not accept chunked request bodies. Forms larger than
+// MaxRequestSize are rejected, as App Engine would.
+func (sv *Server) NewUploadRequest(path string, form *Form) (*http.Request, error) {
+	var dry countingWriter
+	mw := multipart.NewWriter(&dry)
+	files, err := form.write(mw, true)
+	if err != nil {
+		return nil, err
+	}
+	total := int64(dry) + files
+	if total > MaxRequestSize {
+		return nil, fmt.Errorf("upload: body of %d bytes exceeds the %d bytes App Engine accepts", total, MaxRequestSize)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		w := multipart.NewWriter(pw)
+		w.SetBoundary(mw.Boundary())
+		_, err := form.write(w, false)
+		pw.CloseWithError(err)
+	}()
+	var body io.Reader = pr
+	if form.Progress != nil {
+		body = &progressReader{r: pr, total: total, progress: form.Progress}
+	}
+	req, err := http.NewRequest("POST", sv.ModuleURL+path, body)
+	if err != nil {
+		pr.Close()
+		return nil, err
+	}
+	req.ContentLength = total
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req, nil
+}
@@ -0,0 +1,58 @@
+package gaetest
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNewUploadRequest(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TransferEncoding) > 0 {
+			http.Error(w, "chunked body", http.StatusBadRequest)
+			return
+		}
+		f, fh, err := r.FormFile("photo")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data, _ := ioutil.ReadAll(f)
+		fmt.Fprintf(w, "%s %s %d %s", r.FormValue("title"), fh.Filename, len(data), fh.Header.Get("Content-Type"))
+	}))
+	defer ts.Close()
+	sv := &Server{ModuleURL: ts.URL}
+
+	var sent, total int64
+	req, err := sv.NewUploadRequest("/upload", &Form{
+		Fields: map[string]string{"title": "cat"},
+		Files: []FormFile{
+			{Field: "photo", Name: "cat.jpg", ContentType: "image/jpeg", Content: bytes.NewReader(Payload(100000))},
+		},
+		Progress: func(s, t int64) { sent, total = s, t },
+	})
+	if err != nil {
+		t.Fatalf("NewUploadRequest returned %v, expected nil", err)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Do returned %v, expected nil", err)
+	}
+	defer res.Body.Close()
+	body, _ := ioutil.ReadAll(res.Body)
+	if expect := "cat cat.jpg 100000 image/jpeg"; string(body) != expect {
+		t.Fatalf("got %q, but expect %q", body, expect)
+	}
+	if sent != total || total != req.ContentLength {
+		t.Fatalf("got progress %d/%d, but expect %d bytes to be sent", sent, total, req.ContentLength)
+	}
+
+	_, err = sv.NewUploadRequest("/upload", &Form{Files: []FormFile{{Field: "f", Name: "stream", Content: strings.NewReader("")}, {Field: "g", Name: "big", Content: ioutil.NopCloser(nil), Size: MaxRequestSize}}})
+	if err == nil || !strings.Contains(err.Error(), "exceeds") {
+		t.Fatalf("got %v, but expect the size limit to be enforced", err)
+	}
+}
@@ -0,0 +1,260 @@
+package gaetest
+
+import (
+	"crypto/md5"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// loginCookie is the cookie the Users stub of dev_appserver.py uses to track the
+// logged in user.
+const loginCookie = "dev_appserver_login"
+
+// loginPath is the login page of the Users stub.
+const loginPath = "/_ah/login"
+
+// User is a user of the Users API stub.
+type User struct {
+	Email string
+	// Admin users pass handlers restricted with "login: admin".
+	Admin bool
+	// OAuthEmail is the email the stub returns from oauth.CurrentUser.
+	// Defaults to Email.
+	OAuthEmail string
+}
+
+// userID derives the user ID from email the same way the Users stub does.
+func userID(email string) string {
+	sum := md5.Sum([]byte(strings.ToLower(email)))
+	var digits string
+	for _, b := range sum {
+		digits += fmt.Sprintf("%02d", b)
+	}
+	return "1" + digits[:20]
+}
+
+// cookie returns the login cookie of u.
+func (u *User) cookie() *http.Cookie {
+	admin := "False"
+	if u.Admin {
+		admin = "True"
+	}
+	return &http.Cookie{
+		Name:  loginCookie,
+		Value: fmt.Sprintf("%s:%s:%s", u.Email, admin, userID(u.Email)),
+		Path:  "/",
+	}
+}
+
+// env returns the environment variables configuring the OAuth part of the
+// Users stub.
+func (u *User) env() []string {
+	email := u.OAuthEmail
+	if email == "" {
+		email = u.Email
+	}
+	admin := "0"
+	if u.Admin {
+		admin = "1"
+	}
+	return []string{
+		"OAUTH_EMAIL=" + email,
+		"OAUTH_USER_ID=" + userID(email),
+		"OAUTH_IS_ADMIN=" + admin,
+	}
+}
+
+// ClientOption configures a client returned by Server.Client.
+type ClientOption func(*clientOptions)
+
+type clientOptions struct {
+	header http.Header // set on every request
+	// rawEncoding disables the transparent decompression of responses.
+	rawEncoding bool
+	login       *User // logged in through the login page, see LoginAs
+}
+
+// LoginOption configures the user logged in by LoginAs.
+type LoginOption func(*User)
+
+// AsAdmin logs the user in as an administrator of the app, who passes
+// handlers restricted with "login: admin".
+func AsAdmin() LoginOption {
+	return func(u *User) { u.Admin = true }
+}
+
+// LoginAs makes the client log in as the user with email before its first
+// request, through the login page of the Users stub, /_ah/login, the way a
+// browser does, and carry the login cookie the page sets:
+//
+//	client := sv.Client(gaetest.LoginAs("alice@example.com", gaetest.AsAdmin()))
+//
+// It overrides Options.User for the client. If the login fails, the first
+// request returns the error.
+func LoginAs(email string, opts ...LoginOption) ClientOption {
+	return func(o *clientOptions) {
+		u := &User{Email: email}
+		for _, opt := range opts {
+			opt(u)
+		}
+		o.login = u
+	}
+}
+
+// loginTransport logs user in through the login page at loginURL before the
+// first request it sends, storing the login cookie in jar.
+type loginTransport struct {
+	base     http.RoundTripper
+	jar      http.CookieJar
+	loginURL string
+	user     *User
+
+	once sync.Once
+	err  error
+}
+
+func (t *loginTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.once.Do(func() { t.err = t.login() })
+	if t.err != nil {
+		return nil, t.err
+	}
+	// The client took the cookies of the request from the jar before the
+	// login stored the login cookie.
+	if _, err := req.Cookie(loginCookie); err == http.ErrNoCookie {
+		req = cloneRequest(req)
+		for _, c := range t.jar.Cookies(req.URL) {
+			req.AddCookie(c)
+		}
+	}
+	return t.base.RoundTrip(req)
+}
+
+// login requests the login page, which sets the login cookie and redirects.
+func (t *loginTransport) login() error {
+	admin := "False"
+	if t.user.Admin {
+		admin = "True"
+	}
+	u, err := url.Parse(t.loginURL + "?" + url.Values{
+		"email":    {t.user.Email},
+		"admin":    {admin},
+		"action":   {"Login"},
+		"continue": {"/"},
+	}.Encode())
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return err
+	}
+	res, err := t.base.RoundTrip(req)
+	if err != nil {
+		return fmt.Errorf("gaetest: login as %s: %v", t.user.Email, err)
+	}
+	res.Body.Close()
+	for _, c := range res.Cookies() {
+		if c.Name == loginCookie {
+			t.jar.SetCookies(u, res.Cookies())
+			return nil
+		}
+	}
+	return fmt.Errorf("gaetest: login as %s: %s set no login cookie (%s)", t.user.Email, loginPath, res.Status)
+}
+
+// InboundAppID makes the client identify itself as the App Engine app appID
+// in the X-Appengine-Inbound-Appid header, the way production marks requests
+// made by other apps with urlfetch. The dev server passes the header on to
+// the app, so that authorizing callers by app ID can be tested.
+func InboundAppID(appID string) ClientOption {
+	return func(o *clientOptions) {
+		o.header.Set("X-Appengine-Inbound-Appid", appID)
+	}
+}
+
+// headerTransport sets headers on the requests it sends.
+type headerTransport struct {
+	base   http.RoundTripper
+	header http.Header
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTrippers must not modify the request.
+	req = cloneRequest(req)
+	for k, v := range t.header {
+		req.Header[k] = v
+	}
+	return t.base.RoundTrip(req)
+}
+
+// cloneRequest returns a shallow copy of req with a copy of its headers.
+func cloneRequest(req *http.Request) *http.Request {
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		r.Header[k] = append([]string(nil), v...)
+	}
+	return r
+}
+
+// Client returns an HTTP client for requests to the app. If Options.User is
+// set, the client carries the login cookie of that user, so requests are made
+// as if the user had logged in through the dev server's login page. opts
+// configure the client further, e.g. LoginAs logs in another user.
+func (sv *Server) Client(opts ...ClientOption) *http.Client {
+	o := &clientOptions{header: make(http.Header)}
+	for _, opt := range opts {
+		opt(o)
+	}
+	jar, _ := cookiejar.New(nil) // never fails without options
+	if sv.opts.User != nil && o.login == nil {
+		for _, u := range sv.appURLs() {
+			if parsed, err := url.Parse(u); err == nil {
+				jar.SetCookies(parsed, []*http.Cookie{sv.opts.User.cookie()})
+			}
+		}
+	}
+	client := &http.Client{Jar: jar}
+	var base http.RoundTripper = sv.transport()
+	if o.rawEncoding {
+		t := sv.transport().Clone()
+		t.DisableCompression = true
+		base = t
+	}
+	if len(o.header) > 0 {
+		client.Transport = &headerTransport{base: base, header: o.header}
+	} else if base != http.DefaultTransport {
+		client.Transport = base
+	}
+	if o.login != nil {
+		transport := client.Transport
+		if transport == nil {
+			transport = http.DefaultTransport
+		}
+		client.Transport = &loginTransport{base: transport, jar: jar, loginURL: sv.ModuleURL + loginPath, user: o.login}
+	}
+	if sv.suite != nil {
+		transport := client.Transport
+		if transport == nil {
+			transport = http.DefaultTransport
+		}
+		client.Transport = &deadlineTransport{base: transport, sv: sv}
+	}
+	return client
+}
+
+// appURLs returns the URLs of the module servers.
+func (sv *Server) appURLs() []string {
+	urls := []string{sv.ModuleURL}
+	for _, u := range sv.services {
+		if u != sv.ModuleURL {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
@@ -0,0 +1,103 @@
+package gaetest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestUserCookie(t *testing.T) {
+	u := &User{Email: "test@example.com", Admin: true}
+	c := u.cookie()
+	// The ID the Users stub derives for test@example.com.
+	if expect := "test@example.com:True:185804764220139124118"; c.Value != expect {
+		t.Fatalf("got %q, but expect %q", c.Value, expect)
+	}
+}
+
+func TestClientUser(t *testing.T) {
+	var got string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c, err := r.Cookie(loginCookie); err == nil {
+			got = c.Value
+		}
+	}))
+	defer ts.Close()
+
+	u := &User{Email: "alice@example.com"}
+	sv := &Server{opts: &Options{User: u}, ModuleURL: ts.URL}
+	res, err := sv.Client().Get(ts.URL + "/profile")
+	if err != nil {
+		t.Fatalf("Get returned %v, expected nil", err)
+	}
+	res.Body.Close()
+	if expect := u.cookie().Value; got != expect {
+		t.Fatalf("got cookie %q, but expect %q", got, expect)
+	}
+}
+
+func TestClientInboundAppID(t *testing.T) {
+	var got string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("X-Appengine-Inbound-Appid")
+	}))
+	defer ts.Close()
+
+	sv := &Server{opts: &Options{}, ModuleURL: ts.URL}
+	req, _ := http.NewRequest("GET", ts.URL, nil)
+	res, err := sv.Client(InboundAppID("billing-app")).Do(req)
+	if err != nil {
+		t.Fatalf("Do returned %v, expected nil", err)
+	}
+	res.Body.Close()
+	if got != "billing-app" {
+		t.Fatalf("got %q, but expect %q", got, "billing-app")
+	}
+	if req.Header.Get("X-Appengine-Inbound-Appid") != "" {
+		t.Fatalf("got headers %v, but expect the request to be left unchanged", req.Header)
+	}
+}
+
+func TestClientLoginAs(t *testing.T) {
+	logins := 0
+	var got []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == loginPath {
+			logins++
+			if r.FormValue("action") != "Login" || r.FormValue("email") == "" {
+				return
+			}
+			admin := r.FormValue("admin") == "True"
+			u := &User{Email: r.FormValue("email"), Admin: admin}
+			http.SetCookie(w, u.cookie())
+			http.Redirect(w, r, r.FormValue("continue"), http.StatusFound)
+			return
+		}
+		c, _ := r.Cookie(loginCookie)
+		if c != nil {
+			got = append(got, c.Value)
+		}
+	}))
+	defer ts.Close()
+
+	sv := &Server{opts: &Options{User: &User{Email: "bob@example.com"}}, ModuleURL: ts.URL}
+	client := sv.Client(LoginAs("alice@example.com", AsAdmin()))
+	for i := 0; i < 2; i++ {
+		res, err := client.Get(ts.URL + "/admin")
+		if err != nil {
+			t.Fatalf("Get returned %v, expected nil", err)
+		}
+		res.Body.Close()
+	}
+	expect := (&User{Email: "alice@example.com", Admin: true}).cookie().Value
+	if len(got) != 2 || got[0] != expect || got[1] != expect {
+		t.Fatalf("got cookies %q, but expect %q twice", got, expect)
+	}
+	if logins != 1 {
+		t.Fatalf("got %d logins, but expect 1", logins)
+	}
+
+	if _, err := sv.Client(LoginAs("")).Get(ts.URL + "/admin"); err == nil {
+		t.Fatal("Get returned nil after a failed login, expected an error")
+	}
+}
@@ -0,0 +1,194 @@
+package gaetest
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Issue is a problem found by VetApp.
+type Issue struct {
+	// Pos locates the problem, e.g. "app.yaml handler 3" or "main.go:12".
+	Pos     string
+	Message string
+}
+
+func (i Issue) String() string {
+	return i.Pos + ": " + i.Message
+}
+
+// appHandler is an entry of the handlers section of app.yaml.
+type appHandler struct {
+	url    string
+	re     *regexp.Regexp
+	script string
+	static bool
+	login  string
+}
+
+// adminPathRE matches paths that are usually meant for administrators, cron or
+// task queues only.
+var adminPathRE = regexp.MustCompile(`^/(admin|cron|tasks?|internal|_ah/(mail|bounce|queue))(/|$|\b)`)
+
+// goAppScript is the script name routing requests to a Go app.
+const goAppScript = "_go_app"
+
+// VetApp cross-checks the handlers in the app.yaml of the app at appDir against
+// the routes the Go source files in appDir register with http.Handle and
+// http.HandleFunc. It reports handlers shadowed by earlier ones, paths that
+// look administrative but are not restricted with "login: admin", and routes
+// that app.yaml never sends to the Go app.
+func VetApp(appDir string) ([]Issue, error) {
+	handlers, err := readHandlers(filepath.Join(appDir, "app.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	issues := vetHandlers(handlers)
+	routes, err := goRoutes(appDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range routes {
+		if h := matchHandler(handlers, r.path); h == nil || h.static || h.script != goAppScript {
+			issues = append(issues, Issue{Pos: r.pos, Message: fmt.Sprintf("route %q is not sent to the Go app by app.yaml", r.path)})
+		}
+	}
+	return issues, nil
+}
+
+func readHandlers(path string) ([]*appHandler, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := parseYAML(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	root, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: expected a mapping", path)
+	}
+	list, _ := root["handlers"].([]interface{})
+	var handlers []*appHandler
+	for i, entry := range list {
+		m, ok := entry.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s: handler %d: expected a mapping", path, i+1)
+		}
+		h := &appHandler{
+			url:    yamlString(m, "url"),
+			script: yamlString(m, "script"),
+			static: yamlString(m, "static_dir") != "" || yamlString(m, "static_files") != "",
+			login:  yamlString(m, "login"),
+		}
+		if yamlString(m, "static_dir") != "" {
+			// static_dir handlers match everything below their URL.
+			h.re, err = regexp.Compile("^" + regexp.QuoteMeta(strings.TrimSuffix(h.url, "/")) + "/.*$")
+		} else {
+			h.re, err = regexp.Compile("^(?:" + h.url + ")$")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: handler %d: invalid url %q: %v", path, i+1, h.url, err)
+		}
+		handlers = append(handlers, h)
+	}
+	if len(handlers) == 0 {
+		return nil, errors.New(path + ": no handlers")
+	}
+	return handlers, nil
+}
+
+// catchAllRE matches handler URLs that match every path.
+var catchAllRE = regexp.MustCompile(`^/?\(?\.\*\)?$`)
+
+func vetHandlers(handlers []*appHandler) []Issue {
+	var issues []Issue
+	for i, h := range handlers {
+		pos := fmt.Sprintf("app.yaml handler %d", i+1)
+		for j, prev := range handlers[:i] {
+			if prev.url == h.url || catchAllRE.MatchString(prev.url) {
+				issues = append(issues, Issue{Pos: pos, Message: fmt.Sprintf("url %q is unreachable, handler %d (%q) matches first", h.url, j+1, prev.url)})
+				break
+			}
+		}
+		if adminPathRE.MatchString(h.url) && h.login != "admin" {
+			issues = append(issues, Issue{Pos: pos, Message: fmt.Sprintf("url %q looks administrative but is not restricted with login: admin", h.url)})
+		}
+	}
+	return issues
+}
+
+// matchHandler returns the handler App Engine picks for path.
+func matchHandler(handlers []*appHandler, path string) *appHandler {
+	for _, h := range handlers {
+		if h.re.MatchString(path) {
+			return h
+		}
+	}
+	return nil
+}
+
+type goRoute struct {
+	pos  string
+	path string
+}
+
+// goRoutes returns the paths registered with literal patterns through
+// http.Handle and http.HandleFunc by the non-test Go files under dir.
+func goRoutes(dir string) ([]goRoute, error) {
+	var routes []goRoute
+	fset := token.NewFileSet()
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			if path != dir && (fi.Name() == "vendor" || strings.HasPrefix(fi.Name(), ".")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(path, ".go") || strings.HasSuffix(path, "_test.go") {
+			return nil
+		}
+		f, err := parser.ParseFile(fset, path, nil, 0)
+		if err != nil {
+			return err
+		}
+		ast.Inspect(f, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok || len(call.Args) != 2 {
+				return true
+			}
+			sel, ok := call.Fun.(*ast.SelectorExpr)
+			if !ok || sel.Sel.Name != "Handle" && sel.Sel.Name != "HandleFunc" {
+				return true
+			}
+			lit, ok := call.Args[0].(*ast.BasicLit)
+			if !ok || lit.Kind != token.STRING {
+				return true
+			}
+			pattern, err := strconv.Unquote(lit.Value)
+			if err != nil || !strings.HasPrefix(pattern, "/") {
+				return true // host patterns cannot be checked against app.yaml
+			}
+			rel, _ := filepath.Rel(dir, fset.Position(lit.Pos()).Filename)
+			routes = append(routes, goRoute{
+				pos:  fmt.Sprintf("%s:%d", rel, fset.Position(lit.Pos()).Line),
+				path: pattern,
+			})
+			return true
+		})
+		return nil
+	})
+	return routes, err
+}
@@ -0,0 +1,69 @@
+package gaetest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const vetAppYAML = `
+runtime: go
+api_version: go1
+handlers:
+- url: /static
+  static_dir: static
+- url: /admin/.*
+  script: _go_app
+- url: /.*
+  script: _go_app
+  login: required
+- url: /tasks/.*
+  script: _go_app
+  login: admin
+`
+
+const vetAppSource = `package app
+
+import "net/http"
+
+func init() {
+	http.HandleFunc("/", root)
+	http.HandleFunc("/static/logo.png", root)
+	http.Handle("/admin/users", nil)
+}
+
+func root(w http.ResponseWriter, r *http.Request) {}
+`
+
+func TestVetApp(t *testing.T) {
+	appDir, err := ioutil.TempDir("", "gaetest")
+	if err != nil {
+		t.Fatalf("Got %v, expected nil", err)
+	}
+	defer os.RemoveAll(appDir)
+	if err := ioutil.WriteFile(filepath.Join(appDir, "app.yaml"), []byte(vetAppYAML), 0644); err != nil {
+		t.Fatalf("Got %v, expected nil", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(appDir, "app.go"), []byte(vetAppSource), 0644); err != nil {
+		t.Fatalf("Got %v, expected nil", err)
+	}
+
+	issues, err := VetApp(appDir)
+	if err != nil {
+		t.Fatalf("VetApp returned %v, expected nil", err)
+	}
+	var got []string
+	for _, issue := range issues {
+		got = append(got, issue.String())
+	}
+	expect := []string{
+		`app.yaml handler 2: url "/admin/.*" looks administrative but is not restricted with login: admin`,
+		`app.yaml handler 4: url "/tasks/.*" is unreachable, handler 3 ("/.*") matches first`,
+		`app.go:7: route "/static/logo.png" is not sent to the Go app by app.yaml`,
+	}
+	if !reflect.DeepEqual(got, expect) {
+		t.Fatalf("got %q, but expect %q", got, expect)
+	}
+}
@@ -0,0 +1,127 @@
+package gaetest
+
+import (
+	"bytes"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fileChangesRE matches the line dev_appserver.py logs when it notices changes
+// to the app. The changed files follow on lines of their own, indented.
+var fileChangesRE = regexp.MustCompile(`Detected file changes:`)
+
+// reloadSettle is how long the watcher waits for dev_appserver.py to list the
+// changed files before probing the app.
+const reloadSettle = 200 * time.Millisecond
+
+// ReadyEvent reports that the app was reloaded after a change to its files.
+type ReadyEvent struct {
+	Time time.Time
+	// Changes are the files dev_appserver.py reported as changed.
+	Changes []string
+	// Status is the status of the response to the request probing the
+	// reloaded app. A failed build of a Go app makes it 500.
+	Status int
+	// Err is set if the app did not respond to the probe.
+	Err error
+}
+
+// watcher follows the output of dev_appserver.py for reloads and probes the
+// app once it has reloaded.
+type watcher struct {
+	url     func() string
+	timeout time.Duration
+	client  *http.Client
+	ready   chan ReadyEvent
+	done    chan struct{}
+
+	mu       sync.Mutex
+	partial  []byte
+	changes  []string
+	changing bool
+}
+
+func newWatcher(url func() string, timeout time.Duration, client *http.Client) *watcher {
+	return &watcher{
+		url:     url,
+		timeout: timeout,
+		client:  client,
+		ready:   make(chan ReadyEvent, 16),
+		done:    make(chan struct{}),
+	}
+}
+
+func (w *watcher) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		w.line(strings.TrimSuffix(string(w.partial[:i]), "\r"))
+		w.partial = w.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+func (w *watcher) line(line string) {
+	if fileChangesRE.MatchString(line) {
+		if !w.changing {
+			w.changing = true
+			go w.probe()
+		}
+		return
+	}
+	if w.changing && strings.HasPrefix(line, " ") {
+		w.changes = append(w.changes, strings.TrimSpace(line))
+	}
+}
+
+// probe waits for the reload to settle, then requests the app until it
+// answers and reports the result on w.ready.
+func (w *watcher) probe() {
+	time.Sleep(reloadSettle)
+	w.mu.Lock()
+	ev := ReadyEvent{Changes: w.changes}
+	w.changes, w.changing = nil, false
+	w.mu.Unlock()
+
+	deadline := time.Now().Add(w.timeout)
+	for {
+		res, err := w.client.Get(w.url())
+		if err == nil {
+			res.Body.Close()
+			ev.Status = res.StatusCode
+			break
+		}
+		if time.Now().After(deadline) {
+			ev.Err = err
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	ev.Time = time.Now()
+	select {
+	case w.ready <- ev:
+	case <-w.done:
+	}
+}
+
+func (w *watcher) Close() {
+	close(w.done)
+}
+
+// Ready returns the channel on which a ReadyEvent is sent every time
+// dev_appserver.py reloads the app after a change to its files. It requires
+// Options.Watch, otherwise it returns nil.
+func (sv *Server) Ready() <-chan ReadyEvent {
+	if sv.watcher == nil {
+		return nil
+	}
+	return sv.watcher.ready
+}
@@ -0,0 +1,34 @@
+package gaetest
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWatcher(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "build failed", http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	w := newWatcher(func() string { return ts.URL }, time.Second, http.DefaultClient)
+	defer w.Close()
+	fmt.Fprint(w, "INFO     2016-10-02 21:50:01,000 module.py:444] Detected file changes:\n  /app/main.go\n")
+	fmt.Fprint(w, "  /app/app.yaml\nINFO     2016-10-02 21:50:01,100 module.py:1500] Building Go app\n")
+
+	select {
+	case ev := <-w.ready:
+		if expect := []string{"/app/main.go", "/app/app.yaml"}; !reflect.DeepEqual(ev.Changes, expect) {
+			t.Fatalf("got %q, but expect %q", ev.Changes, expect)
+		}
+		if ev.Status != http.StatusInternalServerError || ev.Err != nil {
+			t.Fatalf("got status %d and error %v, but expect status 500", ev.Status, ev.Err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no ReadyEvent after the reload")
+	}
+}
@@ -0,0 +1,240 @@
+package gaetest
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// The configuration files of App Engine apps (app.yaml, cron.yaml, queue.yaml,
+// ...) only use a small subset of YAML: block mappings and sequences of plain
+// or quoted scalars. parseYAML understands that subset, which spares the
+// package a dependency on a full YAML implementation. Mappings are returned as
+// map[string]interface{}, sequences as []interface{} and scalars as string.
+
+type yamlLine struct {
+	num    int // 1-based line number, for error messages
+	indent int
+	text   string
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+// parseYAML parses data into a tree of maps, slices and strings.
+func parseYAML(data []byte) (interface{}, error) {
+	p := &yamlParser{}
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for num := 1; s.Scan(); num++ {
+		text := strings.TrimRight(stripYAMLComment(s.Text()), " \t")
+		trimmed := strings.TrimLeft(text, " ")
+		if trimmed == "" || trimmed == "---" {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("yaml: line %d: tabs are not allowed for indentation", num)
+		}
+		p.lines = append(p.lines, yamlLine{num: num, indent: len(text) - len(trimmed), text: trimmed})
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if len(p.lines) == 0 {
+		return nil, nil
+	}
+	v, err := p.parseBlock(p.lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.lines) {
+		return nil, fmt.Errorf("yaml: line %d: unexpected indentation", p.lines[p.pos].num)
+	}
+	return v, nil
+}
+
+// stripYAMLComment removes a trailing comment, leaving # inside quotes alone.
+func stripYAMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func isYAMLItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// parseBlock parses the mapping or sequence starting at the current line.
+func (p *yamlParser) parseBlock(indent int) (interface{}, error) {
+	if isYAMLItem(p.lines[p.pos].text) {
+		return p.parseSequence(indent)
+	}
+	return p.parseMapping(indent)
+}
+
+func (p *yamlParser) parseSequence(indent int) (interface{}, error) {
+	var seq []interface{}
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		if line.indent != indent || !isYAMLItem(line.text) {
+			break
+		}
+		content := strings.TrimLeft(strings.TrimPrefix(line.text, "-"), " ")
+		if content == "" {
+			p.pos++
+			if p.pos == len(p.lines) || p.lines[p.pos].indent <= indent {
+				seq = append(seq, nil)
+				continue
+			}
+			v, err := p.parseBlock(p.lines[p.pos].indent)
+			if err != nil {
+				return nil, err
+			}
+			seq = append(seq, v)
+			continue
+		}
+		if _, _, ok := splitYAMLKey(content); ok || isYAMLItem(content) {
+			// The item is a nested block starting on the same line as
+			// the dash; parse it as if it started on a line of its own.
+			p.lines[p.pos] = yamlLine{
+				num:    line.num,
+				indent: indent + len(line.text) - len(content),
+				text:   content,
+			}
+			v, err := p.parseBlock(p.lines[p.pos].indent)
+			if err != nil {
+				return nil, err
+			}
+			seq = append(seq, v)
+			continue
+		}
+		v, err := parseYAMLScalar(content, line.num)
+		if err != nil {
+			return nil, err
+		}
+		seq = append(seq, v)
+		p.pos++
+	}
+	return seq, nil
+}
+
+func (p *yamlParser) parseMapping(indent int) (interface{}, error) {
+	m := make(map[string]interface{})
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		if line.indent < indent || isYAMLItem(line.text) && line.indent == indent {
+			break
+		}
+		if line.indent > indent {
+			return nil, fmt.Errorf("yaml: line %d: unexpected indentation", line.num)
+		}
+		key, value, ok := splitYAMLKey(line.text)
+		if !ok {
+			return nil, fmt.Errorf("yaml: line %d: expected a key", line.num)
+		}
+		if _, dup := m[key]; dup {
+			return nil, fmt.Errorf("yaml: line %d: duplicate key %q", line.num, key)
+		}
+		p.pos++
+		if value != "" {
+			v, err := parseYAMLScalar(value, line.num)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = v
+			continue
+		}
+		m[key] = nil
+		if p.pos == len(p.lines) {
+			continue
+		}
+		// Sequences are commonly written at the indentation of their key.
+		next := p.lines[p.pos]
+		if next.indent > indent || next.indent == indent && isYAMLItem(next.text) {
+			v, err := p.parseBlock(next.indent)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = v
+		}
+	}
+	return m, nil
+}
+
+// splitYAMLKey splits "key: value" and "key:" lines. Keys may be quoted.
+func splitYAMLKey(text string) (key, value string, ok bool) {
+	if text == "" || text[0] == '[' || text[0] == '{' {
+		return "", "", false
+	}
+	if text[0] == '"' || text[0] == '\'' {
+		end := strings.IndexByte(text[1:], text[0]) + 1
+		if end == 0 {
+			return "", "", false
+		}
+		rest := text[end+1:]
+		if rest != ":" && !strings.HasPrefix(rest, ": ") {
+			return "", "", false
+		}
+		return text[1:end], strings.TrimSpace(rest[1:]), true
+	}
+	if strings.HasSuffix(text, ":") {
+		return strings.TrimSpace(text[:len(text)-1]), "", true
+	}
+	i := strings.Index(text, ": ")
+	if i < 0 {
+		return "", "", false
+	}
+	return strings.TrimSpace(text[:i]), strings.TrimSpace(text[i+2:]), true
+}
+
+func parseYAMLScalar(text string, num int) (interface{}, error) {
+	switch text[0] {
+	case '"', '\'':
+		if len(text) < 2 || text[len(text)-1] != text[0] {
+			return nil, fmt.Errorf("yaml: line %d: unterminated string", num)
+		}
+		s := text[1 : len(text)-1]
+		if text[0] == '\'' {
+			return strings.Replace(s, "''", "'", -1), nil
+		}
+		return strings.Replace(s, `\"`, `"`, -1), nil
+	case '[':
+		if text[len(text)-1] != ']' {
+			return nil, fmt.Errorf("yaml: line %d: unterminated flow sequence", num)
+		}
+		var seq []interface{}
+		for _, item := range strings.Split(text[1:len(text)-1], ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			v, err := parseYAMLScalar(item, num)
+			if err != nil {
+				return nil, err
+			}
+			seq = append(seq, v)
+		}
+		return seq, nil
+	case '{', '|', '>', '&', '*', '!':
+		return nil, fmt.Errorf("yaml: line %d: unsupported syntax %q", num, text)
+	}
+	return text, nil
+}
+
+// yamlString returns m[key] if it is a scalar.
+func yamlString(m map[string]interface{}, key string) string {
+	s, _ := m[key].(string)
+	return s
+}
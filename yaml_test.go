@@ -0,0 +1,55 @@
+package gaetest
+
+import (
+	"reflect"
+	"testing"
+)
+
+const yamlDoc = `
+# comment
+application: gaetest
+version: "1"
+handlers:
+- url: /admin/.*   # trailing comment
+  script: _go_app
+  login: admin
+- url: /.*
+  script: _go_app
+skip_files: [a, 'b#c']
+env_variables:
+  NAME: 'it''s'
+  "404": not found
+`
+
+func TestParseYAML(t *testing.T) {
+	got, err := parseYAML([]byte(yamlDoc))
+	if err != nil {
+		t.Fatalf("parseYAML returned %v, expected nil", err)
+	}
+	expect := map[string]interface{}{
+		"application": "gaetest",
+		"version":     "1",
+		"handlers": []interface{}{
+			map[string]interface{}{"url": "/admin/.*", "script": "_go_app", "login": "admin"},
+			map[string]interface{}{"url": "/.*", "script": "_go_app"},
+		},
+		"skip_files":    []interface{}{"a", "b#c"},
+		"env_variables": map[string]interface{}{"NAME": "it's", "404": "not found"},
+	}
+	if !reflect.DeepEqual(got, expect) {
+		t.Fatalf("got %#v, but expect %#v", got, expect)
+	}
+}
+
+func TestParseYAMLErrors(t *testing.T) {
+	for _, doc := range []string{
+		"a: 1\n  b: 2\n",
+		"a: 1\na: 2\n",
+		"a: \"open\n",
+		"a: |\n  text\n",
+	} {
+		if _, err := parseYAML([]byte(doc)); err == nil {
+			t.Fatalf("parseYAML(%q) returned nil, expected an error", doc)
+		}
+	}
+}